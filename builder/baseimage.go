@@ -0,0 +1,110 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"runtime"
+	"time"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/image"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+// enforceBaseImagePolicy evaluates the base images of the build step, which must have been scanned, against the
+// policy. Violations fail the step, unless the policy is in audit mode, in which case they're only logged.
+func (b *Builder) enforceBaseImagePolicy(ctx context.Context, step *graph.Step, policy *graph.BaseImagePolicy) error {
+	// The images' ages are read from their registries, which aren't accessed in a dry run.
+	var created graph.BaseImageCreatedFunc
+	if !b.procManager.DryRun {
+		client := b.registryClient()
+		created = func(ref *image.Reference) (time.Time, error) {
+			return imageCreated(ctx, ref.Reference, client)
+		}
+	}
+
+	violations := policy.Evaluate(baseImages(step), time.Now(), created)
+	if len(violations) == 0 {
+		return nil
+	}
+	if policy.Audit {
+		for _, violation := range violations {
+			log.Printf("WARNING: step ID: %s violates the base image policy: %s\n", step.ID, violation)
+		}
+		return nil
+	}
+	return &graph.PolicyViolationError{Violations: violations}
+}
+
+// baseImages returns the distinct base images of the build step, which must have been scanned, excluding scratch.
+func baseImages(step *graph.Step) []*image.Reference {
+	var refs []*image.Reference
+	seen := make(map[string]bool)
+	for _, deps := range step.ImageDependencies {
+		for _, ref := range append([]*image.Reference{deps.Runtime}, deps.Buildtime...) {
+			if ref == nil || ref.Reference == "" || ref.Reference == NoBaseImageSpecifierLatest || seen[ref.Reference] {
+				continue
+			}
+			seen[ref.Reference] = true
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// imageCreated returns the time the image was created, according to its config. The image for the
+// builder's platform is used if the image is multi-platform.
+func imageCreated(ctx context.Context, img string, client remote.Client) (time.Time, error) {
+	repo, _, desc, err := resolveImage(ctx, img, client)
+	if err != nil {
+		return time.Time{}, err
+	}
+	data, err := content.FetchAll(ctx, repo, desc)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "failed to fetch the manifest of image %s", img)
+	}
+
+	if desc.MediaType == ocispec.MediaTypeImageIndex || desc.MediaType == dockerManifestListMediaType {
+		var index ocispec.Index
+		if err = json.Unmarshal(data, &index); err != nil {
+			return time.Time{}, errors.Wrapf(err, "failed to parse the index of image %s", img)
+		}
+		found := false
+		for _, m := range index.Manifests {
+			if m.Platform != nil && m.Platform.OS == runtime.GOOS && m.Platform.Architecture == runtime.GOARCH {
+				desc, found = m, true
+				break
+			}
+		}
+		if !found {
+			return time.Time{}, fmt.Errorf("image %s has no manifest for %s/%s", img, runtime.GOOS, runtime.GOARCH)
+		}
+		if data, err = content.FetchAll(ctx, repo, desc); err != nil {
+			return time.Time{}, errors.Wrapf(err, "failed to fetch the manifest of image %s", img)
+		}
+	}
+
+	var manifest ocispec.Manifest
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return time.Time{}, errors.Wrapf(err, "failed to parse the manifest of image %s", img)
+	}
+	if data, err = content.FetchAll(ctx, repo, manifest.Config); err != nil {
+		return time.Time{}, errors.Wrapf(err, "failed to fetch the config of image %s", img)
+	}
+	var config ocispec.Image
+	if err = json.Unmarshal(data, &config); err != nil {
+		return time.Time{}, errors.Wrapf(err, "failed to parse the config of image %s", img)
+	}
+	if config.Created == nil {
+		return time.Time{}, fmt.Errorf("image %s doesn't record when it was created", img)
+	}
+	return *config.Created, nil
+}
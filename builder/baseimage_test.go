@@ -0,0 +1,81 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/image"
+	"github.com/Azure/acr-builder/pkg/procmanager"
+)
+
+func TestEnforceBaseImagePolicy(t *testing.T) {
+	golang := &image.Reference{Registry: "registry.hub.docker.com", Repository: "library/golang", Tag: "latest", Reference: "golang:latest"}
+	step := &graph.Step{
+		ID: "build",
+		ImageDependencies: []*image.Dependencies{{
+			Runtime:   &image.Reference{Reference: NoBaseImageSpecifierLatest, Tag: "latest"},
+			Buildtime: []*image.Reference{golang},
+		}, {
+			Runtime: golang,
+		}},
+	}
+
+	tests := []struct {
+		policy      *graph.BaseImagePolicy
+		shouldError bool
+	}{
+		{&graph.BaseImagePolicy{AllowedRegistries: []string{"docker.io"}}, false},
+		{&graph.BaseImagePolicy{ForbiddenTags: []string{"latest"}}, true},
+		{&graph.BaseImagePolicy{ForbiddenTags: []string{"latest"}, Audit: true}, false},
+		{&graph.BaseImagePolicy{RequireDigest: true}, true},
+	}
+	for _, test := range tests {
+		b := &Builder{procManager: procmanager.NewProcManager(false)}
+		err := b.enforceBaseImagePolicy(context.Background(), step, test.policy)
+		if (err != nil) != test.shouldError {
+			t.Errorf("expected error to be %v for policy %+v but got %v", test.shouldError, test.policy, err)
+		}
+		// scratch isn't a base image, and golang is only evaluated once.
+		var violationErr *graph.PolicyViolationError
+		if errors.As(err, &violationErr) && len(violationErr.Violations) != 1 {
+			t.Errorf("expected 1 violation for policy %+v but got %v", test.policy, violationErr.Violations)
+		}
+	}
+}
+
+func TestEnforceBaseImagePolicy_MaxAge(t *testing.T) {
+	registry := &testRegistry{blobs: make(map[string]map[string]bool), manifests: make(map[string]string)}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	img := host + "/base:v1"
+	if _, err := pushImage(context.Background(), loadTestSBOMImage(t, img), img, &http.Client{}, make(map[string][]string)); err != nil {
+		t.Fatalf("failed to push %s: %v", img, err)
+	}
+
+	step := &graph.Step{
+		ID:                "build",
+		ImageDependencies: []*image.Dependencies{{Runtime: &image.Reference{Registry: host, Repository: "base", Tag: "v1", Reference: img}}},
+	}
+	b := &Builder{procManager: procmanager.NewProcManager(false)}
+	policy := &graph.BaseImagePolicy{MaxAge: "30d"}
+	// The image's config doesn't record when it was created, so its age can't be determined.
+	err := b.enforceBaseImagePolicy(context.Background(), step, policy)
+	if err == nil || !strings.Contains(err.Error(), "doesn't record when it was created") {
+		t.Errorf("expected the age of %s to be unknown but got %v", img, err)
+	}
+
+	// The registry isn't accessed in a dry run.
+	b.procManager = procmanager.NewProcManager(true)
+	if err = b.enforceBaseImagePolicy(context.Background(), step, policy); err != nil {
+		t.Errorf("expected the age not to be checked in a dry run but got %v", err)
+	}
+}
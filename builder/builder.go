@@ -6,6 +6,7 @@ package builder
 import (
 	"bytes"
 	"context"
+	"crypto"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -17,11 +18,16 @@ import (
 	"time"
 
 	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/container"
 	"github.com/Azure/acr-builder/pkg/image"
 	"github.com/Azure/acr-builder/pkg/procmanager"
+	"github.com/Azure/acr-builder/pkg/redact"
 	"github.com/Azure/acr-builder/pkg/volume"
+	"github.com/Azure/acr-builder/secretmgmt"
 	"github.com/Azure/acr-builder/util"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"oras.land/oras-go/v2/registry/remote/auth"
 )
 
 const (
@@ -33,30 +39,100 @@ var once sync.Once
 
 // Builder builds images.
 type Builder struct {
+	// ReportFile is the path to write a run report to after a Task has been executed.
+	// No report is written if it's empty.
+	ReportFile string
+
+	// LogsDir is the directory to save the logs of detached steps to when the Task is cleaned up.
+	// The logs are printed if it's empty.
+	LogsDir string
+
+	// Provenance enables signed SLSA provenance for the images which the Task builds and pushes,
+	// if it isn't nil.
+	Provenance *ProvenanceOptions
+
 	procManager  *procmanager.ProcManager
 	workspaceDir string
 	debug        bool
+
+	// containerRuntime manages the containers, networks and images of the Task.
+	containerRuntime container.Runtime
+
+	// baseImagePolicies restrict the base images of the running Task's build steps.
+	baseImagePolicies []*graph.BaseImagePolicy
+
+	// containers are the names of the running containers started by the Builder, which are removed by CleanTask.
+	containers   map[string]bool
+	containersMu sync.Mutex
+
+	// registryConfig describes how the Docker daemon accesses registries, i.e. which of them are insecure.
+	registryConfig *container.RegistryConfig
+
+	// registryLoginCredentials are the credentials of the registries which the running Task logged in to.
+	registryLoginCredentials graph.RegistryLoginCredentials
+
+	// dockerConfigCreds are the registry logins of the home volume's Docker config, which are read on demand.
+	dockerConfigCreds map[string]auth.Credential
+	dockerConfigMu    sync.Mutex
+
+	// secrets are the running Task's secrets, which may contain signing and verification keys.
+	secrets []*secretmgmt.Secret
+
+	// buildSteps are the build steps of the running Task, which pushed images are attributed to by their tags.
+	buildSteps []*graph.Step
+
+	// outputSteps are the IDs of the running Task's steps which other steps depend on, so their outputs are read.
+	outputSteps map[string]bool
+
+	// provenanceKey signs the provenance of the running Task's images, if Provenance is enabled.
+	provenanceKey crypto.Signer
 }
 
-// NewBuilder creates a new Builder.
+// NewBuilder creates a new Builder which uses the Docker daemon, or only logs its operations during a dry run.
 func NewBuilder(pm *procmanager.ProcManager, debug bool, workspaceDir string) *Builder {
+	var rt container.Runtime = container.NewDockerRuntime("")
+	if pm.DryRun {
+		rt = container.NewDryRunRuntime()
+	}
+	return NewBuilderWithRuntime(pm, debug, workspaceDir, rt)
+}
+
+// NewBuilderWithRuntime creates a new Builder which uses the specified container runtime.
+func NewBuilderWithRuntime(pm *procmanager.ProcManager, debug bool, workspaceDir string, rt container.Runtime) *Builder {
 	return &Builder{
-		procManager:  pm,
-		debug:        debug,
-		workspaceDir: workspaceDir,
+		procManager:      pm,
+		debug:            debug,
+		workspaceDir:     workspaceDir,
+		containerRuntime: rt,
 	}
 }
 
-// RunTask executes a Task.
+// RunTask executes a Task. If the Builder has a ReportFile, a run report is written to it afterwards.
 func (b *Builder) RunTask(ctx context.Context, task *graph.Task) error {
+	if b.ReportFile == "" {
+		return b.runTask(ctx, task)
+	}
+
+	startTime := time.Now()
+	err := b.runTask(ctx, task)
+	report := NewRunReport(task, startTime, time.Now(), err)
+	if reportErr := report.WriteFile(b.ReportFile); reportErr != nil {
+		log.Printf("Failed to write run report to %s, err: %v\n", b.ReportFile, reportErr)
+	}
+	return err
+}
+
+func (b *Builder) runTask(ctx context.Context, task *graph.Task) error {
+	b.baseImagePolicies = task.BaseImagePolicies()
 	for _, network := range task.Networks {
 		if network.SkipCreation {
 			log.Printf("Skip creating network: %s\n", network.Name)
 			continue
 		}
 		log.Printf("Creating Docker network: %s, driver: '%s'\n", network.Name, network.Driver)
-		if msg, err := network.Create(ctx, b.procManager); err != nil {
-			return fmt.Errorf("failed to create network: %s, err: %v, msg: %s", network.Name, err, msg)
+		opts := &container.NetworkOptions{Driver: network.Driver, IPv6: network.Ipv6}
+		if err := b.containerRuntime.CreateNetwork(ctx, network.Name, opts); err != nil {
+			return fmt.Errorf("failed to create network: %s, err: %v", network.Name, err)
 		}
 		log.Printf("Successfully set up Docker network: %s\n", network.Name)
 	}
@@ -65,10 +141,35 @@ func (b *Builder) RunTask(ctx context.Context, task *graph.Task) error {
 	timeout := time.Duration(configTimeoutInSec) * time.Second
 	configCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	if err := b.setupConfig(configCtx); err != nil {
+	if err := b.setupConfig(configCtx, b.staleStepOutputFiles(task)); err != nil {
 		return err
 	}
 	log.Println("Successfully set up Docker configuration")
+	registryConfig, err := b.containerRuntime.RegistryConfig(ctx)
+	if err != nil {
+		log.Printf("Failed to read the registry configuration of the Docker daemon, its insecure registries won't be used, err: %v\n", err)
+	}
+	b.registryConfig = registryConfig
+	b.registryLoginCredentials = task.RegistryLoginCredentials
+	b.secrets = task.Secrets
+	b.buildSteps = nil
+	b.outputSteps = make(map[string]bool)
+	for _, step := range task.Steps {
+		if step.IsBuildStep() {
+			b.buildSteps = append(b.buildSteps, step)
+		}
+		if task.Dag != nil && task.Dag.HasDependents(step.ID) {
+			b.outputSteps[step.ID] = true
+		}
+	}
+	b.provenanceKey = nil
+	if b.Provenance != nil {
+		key, err := b.loadProvenanceKey(task)
+		if err != nil {
+			return err
+		}
+		b.provenanceKey = key
+	}
 	if task.UsingRegistryCreds() {
 		timeout := time.Duration(loginTimeoutInSec) * time.Second
 		for registry, cred := range task.RegistryLoginCredentials {
@@ -82,20 +183,16 @@ func (b *Builder) RunTask(ctx context.Context, task *graph.Task) error {
 		}
 	}
 
-	var completedChans []chan bool
-	errorChan := make(chan error)
-	for _, node := range task.Dag.Nodes {
-		completedChans = append(completedChans, node.Value.CompletedChan)
-	}
-
 	if task.InitBuildkitContainer {
 		log.Println("Task will use build cache, initializing buildkitd container")
 		// --workdir = /workspace
-		args := b.getDockerRunArgs(
+		opts, err := b.getRunOptions(
 			make(map[string]string),
 			b.workspaceDir,
 			"",
 			false,
+			false,
+			false,
 			true,
 			true,
 			[]string{},
@@ -110,23 +207,27 @@ func (b *Builder) RunTask(ctx context.Context, task *graph.Task) error {
 			buildkitdContainerName,
 			buildxImg+" create --use",
 		)
+		if err != nil {
+			return err
+		}
 		if b.debug {
-			log.Printf("buildkitd container args: %v\n", strings.Join(args, ", "))
+			log.Printf("buildkitd container image: %s, cmd: %v\n", opts.Image, opts.Cmd)
 		}
 
+		// The buildkitd container keeps running after the run, so it's removed when the Task is cleaned up.
+		b.trackContainer(buildkitdContainerName)
 		timeout := time.Duration(buildkitdContainerRunTimeoutInSeconds) * time.Second
 		buildkitCtx, cancel := context.WithTimeout(ctx, timeout)
 		defer cancel()
 
-		err := b.procManager.RunRepeatWithRetries(
+		_, err = procmanager.RepeatWithRetries(
 			buildkitCtx,
-			args,
-			nil,
+			b.runFunc(opts),
 			os.Stdout,
 			os.Stderr,
-			"",
 			buildkitdContainerInitRetries,
 			nil,
+			nil,
 			buildkitdContainerInitRetryDelay,
 			buildkitdContainerName,
 			buildkitdContainerInitRepeat)
@@ -142,25 +243,16 @@ func (b *Builder) RunTask(ctx context.Context, task *graph.Task) error {
 		}
 	}
 
-	for _, child := range task.Dag.Root.Children() {
-		go b.processVertex(ctx, task, task.Dag.Root, child, errorChan)
-	}
+	b.restoreStepOutputs(ctx, task)
 
-	// Block until either:
-	// - The global context expires
-	// - A step has an error
-	// - All steps have been processed
-	for _, ch := range completedChans {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-ch:
-			continue
-		case err := <-errorChan:
-			return err
-		}
+	s := newScheduler(ctx, b, task)
+	defer s.cancel()
+	if err := s.run(ctx); err != nil {
+		return err
 	}
 
+	// Images are pushed using the registry API, so the Docker store doesn't know the digests of the pushed images.
+	pushed := pushedDigests(task)
 	var deps []*image.Dependencies
 	for _, step := range task.Steps {
 		log.Printf("Step ID: %v marked as %v (elapsed time in seconds: %f)\n", step.ID, step.StepStatus, step.EndTime.Sub(step.StartTime).Seconds())
@@ -171,12 +263,14 @@ func (b *Builder) RunTask(ctx context.Context, task *graph.Task) error {
 			digestCtx, cancel := context.WithTimeout(ctx, timeout)
 			defer cancel()
 
-			usingBuildkit := false
-			if (step.UseBuildCacheForBuildStep() && runtime.GOOS == util.LinuxOS) || step.UsesBuildkit {
+			usingBuildkit := builtWithBuildkit(step)
+			if usingBuildkit {
 				log.Printf("Image was built using buildkit, fetching Digest from remote...")
-				usingBuildkit = true
 			}
 
+			for _, d := range step.ImageDependencies {
+				populatePushedDigest(d.Image, pushed)
+			}
 			if err := b.getPopulateDigests(digestCtx, step.ImageDependencies, usingBuildkit, task.RegistryLoginCredentials); err != nil {
 				return err
 			}
@@ -197,15 +291,46 @@ func (b *Builder) RunTask(ctx context.Context, task *graph.Task) error {
 	return nil
 }
 
-// CleanTask iterates through all build steps and removes
-// their corresponding containers.
+// CleanTask iterates through all build steps and removes their corresponding containers,
+// then removes the Task's volumes and networks. Detached steps are stopped gracefully first.
+// The context bounds the whole teardown.
 func (b *Builder) CleanTask(ctx context.Context, task *graph.Task) {
-	args := []string{"docker", "rm", "-f"}
+	// Give detached steps a chance to exit gracefully and collect their logs before their containers are removed.
+	for _, n := range task.Dag.Nodes {
+		step := n.Value
+		if step.Detach && step.StepStatus != graph.Skipped {
+			log.Printf("Stopping detached step: %s\n", step.ID)
+			if err := b.containerRuntime.Stop(ctx, step.ID, time.Duration(step.StopTimeout)*time.Second); err != nil && !container.IsNotFound(err) {
+				log.Printf("Failed to stop container: %s, err: %v\n", step.ID, err)
+			}
+			b.saveDetachedLogs(ctx, step)
+		}
+	}
+
+	// Remove the containers of all steps which weren't skipped, even if they weren't run by this Builder,
+	// and any helper containers.
+	names := b.trackedContainers()
+	tracked := make(map[string]bool, len(names))
+	for _, name := range names {
+		tracked[name] = true
+	}
 	for _, n := range task.Dag.Nodes {
 		step := n.Value
-		if step.StepStatus != graph.Skipped {
-			killArgs := append(args, step.ID)
-			_ = b.procManager.Run(ctx, killArgs, nil, nil, nil, "")
+		if step.StepStatus != graph.Skipped && !tracked[step.ID] {
+			names = append(names, step.ID)
+		}
+	}
+	for _, name := range names {
+		if err := b.containerRuntime.Remove(ctx, name); err != nil && !container.IsNotFound(err) {
+			log.Printf("Failed to remove container: %s, err: %v\n", name, err)
+		}
+	}
+
+	// Remove the volumes populated from the Task's volume sources, which may contain secrets,
+	// once no container uses them.
+	for _, volMount := range task.Volumes {
+		if err := b.containerRuntime.RemoveVolume(ctx, volMount.Name); err != nil && !container.IsNotFound(err) {
+			log.Printf("Failed to remove volume: %s, err: %v\n", volMount.Name, err)
 		}
 	}
 
@@ -214,45 +339,14 @@ func (b *Builder) CleanTask(ctx context.Context, task *graph.Task) {
 			log.Printf("Skip deleting network: %s\n", network.Name)
 			continue
 		}
-		if msg, err := network.Delete(ctx, b.procManager); err != nil {
-			log.Printf("Failed to delete network: %s, err: %v, msg: %s\n", network.Name, err, msg)
+		if err := b.containerRuntime.RemoveNetwork(ctx, network.Name); err != nil {
+			log.Printf("Failed to delete network: %s, err: %v\n", network.Name, err)
 		}
 	}
 
 	_ = b.procManager.Stop()
 }
 
-func (b *Builder) processVertex(ctx context.Context, task *graph.Task, parent *graph.Node, child *graph.Node, errorChan chan error) {
-	err := task.Dag.RemoveEdge(parent.Name, child.Name)
-	if err != nil {
-		errorChan <- errors.Wrap(err, "failed to remove edge")
-		return
-	}
-
-	degree := child.GetDegree()
-	if degree == 0 {
-		step := child.Value
-		err := b.runStep(ctx, step, task.Credentials)
-		if err != nil && step.IgnoreErrors {
-			log.Printf("Step ID: %s encountered an error: %v, but is set to ignore errors. Continuing...\n", step.ID, err)
-			step.StepStatus = graph.Successful
-			for _, c := range child.Children() {
-				go b.processVertex(ctx, task, child, c, errorChan)
-			}
-		} else if err != nil {
-			step.StepStatus = graph.Failed
-			errorChan <- errors.Wrapf(err, "failed to run step ID: %s", step.ID)
-		} else {
-			step.StepStatus = graph.Successful
-			for _, c := range child.Children() {
-				go b.processVertex(ctx, task, child, c, errorChan)
-			}
-		}
-		// Step must always be marked as complete.
-		step.CompletedChan <- true
-	}
-}
-
 func (b *Builder) runStep(ctx context.Context, step *graph.Step, credentials []*graph.RegistryCredential) error {
 	log.Printf("Executing step ID: %s. Timeout(sec): %d, Working directory: '%s', Network: '%s'\n", step.ID, step.Timeout, step.WorkingDirectory, step.Network)
 	if step.StartDelay > 0 {
@@ -273,7 +367,10 @@ func (b *Builder) runStep(ctx context.Context, step *graph.Step, credentials []*
 		step.EndTime = time.Now()
 	}()
 
-	var args []string
+	var (
+		opts *container.RunOptions
+		err  error
+	)
 
 	if step.IsBuildStep() {
 		dockerfile, target, dockerContext := parseDockerBuildCmd(step.Build)
@@ -286,12 +383,22 @@ func (b *Builder) runStep(ctx context.Context, step *graph.Step, credentials []*
 		timeout := time.Duration(scrapeTimeoutInSec) * time.Second
 		scrapeCtx, cancel := context.WithTimeout(ctx, timeout)
 		defer cancel()
-		deps, err := b.scrapeDependencies(scrapeCtx, volName, step.WorkingDirectory, step.ID, dockerfile, dockerContext, step.Tags, step.BuildArgs, target, credentials)
+		step.ImageDependencies, err = b.scrapeDependencies(scrapeCtx, volName, step.WorkingDirectory, step.ID, dockerfile, dockerContext, step.Tags, step.BuildArgs, target, credentials)
 		if err != nil {
 			return errors.Wrap(err, "failed to scan dependencies")
 		}
 		log.Println("Successfully scanned dependencies")
-		step.ImageDependencies = deps
+
+		for _, policy := range b.baseImagePolicies {
+			if err = b.enforceBaseImagePolicy(ctx, step, policy); err != nil {
+				return err
+			}
+		}
+		if step.VerifySignature != nil {
+			if err = b.verifyBaseImages(ctx, step); err != nil {
+				return err
+			}
+		}
 
 		workingDirectory := step.WorkingDirectory
 		// Modify the Run command if it's a tar or a git URL.
@@ -309,22 +416,40 @@ func (b *Builder) runStep(ctx context.Context, step *graph.Step, credentials []*
 		}
 		step.UpdateBuildStepWithDefaults()
 
+		buildArgs := getBuildOptionArgs(step) + step.Build
 		if step.UseBuildCacheForBuildStep() {
-			args = b.getDockerRunArgsForStep(volName, workingDirectory, step, "", buildxImg+" build "+step.Build)
+			opts, err = b.getRunOptionsForStep(volName, workingDirectory, step, "", buildxImg+" build "+buildArgs)
 		} else {
-			args = b.getDockerRunArgsForStep(volName, workingDirectory, step, "", dockerImg+" build "+step.Build)
+			opts, err = b.getRunOptionsForStep(volName, workingDirectory, step, "", dockerImg+" build "+buildArgs)
 		}
 	} else if step.IsPushStep() {
 		timeout := time.Duration(step.Timeout) * time.Second
 		pushCtx, cancel := context.WithTimeout(ctx, timeout)
 		defer cancel()
-		return b.pushWithRetries(pushCtx, step.Push)
+		return b.pushWithRetries(pushCtx, step)
+	} else if step.IsSignStep() {
+		timeout := time.Duration(step.Timeout) * time.Second
+		signCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return b.signImages(signCtx, step)
 	} else {
-		args = b.getDockerRunArgsForStep(b.workspaceDir, step.WorkingDirectory, step, step.EntryPoint, step.Cmd)
+		if step.VerifySignature != nil {
+			if err = b.verifyCmdImage(ctx, step); err != nil {
+				return err
+			}
+		}
+		opts, err = b.getRunOptionsForStep(b.workspaceDir, step.WorkingDirectory, step, step.EntryPoint, step.Cmd)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to create the container options for step ID: %s", step.ID)
+	}
+	// The image is pulled with the credentials of its registry if it doesn't exist locally.
+	if opts.PullAuth, err = b.registryAuthConfig(ctx, opts.Image); err != nil {
+		return err
 	}
 
 	if b.debug {
-		log.Printf("Step args: %v\n", strings.Join(args, ", "))
+		log.Printf("Step container image: %s, entrypoint: %v, cmd: %v\n", opts.Image, opts.Entrypoint, opts.Cmd)
 	}
 
 	timeout := time.Duration(step.Timeout) * time.Second
@@ -340,23 +465,71 @@ func (b *Builder) runStep(ctx context.Context, step *graph.Step, credentials []*
 		}
 	}
 
-	return b.procManager.RunRepeatWithRetries(
-		stepCtx,
-		args,
-		nil,
-		os.Stdout,
-		os.Stderr,
-		"",
-		step.Retries,
-		step.RetryOnErrors,
-		step.RetryDelayInSeconds,
-		step.ID,
-		step.Repeat)
+	// Secrets can be split across writes, so the step's output is redacted by writers dedicated to the step.
+	stdout, stderr := redact.NewWriter(os.Stdout), redact.NewWriter(os.Stderr)
+	defer func() {
+		_ = stdout.Flush()
+		_ = stderr.Flush()
+	}()
+
+	// The exit code of a detached step is docker run's rather than its container's, so it's not evaluated.
+	isSuccessfulExitCode := step.IsSuccessfulExitCode
+	if step.Detach {
+		isSuccessfulExitCode = nil
+	}
+
+	step.ContainerName = step.ID
+	var result *procmanager.RunResult
+	err = b.runInContainer(stepCtx, step.ID, time.Duration(step.StopTimeout)*time.Second, func() error {
+		var runErr error
+		result, runErr = procmanager.RepeatWithRetries(
+			stepCtx,
+			b.runFunc(opts),
+			stdout,
+			stderr,
+			step.Retries,
+			step.RetryOnErrors,
+			isSuccessfulExitCode,
+			step.RetryDelayInSeconds,
+			step.ID,
+			step.Repeat)
+		return runErr
+	})
+	step.ExitCode = result.ExitCode
+	step.RetryCount = result.Retries(step.Repeat + 1)
+	if err == nil && step.Detach && step.Readiness != nil {
+		// The steps which depend on a detached step aren't run until it's ready.
+		err = b.waitUntilReady(stepCtx, step)
+	}
+	if err != nil && ctx.Err() == nil && stepCtx.Err() == context.DeadlineExceeded {
+		return errors.Wrapf(stepCtx.Err(), "timed out after %d seconds", step.Timeout)
+	}
+	if step.IsCmdStep() && !step.DisableHomeVolume {
+		// The step may have logged in to registries with `docker login`.
+		b.invalidateDockerConfig()
+	}
+	// Steps without the home volume can't write outputs, and only the outputs of steps with dependents are used.
+	if err != nil || !step.IsCmdStep() || step.DisableHomeVolume || !b.outputSteps[step.ID] {
+		return err
+	}
+
+	outputs, err := b.readStepOutputs(ctx, step.ID)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read the outputs of step ID: %s", step.ID)
+	}
+	step.Outputs = outputs
+	return nil
+}
+
+// builtWithBuildkit returns true if the build step's image was built using buildkit, in which case its
+// base images aren't in the Docker store.
+func builtWithBuildkit(step *graph.Step) bool {
+	return (step.UseBuildCacheForBuildStep() && runtime.GOOS == util.LinuxOS) || step.UsesBuildkit
 }
 
 // getPopulateDigests populates digests on dependencies
 func (b *Builder) getPopulateDigests(ctx context.Context, dependencies []*image.Dependencies, usingBuildkit bool, registryCreds graph.RegistryLoginCredentials) error {
-	dockerStoreDigester := newDockerStoreDigest(b.procManager, b.debug)
+	dockerStoreDigester := newDockerStoreDigest(b)
 
 	var baseImgDigester DigestHelper
 	baseImgDigester = dockerStoreDigester
@@ -390,21 +563,31 @@ func validateDockerContext(sourceContext string) {
 	}
 }
 
+// pullImageBeforeRun pulls the image of a command step, using the credentials of its registry if the Task logged in to it.
 func (b *Builder) pullImageBeforeRun(ctx context.Context, cmdArgs string, retries, retryDelayInSeconds int) error {
 	imageName := parseImageNameFromArgs(cmdArgs)
-	args := []string{
-		"docker",
-		"run",
-		"--rm",
-		"--volume", util.DockerSocketVolumeMapping,
-		"docker",
-		"pull",
-		imageName,
+	pullAuth, err := b.registryAuthConfig(ctx, imageName)
+	if err != nil {
+		return err
 	}
 	if b.debug {
-		log.Printf("pull image args: %v\n", args)
+		log.Printf("Pulling image: %s\n", imageName)
+	}
+
+	stdout := redact.NewWriter(os.Stdout)
+	defer func() { _ = stdout.Flush() }()
+	for attempt := 0; ; attempt++ {
+		err = b.containerRuntime.Pull(ctx, imageName, pullAuth, stdout)
+		if err == nil || attempt >= retries {
+			return err
+		}
+		log.Printf("Failed to pull image: %s, waiting %d seconds before retrying, err: %v\n", imageName, retryDelayInSeconds, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(retryDelayInSeconds) * time.Second):
+		}
 	}
-	return b.procManager.RunWithRetries(ctx, args, nil, os.Stdout, os.Stdout, "", retries, nil, retryDelayInSeconds, "")
 }
 
 // parseImageNameFromArgs parses an image's name from a command step's arguments.
@@ -418,23 +601,22 @@ func parseImageNameFromArgs(cmdArgs string) string {
 
 // preRunWindowsContainer runs a Windows Server 2019 Hyper-V container to ensure subsequent runs succeed.
 func (b *Builder) preRunWindowsContainer(ctx context.Context, step *graph.Step) {
-	preRunArgs := []string{
-		"docker",
-		"run",
-		"--rm",
-		"--name", step.ID + "_prerun",
-		"--isolation", "hyperv",
-		"--pull", "always",
-		WindowServerCore2019Image,
+	// Silently run the command to not confuse the user. Only expose error in debug mode.
+	var stdErrBuf bytes.Buffer
+	opts := &container.RunOptions{
+		Name:      step.ID + "_prerun",
+		Image:     WindowServerCore2019Image,
+		Isolation: "hyperv",
+		Pull:      true,
+		Remove:    true,
+		Stderr:    &stdErrBuf,
 	}
 
 	if b.debug {
-		log.Printf("Pre-run args: %v\n", strings.Join(preRunArgs, ", "))
+		log.Printf("Pre-run container: %s, image: %s\n", opts.Name, opts.Image)
 	}
 
-	// Silently run the command to not confuse the user. Only expose error in debug mode.
-	var stdErrBuf bytes.Buffer
-	err := b.procManager.Run(ctx, preRunArgs, nil, nil, &stdErrBuf, "")
+	err := b.runContainer(ctx, opts)
 	if b.debug {
 		if err != nil {
 			log.Printf("Pre-run ran with error: %s\n", stdErrBuf.String())
@@ -503,25 +685,28 @@ func (b *Builder) createSecretFiles(ctx context.Context, volMount *volume.Volume
 
 // populateSecretVolume mounts all files of type source Secret generated into a volume
 func (b *Builder) populateSecretVolume(ctx context.Context, volMount *volume.Volume) error {
-	var dataContainerArgs []string
-	var dataSB strings.Builder
-	dataContainerArgs = getShell()
+	var buf bytes.Buffer
+	opts := &container.RunOptions{
+		Name:   fmt.Sprintf("acb_secret_volume_%s", uuid.New()),
+		Image:  configImageName,
+		Remove: true,
+		Stdout: &buf,
+		Stderr: &buf,
+	}
 	if runtime.GOOS == util.WindowsOS {
-		dataSB.WriteString("docker run --rm -v " + b.workspaceDir + ":c:\\source -v ")
-		dataSB.WriteString(volMount.Name + ":c:\\dest -w c:\\source ")
-		dataSB.WriteString(configImageName + " cmd.exe /c copy c:\\source\\" + volMount.Name + " c:\\dest")
+		opts.Binds = []string{b.workspaceDir + ":c:\\source", volMount.Name + ":c:\\dest"}
+		opts.WorkingDir = "c:\\source"
+		opts.Cmd = []string{"cmd.exe", "/c", "copy", "c:\\source\\" + volMount.Name, "c:\\dest"}
 	} else {
-		dataSB.WriteString("docker run --rm -v " + b.workspaceDir + ":/source -v ")
-		dataSB.WriteString(volMount.Name + ":/dest -w /source " + configImageName + " cp ")
+		opts.Binds = []string{b.workspaceDir + ":/source", volMount.Name + ":/dest"}
+		opts.WorkingDir = "/source"
+		opts.Cmd = []string{"cp"}
 		for k := range volMount.Source.Secret {
-			dataSB.WriteString(volMount.Name + "/" + k)
-			dataSB.WriteString(" ")
+			opts.Cmd = append(opts.Cmd, volMount.Name+"/"+k)
 		}
-		dataSB.WriteString("/dest")
+		opts.Cmd = append(opts.Cmd, "/dest")
 	}
-	dataContainerArgs = append(dataContainerArgs, dataSB.String())
-	var buf bytes.Buffer
-	if err := b.procManager.Run(ctx, dataContainerArgs, nil, &buf, &buf, ""); err != nil {
+	if err := b.runContainer(ctx, opts); err != nil {
 		return errors.Wrapf(err, "failed to populate container, %s", buf.String())
 	}
 	return nil
@@ -10,6 +10,12 @@ const (
 	// containerWorkspaceDir is the default working directory for a container.
 	containerWorkspaceDir = "/workspace"
 
+	// stepOutputsDir is the directory in $HOME containing each step's output file.
+	stepOutputsDir = homeWorkDir + "/.acb/outputs/"
+
+	// dockerConfigFile is the Docker CLI's configuration file in $HOME, which holds its registry logins.
+	dockerConfigFile = homeWorkDir + "/.docker/config.json"
+
 	configImageName = "bash"
 )
 
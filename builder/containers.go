@@ -0,0 +1,90 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"io"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/Azure/acr-builder/pkg/container"
+	"github.com/Azure/acr-builder/pkg/procmanager"
+)
+
+// runInContainer runs the command which starts the named container, tracking the container for teardown while
+// it runs. If the context is done, the container is stopped in case the command couldn't stop it: it's sent SIGTERM
+// and, if it hasn't exited after stopTimeout, SIGKILL. A container which couldn't be stopped remains tracked.
+func (b *Builder) runInContainer(ctx context.Context, name string, stopTimeout time.Duration, run func() error) error {
+	b.trackContainer(name)
+	err := run()
+	if ctx.Err() != nil && !b.stopContainer(name, stopTimeout) {
+		return err
+	}
+	b.untrackContainer(name)
+	return err
+}
+
+// runContainer runs the container described by opts with the container runtime, tracking it for teardown while
+// it runs.
+func (b *Builder) runContainer(ctx context.Context, opts *container.RunOptions) error {
+	return b.runInContainer(ctx, opts.Name, container.DefaultStopTimeout, func() error {
+		_, err := b.containerRuntime.Run(ctx, opts)
+		return err
+	})
+}
+
+// runFunc returns a procmanager.RunFunc which runs the container described by opts, so it can be retried and
+// repeated. Each run writes the container's output to the writers it's given.
+func (b *Builder) runFunc(opts *container.RunOptions) procmanager.RunFunc {
+	return func(ctx context.Context, stdout io.Writer, stderr io.Writer) error {
+		runOpts := *opts
+		runOpts.Stdout, runOpts.Stderr = stdout, stderr
+		_, err := b.containerRuntime.Run(ctx, &runOpts)
+		return err
+	}
+}
+
+// stopContainer stops the named container, using a separate context since the run's context may be done.
+// It returns false if the container couldn't be stopped.
+func (b *Builder) stopContainer(name string, stopTimeout time.Duration) bool {
+	log.Printf("Stopping container: %s, grace period: %v\n", name, stopTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout+container.DefaultStopTimeout)
+	defer cancel()
+	if err := b.containerRuntime.Stop(ctx, name, stopTimeout); err != nil && !container.IsNotFound(err) {
+		log.Printf("Failed to stop container: %s, err: %v\n", name, err)
+		return false
+	}
+	return true
+}
+
+// trackContainer records a container started by the Builder, so it's removed when the Task is cleaned up.
+func (b *Builder) trackContainer(name string) {
+	b.containersMu.Lock()
+	defer b.containersMu.Unlock()
+	if b.containers == nil {
+		b.containers = make(map[string]bool)
+	}
+	b.containers[name] = true
+}
+
+// untrackContainer removes the record of a container which no longer needs to be removed.
+func (b *Builder) untrackContainer(name string) {
+	b.containersMu.Lock()
+	defer b.containersMu.Unlock()
+	delete(b.containers, name)
+}
+
+// trackedContainers returns the names of the containers started by the Builder which may still exist.
+func (b *Builder) trackedContainers() []string {
+	b.containersMu.Lock()
+	defer b.containersMu.Unlock()
+	names := make([]string, 0, len(b.containers))
+	for name := range b.containers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
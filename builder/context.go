@@ -13,8 +13,10 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/container"
 	"github.com/Azure/acr-builder/pkg/image"
 	"github.com/Azure/acr-builder/util"
 	"github.com/google/uuid"
@@ -25,12 +27,16 @@ var (
 	dependenciesRE = regexp.MustCompile(`(\[{"image.*?\])$`)
 )
 
-// getDockerRunArgs populates the args for running a Docker container.
-func (b *Builder) getDockerRunArgs(
+// getRunOptions populates the options for running a Docker container. Values such as environment variables
+// are passed to the container verbatim. The command is split into the image and its arguments like a shell
+// would, but isn't otherwise interpreted.
+func (b *Builder) getRunOptions(
 	volMounts map[string]string,
 	volName string,
 	workDir string,
 	disableWorkDirOverride bool,
+	disableDockerSocket bool,
+	disableHomeVolume bool,
 	remove bool,
 	detach bool,
 	envs []string,
@@ -43,85 +49,65 @@ func (b *Builder) getDockerRunArgs(
 	cpus string,
 	entrypoint string,
 	containerName string,
-	cmd string) []string {
-	var args []string
-	var sb strings.Builder
-	// Run user commands from a shell instance in order to mirror the shell's field splitting algorithms,
-	// so we don't have to write our own argv parser for exec.Command.
-	if runtime.GOOS == util.WindowsOS {
-		args = []string{"powershell.exe", "-Command"}
-	} else {
-		args = []string{"/bin/sh", "-c"}
-	}
-
-	sb.WriteString("docker run")
-	if remove {
-		sb.WriteString(" --rm")
-	}
-	if detach {
-		sb.WriteString(" --detach")
-	}
-	for _, port := range ports {
-		sb.WriteString(" -p " + port)
+	cmd string) (*container.RunOptions, error) {
+	cmdArgs, err := util.SplitWords(cmd)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse the command")
 	}
-	for _, exp := range expose {
-		sb.WriteString(" --expose " + exp)
+	if len(cmdArgs) == 0 {
+		return nil, errors.New("the command is empty")
 	}
-	if privilaged {
-		sb.WriteString(" --privileged")
+
+	opts := &container.RunOptions{
+		Name:       containerName,
+		Image:      cmdArgs[0],
+		Cmd:        cmdArgs[1:],
+		Remove:     remove,
+		Detach:     detach,
+		Ports:      ports,
+		Expose:     expose,
+		Privileged: privilaged,
+		User:       user,
+		Network:    network,
+		Isolation:  isolation,
+		CPUs:       cpus,
 	}
-	if user != "" {
-		sb.WriteString(" --user " + user)
+	if entrypoint != "" {
+		opts.Entrypoint = []string{entrypoint}
 	}
-	if network != "" {
-		sb.WriteString(" --network " + network)
+	opts.Binds = append(opts.Binds, volName+":"+containerWorkspaceDir)
+	if !disableDockerSocket {
+		opts.Binds = append(opts.Binds, util.DockerSocketVolumeMapping)
 	}
-	if isolation != "" {
-		sb.WriteString(" --isolation " + isolation)
+	if !disableHomeVolume {
+		opts.Binds = append(opts.Binds, homeVol+":"+homeWorkDir)
 	}
-	if cpus != "" {
-		sb.WriteString(" --cpus " + cpus)
+	for key, val := range volMounts {
+		opts.Binds = append(opts.Binds, key+":"+val)
 	}
-	if entrypoint != "" {
-		sb.WriteString(" --entrypoint " + entrypoint)
+	if !disableHomeVolume {
+		opts.Env = append(opts.Env, homeEnv)
 	}
-	sb.WriteString(" --name " + containerName)
-	sb.WriteString(" --volume " + volName + ":" + containerWorkspaceDir)
-	sb.WriteString(" --volume " + util.DockerSocketVolumeMapping)
-	sb.WriteString(" --volume " + homeVol + ":" + homeWorkDir)
-	if len(volMounts) > 0 {
-		for key, val := range volMounts {
-			sb.WriteString(" --volume " + key + ":" + val)
-		}
-	}
-	sb.WriteString(" --env " + homeEnv)
 
 	// User environment variables come after any defaults.
 	// This allows overriding the HOME environment variable for a step.
 	// NB: this has the assumption that the underlying runtime handles the case of duplicated
 	// environment variables by only keeping the last specified.
-	for _, env := range envs {
-		sb.WriteString(" --env " + env)
-	}
+	opts.Env = append(opts.Env, envs...)
 
 	if !disableWorkDirOverride {
-		sb.WriteString(" --workdir " + normalizeWorkDir(workDir))
+		opts.WorkingDir = normalizeWorkDir(workDir)
 	}
-	sb.WriteString(" " + cmd)
-
-	args = append(args, sb.String())
-	return args
+	return opts, nil
 }
 
-// getDockerRunArgsForStep populates the args for running a Docker container for the step.
-func (b *Builder) getDockerRunArgsForStep(
+// getRunOptionsForStep populates the options for running a Docker container for the step.
+func (b *Builder) getRunOptionsForStep(
 	volName string,
 	stepWorkDir string,
 	step *graph.Step,
 	entrypoint string,
-	cmd string) []string {
-	// Run user commands from a shell instance in order to mirror the shell's field splitting algorithms,
-	// so we don't have to write our own argv parser for exec.Command.
+	cmd string) (*container.RunOptions, error) {
 	if runtime.GOOS == util.WindowsOS && step.Isolation == "" && !step.IsBuildStep() {
 		// Use hyperv isolation for non-build steps.
 		// Use default isolation for build step to improve performance. It assumes the docker-cli image is compatible with the host os.
@@ -138,14 +124,22 @@ func (b *Builder) getDockerRunArgsForStep(
 		volMounts[mount.Name] = mount.MountPath
 	}
 
-	return b.getDockerRunArgs(
+	envs := step.Envs
+	// Outputs are written to the home volume.
+	if step.IsCmdStep() && !step.DisableHomeVolume {
+		envs = append([]string{graph.OutputEnvName + "=" + b.stepOutputFile(step.ID)}, envs...)
+	}
+
+	opts, err := b.getRunOptions(
 		volMounts,
 		volName,
 		stepWorkDir,
 		step.DisableWorkingDirectoryOverride,
+		step.DisableDockerSocket,
+		step.DisableHomeVolume,
 		!step.Keep,
 		step.Detach,
-		step.Envs,
+		envs,
 		step.Ports,
 		step.Expose,
 		step.Privileged,
@@ -157,6 +151,48 @@ func (b *Builder) getDockerRunArgsForStep(
 		step.ID,
 		cmd,
 	)
+	if err != nil {
+		return nil, err
+	}
+	// The options of a build step apply to the build rather than to the container which runs the Docker CLI.
+	if !step.IsBuildStep() {
+		setContainerOptions(opts, step)
+	}
+	opts.StopTimeout = time.Duration(step.StopTimeout) * time.Second
+	return opts, nil
+}
+
+// setContainerOptions sets the resource limits and security options of the step on the options of its container.
+func setContainerOptions(opts *container.RunOptions, step *graph.Step) {
+	opts.Memory = step.Memory
+	opts.MemorySwap = step.MemorySwap
+	opts.ShmSize = step.ShmSize
+	opts.PidsLimit = step.PidsLimit
+	opts.Ulimits = step.Ulimits
+	opts.CapAdd = step.CapAdd
+	opts.CapDrop = step.CapDrop
+	opts.SecurityOpt = step.SecurityOpt
+	opts.ReadOnly = step.ReadOnlyRootFilesystem
+	opts.Tmpfs = step.Tmpfs
+	opts.DNS = step.DNS
+	opts.ExtraHosts = step.ExtraHosts
+	opts.Labels = step.Labels
+	opts.Devices = step.Devices
+}
+
+// getBuildOptionArgs returns the docker build flags for the build step's resource limits, followed by a space.
+func getBuildOptionArgs(step *graph.Step) string {
+	var sb strings.Builder
+	if step.Memory != "" {
+		sb.WriteString("--memory " + step.Memory + " ")
+	}
+	if step.ShmSize != "" {
+		sb.WriteString("--shm-size " + step.ShmSize + " ")
+	}
+	for _, ulimit := range step.Ulimits {
+		sb.WriteString("--ulimit " + ulimit + " ")
+	}
+	return sb.String()
 }
 
 func (b *Builder) scrapeDependencies(
@@ -172,7 +208,7 @@ func (b *Builder) scrapeDependencies(
 	credentials []*graph.RegistryCredential) ([]*image.Dependencies, error) {
 	containerName := fmt.Sprintf("acb_dep_scanner_%s", uuid.New())
 
-	args, censoredArgs, err := getScanArgs(
+	opts, censoredCmd, err := getScanRunOptions(
 		containerName,
 		volName,
 		containerWorkspaceDir,
@@ -190,11 +226,15 @@ func (b *Builder) scrapeDependencies(
 	}
 
 	if b.debug {
-		log.Printf("Scan args: %v\n", censoredArgs)
+		log.Printf("Scan args: %v\n", censoredCmd)
 	}
 
 	var buf bytes.Buffer
-	err = b.procManager.Run(ctx, args, nil, &buf, &buf, "")
+	opts.Stdout, opts.Stderr = &buf, &buf
+	err = b.runInContainer(ctx, containerName, container.DefaultStopTimeout, func() error {
+		_, runErr := b.containerRuntime.Run(ctx, opts)
+		return runErr
+	})
 	output := strings.TrimSpace(buf.String())
 	if err != nil {
 		log.Printf("Output from dependency scanning: %s\n", output)
@@ -204,7 +244,9 @@ func (b *Builder) scrapeDependencies(
 	return getImageDependencies(output)
 }
 
-func getScanArgs(
+// getScanRunOptions returns the options for running the dependency scanner, and its command with the
+// credentials censored.
+func getScanRunOptions(
 	containerName string,
 	volName string,
 	containerWorkspaceDir string,
@@ -215,54 +257,54 @@ func getScanArgs(
 	buildArgs []string,
 	target string,
 	sourceContext string,
-	credentials []*graph.RegistryCredential) ([]string, []string, error) {
-	args := []string{
-		"docker",
-		"run",
-		"--rm",
-		"--name", containerName,
-		"--volume", volName + ":" + containerWorkspaceDir,
-		"--workdir", normalizeWorkDir(stepWorkDir),
-
-		// Mount home
-		"--volume", homeVol + ":" + homeWorkDir,
-		"--env", homeEnv,
-
-		scannerImageName,
+	credentials []*graph.RegistryCredential) (*container.RunOptions, []string, error) {
+	cmd := []string{
 		"scan",
 		"-f", dockerfile,
 		"--destination", outputDir,
 	}
 
 	for _, tag := range tags {
-		args = append(args, "-t", tag)
+		cmd = append(cmd, "-t", tag)
 	}
 
 	for _, buildArg := range buildArgs {
-		args = append(args, "--build-arg", buildArg)
+		cmd = append(cmd, "--build-arg", buildArg)
 	}
 
-	var censoredArgs = make([]string, len(args))
-	copy(censoredArgs, args)
+	var censoredCmd = make([]string, len(cmd))
+	copy(censoredCmd, cmd)
 
 	for _, cred := range credentials {
 		serializedCredential, err := cred.String()
 		if err != nil {
 			return nil, nil, errors.New("credential serialization failed for given registry credential")
 		}
-		censoredArgs = append(censoredArgs, "--credential", "***")
-		args = append(args, "--credential", serializedCredential)
+		censoredCmd = append(censoredCmd, "--credential", "***")
+		cmd = append(cmd, "--credential", serializedCredential)
 	}
 
 	if len(target) > 0 {
-		censoredArgs = append(censoredArgs, "--target", target)
-		args = append(args, "--target", target)
+		censoredCmd = append(censoredCmd, "--target", target)
+		cmd = append(cmd, "--target", target)
 	}
 
 	// Positional context must appear last
-	censoredArgs = append(censoredArgs, sourceContext)
-	args = append(args, sourceContext)
-	return args, censoredArgs, nil
+	censoredCmd = append(censoredCmd, sourceContext)
+	cmd = append(cmd, sourceContext)
+
+	opts := &container.RunOptions{
+		Name:       containerName,
+		Image:      scannerImageName,
+		Cmd:        cmd,
+		Remove:     true,
+		WorkingDir: normalizeWorkDir(stepWorkDir),
+
+		// Mount home
+		Binds: []string{volName + ":" + containerWorkspaceDir, homeVol + ":" + homeWorkDir},
+		Env:   []string{homeEnv},
+	}
+	return opts, censoredCmd, nil
 }
 
 func getImageDependencies(s string) ([]*image.Dependencies, error) {
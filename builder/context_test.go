@@ -8,8 +8,10 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/container"
 	"github.com/Azure/acr-builder/pkg/image"
 	"github.com/Azure/acr-builder/util"
 )
@@ -78,123 +80,218 @@ func TestGetImageDependencies(t *testing.T) {
 	}
 }
 
-func TestGetBuildDockerRunArgs(t *testing.T) {
+func TestGetBuildRunOptions(t *testing.T) {
 	builder := &Builder{}
-	actualCmds := builder.getDockerRunArgsForStep("volName", "stepWorkDir", &graph.Step{ID: "id", Build: "-f Dockerfile .", Envs: []string{"foo=bar", "HOME=qux"}}, "", "docker build -f Dockerfile .")
-
-	var expectedCmds []string
+	actual, err := builder.getRunOptionsForStep("volName", "stepWorkDir", &graph.Step{ID: "id", Build: "-f Dockerfile .", Envs: []string{"foo=bar", "HOME=qux"}}, "", "docker build -f Dockerfile .")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
 
+	expected := &container.RunOptions{
+		Name:   "id",
+		Image:  "docker",
+		Cmd:    []string{"build", "-f", "Dockerfile", "."},
+		Remove: true,
+	}
 	if runtime.GOOS == util.WindowsOS {
-		expectedCmds = []string{
-			"powershell.exe",
-			"-Command",
-			"docker run --rm --cpus 1 --name id --volume volName:c:\\workspace --volume \\\\.\\pipe\\docker_engine:\\\\.\\pipe\\docker_engine --volume home:c:\\acb\\home --env USERPROFILE=c:\\acb\\home --env foo=bar --env HOME=qux --workdir c:\\workspace/stepWorkDir docker build -f Dockerfile .",
-		}
+		expected.CPUs = "1"
+		expected.Binds = []string{"volName:c:\\workspace", "\\\\.\\pipe\\docker_engine:\\\\.\\pipe\\docker_engine", "home:c:\\acb\\home"}
+		expected.Env = []string{"USERPROFILE=c:\\acb\\home", "foo=bar", "HOME=qux"}
+		expected.WorkingDir = "c:\\workspace/stepWorkDir"
 	} else {
-		expectedCmds = []string{
-			"/bin/sh",
-			"-c",
-			"docker run --rm --name id --volume volName:/workspace --volume /var/run/docker.sock:/var/run/docker.sock --volume home:/acb/home --env HOME=/acb/home --env foo=bar --env HOME=qux --workdir /workspace/stepWorkDir docker build -f Dockerfile .",
-		}
+		expected.Binds = []string{"volName:/workspace", "/var/run/docker.sock:/var/run/docker.sock", "home:/acb/home"}
+		expected.Env = []string{"HOME=/acb/home", "foo=bar", "HOME=qux"}
+		expected.WorkingDir = "/workspace/stepWorkDir"
 	}
 
-	if !reflect.DeepEqual(actualCmds, expectedCmds) {
-		t.Errorf("invalid docker run args, expected %v but got %v", expectedCmds, actualCmds)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("invalid run options, expected %+v but got %+v", expected, actual)
 	}
 }
 
-func TestGetNonBuildDockerRunArgs(t *testing.T) {
+func TestGetNonBuildRunOptions(t *testing.T) {
 	builder := &Builder{}
-	actualCmds := builder.getDockerRunArgsForStep("volName", "stepWorkDir", &graph.Step{ID: "id", Envs: []string{"foo=bar"}}, "", "hello-world")
-
-	var expectedCmds []string
+	actual, err := builder.getRunOptionsForStep("volName", "stepWorkDir", &graph.Step{ID: "id", Cmd: "hello-world", Envs: []string{"foo=bar"}, StopTimeout: 5}, "", "hello-world")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
 
+	expected := &container.RunOptions{
+		Name:        "id",
+		Image:       "hello-world",
+		Cmd:         []string{},
+		Remove:      true,
+		StopTimeout: 5 * time.Second,
+	}
 	if runtime.GOOS == util.WindowsOS {
-		expectedCmds = []string{
-			"powershell.exe",
-			"-Command",
-			"docker run --rm --isolation hyperv --name id --volume volName:c:\\workspace --volume \\\\.\\pipe\\docker_engine:\\\\.\\pipe\\docker_engine --volume home:c:\\acb\\home --env USERPROFILE=c:\\acb\\home --env foo=bar --workdir c:\\workspace/stepWorkDir hello-world",
-		}
+		expected.Isolation = "hyperv"
+		expected.Binds = []string{"volName:c:\\workspace", "\\\\.\\pipe\\docker_engine:\\\\.\\pipe\\docker_engine", "home:c:\\acb\\home"}
+		expected.Env = []string{"USERPROFILE=c:\\acb\\home", "ACB_OUTPUT=c:\\acb\\home\\.acb\\outputs\\id", "foo=bar"}
+		expected.WorkingDir = "c:\\workspace/stepWorkDir"
 	} else {
-		expectedCmds = []string{
-			"/bin/sh",
-			"-c",
-			"docker run --rm --name id --volume volName:/workspace --volume /var/run/docker.sock:/var/run/docker.sock --volume home:/acb/home --env HOME=/acb/home --env foo=bar --workdir /workspace/stepWorkDir hello-world",
-		}
+		expected.Binds = []string{"volName:/workspace", "/var/run/docker.sock:/var/run/docker.sock", "home:/acb/home"}
+		expected.Env = []string{"HOME=/acb/home", "ACB_OUTPUT=/acb/home/.acb/outputs/id", "foo=bar"}
+		expected.WorkingDir = "/workspace/stepWorkDir"
 	}
 
-	if !reflect.DeepEqual(actualCmds, expectedCmds) {
-		t.Errorf("invalid docker run args, expected %v but got %v", expectedCmds, actualCmds)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("invalid run options, expected %+v but got %+v", expected, actual)
 	}
 }
 
-func TestGetScanArgs(t *testing.T) {
-	tests := []struct {
-		containerName         string
-		volName               string
-		containerWorkspaceDir string
-		stepWorkDir           string
-		dockerfile            string
-		outputDir             string
-		tags                  []string
-		buildArgs             []string
-		target                string
-		context               string
-		creds                 []string
-		expected              string
-	}{
-		{
-			"containerName",
-			"volumeName",
-			"workspaceDir",
-			"workingDirectory",
-			"Dockerfile",
-			"OutputDirectory",
-			[]string{"tag1", "tag2"},
-			[]string{"arg1=a", "arg2=b"},
-			"build",
-			"someContext",
-			[]string{`{"registry":"foo.azurecr.io","username":"user","userNameProviderType":"opaque","password":"pw","passwordProviderType":"opaque"}`},
-			"docker run --rm " +
-				"--name containerName " +
-				"--volume volumeName" + ":workspaceDir " +
-				"--workdir " + normalizeWorkDir("workingDirectory") + " " +
-				"--volume " + homeVol + ":" + homeWorkDir + " " +
-				"--env " + homeEnv + " " +
-				"acb scan -f Dockerfile --destination OutputDirectory " +
-				"-t tag1 -t tag2 --build-arg arg1=a --build-arg arg2=b " +
-				"--credential {\"registry\":\"foo.azurecr.io\",\"username\":\"user\",\"userNameProviderType\":\"opaque\",\"password\":\"pw\",\"passwordProviderType\":\"opaque\"} " +
-				"--target build someContext",
+func TestGetRunOptions_DisableMounts(t *testing.T) {
+	if runtime.GOOS == util.WindowsOS {
+		t.Skip("the expected options are for Linux")
+	}
+	builder := &Builder{}
+	step := &graph.Step{ID: "id", Cmd: "hello-world", DisableDockerSocket: true, DisableHomeVolume: true}
+	actual, err := builder.getRunOptionsForStep("volName", "stepWorkDir", step, "", "hello-world")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if expected := []string{"volName:/workspace"}; !reflect.DeepEqual(actual.Binds, expected) {
+		t.Errorf("expected binds %v but got %v", expected, actual.Binds)
+	}
+	if len(actual.Env) != 0 {
+		t.Errorf("expected no environment variables but got %v", actual.Env)
+	}
+}
+
+func TestGetRunOptions_VerbatimValues(t *testing.T) {
+	builder := &Builder{}
+	envs := []string{
+		`CONFIG={"a": 1, "b": [2, 3]}`,
+		"CONN=Server=tcp:db;User ID=admin;Password=p@ss w0rd$1",
+		`QUOTES=it's "quoted"`,
+	}
+	step := &graph.Step{ID: "id", Cmd: "alpine", Envs: envs, DisableHomeVolume: true}
+	actual, err := builder.getRunOptionsForStep("volName", "stepWorkDir", step, "", `alpine sh -c 'echo "$CONFIG" > out.json; cat out.json'`)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !reflect.DeepEqual(actual.Env, envs) {
+		t.Errorf("expected environment variables %q but got %q", envs, actual.Env)
+	}
+	if actual.Image != "alpine" {
+		t.Errorf("expected image alpine but got %s", actual.Image)
+	}
+	if expected := []string{"sh", "-c", `echo "$CONFIG" > out.json; cat out.json`}; !reflect.DeepEqual(actual.Cmd, expected) {
+		t.Errorf("expected command %q but got %q", expected, actual.Cmd)
+	}
+
+	if _, err := builder.getRunOptionsForStep("volName", "stepWorkDir", step, "", `alpine echo "unterminated`); err == nil {
+		t.Error("expected an unterminated quote in the command to fail")
+	}
+}
+
+func TestSetContainerOptions(t *testing.T) {
+	step := &graph.Step{
+		ID:                     "id",
+		Cmd:                    "hello-world",
+		Memory:                 "512m",
+		MemorySwap:             "1g",
+		ShmSize:                "64m",
+		PidsLimit:              100,
+		Ulimits:                []string{"nofile=1024:2048"},
+		CapAdd:                 []string{"NET_ADMIN"},
+		CapDrop:                []string{"ALL"},
+		SecurityOpt:            []string{"no-new-privileges"},
+		ReadOnlyRootFilesystem: true,
+		Tmpfs:                  []string{"/tmp:size=64m"},
+		DNS:                    []string{"8.8.8.8"},
+		ExtraHosts:             []string{"db:10.0.0.2"},
+		Labels:                 []string{"team=acr"},
+		Devices:                []string{"/dev/fuse"},
+	}
+	expected := &container.RunOptions{
+		Memory:      "512m",
+		MemorySwap:  "1g",
+		ShmSize:     "64m",
+		PidsLimit:   100,
+		Ulimits:     []string{"nofile=1024:2048"},
+		CapAdd:      []string{"NET_ADMIN"},
+		CapDrop:     []string{"ALL"},
+		SecurityOpt: []string{"no-new-privileges"},
+		ReadOnly:    true,
+		Tmpfs:       []string{"/tmp:size=64m"},
+		DNS:         []string{"8.8.8.8"},
+		ExtraHosts:  []string{"db:10.0.0.2"},
+		Labels:      []string{"team=acr"},
+		Devices:     []string{"/dev/fuse"},
+	}
+	actual := &container.RunOptions{}
+	setContainerOptions(actual, step)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected container options %+v but got %+v", expected, actual)
+	}
+}
+
+func TestGetBuildOptionArgs(t *testing.T) {
+	step := &graph.Step{ID: "id", Build: ".", Memory: "2g", ShmSize: "64m", Ulimits: []string{"nofile=1024:2048", "nproc=512"}}
+	expected := "--memory 2g --shm-size 64m --ulimit nofile=1024:2048 --ulimit nproc=512 "
+	if actual := getBuildOptionArgs(step); actual != expected {
+		t.Errorf("expected build option args %q but got %q", expected, actual)
+	}
+	if actual := getBuildOptionArgs(&graph.Step{ID: "id", Build: "."}); actual != "" {
+		t.Errorf("expected no build option args but got %q", actual)
+	}
+
+	// The options apply to the build, not to the container which runs the Docker CLI.
+	opts, err := (&Builder{}).getRunOptionsForStep("volName", "", step, "", "docker build "+expected+".")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if opts.Memory != "" || opts.ShmSize != "" || len(opts.Ulimits) != 0 {
+		t.Errorf("expected the build step's container to have no resource limits but got %+v", opts)
+	}
+}
+
+func TestGetScanRunOptions(t *testing.T) {
+	opts, censoredCmd, err := getScanRunOptions(
+		"containerName",
+		"volumeName",
+		"workspaceDir",
+		"workingDirectory",
+		"Dockerfile",
+		"OutputDirectory",
+		[]string{"tag1", "tag2"},
+		[]string{"arg1=a", "arg2=b"},
+		"build",
+		"someContext",
+		[]*graph.RegistryCredential{
+			{
+				Registry:     "foo.azurecr.io",
+				Username:     "user",
+				UsernameType: "opaque",
+				Password:     "pw",
+				PasswordType: "opaque",
+			},
+		})
+	if err != nil {
+		t.Fatal("Failed to serialize provided credentials")
+	}
+
+	expected := &container.RunOptions{
+		Name:  "containerName",
+		Image: scannerImageName,
+		Cmd: []string{
+			"scan", "-f", "Dockerfile", "--destination", "OutputDirectory",
+			"-t", "tag1", "-t", "tag2", "--build-arg", "arg1=a", "--build-arg", "arg2=b",
+			"--credential", `{"registry":"foo.azurecr.io","username":"user","userNameProviderType":"opaque","password":"pw","passwordProviderType":"opaque"}`,
+			"--target", "build", "someContext",
 		},
+		Remove:     true,
+		WorkingDir: normalizeWorkDir("workingDirectory"),
+		Binds:      []string{"volumeName:workspaceDir", homeVol + ":" + homeWorkDir},
+		Env:        []string{homeEnv},
+	}
+	if !reflect.DeepEqual(opts, expected) {
+		t.Fatalf("Expected\n%+v\nbut got\n%+v", expected, opts)
 	}
 
-	for _, test := range tests {
-		args, _, err := getScanArgs(
-			test.containerName,
-			test.volName,
-			test.containerWorkspaceDir,
-			test.stepWorkDir,
-			test.dockerfile,
-			test.outputDir,
-			test.tags,
-			test.buildArgs,
-			test.target,
-			test.context,
-			[]*graph.RegistryCredential{
-				{
-					Registry:     "foo.azurecr.io",
-					Username:     "user",
-					UsernameType: "opaque",
-					Password:     "pw",
-					PasswordType: "opaque",
-				},
-			})
-		if err != nil {
-			t.Fatal("Failed to serialize provided credentials")
-		}
-		actual := strings.Join(args, " ")
-		if test.expected != actual {
-			t.Fatalf("Expected\n%s\nbut got\n%s", test.expected, actual)
-		}
+	expectedCensored := "scan -f Dockerfile --destination OutputDirectory -t tag1 -t tag2 " +
+		"--build-arg arg1=a --build-arg arg2=b --credential *** --target build someContext"
+	if actual := strings.Join(censoredCmd, " "); actual != expectedCensored {
+		t.Fatalf("Expected\n%s\nbut got\n%s", expectedCensored, actual)
 	}
 }
@@ -7,24 +7,24 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"strings"
 
+	"github.com/Azure/acr-builder/pkg/container"
 	"github.com/Azure/acr-builder/pkg/image"
-	"github.com/Azure/acr-builder/pkg/procmanager"
 	"github.com/Azure/acr-builder/util"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 )
 
 type dockerStoreDigest struct {
-	procManager *procmanager.ProcManager
-	debug       bool
+	builder *Builder
 }
 
-func newDockerStoreDigest(procManager *procmanager.ProcManager, debug bool) *dockerStoreDigest {
+func newDockerStoreDigest(b *Builder) *dockerStoreDigest {
 	return &dockerStoreDigest{
-		procManager: procManager,
-		debug:       debug,
+		builder: b,
 	}
 }
 
@@ -43,27 +43,23 @@ func (d *dockerStoreDigest) PopulateDigest(ctx context.Context, reference *image
 	if reference.Reference == NoBaseImageSpecifierLatest {
 		return nil
 	}
-	args := []string{
-		"docker",
-		"run",
-		"--rm",
+	var buf bytes.Buffer
+	opts := &container.RunOptions{
+		Name:   fmt.Sprintf("acb_query_digest_%s", uuid.New()),
+		Image:  dockerCLIImageName,
+		Cmd:    []string{"inspect", "--format", "{{json .RepoDigests}}", reference.Reference},
+		Remove: true,
 
 		// Mount home
-		"--volume", util.DockerSocketVolumeMapping,
-		"--volume", homeVol + ":" + homeWorkDir,
-		"--env", homeEnv,
-
-		"docker",
-		"inspect",
-		"--format",
-		"\"{{json .RepoDigests}}\"",
-		reference.Reference,
+		Binds:  []string{util.DockerSocketVolumeMapping, homeVol + ":" + homeWorkDir},
+		Env:    []string{homeEnv},
+		Stdout: &buf,
+		Stderr: &buf,
 	}
-	if d.debug {
-		log.Printf("query digest args: %v\n", args)
+	if d.builder.debug {
+		log.Printf("query digest cmd: %v\n", opts.Cmd)
 	}
-	var buf bytes.Buffer
-	if err := d.procManager.Run(ctx, args, nil, &buf, &buf, ""); err != nil {
+	if err := d.builder.runContainer(ctx, opts); err != nil {
 		return errors.Wrapf(err, "failed to query digests, msg: %s", buf.String())
 	}
 	trimCharPredicate := func(c rune) bool {
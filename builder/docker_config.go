@@ -0,0 +1,100 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/pkg/errors"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+// dockerConfig is the part of the Docker CLI's configuration file which holds its registry logins.
+type dockerConfig struct {
+	Auths map[string]dockerConfigAuth `json:"auths"`
+}
+
+// dockerConfigAuth is a registry login of the Docker CLI. Auth is the base64 encoded username and password.
+type dockerConfigAuth struct {
+	Auth          string `json:"auth,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// parseDockerConfig parses the registry logins of a Docker CLI configuration file by registry.
+// Logins stored by credential helpers can't be read and are ignored.
+func parseDockerConfig(data string) (map[string]auth.Credential, error) {
+	creds := make(map[string]auth.Credential)
+	if strings.TrimSpace(data) == "" {
+		return creds, nil
+	}
+	var config dockerConfig
+	if err := json.Unmarshal([]byte(data), &config); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the Docker config")
+	}
+	for key, a := range config.Auths {
+		cred := auth.Credential{Username: a.Username, Password: a.Password, RefreshToken: a.IdentityToken}
+		if a.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth of registry %s in the Docker config: %v", key, err)
+			}
+			user, pw, ok := strings.Cut(string(decoded), ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid auth of registry %s in the Docker config: missing password", key)
+			}
+			cred.Username, cred.Password = user, pw
+		}
+		if cred == auth.EmptyCredential {
+			continue
+		}
+		creds[dockerConfigRegistry(key)] = cred
+	}
+	return creds, nil
+}
+
+// dockerConfigRegistry returns the registry of a key of the Docker config's logins, which may be a URL,
+// e.g. https://index.docker.io/v1/ for Docker Hub.
+func dockerConfigRegistry(key string) string {
+	registry := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	registry, _, _ = strings.Cut(registry, "/")
+	switch registry {
+	case "index.docker.io", dockerHubRegistryHost:
+		return dockerHubRegistry
+	}
+	return registry
+}
+
+// dockerConfigCredential returns the credential of the registry in the home volume's Docker config, which
+// holds the logins of `docker login` in cmd steps. The config is read again after any cmd step which mounts
+// the home volume has run.
+func (b *Builder) dockerConfigCredential(ctx context.Context, registry string) (auth.Credential, bool) {
+	b.dockerConfigMu.Lock()
+	defer b.dockerConfigMu.Unlock()
+	if b.dockerConfigCreds == nil {
+		data, err := b.readHomeFile(ctx, "acb_read_docker_config", dockerConfigFile)
+		if err == nil {
+			b.dockerConfigCreds, err = parseDockerConfig(data)
+		}
+		if err != nil {
+			log.Printf("WARNING: failed to read the registry logins of the Docker config, err: %v\n", err)
+			return auth.EmptyCredential, false
+		}
+	}
+	cred, ok := b.dockerConfigCreds[registry]
+	return cred, ok
+}
+
+// invalidateDockerConfig makes the Docker config be read again the next time a credential is looked up.
+func (b *Builder) invalidateDockerConfig() {
+	b.dockerConfigMu.Lock()
+	defer b.dockerConfigMu.Unlock()
+	b.dockerConfigCreds = nil
+}
@@ -0,0 +1,126 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/acr-builder/pkg/container"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+// dockerConfigRuntime is a container runtime whose home volume has the specified Docker config.
+type dockerConfigRuntime struct {
+	container.Runtime
+	config string
+	reads  int
+}
+
+func (r *dockerConfigRuntime) Run(_ context.Context, opts *container.RunOptions) (string, error) {
+	if strings.Contains(strings.Join(opts.Cmd, " "), dockerConfigFile) {
+		r.reads++
+		_, _ = fmt.Fprint(opts.Stdout, r.config)
+	}
+	return opts.Name, nil
+}
+
+func TestParseDockerConfig(t *testing.T) {
+	config := `{
+	"auths": {
+		"https://index.docker.io/v1/": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("user:p:w")) + `"},
+		"myregistry.azurecr.io": {"username": "00000000-0000-0000-0000-000000000000", "identitytoken": "token"},
+		"helper.azurecr.io": {}
+	},
+	"credsStore": "desktop"
+}`
+	creds, err := parseDockerConfig(config)
+	if err != nil {
+		t.Fatalf("failed to parse the Docker config: %v", err)
+	}
+	expected := map[string]auth.Credential{
+		"docker.io":             {Username: "user", Password: "p:w"},
+		"myregistry.azurecr.io": {Username: "00000000-0000-0000-0000-000000000000", RefreshToken: "token"},
+	}
+	if !reflect.DeepEqual(creds, expected) {
+		t.Errorf("expected credentials %v but got %v", expected, creds)
+	}
+
+	if creds, err = parseDockerConfig(""); err != nil || len(creds) != 0 {
+		t.Errorf("expected a missing Docker config to have no credentials but got %v, err: %v", creds, err)
+	}
+	if _, err = parseDockerConfig(`{"auths": {"example.com": {"auth": "invalid"}}}`); err == nil {
+		t.Error("expected an invalid auth to fail to parse")
+	}
+}
+
+// TestPushImage_DockerConfigCredential pushes to a registry which the Task didn't log in to, but a cmd step did
+// with `docker login`.
+func TestPushImage_DockerConfigCredential(t *testing.T) {
+	store := loadTestImageArchive(t)
+	registry := &testRegistry{blobs: make(map[string]map[string]bool), manifests: make(map[string]string)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if user, pw, ok := req.BasicAuth(); !ok || user != "user" || pw != "pw" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		registry.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	img := host + "/foo:v1"
+	store.tags[img] = store.tags["docker.io/library/foo:v1"]
+
+	rt := &dockerConfigRuntime{
+		config: `{"auths": {"` + host + `": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("user:pw")) + `"}}}`,
+	}
+	b := &Builder{containerRuntime: rt}
+
+	anonymous := &auth.Client{Credential: auth.StaticCredential("example.com", auth.EmptyCredential)}
+	if _, err := pushImage(context.Background(), store, img, anonymous, make(map[string][]string)); err == nil {
+		t.Fatal("expected an anonymous push to fail")
+	}
+
+	client := &auth.Client{Cache: auth.NewCache(), Credential: b.registryCredential}
+	desc, err := pushImage(context.Background(), store, img, client, make(map[string][]string))
+	if err != nil {
+		t.Fatalf("failed to push %s: %v", img, err)
+	}
+	if actual := registry.manifests["foo:v1"]; actual != desc.Digest.String() {
+		t.Errorf("expected %s to be pushed with digest %s but got %s", img, desc.Digest, actual)
+	}
+
+	// The config is read once, and again after a cmd step may have changed it.
+	if rt.reads != 1 {
+		t.Errorf("expected the Docker config to be read once but got %d", rt.reads)
+	}
+	b.invalidateDockerConfig()
+	if _, err = b.registryCredential(context.Background(), host); err != nil || rt.reads != 2 {
+		t.Errorf("expected the Docker config to be read again but got %d reads, err: %v", rt.reads, err)
+	}
+}
+
+func TestIsLocalRegistry(t *testing.T) {
+	tests := map[string]bool{
+		"localhost":             true,
+		"localhost:5000":        true,
+		"127.0.0.1:5000":        true,
+		"[::1]:5000":            true,
+		"[::1]":                 true,
+		"myregistry.azurecr.io": false,
+		"[::2]:5000":            false,
+	}
+	for host, expected := range tests {
+		if actual := isLocalRegistry(host); actual != expected {
+			t.Errorf("expected isLocalRegistry(%s) to be %v but got %v", host, expected, actual)
+		}
+	}
+}
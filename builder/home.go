@@ -0,0 +1,37 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// readHomeFile reads the specified file from the home volume in a container named after the prefix.
+// A file which doesn't exist is read as empty.
+func (b *Builder) readHomeFile(ctx context.Context, prefix string, file string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	opts := readFileRunOptions(file)
+	opts.Name = fmt.Sprintf("%s_%s", prefix, uuid.New())
+	opts.Remove = true
+
+	// Home
+	opts.Binds = []string{homeVol + ":" + homeWorkDir}
+	opts.Env = []string{homeEnv}
+	opts.Stdout, opts.Stderr = &stdout, &stderr
+
+	timeout := time.Duration(configTimeoutInSec) * time.Second
+	readCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := b.runContainer(readCtx, opts); err != nil {
+		return "", errors.Wrapf(err, "failed to read %s, msg: %s", file, stderr.String())
+	}
+	return stdout.String(), nil
+}
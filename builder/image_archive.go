@@ -0,0 +1,303 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/errdef"
+)
+
+const (
+	dockerManifestMediaType     = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerManifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+	dockerConfigMediaType       = "application/vnd.docker.container.image.v1+json"
+	dockerLayerMediaType        = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+
+	imageArchiveManifestFile = "manifest.json"
+)
+
+// imageArchiveManifest is an entry of the manifest file in an archive created by `docker save`.
+type imageArchiveManifest struct {
+	Config       string
+	RepoTags     []string
+	Layers       []string
+	LayerSources map[digest.Digest]ocispec.Descriptor `json:",omitempty"`
+}
+
+// imageArchive is a read-only OCI store containing the images of an archive created by `docker save`.
+// Every layer is compressed once, regardless of how many of the archive's images and tags include it,
+// so that it's only uploaded once when pushing several tags.
+type imageArchive struct {
+	dir string
+
+	// files are the paths of the blobs stored on disk, by digest.
+	files map[digest.Digest]string
+
+	// manifests are the image manifests generated for the archive's images, by digest.
+	manifests map[digest.Digest][]byte
+
+	// tags are the manifests of the archive's images, by normalized image reference.
+	tags map[string]ocispec.Descriptor
+}
+
+var _ oras.ReadOnlyTarget = &imageArchive{}
+
+// errImageArchiveTooLarge is returned when an image archive exceeds its size limit.
+var errImageArchiveTooLarge = errors.New("image archive is too large")
+
+// sizeLimitedReader reads from a reader until it has read more than a limited number of bytes,
+// after which it fails with errImageArchiveTooLarge.
+type sizeLimitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func newSizeLimitedReader(r io.Reader, limit int64) *sizeLimitedReader {
+	return &sizeLimitedReader{r: r, remaining: limit}
+}
+
+func (l *sizeLimitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, errImageArchiveTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, errImageArchiveTooLarge
+	}
+	return n, err
+}
+
+// loadImageArchive extracts the archive created by `docker save`, which is read from r, to dir and creates
+// a store for its images.
+func loadImageArchive(r io.Reader, dir string) (*imageArchive, error) {
+	if err := archive.Untar(r, dir, &archive.TarOptions{NoLchown: true}); err != nil {
+		return nil, errors.Wrap(err, "failed to extract image archive")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, imageArchiveManifestFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the image archive's manifest")
+	}
+	var entries []imageArchiveManifest
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the image archive's manifest")
+	}
+
+	a := &imageArchive{
+		dir:       dir,
+		files:     make(map[digest.Digest]string),
+		manifests: make(map[digest.Digest][]byte),
+		tags:      make(map[string]ocispec.Descriptor),
+	}
+	layers := make(map[string]ocispec.Descriptor)
+	for _, entry := range entries {
+		var desc ocispec.Descriptor
+		if desc, err = a.addImage(entry, layers); err != nil {
+			return nil, err
+		}
+		for _, tag := range entry.RepoTags {
+			var name string
+			if name, err = normalizeImageTag(tag); err != nil {
+				return nil, err
+			}
+			a.tags[name] = desc
+		}
+	}
+	return a, nil
+}
+
+// addImage generates a manifest for the image described by the entry and adds it and its blobs to the store.
+// layers caches the descriptors of the layers which have already been added, by path.
+func (a *imageArchive) addImage(entry imageArchiveManifest, layers map[string]ocispec.Descriptor) (ocispec.Descriptor, error) {
+	configFile, err := a.path(entry.Config)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	configData, err := os.ReadFile(configFile)
+	if err != nil {
+		return ocispec.Descriptor{}, errors.Wrapf(err, "failed to read image config %s", entry.Config)
+	}
+	var config ocispec.Image
+	if err = json.Unmarshal(configData, &config); err != nil {
+		return ocispec.Descriptor{}, errors.Wrapf(err, "failed to parse image config %s", entry.Config)
+	}
+	if len(config.RootFS.DiffIDs) != len(entry.Layers) {
+		return ocispec.Descriptor{}, fmt.Errorf("image config %s has %d layers, but the archive contains %d", entry.Config, len(config.RootFS.DiffIDs), len(entry.Layers))
+	}
+
+	manifest := ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: dockerManifestMediaType,
+		Config: ocispec.Descriptor{
+			MediaType: dockerConfigMediaType,
+			Digest:    digest.FromBytes(configData),
+			Size:      int64(len(configData)),
+		},
+		Layers: make([]ocispec.Descriptor, 0, len(entry.Layers)),
+	}
+	a.files[manifest.Config.Digest] = configFile
+
+	for i, layer := range entry.Layers {
+		// Foreign layers, i.e. of Windows base images, are referenced by URL and aren't pushed.
+		if foreign, ok := entry.LayerSources[config.RootFS.DiffIDs[i]]; ok {
+			manifest.Layers = append(manifest.Layers, foreign)
+			continue
+		}
+
+		var layerFile string
+		if layerFile, err = a.path(layer); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		desc, ok := layers[layerFile]
+		if !ok {
+			if desc, err = a.addLayer(layerFile); err != nil {
+				return ocispec.Descriptor{}, errors.Wrapf(err, "failed to add layer %s", layer)
+			}
+			layers[layerFile] = desc
+		}
+		manifest.Layers = append(manifest.Layers, desc)
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return ocispec.Descriptor{}, errors.Wrap(err, "failed to marshal image manifest")
+	}
+	desc := ocispec.Descriptor{
+		MediaType: dockerManifestMediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	a.manifests[desc.Digest] = data
+	return desc, nil
+}
+
+// addLayer adds the layer to the store, compressing it if it isn't compressed already.
+func (a *imageArchive) addLayer(file string) (ocispec.Descriptor, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	if magic, _ := r.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return a.addFile(file, r)
+	}
+
+	compressed, err := os.CreateTemp(a.dir, "layer-*.tar.gz")
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	defer compressed.Close()
+	gw := gzip.NewWriter(compressed)
+	if _, err = io.Copy(gw, r); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if err = gw.Close(); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if _, err = compressed.Seek(0, io.SeekStart); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	desc, err := a.addFile(compressed.Name(), compressed)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	// Only the compressed layer is pushed, so the uncompressed one is removed to save disk space.
+	_ = f.Close()
+	_ = os.Remove(file)
+	return desc, nil
+}
+
+// addFile adds the compressed layer stored in file, whose content is read from r, to the store.
+func (a *imageArchive) addFile(file string, r io.Reader) (ocispec.Descriptor, error) {
+	digester := digest.Canonical.Digester()
+	size, err := io.Copy(digester.Hash(), r)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	desc := ocispec.Descriptor{
+		MediaType: dockerLayerMediaType,
+		Digest:    digester.Digest(),
+		Size:      size,
+	}
+	a.files[desc.Digest] = file
+	return desc, nil
+}
+
+// path returns the path of the specified file in the archive, which must not be outside of it.
+func (a *imageArchive) path(name string) (string, error) {
+	p := filepath.Join(a.dir, filepath.FromSlash(name))
+	if !strings.HasPrefix(p, filepath.Clean(a.dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid path %s in image archive", name)
+	}
+	return p, nil
+}
+
+// Fetch fetches the content identified by the descriptor.
+func (a *imageArchive) Fetch(_ context.Context, target ocispec.Descriptor) (io.ReadCloser, error) {
+	if data, ok := a.manifests[target.Digest]; ok {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	if file, ok := a.files[target.Digest]; ok {
+		return os.Open(file)
+	}
+	return nil, errors.Wrapf(errdef.ErrNotFound, "%s", target.Digest)
+}
+
+// Exists returns true if the described content exists.
+func (a *imageArchive) Exists(_ context.Context, target ocispec.Descriptor) (bool, error) {
+	_, isManifest := a.manifests[target.Digest]
+	_, isFile := a.files[target.Digest]
+	return isManifest || isFile, nil
+}
+
+// Resolve resolves an image reference, i.e. one of the step's push targets, to its manifest descriptor.
+func (a *imageArchive) Resolve(_ context.Context, ref string) (ocispec.Descriptor, error) {
+	name, err := normalizeImageTag(ref)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	desc, ok := a.tags[name]
+	if !ok {
+		return ocispec.Descriptor{}, errors.Wrapf(errdef.ErrNotFound, "%s", ref)
+	}
+	return desc, nil
+}
+
+// normalizeImageTag normalizes the image reference to its fully qualified tagged form,
+// i.e. hello-world becomes docker.io/library/hello-world:latest.
+func normalizeImageTag(ref string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse image reference %s", ref)
+	}
+	tagged, ok := reference.TagNameOnly(named).(reference.NamedTagged)
+	if !ok {
+		return "", fmt.Errorf("image reference %s must be tagged", ref)
+	}
+	return tagged.String(), nil
+}
@@ -0,0 +1,200 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/acr-builder/pkg/procmanager"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// writeImageArchive writes an archive in the format created by `docker save`, containing an image
+// tagged as foo:v1 and bar:v1 and an image tagged as baz:v1 which share a layer. baz:v1 also has a foreign layer.
+func writeImageArchive(t *testing.T, file string) {
+	layerA, layerB := []byte("layer a"), []byte("layer b")
+	foreign := ocispec.Descriptor{
+		MediaType: "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip",
+		Digest:    digest.FromString("foreign"),
+		Size:      7,
+		URLs:      []string{"https://example.com/foreign"},
+	}
+	config := func(diffIDs ...digest.Digest) []byte {
+		data, _ := json.Marshal(ocispec.Image{RootFS: ocispec.RootFS{Type: "layers", DiffIDs: diffIDs}})
+		return data
+	}
+	files := map[string][]byte{
+		"a/layer.tar": layerA,
+		"b/layer.tar": layerB,
+		"c/layer.tar": []byte("foreign"),
+		"foo.json":    config(digest.FromBytes(layerA)),
+		"baz.json":    config(digest.FromBytes(layerA), digest.FromString("foreign"), digest.FromBytes(layerB)),
+	}
+	manifest, _ := json.Marshal([]imageArchiveManifest{
+		{Config: "foo.json", RepoTags: []string{"foo:v1", "bar:v1"}, Layers: []string{"a/layer.tar"}},
+		{
+			Config:       "baz.json",
+			RepoTags:     []string{"baz:v1"},
+			Layers:       []string{"a/layer.tar", "c/layer.tar", "b/layer.tar"},
+			LayerSources: map[digest.Digest]ocispec.Descriptor{digest.FromString("foreign"): foreign},
+		},
+	})
+	files[imageArchiveManifestFile] = manifest
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, data := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data))}); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatalf("failed to write tar entry: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+	if err := os.WriteFile(file, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("failed to write image archive: %v", err)
+	}
+}
+
+func loadTestImageArchive(t *testing.T) *imageArchive {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "images.tar")
+	writeImageArchive(t, file)
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("failed to open image archive: %v", err)
+	}
+	defer f.Close()
+	store, err := loadImageArchive(f, filepath.Join(dir, "images"))
+	if err != nil {
+		t.Fatalf("failed to load image archive: %v", err)
+	}
+	return store
+}
+
+func fetchManifest(t *testing.T, store *imageArchive, ref string) (ocispec.Descriptor, ocispec.Manifest) {
+	ctx := context.Background()
+	desc, err := store.Resolve(ctx, ref)
+	if err != nil {
+		t.Fatalf("failed to resolve %s: %v", ref, err)
+	}
+	rc, err := store.Fetch(ctx, desc)
+	if err != nil {
+		t.Fatalf("failed to fetch manifest of %s: %v", ref, err)
+	}
+	defer rc.Close()
+	var manifest ocispec.Manifest
+	if err := json.NewDecoder(rc).Decode(&manifest); err != nil {
+		t.Fatalf("failed to decode manifest of %s: %v", ref, err)
+	}
+	return desc, manifest
+}
+
+func TestLoadImageArchive(t *testing.T) {
+	store := loadTestImageArchive(t)
+
+	fooDesc, foo := fetchManifest(t, store, "docker.io/library/foo:v1")
+	barDesc, _ := fetchManifest(t, store, "bar:v1")
+	if fooDesc.Digest != barDesc.Digest {
+		t.Errorf("expected foo:v1 and bar:v1 to have the same manifest but got %s and %s", fooDesc.Digest, barDesc.Digest)
+	}
+	if foo.MediaType != dockerManifestMediaType || foo.Config.MediaType != dockerConfigMediaType {
+		t.Errorf("unexpected media types %s and %s", foo.MediaType, foo.Config.MediaType)
+	}
+
+	_, baz := fetchManifest(t, store, "baz:v1")
+	if len(baz.Layers) != 3 {
+		t.Fatalf("expected 3 layers but got %d", len(baz.Layers))
+	}
+	if baz.Layers[0].Digest != foo.Layers[0].Digest {
+		t.Errorf("expected the shared layer to have the same digest but got %s and %s", baz.Layers[0].Digest, foo.Layers[0].Digest)
+	}
+	if len(baz.Layers[1].URLs) != 1 {
+		t.Errorf("expected the foreign layer to be referenced by URL but got %v", baz.Layers[1])
+	}
+
+	// Layers are compressed.
+	rc, err := store.Fetch(context.Background(), baz.Layers[2])
+	if err != nil {
+		t.Fatalf("failed to fetch layer: %v", err)
+	}
+	defer rc.Close()
+	data, _ := io.ReadAll(rc)
+	if digest.FromBytes(data) != baz.Layers[2].Digest || !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		t.Errorf("expected the layer to be gzip compressed with digest %s", baz.Layers[2].Digest)
+	}
+
+	if _, err := store.Resolve(context.Background(), "missing:v1"); err == nil {
+		t.Error("expected an error resolving an image which isn't in the archive")
+	}
+}
+
+func TestLoadImageArchive_TooLarge(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "images.tar")
+	writeImageArchive(t, file)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("failed to read image archive: %v", err)
+	}
+
+	r := newSizeLimitedReader(bytes.NewReader(data), int64(len(data)/2))
+	if _, err = loadImageArchive(r, filepath.Join(dir, "images")); !errors.Is(err, errImageArchiveTooLarge) {
+		t.Errorf("expected the image archive to be too large but got: %v", err)
+	}
+	r = newSizeLimitedReader(bytes.NewReader(data), int64(len(data)))
+	if _, err = loadImageArchive(r, filepath.Join(dir, "exact")); err != nil {
+		t.Errorf("expected an image archive within the limit to be loaded but got: %v", err)
+	}
+}
+
+func TestSaveImages(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "images.tar")
+	writeImageArchive(t, file)
+
+	pm := procmanager.NewProcManager(true)
+	var saved []string
+	pm.Executor = func(_ context.Context, args []string, _ io.Reader, stdOut io.Writer, _ io.Writer, _ string) error {
+		saved = args
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(stdOut, f)
+		return err
+	}
+	b := &Builder{procManager: pm}
+	store, err := b.saveImages(context.Background(), []string{"foo:v1", "bar:v1", "foo:v1"}, dir)
+	if err != nil {
+		t.Fatalf("failed to save images: %v", err)
+	}
+	if expected := []string{"docker", "save", "foo:v1", "bar:v1"}; !reflect.DeepEqual(expected, saved) {
+		t.Errorf("expected the images to be saved with %v but got %v", expected, saved)
+	}
+	if _, err = store.Resolve(context.Background(), "foo:v1"); err != nil {
+		t.Errorf("expected foo:v1 to be in the store but got: %v", err)
+	}
+	// The archive is streamed into the store rather than written to the directory.
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if e.Name() != "images.tar" && e.Name() != "images" {
+			t.Errorf("unexpected file in the directory: %s", e.Name())
+		}
+	}
+}
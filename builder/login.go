@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Azure/acr-builder/pkg/container"
 	"github.com/Azure/acr-builder/util"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -21,31 +22,23 @@ const (
 
 // dockerLogin performs a docker login
 func (b *Builder) dockerLogin(ctx context.Context, registry string, user string, pw string) error {
-	args := []string{
-		"docker",
-		"run",
-		"--name", fmt.Sprintf("acb_docker_login_%s", uuid.New()),
-		"--rm",
-
-		// Interactive mode for --password-stdin
-		"-i",
+	var buf bytes.Buffer
+	opts := &container.RunOptions{
+		Name:   fmt.Sprintf("acb_docker_login_%s", uuid.New()),
+		Image:  dockerCLIImageName,
+		Cmd:    []string{"login", "--username", user, "--password-stdin", registry},
+		Remove: true,
 
 		// Mount home
-		"--volume", util.DockerSocketVolumeMapping,
-		"--volume", homeVol + ":" + homeWorkDir,
-		"--env", homeEnv,
+		Binds: []string{util.DockerSocketVolumeMapping, homeVol + ":" + homeWorkDir},
+		Env:   []string{homeEnv},
 
-		dockerCLIImageName,
-		"login",
-		"--username", user,
-		"--password-stdin",
-		registry,
+		// The password is written to the standard input for --password-stdin
+		Stdin:  strings.NewReader(pw + "\n"),
+		Stdout: &buf,
+		Stderr: &buf,
 	}
-
-	stdIn := strings.NewReader(pw + "\n")
-
-	var buf bytes.Buffer
-	if err := b.procManager.Run(ctx, args, stdIn, &buf, &buf, ""); err != nil {
+	if err := b.runContainer(ctx, opts); err != nil {
 		return errors.Wrap(err, fmt.Sprintf("failed to set docker credentials: %s", buf.String()))
 	}
 
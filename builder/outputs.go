@@ -0,0 +1,63 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/pkg/errors"
+)
+
+// stepOutputsRunDir returns the directory in $HOME containing the output files of the run's steps. Each workspace
+// volume has its own directory, so concurrent runs don't share output files and resuming a run with its
+// workspace volume restores the outputs of its steps.
+func (b *Builder) stepOutputsRunDir() string {
+	return filepath.Join(stepOutputsDir, b.workspaceDir)
+}
+
+// stepOutputFile returns the path of the file which the specified step can write its outputs to.
+func (b *Builder) stepOutputFile(id string) string {
+	return filepath.Join(b.stepOutputsRunDir(), id)
+}
+
+// staleStepOutputFiles returns the output files of the steps which are going to run, which are deleted
+// before the Task runs so that a step which writes no outputs doesn't inherit those of an earlier run.
+func (b *Builder) staleStepOutputFiles(task *graph.Task) []string {
+	var files []string
+	for _, step := range task.Steps {
+		if step.StepStatus != graph.Successful && step.IsCmdStep() && !step.DisableHomeVolume {
+			files = append(files, b.stepOutputFile(step.ID))
+		}
+	}
+	return files
+}
+
+// readStepOutputs reads and parses the specified step's output file from the home volume.
+// A step which didn't write any outputs has no output file.
+func (b *Builder) readStepOutputs(ctx context.Context, id string) (map[string]string, error) {
+	data, err := b.readHomeFile(ctx, "acb_read_outputs", b.stepOutputFile(id))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read output file")
+	}
+	return graph.ParseOutputs(data)
+}
+
+// restoreStepOutputs reads the outputs of the steps which were marked as successful before the Task ran,
+// i.e. the dependencies of the selected steps when resuming a Task, from the previous run's output files.
+func (b *Builder) restoreStepOutputs(ctx context.Context, task *graph.Task) {
+	for _, step := range task.Steps {
+		if step.StepStatus != graph.Successful || !step.IsCmdStep() || !b.outputSteps[step.ID] {
+			continue
+		}
+		outputs, err := b.readStepOutputs(ctx, step.ID)
+		if err != nil {
+			log.Printf("Failed to restore the outputs of step ID: %s, err: %v\n", step.ID, err)
+			continue
+		}
+		step.Outputs = outputs
+	}
+}
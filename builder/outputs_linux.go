@@ -0,0 +1,15 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import "github.com/Azure/acr-builder/pkg/container"
+
+// readFileRunOptions returns the options of a container which prints the specified file if it exists.
+func readFileRunOptions(file string) *container.RunOptions {
+	return &container.RunOptions{
+		Image:      configImageName,
+		Entrypoint: []string{"bash"},
+		Cmd:        []string{"-c", "if [ -f " + file + " ]; then cat " + file + "; fi"},
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/container"
+)
+
+// homeVolumeRuntime runs the Builder's bash containers on the local host, with the home volume in dir.
+type homeVolumeRuntime struct {
+	container.Runtime
+	dir string
+}
+
+func (r *homeVolumeRuntime) Run(ctx context.Context, opts *container.RunOptions) (string, error) {
+	args := make([]string, len(opts.Cmd))
+	for i, arg := range opts.Cmd {
+		args[i] = strings.ReplaceAll(arg, homeWorkDir, r.dir)
+	}
+	cmd := exec.CommandContext(ctx, "bash", args...)
+	cmd.Env = []string{"HOME=" + r.dir}
+	cmd.Stdout, cmd.Stderr = opts.Stdout, opts.Stderr
+	return opts.Name, cmd.Run()
+}
+
+// writeOutputs writes the step's output file like the step's container would.
+func (r *homeVolumeRuntime) writeOutputs(t *testing.T, b *Builder, id string, data string) {
+	file := strings.ReplaceAll(b.stepOutputFile(id), homeWorkDir, r.dir)
+	if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatalf("failed to write the outputs of step ID: %s: %v", id, err)
+	}
+}
+
+// TestStepOutputs_Stale verifies that a step which writes no outputs doesn't inherit the outputs of an earlier
+// run, and that runs with different workspace volumes don't share outputs.
+func TestStepOutputs_Stale(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash isn't available")
+	}
+	ctx := context.Background()
+	rt := &homeVolumeRuntime{dir: t.TempDir()}
+	task := &graph.Task{Steps: []*graph.Step{{ID: "version", Cmd: "bash echo"}}}
+
+	b := &Builder{workspaceDir: "acb_vol_1", containerRuntime: rt}
+	if err := b.setupConfig(ctx, b.staleStepOutputFiles(task)); err != nil {
+		t.Fatalf("failed to set up the first run: %v", err)
+	}
+	rt.writeOutputs(t, b, "version", "tag=v1\n")
+	outputs, err := b.readStepOutputs(ctx, "version")
+	if err != nil {
+		t.Fatalf("failed to read the outputs of the first run: %v", err)
+	}
+	if expected := map[string]string{"tag": "v1"}; !reflect.DeepEqual(outputs, expected) {
+		t.Errorf("expected outputs %v but got %v", expected, outputs)
+	}
+
+	// A concurrent run has its own outputs.
+	other := &Builder{workspaceDir: "acb_vol_2", containerRuntime: rt}
+	if outputs, err = other.readStepOutputs(ctx, "version"); err != nil || len(outputs) != 0 {
+		t.Errorf("expected another workspace volume to have no outputs but got %v, err: %v", outputs, err)
+	}
+
+	// Running the step again with the same workspace volume, it doesn't write any outputs.
+	if err = b.setupConfig(ctx, b.staleStepOutputFiles(task)); err != nil {
+		t.Fatalf("failed to set up the second run: %v", err)
+	}
+	if outputs, err = b.readStepOutputs(ctx, "version"); err != nil || len(outputs) != 0 {
+		t.Errorf("expected the second run to have no outputs but got %v, err: %v", outputs, err)
+	}
+	if _, err = os.Stat(filepath.Join(rt.dir, ".docker", "config.json")); err != nil {
+		t.Errorf("expected the Docker config to be written: %v", err)
+	}
+
+	// When resuming, the outputs of the steps which already completed are kept.
+	task.Steps[0].StepStatus = graph.Successful
+	if files := b.staleStepOutputFiles(task); len(files) != 0 {
+		t.Errorf("expected the outputs of completed steps to be kept but got %v", files)
+	}
+}
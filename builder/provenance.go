@@ -0,0 +1,113 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"log"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/provenance"
+	"github.com/Azure/acr-builder/pkg/redact"
+	"github.com/Azure/acr-builder/pkg/signing"
+	"github.com/Azure/acr-builder/templating"
+	"github.com/Azure/acr-builder/version"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+// ProvenanceOptions configures the signed SLSA provenance which is attached to each image built and pushed by a Task.
+type ProvenanceOptions struct {
+	// KeyFile is the path of the PEM encoded private key which signs the provenance.
+	KeyFile string
+
+	// KeySecret is the ID of the Task's secret whose value is the PEM encoded private key, if KeyFile isn't specified.
+	KeySecret string
+
+	// Run describes the run, i.e. its ID and the source repository and commit which the images are built from.
+	Run *templating.BaseRenderOptions
+}
+
+// loadProvenanceKey loads the private key which signs the provenance from its file or the Task's secret.
+func (b *Builder) loadProvenanceKey(task *graph.Task) (crypto.Signer, error) {
+	if b.Provenance.KeyFile == "" && b.Provenance.KeySecret == "" {
+		return nil, errors.New("a provenance key file or secret is required")
+	}
+	data, err := readKey(task.Secrets, b.Provenance.KeyFile, b.Provenance.KeySecret)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the provenance key")
+	}
+	key, err := signing.LoadPrivateKey(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load the provenance key")
+	}
+	return key, nil
+}
+
+// attachProvenance signs the provenance of the pushed image if provenance is enabled and the image was built by
+// one of the Task's build steps, and pushes it to the image's repository as an artifact which refers to the image's manifest.
+func (b *Builder) attachProvenance(ctx context.Context, img string, desc ocispec.Descriptor, client remote.Client) error {
+	if b.provenanceKey == nil {
+		return nil
+	}
+	step := b.buildStep(img)
+	if step == nil {
+		log.Printf("WARNING: image %s wasn't built by the task, so no provenance is attached to it\n", img)
+		return nil
+	}
+
+	log.Printf("Generating provenance for image: %s\n", img)
+	deps, err := b.pushedImageDependencies(ctx, step, img, desc)
+	if err != nil {
+		return err
+	}
+	dockerfile, target, dockerContext := parseDockerBuildCmd(step.Build)
+	build := &provenance.Build{
+		Image:          img,
+		Digest:         desc.Digest.String(),
+		Dependencies:   deps,
+		Dockerfile:     dockerfile,
+		Target:         target,
+		Context:        dockerContext,
+		BuilderVersion: version.Version,
+		StartedOn:      step.StartTime,
+		FinishedOn:     step.EndTime,
+	}
+	// Build args can contain secrets.
+	for _, arg := range step.BuildArgs {
+		build.BuildArgs = append(build.BuildArgs, redact.String(arg))
+	}
+	if run := b.Provenance.Run; run != nil {
+		build.Source = provenance.Source{Repository: run.Repository, Commit: run.Commit, Branch: run.Branch, Tag: run.GitTag}
+		build.RunID = run.ID
+		build.TriggeredBy = run.TriggeredBy
+	}
+
+	statement, err := provenance.NewStatement(build)
+	if err != nil {
+		return err
+	}
+	envelope, err := provenance.Sign(statement, b.provenanceKey)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the provenance")
+	}
+
+	repo, _, err := newRepository(img, client)
+	if err != nil {
+		return err
+	}
+	manifest, err := pushReferrer(ctx, repo, desc, provenance.ArtifactType, provenance.MediaType, data)
+	if err != nil {
+		return errors.Wrap(err, "failed to push the provenance")
+	}
+	log.Printf("Successfully attached provenance to image: %s, digest: %s\n", img, manifest.Digest)
+	return nil
+}
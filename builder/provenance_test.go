@@ -0,0 +1,143 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/image"
+	"github.com/Azure/acr-builder/pkg/provenance"
+	"github.com/Azure/acr-builder/secretmgmt"
+	"github.com/Azure/acr-builder/templating"
+)
+
+func TestAttachProvenance(t *testing.T) {
+	registry := &testRegistry{blobs: make(map[string]map[string]bool), manifests: make(map[string]string)}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	img := host + "/foo:v1"
+
+	step := &graph.Step{
+		ID:        "build",
+		Build:     "-f Dockerfile.prod --build-arg a=b -t " + img + " .",
+		BuildArgs: []string{"a=b"},
+		Tags:      []string{img},
+		StartTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		EndTime:   time.Date(2024, 1, 2, 3, 5, 5, 0, time.UTC),
+		ImageDependencies: []*image.Dependencies{{
+			Image: &image.Reference{Registry: host, Repository: "foo", Tag: "v1", Reference: img},
+			Runtime: &image.Reference{
+				Registry:   "registry.hub.docker.com",
+				Repository: "library/debian",
+				Tag:        "12",
+				Digest:     "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+				Reference:  "debian:12",
+			},
+		}},
+	}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	b := &Builder{
+		buildSteps:    []*graph.Step{step},
+		provenanceKey: key,
+		Provenance: &ProvenanceOptions{
+			Run: &templating.BaseRenderOptions{ID: "cf1", Repository: "https://github.com/Azure/acr-builder.git", Commit: "abc"},
+		},
+	}
+	store := loadTestSBOMImage(t, img)
+	client := &http.Client{}
+	desc, err := pushImage(context.Background(), store, img, client, make(map[string][]string))
+	if err != nil {
+		t.Fatalf("failed to push %s: %v", img, err)
+	}
+	if err = b.attachProvenance(context.Background(), img, desc, client); err != nil {
+		t.Fatalf("failed to attach provenance: %v", err)
+	}
+
+	repo, _, err := newRepository(img, client)
+	if err != nil {
+		t.Fatalf("failed to create repository: %v", err)
+	}
+	envelopes, err := provenance.Fetch(context.Background(), repo, desc)
+	if err != nil {
+		t.Fatalf("failed to fetch provenance: %v", err)
+	}
+	if len(envelopes) != 1 {
+		t.Fatalf("expected 1 envelope but got %d", len(envelopes))
+	}
+	s, err := envelopes[0].Verify(key.Public())
+	if err != nil {
+		t.Fatalf("failed to verify provenance: %v", err)
+	}
+	if !s.HasSubject(desc.Digest.String()) {
+		t.Errorf("expected the provenance's subject to be %s but got %+v", desc.Digest, s.Subject)
+	}
+	params := s.Predicate.BuildDefinition.ExternalParameters
+	if params.Dockerfile != "Dockerfile.prod" || params.Context != "." {
+		t.Errorf("expected the Dockerfile and context to be Dockerfile.prod and . but got %s and %s", params.Dockerfile, params.Context)
+	}
+	if params.Source == nil || params.Source.Commit != "abc" {
+		t.Errorf("expected the source commit to be abc but got %+v", params.Source)
+	}
+	if s.Predicate.RunDetails.Metadata.InvocationID != "cf1" {
+		t.Errorf("expected the invocation ID to be cf1 but got %s", s.Predicate.RunDetails.Metadata.InvocationID)
+	}
+
+	// Images which aren't built by the task don't get provenance.
+	other := host + "/bar:v1"
+	if err = b.attachProvenance(context.Background(), other, desc, client); err != nil {
+		t.Errorf("expected no provenance to be attached to %s but got %v", other, err)
+	}
+}
+
+func TestLoadProvenanceKey(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(key)
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(keyFile, data, 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	task := &graph.Task{Secrets: []*secretmgmt.Secret{{ID: "key", ResolvedValue: string(data)}, {ID: "unresolved", KeyVault: "https://myvault.vault.azure.net/secrets/key"}}}
+
+	tests := []struct {
+		opts        *ProvenanceOptions
+		shouldError bool
+	}{
+		{&ProvenanceOptions{KeyFile: keyFile}, false},
+		{&ProvenanceOptions{KeySecret: "key"}, false},
+		{&ProvenanceOptions{KeyFile: filepath.Join(t.TempDir(), "missing.pem")}, true},
+		{&ProvenanceOptions{KeySecret: "missing"}, true},
+		{&ProvenanceOptions{KeySecret: "unresolved"}, true},
+		{&ProvenanceOptions{}, true},
+	}
+	for _, test := range tests {
+		b := &Builder{Provenance: test.opts}
+		signer, err := b.loadProvenanceKey(task)
+		if test.shouldError {
+			if err == nil {
+				t.Errorf("expected loading the key with %+v to fail", test.opts)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("failed to load the key with %+v: %v", test.opts, err)
+		} else if !key.PublicKey.Equal(signer.Public()) {
+			t.Errorf("expected the key loaded with %+v to match", test.opts)
+		}
+	}
+}
@@ -4,58 +4,447 @@
 package builder
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/Azure/acr-builder/util"
-	"github.com/google/uuid"
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/container"
+	"github.com/Azure/acr-builder/pkg/image"
+	"github.com/docker/distribution/reference"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/retry"
 )
 
 const (
 	maxPushRetries = 3
+
+	// maxConcurrentUploads is the maximum number of blobs uploaded concurrently while pushing an image.
+	maxConcurrentUploads = 4
+
+	// maxImageArchiveSize is the maximum size of the archive of the images which a push step exports from
+	// the Docker daemon, i.e. of their uncompressed layers.
+	maxImageArchiveSize = 32 << 30
+
+	dockerHubRegistry     = "docker.io"
+	dockerHubRegistryHost = "registry-1.docker.io"
 )
 
-func (b *Builder) pushWithRetries(ctx context.Context, images []string) error {
-	if len(images) == 0 {
+// pushWithRetries pushes all of the step's images using the registry API, recording the outcome of each push on the step.
+// Requests which fail with a retriable status, i.e. 408, 429 or 5xx, are retried.
+// The images are exported from the Docker daemon to a temporary directory first, and their layers are compressed
+// there, so pushing needs up to the images' uncompressed size in disk space, bounded by maxImageArchiveSize.
+func (b *Builder) pushWithRetries(ctx context.Context, step *graph.Step) error {
+	if len(step.Push) == 0 {
+		return nil
+	}
+	if b.procManager.DryRun {
+		for _, img := range step.Push {
+			if err := b.containerRuntime.Push(ctx, img, nil, nil); err != nil {
+				return err
+			}
+		}
 		return nil
 	}
 
+	dir, err := os.MkdirTemp("", "acb_push_")
+	if err != nil {
+		return errors.Wrap(err, "failed to create a temporary directory for the images")
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := b.saveImages(ctx, step.Push, dir)
+	if err != nil {
+		return err
+	}
+
+	policy := &retryPolicy{Policy: retryablePolicy}
+	client := &auth.Client{
+		Client: &http.Client{
+			Transport: &retry.Transport{Base: b.registryTransport(), Policy: func() retry.Policy { return policy }},
+		},
+		Header: http.Header{
+			"X-Meta-Source-Client": {"azure/acr/tasks"},
+		},
+		Cache:      auth.NewCache(),
+		Credential: b.registryCredential,
+	}
+	defer func() {
+		step.RetryCount += policy.Count()
+	}()
+
+	// pushed tracks the repositories which have been pushed to by registry, so that
+	// blobs can be mounted from them instead of being uploaded again.
+	pushed := make(map[string][]string)
+	for _, img := range step.Push {
+		log.Printf("Pushing image: %s\n", img)
+		desc, pushErr := pushImage(ctx, store, img, client, pushed)
+		if pushErr != nil {
+			return errors.Wrapf(pushErr, "failed to push image: %s", img)
+		}
+		log.Printf("Successfully pushed image: %s, digest: %s\n", img, desc.Digest)
+		step.PushedTags = append(step.PushedTags, img)
+		if step.PushedDigests == nil {
+			step.PushedDigests = make(map[string]string)
+		}
+		step.PushedDigests[img] = desc.Digest.String()
+
+		if err = b.attachSBOM(ctx, store, img, desc, client); err != nil {
+			return errors.Wrapf(err, "failed to attach an SBOM to image: %s", img)
+		}
+		if err = b.attachProvenance(ctx, img, desc, client); err != nil {
+			return errors.Wrapf(err, "failed to attach provenance to image: %s", img)
+		}
+	}
+
+	return nil
+}
+
+// saveImages streams the images from the Docker daemon into a store in dir. The archive created by `docker save`
+// is extracted as it's read rather than written to disk, and it's limited to maxImageArchiveSize.
+func (b *Builder) saveImages(ctx context.Context, images []string, dir string) (*imageArchive, error) {
+	args := []string{"docker", "save"}
+	seen := make(map[string]bool, len(images))
 	for _, img := range images {
-		args := []string{
-			"docker",
-			"run",
-			"--name", fmt.Sprintf("acb_docker_push_%s", uuid.New()),
-			"--rm",
-
-			// Mount home
-			"--volume", util.DockerSocketVolumeMapping,
-			"--volume", homeVol + ":" + homeWorkDir,
-			"--env", homeEnv,
-
-			dockerCLIImageName,
-			"push",
-			img,
-		}
-
-		attempt := 0
-		for attempt < maxPushRetries {
-			log.Printf("Pushing image: %s, attempt %d\n", img, attempt+1)
-			if err := b.procManager.Run(ctx, args, nil, os.Stdout, os.Stderr, ""); err != nil {
-				time.Sleep(util.GetExponentialBackoff(attempt))
-				attempt++
-			} else {
-				log.Printf("Successfully pushed image: %s\n", img)
-				break
+		if !seen[img] {
+			seen[img] = true
+			args = append(args, img)
+		}
+	}
+
+	saveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pr, pw := io.Pipe()
+	type result struct {
+		store *imageArchive
+		err   error
+	}
+	loaded := make(chan result, 1)
+	go func() {
+		store, err := loadImageArchive(newSizeLimitedReader(pr, maxImageArchiveSize), filepath.Join(dir, "images"))
+		if err != nil {
+			// Stop saving the images, and drain the pipe so that `docker save` isn't blocked writing to it.
+			cancel()
+			_, _ = io.Copy(io.Discard, pr)
+		}
+		loaded <- result{store, err}
+	}()
+
+	var buf bytes.Buffer
+	err := b.procManager.Run(saveCtx, args, nil, pw, &buf, "")
+	_ = pw.CloseWithError(err)
+	res := <-loaded
+	if res.err != nil {
+		return nil, res.err
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to save images, msg: %s", buf.String())
+	}
+	return res.store, nil
+}
+
+// pushImage pushes the image from the store to its registry and returns its manifest descriptor.
+func pushImage(ctx context.Context, store *imageArchive, img string, client remote.Client, pushed map[string][]string) (ocispec.Descriptor, error) {
+	repo, tagged, err := newRepository(img, client)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	registry, repository := reference.Domain(tagged), reference.Path(tagged)
+
+	opts := oras.DefaultCopyOptions
+	opts.Concurrency = maxConcurrentUploads
+	opts.MountFrom = func(context.Context, ocispec.Descriptor) ([]string, error) {
+		var from []string
+		for _, r := range pushed[registry] {
+			if r != repository {
+				from = append(from, r)
 			}
 		}
+		return from, nil
+	}
 
-		if attempt == maxPushRetries {
-			return fmt.Errorf("failed to push images successfully")
+	desc, err := oras.Copy(ctx, store, img, repo, tagged.Tag(), opts)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	pushed[registry] = append(pushed[registry], repository)
+	return desc, nil
+}
+
+// pushedDigests returns the manifest digests of the images pushed by the Task's steps, by normalized image reference.
+func pushedDigests(task *graph.Task) map[string]string {
+	digests := make(map[string]string)
+	for _, step := range task.Steps {
+		for img, d := range step.PushedDigests {
+			if name, err := normalizeImageTag(img); err == nil {
+				digests[name] = d
+			}
 		}
 	}
+	return digests
+}
 
+// populatePushedDigest populates the digest of the image reference if the image was pushed.
+func populatePushedDigest(ref *image.Reference, pushed map[string]string) {
+	if ref == nil || ref.Digest != "" {
+		return
+	}
+	if name, err := normalizeImageTag(ref.Reference); err == nil {
+		ref.Digest = pushed[name]
+	}
+}
+
+// buildStep returns the build step of the running Task which tagged the image, or nil if there isn't one.
+func (b *Builder) buildStep(img string) *graph.Step {
+	name, err := normalizeImageTag(img)
+	if err != nil {
+		return nil
+	}
+	for _, step := range b.buildSteps {
+		for _, tag := range step.Tags {
+			if t, err := normalizeImageTag(tag); err == nil && t == name {
+				return step
+			}
+		}
+	}
 	return nil
 }
+
+// pushedImageDependencies returns the dependencies of the pushed image which were scanned by the build step,
+// with the digests of the image and its base images populated.
+func (b *Builder) pushedImageDependencies(ctx context.Context, step *graph.Step, img string, desc ocispec.Descriptor) (*image.Dependencies, error) {
+	name, err := normalizeImageTag(img)
+	if err != nil {
+		return nil, err
+	}
+	var deps *image.Dependencies
+	for _, d := range step.ImageDependencies {
+		if d.Image == nil {
+			continue
+		}
+		if ref, err := normalizeImageTag(d.Image.Reference); err == nil && ref == name {
+			deps = d
+			break
+		}
+	}
+	if deps == nil {
+		return nil, fmt.Errorf("the dependencies of image %s weren't scanned by step ID: %s", img, step.ID)
+	}
+
+	deps.Image.Digest = desc.Digest.String()
+	if err = b.getPopulateDigests(ctx, []*image.Dependencies{deps}, builtWithBuildkit(step), b.registryLoginCredentials); err != nil {
+		return nil, errors.Wrap(err, "failed to populate the digests of the image's dependencies")
+	}
+	return deps, nil
+}
+
+// pushReferrer pushes the data as a blob of the specified media type, and a manifest of the artifact type which
+// refers to the subject, e.g. an image's manifest, and returns the manifest's descriptor. oras-go falls back to
+// the referrers tag schema if the registry doesn't support the referrers API, so that the artifact can be
+// discovered on any registry.
+func pushReferrer(ctx context.Context, target oras.Target, subject ocispec.Descriptor, artifactType string, mediaType string, data []byte) (ocispec.Descriptor, error) {
+	blob := content.NewDescriptorFromBytes(mediaType, data)
+	if err := target.Push(ctx, blob, bytes.NewReader(data)); err != nil && !errors.Is(err, errdef.ErrAlreadyExists) {
+		return ocispec.Descriptor{}, err
+	}
+	opts := oras.PackManifestOptions{
+		Subject: &subject,
+		Layers:  []ocispec.Descriptor{blob},
+	}
+	return oras.PackManifest(ctx, target, oras.PackManifestVersion1_1, artifactType, opts)
+}
+
+// newRepository creates a client of the repository of the image, which must be tagged, and returns its tagged reference.
+func newRepository(img string, client remote.Client) (*remote.Repository, reference.NamedTagged, error) {
+	named, err := reference.ParseNormalizedNamed(img)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to parse image reference %s", img)
+	}
+	tagged, ok := reference.TagNameOnly(named).(reference.NamedTagged)
+	if !ok {
+		return nil, nil, fmt.Errorf("image reference %s must be tagged", img)
+	}
+
+	repo, err := NewRemoteRepository(tagged, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	return repo, tagged, nil
+}
+
+// NewRemoteRepository creates a client of the named repository. Docker Hub's repositories are accessed through its
+// registry host, and registries on the local host are accessed over plain HTTP.
+func NewRemoteRepository(named reference.Named, client remote.Client) (*remote.Repository, error) {
+	host := reference.Domain(named)
+	if host == dockerHubRegistry {
+		host = dockerHubRegistryHost
+	}
+	repo, err := remote.NewRepository(host + "/" + reference.Path(named))
+	if err != nil {
+		return nil, err
+	}
+	repo.Client = client
+	repo.PlainHTTP = isLocalRegistry(host)
+	return repo, nil
+}
+
+// registryCredential returns the credential of the registry which the Task logged in to, falling back to
+// the logins of the home volume's Docker config, or an empty credential for anonymous access.
+func (b *Builder) registryCredential(ctx context.Context, host string) (auth.Credential, error) {
+	registry := host
+	if host == dockerHubRegistryHost {
+		registry = dockerHubRegistry
+	}
+	if cred, ok := b.registryLoginCredentials[registry]; ok && cred != nil {
+		return auth.Credential{
+			Username: cred.Username.ResolvedValue,
+			Password: cred.Password.ResolvedValue,
+		}, nil
+	}
+	if cred, ok := b.dockerConfigCredential(ctx, registry); ok {
+		return cred, nil
+	}
+	return auth.EmptyCredential, nil
+}
+
+// registryAuthConfig returns the credentials used by the container runtime to pull or push the specified image.
+func (b *Builder) registryAuthConfig(ctx context.Context, img string) (*container.AuthConfig, error) {
+	named, err := reference.ParseNormalizedNamed(img)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse image %s", img)
+	}
+	host := reference.Domain(named)
+	cred, err := b.registryCredential(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	return &container.AuthConfig{
+		Username:      cred.Username,
+		Password:      cred.Password,
+		IdentityToken: cred.RefreshToken,
+		ServerAddress: host,
+	}, nil
+}
+
+// registryTransport returns the transport used to access registries. Like the Docker daemon, it doesn't verify the
+// TLS certificates of the daemon's insecure registries, and accesses them over plain HTTP if they don't serve HTTPS.
+func (b *Builder) registryTransport() http.RoundTripper {
+	secure := http.DefaultTransport.(*http.Transport)
+	insecure := secure.Clone()
+	insecure.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //#nosec G402
+	return &insecureRegistryTransport{
+		secure:   secure,
+		insecure: insecure,
+		config:   b.registryConfig,
+	}
+}
+
+// insecureRegistryTransport accesses the insecure registries of a registry configuration insecurely.
+type insecureRegistryTransport struct {
+	secure   http.RoundTripper
+	insecure http.RoundTripper
+	config   *container.RegistryConfig
+
+	// insecureHosts caches whether or not each host is insecure, since it may require a DNS lookup.
+	insecureHosts sync.Map
+
+	// plainHTTPHosts are the insecure hosts which don't serve HTTPS.
+	plainHTTPHosts sync.Map
+}
+
+// RoundTrip sends the request, falling back to plain HTTP if an insecure registry doesn't serve HTTPS.
+func (t *insecureRegistryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if req.URL.Scheme != "https" || !t.isInsecure(host) {
+		return t.secure.RoundTrip(req)
+	}
+
+	plainReq := req.Clone(req.Context())
+	plainReq.URL.Scheme = "http"
+	if _, ok := t.plainHTTPHosts.Load(host); ok {
+		return t.secure.RoundTrip(plainReq)
+	}
+	resp, err := t.insecure.RoundTrip(req)
+	if err == nil || req.Context().Err() != nil {
+		return resp, err
+	}
+
+	// The request's body may have been consumed, so it can only be retried if it can be read again.
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, err
+		}
+		if plainReq.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	t.plainHTTPHosts.Store(host, true)
+	return t.secure.RoundTrip(plainReq)
+}
+
+func (t *insecureRegistryTransport) isInsecure(host string) bool {
+	if t.config == nil {
+		return false
+	}
+	if insecure, ok := t.insecureHosts.Load(host); ok {
+		return insecure.(bool)
+	}
+	insecure := t.config.IsInsecure(host)
+	t.insecureHosts.Store(host, insecure)
+	return insecure
+}
+
+// isLocalRegistry returns true if the registry is on the local host, which Docker allows to be accessed over HTTP.
+func isLocalRegistry(host string) bool {
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		hostname = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+	return hostname == "localhost" || hostname == "127.0.0.1" || hostname == "::1"
+}
+
+// retryablePolicy retries requests which failed with a retriable status, i.e. 408, 429 or 5xx, or timed out.
+var retryablePolicy retry.Policy = &retry.GenericPolicy{
+	Retryable: retry.DefaultPredicate,
+	Backoff:   retry.DefaultBackoff,
+	MinWait:   200 * time.Millisecond,
+	MaxWait:   3 * time.Second,
+	MaxRetry:  maxPushRetries,
+}
+
+// retryPolicy counts the retries of a policy.
+type retryPolicy struct {
+	retry.Policy
+	count int32
+}
+
+// Retry returns the duration to wait before retrying the request, or a negative value if it shouldn't be retried.
+func (p *retryPolicy) Retry(attempt int, resp *http.Response, respErr error) (time.Duration, error) {
+	wait, err := p.Policy.Retry(attempt, resp, respErr)
+	if err == nil && wait >= 0 {
+		atomic.AddInt32(&p.count, 1)
+	}
+	return wait, err
+}
+
+// Count returns the number of retries.
+func (p *retryPolicy) Count() int {
+	return int(atomic.LoadInt32(&p.count))
+}
@@ -0,0 +1,257 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/container"
+	"github.com/Azure/acr-builder/pkg/image"
+	"github.com/opencontainers/go-digest"
+	"oras.land/oras-go/v2/registry/remote/retry"
+)
+
+// testRegistry is a minimal registry which supports blob uploads, cross repository mounts, manifest pushes
+// and pulls, but not the referrers API.
+type testRegistry struct {
+	mu sync.Mutex
+
+	// blobs are the digests of the blobs in each repository.
+	blobs map[string]map[string]bool
+
+	uploads   int
+	mounts    int
+	manifests map[string]string
+
+	// contents are the pushed manifests and uploaded blobs, by digest.
+	contents map[string][]byte
+
+	// failures is the number of manifest pushes which fail with a 503 before succeeding.
+	failures int
+}
+
+var (
+	blobPathRE     = regexp.MustCompile(`^/v2/(.+)/blobs/(sha256:[a-f0-9]+)$`)
+	uploadPathRE   = regexp.MustCompile(`^/v2/(.+)/blobs/uploads/$`)
+	sessionPathRE  = regexp.MustCompile(`^/v2/(.+)/blobs/uploads/session$`)
+	manifestPathRE = regexp.MustCompile(`^/v2/(.+)/manifests/(.+)$`)
+)
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	path := req.URL.Path
+	switch {
+	case req.Method == http.MethodHead && blobPathRE.MatchString(path):
+		m := blobPathRE.FindStringSubmatch(path)
+		if !r.blobs[m[1]][m[2]] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case req.Method == http.MethodPost && uploadPathRE.MatchString(path):
+		repo := uploadPathRE.FindStringSubmatch(path)[1]
+		if mount, from := req.URL.Query().Get("mount"), req.URL.Query().Get("from"); mount != "" && r.blobs[from][mount] {
+			r.mounts++
+			r.addBlob(repo, mount)
+			w.Header().Set("Docker-Content-Digest", mount)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/session", repo))
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == http.MethodPut && sessionPathRE.MatchString(path):
+		repo := sessionPathRE.FindStringSubmatch(path)[1]
+		data, _ := io.ReadAll(req.Body)
+		d := req.URL.Query().Get("digest")
+		if digest.FromBytes(data).String() != d {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.uploads++
+		r.addBlob(repo, d)
+		r.addContent(d, data)
+		w.Header().Set("Docker-Content-Digest", d)
+		w.WriteHeader(http.StatusCreated)
+	case req.Method == http.MethodPut && manifestPathRE.MatchString(path):
+		if r.failures > 0 {
+			r.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		m := manifestPathRE.FindStringSubmatch(path)
+		data, _ := io.ReadAll(req.Body)
+		d := digest.FromBytes(data).String()
+		r.manifests[m[1]+":"+m[2]] = d
+		r.manifests[m[1]+":"+d] = d
+		r.addContent(d, data)
+		w.Header().Set("Docker-Content-Digest", d)
+		w.WriteHeader(http.StatusCreated)
+	case (req.Method == http.MethodGet || req.Method == http.MethodHead) && manifestPathRE.MatchString(path):
+		m := manifestPathRE.FindStringSubmatch(path)
+		d, ok := r.manifests[m[1]+":"+m[2]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var manifest struct {
+			MediaType string `json:"mediaType"`
+		}
+		_ = json.Unmarshal(r.contents[d], &manifest)
+		w.Header().Set("Content-Type", manifest.MediaType)
+		r.writeContent(w, req, d)
+	case req.Method == http.MethodGet && blobPathRE.MatchString(path):
+		m := blobPathRE.FindStringSubmatch(path)
+		if !r.blobs[m[1]][m[2]] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		r.writeContent(w, req, m[2])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (r *testRegistry) addContent(d string, data []byte) {
+	if r.contents == nil {
+		r.contents = make(map[string][]byte)
+	}
+	r.contents[d] = data
+}
+
+func (r *testRegistry) writeContent(w http.ResponseWriter, req *http.Request, d string) {
+	data := r.contents[d]
+	w.Header().Set("Docker-Content-Digest", d)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodGet {
+		_, _ = w.Write(data)
+	}
+}
+
+func (r *testRegistry) addBlob(repo string, d string) {
+	if r.blobs[repo] == nil {
+		r.blobs[repo] = make(map[string]bool)
+	}
+	r.blobs[repo][d] = true
+}
+
+func TestPushImage(t *testing.T) {
+	store := loadTestImageArchive(t)
+	registry := &testRegistry{blobs: make(map[string]map[string]bool), manifests: make(map[string]string), failures: 1}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	policy := &retryPolicy{Policy: retryablePolicy}
+	client := &http.Client{Transport: &retry.Transport{Policy: func() retry.Policy { return policy }}}
+	pushed := make(map[string][]string)
+
+	// foo:v1 and bar:v1 are the same image, so its blobs are mounted rather than uploaded when pushing bar:v1.
+	// The first manifest push fails with a retriable status.
+	for _, img := range []string{"foo:v1", "bar:v1"} {
+		// Tag the archive's image for the test registry.
+		store.tags[host+"/"+img] = store.tags["docker.io/library/"+img]
+		desc, err := pushImage(context.Background(), store, host+"/"+img, client, pushed)
+		if err != nil {
+			t.Fatalf("failed to push %s: %v", img, err)
+		}
+		repo := strings.Split(img, ":")[0]
+		if actual := registry.manifests[repo+":v1"]; actual != desc.Digest.String() {
+			t.Errorf("expected %s to be pushed with digest %s but got %s", img, desc.Digest, actual)
+		}
+	}
+
+	// The config and layer are uploaded once, and mounted for the second repository.
+	if registry.uploads != 2 || registry.mounts != 2 {
+		t.Errorf("expected 2 uploads and 2 mounts but got %d uploads and %d mounts", registry.uploads, registry.mounts)
+	}
+	if policy.Count() != 1 {
+		t.Errorf("expected 1 retry but got %d", policy.Count())
+	}
+}
+
+func TestRetryablePolicy(t *testing.T) {
+	tests := []struct {
+		status      int
+		shouldRetry bool
+	}{
+		{http.StatusServiceUnavailable, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusRequestTimeout, true},
+		{http.StatusUnauthorized, false},
+		{http.StatusNotFound, false},
+	}
+	for _, test := range tests {
+		wait, err := retryablePolicy.Retry(0, &http.Response{StatusCode: test.status}, nil)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if shouldRetry := wait >= 0; shouldRetry != test.shouldRetry {
+			t.Errorf("expected retry to be %v for status %d but got %v", test.shouldRetry, test.status, shouldRetry)
+		}
+	}
+}
+
+func TestPopulatePushedDigest(t *testing.T) {
+	task := &graph.Task{
+		Steps: []*graph.Step{
+			{ID: "push", PushedDigests: map[string]string{"example.azurecr.io/hello-world:v1": "sha256:abc"}},
+		},
+	}
+	pushed := pushedDigests(task)
+
+	img := &image.Reference{Reference: "example.azurecr.io/hello-world:v1"}
+	populatePushedDigest(img, pushed)
+	if img.Digest != "sha256:abc" {
+		t.Errorf("expected the pushed digest to be populated but got %q", img.Digest)
+	}
+
+	unpushed := &image.Reference{Reference: "example.azurecr.io/hello-world:v2"}
+	populatePushedDigest(unpushed, pushed)
+	if unpushed.Digest != "" {
+		t.Errorf("expected no digest for an image which wasn't pushed but got %q", unpushed.Digest)
+	}
+}
+
+func TestInsecureRegistryTransport(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	tlsServer := httptest.NewTLSServer(handler)
+	defer tlsServer.Close()
+
+	plainHost := strings.TrimPrefix(plain.URL, "http://")
+	tlsHost := strings.TrimPrefix(tlsServer.URL, "https://")
+	b := &Builder{registryConfig: &container.RegistryConfig{InsecureRegistries: []string{plainHost, tlsHost}}}
+	client := &http.Client{Transport: b.registryTransport()}
+	for _, host := range []string{plainHost, tlsHost} {
+		resp, err := client.Get("https://" + host + "/v2/")
+		if err != nil {
+			t.Fatalf("expected insecure registry %s to be accessible but got: %v", host, err)
+		}
+		resp.Body.Close()
+	}
+
+	// Registries which aren't insecure must serve HTTPS with a trusted certificate.
+	b.registryConfig = &container.RegistryConfig{}
+	client = &http.Client{Transport: b.registryTransport()}
+	if _, err := client.Get("https://" + tlsHost + "/v2/"); err == nil {
+		t.Error("expected the certificate of a secure registry to be verified")
+	}
+}
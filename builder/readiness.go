@@ -0,0 +1,236 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/container"
+	"github.com/Azure/acr-builder/pkg/procmanager"
+	"github.com/Azure/acr-builder/pkg/redact"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// waitUntilReady probes a detached step until it's ready. It fails if the step's container exits or the probe
+// fails as many times as the step's Readiness allows.
+func (b *Builder) waitUntilReady(ctx context.Context, step *graph.Step) error {
+	r := step.Readiness
+	log.Printf("Waiting for step ID: %s to be ready, interval: %ds, timeout: %ds, retries: %d\n", step.ID, r.Interval, r.Timeout, r.Retries)
+	helper := &probeHelper{}
+	defer b.removeProbeHelper(helper)
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = b.probe(ctx, step, helper)
+		if err == nil {
+			log.Printf("Step ID: %s is ready\n", step.ID)
+			return nil
+		}
+		var exitedErr *containerExitedError
+		if errors.As(err, &exitedErr) || attempt >= r.Retries {
+			break
+		}
+		if b.debug {
+			log.Printf("Step ID: %s isn't ready yet, attempt: %d, err: %v\n", step.ID, attempt, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.IntervalDuration()):
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return errors.Wrapf(err, "step ID: %s didn't become ready", step.ID)
+}
+
+// containerExitedError is returned when a detached step's container exits before it's ready.
+type containerExitedError struct {
+	exitCode int
+}
+
+func (e *containerExitedError) Error() string {
+	return fmt.Sprintf("container exited with code %d", e.exitCode)
+}
+
+// probeHelper is the container on a detached step's network which issues its TCP and HTTP probes.
+type probeHelper struct {
+	// name is the name of the running helper container, or empty if it hasn't been started.
+	name string
+}
+
+// probe probes a detached step once, giving the probe the step's Readiness timeout. TCP and HTTP probes are run in
+// the helper container on the step's network, so the step's container doesn't need to be reachable by acb.
+// The helper container is started by the first probe, before the probe's timeout starts, and runs until the step
+// is ready. During a dry run, only exec probes are issued, to the container runtime.
+func (b *Builder) probe(ctx context.Context, step *graph.Step, helper *probeHelper) error {
+	r := step.Readiness
+	if len(r.Exec) > 0 {
+		probeCtx, cancel := context.WithTimeout(ctx, r.TimeoutDuration())
+		defer cancel()
+		var buf bytes.Buffer
+		if err := b.containerRuntime.Exec(probeCtx, step.ID, r.Exec, &buf, &buf); err != nil {
+			// The command couldn't be run, e.g. because the container has exited.
+			var exitErr *procmanager.ExitError
+			if !errors.As(err, &exitErr) {
+				if info, inspectErr := b.containerRuntime.Inspect(probeCtx, step.ID); inspectErr == nil && !info.Running {
+					return &containerExitedError{exitCode: info.ExitCode}
+				}
+			}
+			if out := strings.TrimSpace(buf.String()); out != "" {
+				return errors.Wrap(err, out)
+			}
+			return err
+		}
+		return nil
+	}
+	if b.procManager.DryRun {
+		log.Printf("[DRY RUN] Probing step ID: %s\n", step.ID)
+		return nil
+	}
+
+	info, err := b.containerRuntime.Inspect(ctx, step.ID)
+	if err != nil {
+		return err
+	}
+	if !info.Running {
+		return &containerExitedError{exitCode: info.ExitCode}
+	}
+	host, err := containerHost(info, step.Network)
+	if err != nil {
+		return err
+	}
+
+	if helper.name == "" {
+		if err = b.startProbeHelper(ctx, step, host, helper); err != nil {
+			return err
+		}
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, r.TimeoutDuration())
+	defer cancel()
+	var buf bytes.Buffer
+	if err := b.containerRuntime.Exec(probeCtx, helper.name, probeCommand(r.HTTP != nil), &buf, &buf); err != nil {
+		// The probe couldn't be run, e.g. because the helper container has exited, so the next probe starts a new one.
+		var exitErr *procmanager.ExitError
+		if !errors.As(err, &exitErr) {
+			b.removeProbeHelper(helper)
+		}
+		if out := strings.TrimSpace(buf.String()); out != "" {
+			return errors.Wrap(err, out)
+		}
+		return err
+	}
+	return nil
+}
+
+// startProbeHelper starts the helper container which probes the step's container at host, tracking it for teardown.
+func (b *Builder) startProbeHelper(ctx context.Context, step *graph.Step, host string, helper *probeHelper) error {
+	r := step.Readiness
+	port := r.TCP
+	if r.HTTP != nil {
+		port = r.HTTP.Port
+	}
+	path := "/"
+	if r.HTTP != nil && r.HTTP.Path != "" {
+		path = r.HTTP.Path
+	}
+	opts := probeHelperRunOptions()
+	opts.Name = fmt.Sprintf("%s_probe_%s", step.ID, uuid.New())
+	opts.Network = step.Network
+	opts.Detach = true
+	opts.Env = []string{
+		"PROBE_HOST=" + host,
+		"PROBE_PORT=" + strconv.Itoa(port),
+		"PROBE_ADDRESS=" + net.JoinHostPort(host, strconv.Itoa(port)),
+		"PROBE_PATH=" + path,
+	}
+	b.trackContainer(opts.Name)
+	if _, err := b.containerRuntime.Run(ctx, opts); err != nil {
+		b.removeProbeHelper(&probeHelper{name: opts.Name})
+		return errors.Wrapf(err, "failed to start the probe container of step ID: %s", step.ID)
+	}
+	helper.name = opts.Name
+	return nil
+}
+
+// removeProbeHelper removes the helper container if it was started, using a separate context since the step's
+// context may be done. A container which couldn't be removed remains tracked.
+func (b *Builder) removeProbeHelper(helper *probeHelper) {
+	if helper.name == "" {
+		return
+	}
+	name := helper.name
+	helper.name = ""
+	ctx, cancel := context.WithTimeout(context.Background(), container.DefaultStopTimeout)
+	defer cancel()
+	if err := b.containerRuntime.Remove(ctx, name); err != nil && !container.IsNotFound(err) {
+		log.Printf("Failed to remove container: %s, err: %v\n", name, err)
+		return
+	}
+	b.untrackContainer(name)
+}
+
+// containerHost returns the address to probe a container at, which is its IP address on the specified network.
+func containerHost(info *container.Info, network string) (string, error) {
+	if network == "host" {
+		return "127.0.0.1", nil
+	}
+	if ip, ok := info.IPAddresses[network]; ok {
+		return ip, nil
+	}
+	if network == "" && len(info.IPAddresses) == 1 {
+		for _, ip := range info.IPAddresses {
+			return ip, nil
+		}
+	}
+	return "", fmt.Errorf("container %s has no IP address on network %q", info.Name, network)
+}
+
+// saveDetachedLogs prints the logs of a detached step's container or, if the Builder has a LogsDir,
+// saves them to <LogsDir>/<step ID>.log.
+func (b *Builder) saveDetachedLogs(ctx context.Context, step *graph.Step) {
+	if b.LogsDir == "" {
+		log.Printf("Logs of detached step ID: %s\n", step.ID)
+		stdout, stderr := redact.NewWriter(os.Stdout), redact.NewWriter(os.Stderr)
+		defer func() {
+			_ = stdout.Flush()
+			_ = stderr.Flush()
+		}()
+		if err := b.containerRuntime.Logs(ctx, step.ID, false, stdout, stderr); err != nil && !container.IsNotFound(err) {
+			log.Printf("Failed to read the logs of step ID: %s, err: %v\n", step.ID, err)
+		}
+		return
+	}
+
+	if err := os.MkdirAll(b.LogsDir, 0755); err != nil {
+		log.Printf("Failed to create logs directory: %s, err: %v\n", b.LogsDir, err)
+		return
+	}
+	path := filepath.Join(b.LogsDir, step.ID+".log")
+	f, err := os.Create(path)
+	if err != nil {
+		log.Printf("Failed to create log file: %s, err: %v\n", path, err)
+		return
+	}
+	defer f.Close()
+	w := redact.NewWriter(f)
+	defer func() { _ = w.Flush() }()
+	if err := b.containerRuntime.Logs(ctx, step.ID, false, w, w); err != nil && !container.IsNotFound(err) {
+		log.Printf("Failed to read the logs of step ID: %s, err: %v\n", step.ID, err)
+		return
+	}
+	log.Printf("Saved the logs of detached step ID: %s to %s\n", step.ID, path)
+}
@@ -0,0 +1,44 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import "github.com/Azure/acr-builder/pkg/container"
+
+const (
+	// probeHelperScript keeps the helper container running so that probes can be run in it.
+	probeHelperScript = `while :; do sleep 3600; done`
+
+	// tcpProbeScript connects to PROBE_HOST:PROBE_PORT.
+	tcpProbeScript = `exec 3<>"/dev/tcp/$PROBE_HOST/$PROBE_PORT"`
+
+	// httpProbeScript sends a GET request for PROBE_PATH to PROBE_HOST:PROBE_PORT and fails unless it responds
+	// with a 2xx or 3xx status.
+	httpProbeScript = `exec 3<>"/dev/tcp/$PROBE_HOST/$PROBE_PORT" || exit 1
+printf 'GET %s HTTP/1.0\r\nHost: %s\r\n\r\n' "$PROBE_PATH" "$PROBE_ADDRESS" >&3
+read -r _ status _ <&3
+if ! [ "$status" -lt 400 ] 2>/dev/null; then
+  echo "GET $PROBE_PATH responded with ${status:-nothing}"
+  exit 1
+fi`
+)
+
+// probeRunOptions returns the options of a container which probes the address. The probe's values are passed
+// as environment variables.
+// probeHelperRunOptions returns the options of the helper container which probes run in, which idles until it's removed.
+func probeHelperRunOptions() *container.RunOptions {
+	return &container.RunOptions{
+		Image:      configImageName,
+		Entrypoint: []string{"bash"},
+		Cmd:        []string{"-c", probeHelperScript},
+	}
+}
+
+// probeCommand returns the command which runs a TCP or HTTP probe in the helper container.
+func probeCommand(isHTTP bool) []string {
+	script := tcpProbeScript
+	if isHTTP {
+		script = httpProbeScript
+	}
+	return []string{"bash", "-c", script}
+}
@@ -0,0 +1,178 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/container"
+	"github.com/Azure/acr-builder/pkg/procmanager"
+)
+
+func TestContainerHost(t *testing.T) {
+	tests := []struct {
+		ips         map[string]string
+		network     string
+		expected    string
+		shouldError bool
+	}{
+		{map[string]string{"acb_default_network": "172.18.0.2"}, "acb_default_network", "172.18.0.2", false},
+		{map[string]string{"bridge": "172.17.0.2", "acb": "172.18.0.2"}, "acb", "172.18.0.2", false},
+		{map[string]string{"bridge": "172.17.0.2"}, "", "172.17.0.2", false},
+		{nil, "host", "127.0.0.1", false},
+		{map[string]string{"bridge": "172.17.0.2"}, "acb", "", true},
+		{nil, "", "", true},
+	}
+	for _, test := range tests {
+		actual, err := containerHost(&container.Info{Name: "db", IPAddresses: test.ips}, test.network)
+		if test.shouldError {
+			if err == nil {
+				t.Errorf("expected an error for network %q but got %s", test.network, actual)
+			}
+			continue
+		}
+		if err != nil || actual != test.expected {
+			t.Errorf("expected %s for network %q but got %s, err: %v", test.expected, test.network, actual, err)
+		}
+	}
+}
+
+// exitedRuntime is a container runtime whose containers have exited, so commands can't be run in them.
+type exitedRuntime struct {
+	container.Runtime
+}
+
+func (r *exitedRuntime) Exec(context.Context, string, []string, io.Writer, io.Writer) error {
+	return &container.Error{StatusCode: http.StatusConflict, Message: "container is not running"}
+}
+
+func (r *exitedRuntime) Inspect(_ context.Context, id string) (*container.Info, error) {
+	return &container.Info{ID: id, Name: id, Status: "exited", ExitCode: 3}, nil
+}
+
+func TestProbe_ExecAgainstExitedContainer(t *testing.T) {
+	b := &Builder{procManager: procmanager.NewProcManager(false), containerRuntime: &exitedRuntime{}}
+	step := &graph.Step{ID: "db", Readiness: &graph.Readiness{Exec: []string{"pg_isready"}}}
+	err := b.probe(context.Background(), step, &probeHelper{})
+	var exitedErr *containerExitedError
+	if !errors.As(err, &exitedErr) || exitedErr.exitCode != 3 {
+		t.Errorf("expected the probe to report that the container exited with 3 but got %v", err)
+	}
+}
+
+// probeRuntime is a container runtime whose step container becomes ready after a number of TCP probes.
+type probeRuntime struct {
+	container.Runtime
+	failures int
+	started  []string
+	probed   []string
+	removed  []string
+}
+
+func (r *probeRuntime) Inspect(_ context.Context, id string) (*container.Info, error) {
+	return &container.Info{ID: id, Name: id, Running: true, IPAddresses: map[string]string{"acb": "172.18.0.2"}}, nil
+}
+
+func (r *probeRuntime) Run(_ context.Context, opts *container.RunOptions) (string, error) {
+	r.started = append(r.started, opts.Name)
+	return opts.Name, nil
+}
+
+func (r *probeRuntime) Exec(_ context.Context, id string, _ []string, _ io.Writer, _ io.Writer) error {
+	r.probed = append(r.probed, id)
+	if len(r.probed) <= r.failures {
+		return &procmanager.ExitError{Code: 1, Err: errors.New("connection refused")}
+	}
+	return nil
+}
+
+func (r *probeRuntime) Remove(_ context.Context, id string) error {
+	r.removed = append(r.removed, id)
+	return nil
+}
+
+func TestWaitUntilReady_ReusesProbeContainer(t *testing.T) {
+	rt := &probeRuntime{failures: 2}
+	b := &Builder{procManager: procmanager.NewProcManager(false), containerRuntime: rt}
+	step := &graph.Step{ID: "db", Network: "acb", Readiness: &graph.Readiness{TCP: 5432, Timeout: 5, Retries: 3}}
+	if err := b.waitUntilReady(context.Background(), step); err != nil {
+		t.Fatalf("expected the step to become ready but got %v", err)
+	}
+	if len(rt.started) != 1 {
+		t.Fatalf("expected one probe container to be started but got %v", rt.started)
+	}
+	for _, id := range rt.probed {
+		if id != rt.started[0] {
+			t.Errorf("expected every probe to run in %s but got %v", rt.started[0], rt.probed)
+			break
+		}
+	}
+	if len(rt.probed) != 3 {
+		t.Errorf("expected 3 probes but got %d", len(rt.probed))
+	}
+	if !reflect.DeepEqual(rt.removed, rt.started) || len(b.trackedContainers()) != 0 {
+		t.Errorf("expected the probe container to be removed but got removed: %v, tracked: %v", rt.removed, b.trackedContainers())
+	}
+}
+
+func TestProbeScripts(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the probe scripts are run by bash")
+	}
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash isn't installed")
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ready" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	_, closedPort, _ := net.SplitHostPort(closed.Addr().String())
+	_ = closed.Close()
+
+	tests := []struct {
+		isHTTP      bool
+		port        string
+		path        string
+		shouldError bool
+	}{
+		{false, port, "", false},
+		{false, closedPort, "", true},
+		{true, port, "/ready", false},
+		{true, port, "/", true},
+		{true, closedPort, "/ready", true},
+	}
+	for _, test := range tests {
+		probe := probeCommand(test.isHTTP)
+		cmd := exec.Command(probe[0], probe[1:]...) //#nosec G204
+		cmd.Env = append(os.Environ(),
+			"PROBE_HOST="+host,
+			"PROBE_PORT="+test.port,
+			"PROBE_ADDRESS="+net.JoinHostPort(host, test.port),
+			"PROBE_PATH="+test.path)
+		out, err := cmd.CombinedOutput()
+		if test.shouldError && err == nil {
+			t.Errorf("expected the probe of port %s, http: %v, path: %s to fail", test.port, test.isHTTP, test.path)
+		}
+		if !test.shouldError && err != nil {
+			t.Errorf("expected the probe of port %s, http: %v, path: %s to succeed but got %v: %s", test.port, test.isHTTP, test.path, err, out)
+		}
+	}
+}
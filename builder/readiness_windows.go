@@ -0,0 +1,42 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import "github.com/Azure/acr-builder/pkg/container"
+
+const (
+	// probeHelperScript keeps the helper container running so that probes can be run in it.
+	probeHelperScript = `while ($true) { Start-Sleep -Seconds 3600 }`
+
+	// tcpProbeScript connects to PROBE_HOST:PROBE_PORT.
+	tcpProbeScript = `$ErrorActionPreference = 'Stop'; ` +
+		`$c = New-Object System.Net.Sockets.TcpClient; $c.Connect($env:PROBE_HOST, [int]$env:PROBE_PORT); $c.Close()`
+
+	// httpProbeScript sends a GET request for PROBE_PATH to PROBE_ADDRESS and fails unless it responds
+	// with a 2xx or 3xx status.
+	httpProbeScript = `$ErrorActionPreference = 'Stop'; ` +
+		`try { $s = [int](Invoke-WebRequest -UseBasicParsing -MaximumRedirection 0 -Uri ('http://' + $env:PROBE_ADDRESS + $env:PROBE_PATH)).StatusCode } ` +
+		`catch { if (-not $_.Exception.Response) { throw }; $s = [int]$_.Exception.Response.StatusCode }; ` +
+		`if ($s -ge 400) { Write-Output ('GET ' + $env:PROBE_PATH + ' responded with ' + $s); exit 1 }`
+)
+
+// probeRunOptions returns the options of a container which probes the address. The probe's values are passed
+// as environment variables.
+// probeHelperRunOptions returns the options of the helper container which probes run in, which idles until it's removed.
+func probeHelperRunOptions() *container.RunOptions {
+	return &container.RunOptions{
+		Image:      getConfigImageName(),
+		Entrypoint: []string{"powershell"},
+		Cmd:        []string{probeHelperScript},
+	}
+}
+
+// probeCommand returns the command which runs a TCP or HTTP probe in the helper container.
+func probeCommand(isHTTP bool) []string {
+	script := tcpProbeScript
+	if isHTTP {
+		script = httpProbeScript
+	}
+	return []string{"powershell", script}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/image"
+	"github.com/pkg/errors"
+)
+
+const (
+	// RunSucceeded indicates that all of a Task's steps completed successfully.
+	RunSucceeded = "succeeded"
+
+	// RunFailed indicates that a Task's run encountered an error.
+	RunFailed = "failed"
+
+	reportFileMode = 0600
+)
+
+// RunReport is a machine-readable summary of a Task's run.
+type RunReport struct {
+	TaskName  string        `json:"taskName"`
+	Status    string        `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	StartTime time.Time     `json:"startTime"`
+	EndTime   time.Time     `json:"endTime"`
+	Steps     []*StepReport `json:"steps"`
+}
+
+// StepReport is a machine-readable summary of a single step's execution.
+type StepReport struct {
+	ID                string                `json:"id"`
+	Status            graph.StepStatus      `json:"status"`
+	SkipReason        string                `json:"skipReason,omitempty"`
+	StartTime         time.Time             `json:"startTime"`
+	EndTime           time.Time             `json:"endTime"`
+	ExitCode          int                   `json:"exitCode"`
+	RetryCount        int                   `json:"retryCount"`
+	ContainerName     string                `json:"containerName,omitempty"`
+	PushedTags        []string              `json:"pushedTags,omitempty"`
+	PushedDigests     map[string]string     `json:"pushedDigests,omitempty"`
+	Outputs           map[string]string     `json:"outputs,omitempty"`
+	ImageDependencies []*image.Dependencies `json:"imageDependencies,omitempty"`
+}
+
+// NewRunReport creates a RunReport from the specified Task and the error returned from running it, if any.
+func NewRunReport(task *graph.Task, startTime time.Time, endTime time.Time, runErr error) *RunReport {
+	report := &RunReport{
+		Status:    RunSucceeded,
+		StartTime: startTime,
+		EndTime:   endTime,
+		Steps:     []*StepReport{},
+	}
+	if runErr != nil {
+		report.Status = RunFailed
+		report.Error = runErr.Error()
+	}
+	if task == nil {
+		return report
+	}
+
+	report.TaskName = task.TaskName
+	for _, step := range task.Steps {
+		report.Steps = append(report.Steps, &StepReport{
+			ID:                step.ID,
+			Status:            step.StepStatus,
+			SkipReason:        step.SkipReason,
+			StartTime:         step.StartTime,
+			EndTime:           step.EndTime,
+			ExitCode:          step.ExitCode,
+			RetryCount:        step.RetryCount,
+			ContainerName:     step.ContainerName,
+			PushedTags:        step.PushedTags,
+			PushedDigests:     step.PushedDigests,
+			Outputs:           step.Outputs,
+			ImageDependencies: step.ImageDependencies,
+		})
+	}
+	return report
+}
+
+// WriteFile serializes the report as JSON and writes it to the specified file.
+func (r *RunReport) WriteFile(file string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal run report")
+	}
+	if err = os.WriteFile(file, data, reportFileMode); err != nil {
+		return errors.Wrapf(err, "failed to write run report to %s", file)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/image"
+)
+
+func TestNewRunReport(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Minute)
+	task := &graph.Task{
+		TaskName: "quickrun",
+		Steps: []*graph.Step{
+			{
+				ID:            "build",
+				StepStatus:    graph.Successful,
+				StartTime:     start,
+				EndTime:       end,
+				ContainerName: "build",
+				ImageDependencies: []*image.Dependencies{
+					{Image: &image.Reference{Reference: "foo:bar"}},
+				},
+			},
+			{
+				ID:            "push",
+				StepStatus:    graph.Failed,
+				ExitCode:      1,
+				RetryCount:    2,
+				ContainerName: "acb_docker_push_1",
+				PushedTags:    []string{"foo:bar"},
+			},
+		},
+	}
+
+	report := NewRunReport(task, start, end, errors.New("failed to run step ID: push"))
+	if report.Status != RunFailed {
+		t.Errorf("expected status %s but got %s", RunFailed, report.Status)
+	}
+	if report.Error != "failed to run step ID: push" {
+		t.Errorf("unexpected error in report: %s", report.Error)
+	}
+	if report.TaskName != "quickrun" {
+		t.Errorf("unexpected task name in report: %s", report.TaskName)
+	}
+	if len(report.Steps) != 2 {
+		t.Fatalf("expected 2 steps but got %d", len(report.Steps))
+	}
+	if report.Steps[0].ID != "build" || len(report.Steps[0].ImageDependencies) != 1 {
+		t.Errorf("unexpected build step report: %+v", report.Steps[0])
+	}
+	push := report.Steps[1]
+	if push.Status != graph.Failed || push.ExitCode != 1 || push.RetryCount != 2 || push.ContainerName != "acb_docker_push_1" {
+		t.Errorf("unexpected push step report: %+v", push)
+	}
+
+	if report = NewRunReport(nil, start, end, nil); report.Status != RunSucceeded || len(report.Steps) != 0 {
+		t.Errorf("unexpected report for a nil task: %+v", report)
+	}
+}
+
+func TestRunReportWriteFile(t *testing.T) {
+	task := &graph.Task{
+		TaskName: "quickrun",
+		Steps: []*graph.Step{
+			{ID: "a", StepStatus: graph.Successful},
+		},
+	}
+	file := filepath.Join(t.TempDir(), "report.json")
+	if err := NewRunReport(task, time.Now(), time.Now(), nil).WriteFile(file); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	var actual RunReport
+	if err := json.Unmarshal(data, &actual); err != nil {
+		t.Fatalf("failed to unmarshal report: %v", err)
+	}
+	if actual.Status != RunSucceeded || len(actual.Steps) != 1 || actual.Steps[0].Status != graph.Successful {
+		t.Errorf("unexpected report: %+v", actual)
+	}
+}
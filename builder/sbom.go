@@ -0,0 +1,87 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/Azure/acr-builder/pkg/sbom"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+// attachSBOM generates an SBOM for the pushed image if it was built by a build step which generates SBOMs,
+// and pushes it to the image's repository as an artifact which refers to the image's manifest.
+func (b *Builder) attachSBOM(ctx context.Context, store *imageArchive, img string, desc ocispec.Descriptor, client remote.Client) error {
+	step := b.buildStep(img)
+	if step == nil || !step.SBOM {
+		return nil
+	}
+
+	log.Printf("Generating an SBOM for image: %s\n", img)
+	deps, err := b.pushedImageDependencies(ctx, step, img, desc)
+	if err != nil {
+		return err
+	}
+	inv, err := inventoryImage(ctx, store, desc)
+	if err != nil {
+		return errors.Wrap(err, "failed to inventory the image's packages")
+	}
+	for _, warning := range inv.Warnings {
+		log.Printf("WARNING: %s\n", warning)
+	}
+	data, err := json.Marshal(sbom.NewDocument(deps, desc.Digest.String(), inv, time.Now()))
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the SBOM")
+	}
+
+	repo, _, err := newRepository(img, client)
+	if err != nil {
+		return err
+	}
+	manifest, err := pushReferrer(ctx, repo, desc, sbom.MediaType, sbom.MediaType, data)
+	if err != nil {
+		return errors.Wrap(err, "failed to push the SBOM")
+	}
+	log.Printf("Successfully attached an SBOM with %d packages to image: %s, digest: %s\n", len(inv.Packages), img, manifest.Digest)
+	return nil
+}
+
+// inventoryImage returns the packages installed in the image with the specified manifest.
+// Foreign layers aren't in the store, so their packages aren't included.
+func inventoryImage(ctx context.Context, store content.Fetcher, desc ocispec.Descriptor) (*sbom.Inventory, error) {
+	data, err := content.FetchAll(ctx, store, desc)
+	if err != nil {
+		return nil, err
+	}
+	var manifest ocispec.Manifest
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the image's manifest")
+	}
+
+	scanner := sbom.NewScanner()
+	for _, layer := range manifest.Layers {
+		if len(layer.URLs) > 0 {
+			continue
+		}
+		if err = addLayer(ctx, store, layer, scanner); err != nil {
+			return nil, errors.Wrapf(err, "failed to read layer %s", layer.Digest)
+		}
+	}
+	return scanner.Inventory(), nil
+}
+
+func addLayer(ctx context.Context, store content.Fetcher, layer ocispec.Descriptor, scanner *sbom.Scanner) error {
+	rc, err := store.Fetch(ctx, layer)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return scanner.AddLayer(rc)
+}
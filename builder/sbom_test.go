@@ -0,0 +1,149 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/image"
+	"github.com/Azure/acr-builder/pkg/sbom"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// loadTestSBOMImage creates a store containing an image tagged as img, which has a layer with a dpkg database.
+func loadTestSBOMImage(t *testing.T, img string) *imageArchive {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"etc/os-release":      []byte("ID=debian\nVERSION_ID=\"12\"\n"),
+		"var/lib/dpkg/status": []byte("Package: curl\nStatus: install ok installed\nArchitecture: amd64\nVersion: 7.88.1-10\n"),
+	}
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	for name, data := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatalf("failed to write tar entry: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+	config, _ := json.Marshal(ocispec.Image{RootFS: ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{digest.FromBytes(layer.Bytes())}}})
+	if err := os.WriteFile(filepath.Join(dir, "layer.tar"), layer.Bytes(), 0600); err != nil {
+		t.Fatalf("failed to write layer: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), config, 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	store := &imageArchive{
+		dir:       dir,
+		files:     make(map[digest.Digest]string),
+		manifests: make(map[digest.Digest][]byte),
+		tags:      make(map[string]ocispec.Descriptor),
+	}
+	desc, err := store.addImage(imageArchiveManifest{Config: "config.json", Layers: []string{"layer.tar"}}, make(map[string]ocispec.Descriptor))
+	if err != nil {
+		t.Fatalf("failed to add image: %v", err)
+	}
+	store.tags[img] = desc
+	return store
+}
+
+func TestAttachSBOM(t *testing.T) {
+	registry := &testRegistry{blobs: make(map[string]map[string]bool), manifests: make(map[string]string)}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	img := host + "/foo:v1"
+
+	step := &graph.Step{
+		ID:    "build",
+		Build: "-t " + img + " .",
+		SBOM:  true,
+		Tags:  []string{img},
+		ImageDependencies: []*image.Dependencies{{
+			Image: &image.Reference{Registry: host, Repository: "foo", Tag: "v1", Reference: img},
+			Runtime: &image.Reference{
+				Registry:   "registry.hub.docker.com",
+				Repository: "library/debian",
+				Tag:        "12",
+				Digest:     "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+				Reference:  "debian:12",
+			},
+		}},
+	}
+	b := &Builder{buildSteps: []*graph.Step{step}}
+	store := loadTestSBOMImage(t, img)
+	client := &http.Client{}
+	pushed := make(map[string][]string)
+	desc, err := pushImage(context.Background(), store, img, client, pushed)
+	if err != nil {
+		t.Fatalf("failed to push %s: %v", img, err)
+	}
+	if err = b.attachSBOM(context.Background(), store, img, desc, client); err != nil {
+		t.Fatalf("failed to attach SBOM: %v", err)
+	}
+
+	// The registry doesn't support the referrers API, so the SBOM's manifest is added to the referrers tag's index.
+	referrersTag := "foo:" + strings.Replace(desc.Digest.String(), ":", "-", 1)
+	var index ocispec.Index
+	if err = json.Unmarshal(registry.contents[registry.manifests[referrersTag]], &index); err != nil {
+		t.Fatalf("failed to parse the referrers index: %v", err)
+	}
+	if len(index.Manifests) != 1 || index.Manifests[0].ArtifactType != sbom.MediaType {
+		t.Fatalf("expected the referrers index to contain the SBOM but got %+v", index.Manifests)
+	}
+	var manifest ocispec.Manifest
+	if err = json.Unmarshal(registry.contents[index.Manifests[0].Digest.String()], &manifest); err != nil {
+		t.Fatalf("failed to parse the SBOM's manifest: %v", err)
+	}
+	if manifest.Subject == nil || manifest.Subject.Digest != desc.Digest {
+		t.Errorf("expected the SBOM's subject to be %s but got %+v", desc.Digest, manifest.Subject)
+	}
+	if len(manifest.Layers) != 1 || !registry.blobs["foo"][manifest.Layers[0].Digest.String()] {
+		t.Errorf("expected the SBOM to be pushed but got %+v", manifest.Layers)
+	}
+	if step.ImageDependencies[0].Image.Digest != desc.Digest.String() {
+		t.Errorf("expected the image's digest to be %s but got %s", desc.Digest, step.ImageDependencies[0].Image.Digest)
+	}
+
+	// Images which aren't built by steps which generate SBOMs don't get one.
+	other := host + "/bar:v1"
+	if err = b.attachSBOM(context.Background(), store, other, desc, client); err != nil {
+		t.Errorf("expected no SBOM to be attached to %s but got %v", other, err)
+	}
+}
+
+func TestInventoryImage(t *testing.T) {
+	store := loadTestSBOMImage(t, "docker.io/library/foo:v1")
+	desc, err := store.Resolve(context.Background(), "foo:v1")
+	if err != nil {
+		t.Fatalf("failed to resolve image: %v", err)
+	}
+	inv, err := inventoryImage(context.Background(), store, desc)
+	if err != nil {
+		t.Fatalf("failed to inventory image: %v", err)
+	}
+	expected := sbom.Package{Name: "curl", Version: "7.88.1-10", Type: sbom.TypeDeb, Arch: "amd64", Location: "var/lib/dpkg/status"}
+	if len(inv.Packages) != 1 || inv.Packages[0] != expected {
+		t.Errorf("expected packages %+v but got %+v", expected, inv.Packages)
+	}
+	if inv.OS == nil || inv.OS.ID != "debian" || inv.OS.VersionID != "12" {
+		t.Errorf("expected the OS to be debian 12 but got %+v", inv.OS)
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/pkg/errors"
+)
+
+// scheduler walks a Task's Dag and runs each step once all of its dependencies have completed.
+type scheduler struct {
+	task *graph.Task
+
+	// runStep executes a single step.
+	runStep func(ctx context.Context, step *graph.Step, credentials []*graph.RegistryCredential) error
+
+	// ctx is cancelled to stop all running steps, i.e. when a step fails and the Task is fail fast.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// slots limits the number of concurrently running steps. It's nil if there's no limit.
+	slots chan struct{}
+
+	// errorChan receives an error for every step that fails.
+	errorChan chan error
+
+	// mu serializes edge removal so that exactly one parent releases a child,
+	// and guards blocked.
+	mu sync.Mutex
+
+	// blocked tracks steps which can't run because a step they depend on didn't succeed.
+	blocked map[string]bool
+
+	// wg tracks the goroutines processing steps, so that run only returns once they've stopped.
+	wg sync.WaitGroup
+}
+
+func newScheduler(ctx context.Context, b *Builder, task *graph.Task) *scheduler {
+	s := &scheduler{
+		task:      task,
+		runStep:   b.runStep,
+		errorChan: make(chan error),
+		blocked:   make(map[string]bool),
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	if task.MaxParallelism > 0 {
+		s.slots = make(chan struct{}, task.MaxParallelism)
+	}
+	return s
+}
+
+// run processes the steps in the Task's Dag and blocks until either:
+// - The global context expires
+// - A step has an error and the Task is fail fast, in which case all running steps are cancelled
+// - All steps have been processed
+// If the Task isn't fail fast, the errors of all failed steps are aggregated and returned.
+func (s *scheduler) run(ctx context.Context) error {
+	// Only wait for the steps in the Dag, since steps pruned from it never run.
+	var completedChans []chan bool
+	for _, node := range s.task.Dag.Nodes {
+		completedChans = append(completedChans, node.Value.CompletedChan)
+	}
+
+	// Wait for running steps to stop before returning, so the caller sees their final status.
+	defer s.wg.Wait()
+	for _, child := range s.task.Dag.Root.Children() {
+		s.goProcessVertex(s.task.Dag.Root, child, false)
+	}
+
+	var stepErrors []string
+	for _, ch := range completedChans {
+		completed := false
+		for !completed {
+			select {
+			case <-ctx.Done():
+				s.cancel()
+				return ctx.Err()
+			case <-ch:
+				completed = true
+			case err := <-s.errorChan:
+				if s.task.IsFailFast() {
+					s.cancel()
+					return err
+				}
+				log.Printf("%v. The task isn't fail fast, continuing with independent steps...\n", err)
+				stepErrors = append(stepErrors, err.Error())
+			}
+		}
+	}
+	if len(stepErrors) > 0 {
+		return fmt.Errorf("%d step(s) failed: %s", len(stepErrors), strings.Join(stepErrors, ", "))
+	}
+	return nil
+}
+
+// goProcessVertex processes the vertex on a goroutine tracked by wg.
+func (s *scheduler) goProcessVertex(parent *graph.Node, child *graph.Node, skip bool) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.processVertex(parent, child, skip)
+	}()
+}
+
+// processVertex removes the edge between parent and child and, if child has no remaining
+// dependencies, runs it. If skip is true, child is marked as blocked and will not be run.
+func (s *scheduler) processVertex(parent *graph.Node, child *graph.Node, skip bool) {
+	s.mu.Lock()
+	if skip {
+		s.blocked[child.Name] = true
+	}
+	err := s.task.Dag.RemoveEdge(parent.Name, child.Name)
+	degree := child.GetDegree()
+	blocked := s.blocked[child.Name]
+	s.mu.Unlock()
+
+	if err != nil {
+		s.fail(errors.Wrap(err, "failed to remove edge"))
+		return
+	}
+	if degree != 0 {
+		return
+	}
+
+	step := child.Value
+	if step.If != "" {
+		// Steps with a condition are evaluated even if a step they depend on didn't succeed,
+		// which allows them to react to failures.
+		shouldRun, condErr := s.evaluateCondition(step)
+		if condErr != nil {
+			step.StepStatus = graph.Failed
+			s.fail(errors.Wrapf(condErr, "failed to run step ID: %s", step.ID))
+			s.complete(child, true)
+			return
+		}
+		if !shouldRun {
+			s.skip(child, fmt.Sprintf("condition %q evaluated to false", step.If), blocked)
+			return
+		}
+	} else if blocked {
+		s.skip(child, "a step it depends on did not succeed", true)
+		return
+	}
+
+	// Outputs are resolved lazily since they're only available once the step's dependencies have completed.
+	if err = s.task.ResolveStepOutputs(step); err != nil {
+		step.StepStatus = graph.Failed
+		s.fail(errors.Wrapf(err, "failed to run step ID: %s", step.ID))
+		s.complete(child, true)
+		return
+	}
+
+	if !s.acquire() {
+		// The run was cancelled before the step could start.
+		step.StepStatus = graph.Cancelled
+		s.complete(child, true)
+		return
+	}
+	err = s.runStep(s.ctx, step, s.task.Credentials)
+	s.release()
+
+	if err != nil && step.IgnoreErrors {
+		log.Printf("Step ID: %s encountered an error: %v, but is set to ignore errors. Continuing...\n", step.ID, err)
+		step.StepStatus = graph.Successful
+		s.complete(child, false)
+	} else if err != nil {
+		switch {
+		case s.ctx.Err() != nil:
+			step.StepStatus = graph.Cancelled
+		case errors.Is(err, context.DeadlineExceeded):
+			step.StepStatus = graph.TimedOut
+		default:
+			step.StepStatus = graph.Failed
+		}
+		s.fail(errors.Wrapf(err, "failed to run step ID: %s", step.ID))
+		s.complete(child, true)
+	} else {
+		step.StepStatus = graph.Successful
+		s.complete(child, false)
+	}
+}
+
+// skip marks the node's step as skipped for the specified reason and completes it.
+func (s *scheduler) skip(node *graph.Node, reason string, skipChildren bool) {
+	step := node.Value
+	log.Printf("Skipping step ID: %s since %s\n", step.ID, reason)
+	step.StepStatus = graph.Skipped
+	step.SkipReason = reason
+	s.complete(node, skipChildren)
+}
+
+// evaluateCondition determines whether or not the step's condition allows it to run.
+func (s *scheduler) evaluateCondition(step *graph.Step) (bool, error) {
+	condition, err := graph.ParseCondition(step.If)
+	if err != nil {
+		return false, err
+	}
+	return condition.Evaluate(s.task.NewConditionContext(step))
+}
+
+// complete releases the node's children and signals that the node's step has been processed.
+func (s *scheduler) complete(node *graph.Node, skipChildren bool) {
+	for _, c := range node.Children() {
+		s.goProcessVertex(node, c, skipChildren)
+	}
+
+	// Step must always be marked as complete.
+	select {
+	case node.Value.CompletedChan <- true:
+	case <-s.ctx.Done():
+	}
+}
+
+// fail reports a step failure to the reader of errorChan.
+func (s *scheduler) fail(err error) {
+	select {
+	case s.errorChan <- err:
+	case <-s.ctx.Done():
+	}
+}
+
+// acquire blocks until a step is allowed to run, returning false if the run was cancelled.
+func (s *scheduler) acquire() bool {
+	if s.slots == nil || s.ctx.Err() != nil {
+		return s.ctx.Err() == nil
+	}
+	select {
+	case s.slots <- struct{}{}:
+		// A slot may be freed by a step which was cancelled, in which case the run must not continue.
+		if s.ctx.Err() != nil {
+			s.release()
+			return false
+		}
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+// release frees a slot acquired by acquire.
+func (s *scheduler) release() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
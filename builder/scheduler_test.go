@@ -0,0 +1,348 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/acr-builder/graph"
+)
+
+func newTestTask(t *testing.T, failFast bool, maxParallelism int, steps ...*graph.Step) *graph.Task {
+	task, err := graph.NewTask(context.Background(), steps, nil, "", nil, true, "", "")
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+	task.FailFast = &failFast
+	task.MaxParallelism = maxParallelism
+	return task
+}
+
+func TestScheduler_FailFastCancelsRunningSteps(t *testing.T) {
+	task := newTestTask(t, true, 0,
+		&graph.Step{ID: "a", Cmd: "a", When: []string{"-"}},
+		&graph.Step{ID: "b", Cmd: "b", When: []string{"-"}},
+		&graph.Step{ID: "c", Cmd: "c", When: []string{"a"}},
+	)
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	s := newScheduler(context.Background(), &Builder{}, task)
+	defer s.cancel()
+	s.runStep = func(ctx context.Context, step *graph.Step, _ []*graph.RegistryCredential) error {
+		switch step.ID {
+		case "a":
+			// Only fail once b is running, so that it's cancelled rather than never started.
+			<-started
+			return errors.New("boom")
+		case "b":
+			close(started)
+			<-ctx.Done()
+			close(cancelled)
+			return ctx.Err()
+		}
+		t.Errorf("step %s should not have run", step.ID)
+		return nil
+	}
+
+	err := s.run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "step ID: a") {
+		t.Fatalf("expected step a to fail the task, got: %v", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected step b to be cancelled")
+	}
+}
+
+func TestScheduler_ContinuesIndependentStepsWhenNotFailFast(t *testing.T) {
+	task := newTestTask(t, false, 0,
+		&graph.Step{ID: "a", Cmd: "a", When: []string{"-"}},
+		&graph.Step{ID: "b", Cmd: "b", When: []string{"a"}},
+		&graph.Step{ID: "c", Cmd: "c", When: []string{"-"}},
+		&graph.Step{ID: "d", Cmd: "d", When: []string{"c", "b"}},
+		&graph.Step{ID: "e", Cmd: "e", When: []string{"c"}},
+		&graph.Step{ID: "f", Cmd: "f", When: []string{"-"}},
+	)
+
+	var mu sync.Mutex
+	ran := map[string]bool{}
+	s := newScheduler(context.Background(), &Builder{}, task)
+	defer s.cancel()
+	s.runStep = func(_ context.Context, step *graph.Step, _ []*graph.RegistryCredential) error {
+		mu.Lock()
+		ran[step.ID] = true
+		mu.Unlock()
+		if step.ID == "a" || step.ID == "f" {
+			return errors.New("boom")
+		}
+		return nil
+	}
+
+	err := s.run(context.Background())
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.Contains(err.Error(), "2 step(s) failed") {
+		t.Errorf("expected both failures to be reported, got: %v", err)
+	}
+
+	expected := map[string]graph.StepStatus{
+		"a": graph.Failed,
+		"b": graph.Skipped,
+		"c": graph.Successful,
+		"d": graph.Skipped,
+		"e": graph.Successful,
+		"f": graph.Failed,
+	}
+	for _, step := range task.Steps {
+		if step.StepStatus != expected[step.ID] {
+			t.Errorf("expected step %s to be %s but got %s", step.ID, expected[step.ID], step.StepStatus)
+		}
+	}
+	for _, id := range []string{"b", "d"} {
+		if ran[id] {
+			t.Errorf("step %s should not have run", id)
+		}
+	}
+}
+
+func TestScheduler_MaxParallelism(t *testing.T) {
+	var steps []*graph.Step
+	for _, id := range []string{"a", "b", "c", "d", "e", "f"} {
+		steps = append(steps, &graph.Step{ID: id, Cmd: id, When: []string{"-"}})
+	}
+	task := newTestTask(t, true, 2, steps...)
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	s := newScheduler(context.Background(), &Builder{}, task)
+	defer s.cancel()
+	s.runStep = func(_ context.Context, _ *graph.Step, _ []*graph.RegistryCredential) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	}
+
+	if err := s.run(context.Background()); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if maxRunning != 2 {
+		t.Errorf("expected at most 2 steps to run concurrently but got %d", maxRunning)
+	}
+	for _, step := range task.Steps {
+		if step.StepStatus != graph.Successful {
+			t.Errorf("expected step %s to be successful but got %s", step.ID, step.StepStatus)
+		}
+	}
+}
+
+func TestScheduler_CancelsStepsWaitingForASlot(t *testing.T) {
+	task := newTestTask(t, true, 1,
+		&graph.Step{ID: "a", Cmd: "a", When: []string{"-"}},
+		&graph.Step{ID: "b", Cmd: "b", When: []string{"-"}},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var mu sync.Mutex
+	var ran []string
+	s := newScheduler(ctx, &Builder{}, task)
+	defer s.cancel()
+	s.runStep = func(ctx context.Context, step *graph.Step, _ []*graph.RegistryCredential) error {
+		mu.Lock()
+		ran = append(ran, step.ID)
+		mu.Unlock()
+		// The run is cancelled while the other step waits for the only slot.
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	if err := s.run(ctx); err != context.Canceled {
+		t.Fatalf("expected the run to be cancelled but got: %v", err)
+	}
+	if len(ran) != 1 {
+		t.Fatalf("expected only 1 step to run but got %v", ran)
+	}
+	// The step which never got a slot is cancelled rather than left as if it was never scheduled.
+	for _, step := range task.Steps {
+		if step.StepStatus != graph.Cancelled {
+			t.Errorf("expected step %s to be cancelled but got %s", step.ID, step.StepStatus)
+		}
+	}
+}
+
+func TestScheduler_Conditions(t *testing.T) {
+	task := newTestTask(t, false, 0,
+		&graph.Step{ID: "build", Cmd: "build"},
+		&graph.Step{ID: "push", Cmd: "push", If: `Run.Branch == "main"`},
+		&graph.Step{ID: "after-push", Cmd: "after-push"},
+		&graph.Step{ID: "notify", Cmd: "notify", When: []string{"build"}, If: `steps.build.status == "failed"`},
+		&graph.Step{ID: "cleanup", Cmd: "cleanup", When: []string{"build"}, If: `steps.build.status != "failed"`},
+	)
+	task.RunValues = map[string]string{"Branch": "dev"}
+
+	var mu sync.Mutex
+	ran := map[string]bool{}
+	s := newScheduler(context.Background(), &Builder{}, task)
+	defer s.cancel()
+	s.runStep = func(_ context.Context, step *graph.Step, _ []*graph.RegistryCredential) error {
+		mu.Lock()
+		ran[step.ID] = true
+		mu.Unlock()
+		if step.ID == "build" {
+			return errors.New("boom")
+		}
+		return nil
+	}
+
+	if err := s.run(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	expected := map[string]graph.StepStatus{
+		"build":      graph.Failed,
+		"push":       graph.Skipped,
+		"after-push": graph.Skipped,
+		"notify":     graph.Successful,
+		"cleanup":    graph.Skipped,
+	}
+	for _, step := range task.Steps {
+		if step.StepStatus != expected[step.ID] {
+			t.Errorf("expected step %s to be %s but got %s", step.ID, expected[step.ID], step.StepStatus)
+		}
+		if step.StepStatus == graph.Skipped && step.SkipReason == "" {
+			t.Errorf("expected step %s to have a skip reason", step.ID)
+		}
+	}
+	if !ran["notify"] || ran["push"] || ran["cleanup"] || ran["after-push"] {
+		t.Errorf("unexpected steps ran: %v", ran)
+	}
+}
+
+func TestScheduler_StepOutputs(t *testing.T) {
+	task := newTestTask(t, true, 0,
+		&graph.Step{ID: "version", Cmd: "version"},
+		&graph.Step{ID: "test", Cmd: "test " + graph.OutputReference("version", "tag")},
+	)
+
+	var cmd string
+	var envs []string
+	s := newScheduler(context.Background(), &Builder{}, task)
+	defer s.cancel()
+	s.runStep = func(_ context.Context, step *graph.Step, _ []*graph.RegistryCredential) error {
+		if step.ID == "version" {
+			step.Outputs = map[string]string{"tag": "1.0"}
+		} else {
+			cmd, envs = step.Cmd, step.Envs
+		}
+		return nil
+	}
+
+	if err := s.run(context.Background()); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if expected := "test 1.0"; cmd != expected {
+		t.Errorf("expected cmd to be %s but got %s", expected, cmd)
+	}
+	if expected := []string{"STEPS_VERSION_OUTPUTS_TAG=1.0"}; !reflect.DeepEqual(expected, envs) {
+		t.Errorf("expected envs to be %v but got %v", expected, envs)
+	}
+}
+
+func TestScheduler_ResumeFrom(t *testing.T) {
+	task := newTestTask(t, true, 0,
+		&graph.Step{ID: "build", Cmd: "build"},
+		&graph.Step{ID: "lint", Cmd: "lint", When: []string{"-"}},
+		&graph.Step{ID: "test", Cmd: "test", When: []string{"build"}},
+		&graph.Step{ID: "push", Cmd: "push " + graph.OutputReference("build", "tag"), When: []string{"test"}},
+	)
+	if err := task.ResumeFrom("push"); err != nil {
+		t.Fatalf("failed to resume task: %v", err)
+	}
+	// Outputs of resumed dependencies are restored from the previous run.
+	task.Steps[0].Outputs = map[string]string{"tag": "1.0"}
+
+	var mu sync.Mutex
+	var ran []string
+	s := newScheduler(context.Background(), &Builder{}, task)
+	defer s.cancel()
+	s.runStep = func(_ context.Context, step *graph.Step, _ []*graph.RegistryCredential) error {
+		mu.Lock()
+		ran = append(ran, step.Cmd)
+		mu.Unlock()
+		return nil
+	}
+
+	done := make(chan error)
+	go func() { done <- s.run(context.Background()) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the pruned task to complete")
+	}
+
+	if expected := []string{"push 1.0"}; !reflect.DeepEqual(expected, ran) {
+		t.Errorf("expected %v to run but got %v", expected, ran)
+	}
+	expectedStatuses := map[string]graph.StepStatus{
+		"build": graph.Successful,
+		"lint":  graph.Skipped,
+		"test":  graph.Successful,
+		"push":  graph.Successful,
+	}
+	for _, step := range task.Steps {
+		if step.StepStatus != expectedStatuses[step.ID] {
+			t.Errorf("expected step %s to be %s but got %s", step.ID, expectedStatuses[step.ID], step.StepStatus)
+		}
+	}
+}
+
+func TestScheduler_TimedOut(t *testing.T) {
+	task := newTestTask(t, false, 0,
+		&graph.Step{ID: "a", Cmd: "a", When: []string{"-"}},
+		&graph.Step{ID: "b", Cmd: "b", When: []string{"-"}},
+	)
+
+	s := newScheduler(context.Background(), &Builder{}, task)
+	defer s.cancel()
+	s.runStep = func(_ context.Context, step *graph.Step, _ []*graph.RegistryCredential) error {
+		if step.ID == "a" {
+			return fmt.Errorf("timed out after 1 seconds: %w", context.DeadlineExceeded)
+		}
+		return errors.New("boom")
+	}
+
+	if err := s.run(context.Background()); err == nil {
+		t.Fatal("expected the task to fail")
+	}
+	expected := map[string]graph.StepStatus{"a": graph.TimedOut, "b": graph.Failed}
+	for _, step := range task.Steps {
+		if step.StepStatus != expected[step.ID] {
+			t.Errorf("expected step %s to be %s but got %s", step.ID, expected[step.ID], step.StepStatus)
+		}
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"strings"
 
+	"github.com/Azure/acr-builder/pkg/container"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 )
@@ -19,24 +21,24 @@ const (
 	}`
 )
 
-// setupConfig initializes ~/.docker/config.json
-func (b *Builder) setupConfig(ctx context.Context) error {
-	args := []string{
-		"docker",
-		"run",
-		"--name", fmt.Sprintf("acb_init_config_%s", uuid.New()),
-		"--rm",
+// setupConfig initializes ~/.docker/config.json and the directory of the run's step outputs,
+// deleting the stale output files.
+func (b *Builder) setupConfig(ctx context.Context, staleOutputFiles []string) error {
+	var buf bytes.Buffer
+	opts := &container.RunOptions{
+		Name:       fmt.Sprintf("acb_init_config_%s", uuid.New()),
+		Image:      configImageName,
+		Entrypoint: []string{"bash"},
+		Cmd:        []string{"-c", "mkdir -p ~/.docker " + b.stepOutputsRunDir() + " && rm -f " + strings.Join(staleOutputFiles, " ") + " && cat << EOF > ~/.docker/config.json\n" + config + "\nEOF"},
+		Remove:     true,
 
 		// Home
-		"--volume", homeVol + ":" + homeWorkDir,
-		"--env", homeEnv,
-		"--entrypoint", "bash",
-		configImageName,
-		"-c", "mkdir -p ~/.docker && cat << EOF > ~/.docker/config.json\n" + config + "\nEOF",
+		Binds:  []string{homeVol + ":" + homeWorkDir},
+		Env:    []string{homeEnv},
+		Stdout: &buf,
+		Stderr: &buf,
 	}
-
-	var buf bytes.Buffer
-	if err := b.procManager.Run(ctx, args, nil, &buf, &buf, ""); err != nil {
+	if err := b.runContainer(ctx, opts); err != nil {
 		return errors.Wrap(err, fmt.Sprintf("failed to setup config, msg: %s", buf.String()))
 	}
 
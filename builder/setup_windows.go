@@ -8,7 +8,9 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 
+	"github.com/Azure/acr-builder/pkg/container"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 )
@@ -20,24 +22,28 @@ const (
 	}`
 )
 
-// setupConfig initializes ~/.docker/config.json
-func (b *Builder) setupConfig(ctx context.Context) error {
-	args := []string{
-		"docker",
-		"run",
-		"--name", fmt.Sprintf("acb_init_config_%s", uuid.New()),
-		"--rm",
+// setupConfig initializes ~/.docker/config.json and the directory of the run's step outputs,
+// deleting the stale output files.
+func (b *Builder) setupConfig(ctx context.Context, staleOutputFiles []string) error {
+	var buf bytes.Buffer
+	var removeOutputs string
+	if len(staleOutputFiles) > 0 {
+		removeOutputs = "Remove-Item -Force -ErrorAction SilentlyContinue " + strings.Join(staleOutputFiles, ",") + "; "
+	}
+	opts := &container.RunOptions{
+		Name:       fmt.Sprintf("acb_init_config_%s", uuid.New()),
+		Image:      getConfigImageName(),
+		Entrypoint: []string{"powershell"},
+		Cmd:        []string{"mkdir ~/.docker; mkdir " + b.stepOutputsRunDir() + "; " + removeOutputs + "Out-File -InputObject '" + config + "' -FilePath ~/.docker/config.json -Encoding ASCII"},
+		Remove:     true,
 
 		// Home
-		"--volume", homeVol + ":" + homeWorkDir,
-		"--env", homeEnv,
-		"--entrypoint", "powershell",
-		getConfigImageName(),
-		"mkdir ~/.docker; Out-File -InputObject '" + config + "' -FilePath ~/.docker/config.json -Encoding ASCII",
+		Binds:  []string{homeVol + ":" + homeWorkDir},
+		Env:    []string{homeEnv},
+		Stdout: &buf,
+		Stderr: &buf,
 	}
-
-	var buf bytes.Buffer
-	if err := b.procManager.Run(ctx, args, nil, &buf, &buf, ""); err != nil {
+	if err := b.runContainer(ctx, opts); err != nil {
 		return errors.Wrap(err, fmt.Sprintf("failed to setup config: %s", buf.String()))
 	}
 
@@ -0,0 +1,242 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/signing"
+	"github.com/Azure/acr-builder/secretmgmt"
+	"github.com/Azure/acr-builder/util"
+	"github.com/docker/distribution/reference"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/retry"
+)
+
+// signImages signs the manifest digest of each of the sign step's images and pushes the signatures to the images' repositories.
+func (b *Builder) signImages(ctx context.Context, step *graph.Step) error {
+	if b.procManager.DryRun {
+		for _, img := range step.Sign.Images {
+			log.Printf("[DRY RUN] Signing image: %s\n", img)
+		}
+		return nil
+	}
+
+	data, err := readKey(b.secrets, step.Sign.Key, step.Sign.KeySecret)
+	if err != nil {
+		return errors.Wrap(err, "failed to read the signing key")
+	}
+	key, err := signing.LoadPrivateKey(data)
+	if err != nil {
+		return errors.Wrap(err, "failed to load the signing key")
+	}
+
+	client := b.registryClient()
+	for _, img := range step.Sign.Images {
+		repo, named, desc, err := resolveImage(ctx, img, client)
+		if err != nil {
+			return err
+		}
+		payload, err := signing.NewPayload(named.Name(), desc.Digest)
+		if err != nil {
+			return err
+		}
+		if _, err = signing.Attach(ctx, repo, desc, payload, key); err != nil {
+			return errors.Wrapf(err, "failed to push the signature of image %s", img)
+		}
+		log.Printf("Successfully signed image: %s, digest: %s\n", img, desc.Digest)
+	}
+	return nil
+}
+
+// verifyCmdImage verifies the signature of the cmd step's image and pins the step to the verified digest,
+// so that the image can't change between its verification and its use.
+func (b *Builder) verifyCmdImage(ctx context.Context, step *graph.Step) error {
+	words, err := util.SplitWords(step.Cmd)
+	if err != nil || len(words) == 0 {
+		return fmt.Errorf("failed to parse the image of step ID: %s", step.ID)
+	}
+	img := words[0]
+	if b.procManager.DryRun {
+		log.Printf("[DRY RUN] Verifying the signature of image: %s\n", img)
+		return nil
+	}
+
+	key, err := b.loadVerificationKey(step.VerifySignature)
+	if err != nil {
+		return err
+	}
+	pinned, err := verifyImageSignature(ctx, img, key, b.registryClient())
+	if err != nil {
+		return err
+	}
+	if step.Cmd, err = replaceFirstWord(step.Cmd, pinned); err != nil {
+		return errors.Wrapf(err, "failed to pin the image of step ID: %s", step.ID)
+	}
+	return nil
+}
+
+// replaceFirstWord replaces the first word of the command, including any quotes around it, with the word.
+func replaceFirstWord(cmd string, word string) (string, error) {
+	start := len(cmd) - len(strings.TrimLeft(cmd, " \t\r\n"))
+	end := strings.IndexAny(cmd[start:], " \t\r\n")
+	if end < 0 {
+		end = len(cmd)
+	} else {
+		end += start
+	}
+	// The first word must not contain quoted whitespace, since image references can't contain any.
+	rest, err := util.SplitWords(cmd[end:])
+	if err != nil {
+		return "", err
+	}
+	all, err := util.SplitWords(cmd)
+	if err != nil || len(all) != len(rest)+1 {
+		return "", fmt.Errorf("failed to find the first word of the command: %s", cmd)
+	}
+	return cmd[:start] + word + cmd[end:], nil
+}
+
+// verifyBaseImages verifies the signatures of the base images of the build step, which must have been scanned.
+// The base images must be referenced by digest, so that the build uses the verified images.
+func (b *Builder) verifyBaseImages(ctx context.Context, step *graph.Step) error {
+	refs := baseImages(step)
+	for _, ref := range refs {
+		named, err := reference.ParseNormalizedNamed(ref.Reference)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse image reference %s", ref.Reference)
+		}
+		if _, ok := named.(reference.Canonical); !ok {
+			return fmt.Errorf("base image %s of step ID: %s must be referenced by digest to verify its signature", ref.Reference, step.ID)
+		}
+	}
+	if b.procManager.DryRun {
+		for _, ref := range refs {
+			log.Printf("[DRY RUN] Verifying the signature of image: %s\n", ref.Reference)
+		}
+		return nil
+	}
+
+	key, err := b.loadVerificationKey(step.VerifySignature)
+	if err != nil {
+		return err
+	}
+	client := b.registryClient()
+	for _, ref := range refs {
+		if _, err := verifyImageSignature(ctx, ref.Reference, key, client); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyImageSignature verifies that the image was signed by the key, and returns its reference by the verified digest.
+func verifyImageSignature(ctx context.Context, img string, key crypto.PublicKey, client remote.Client) (string, error) {
+	repo, named, desc, err := resolveImage(ctx, img, client)
+	if err != nil {
+		return "", err
+	}
+	if err = signing.VerifyAttached(ctx, repo, named.Name(), desc, key); err != nil {
+		return "", errors.Wrapf(err, "failed to verify the signature of image %s", img)
+	}
+	pinned, err := reference.WithDigest(reference.TrimNamed(named), desc.Digest)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Successfully verified the signature of image: %s, digest: %s\n", img, desc.Digest)
+	return pinned.String(), nil
+}
+
+// loadVerificationKey loads the public key which verifies signatures.
+func (b *Builder) loadVerificationKey(v *graph.VerifySignature) (crypto.PublicKey, error) {
+	var data []byte
+	if strings.HasPrefix(strings.TrimSpace(v.Key), "-----BEGIN") {
+		data = []byte(v.Key)
+	} else {
+		var err error
+		if data, err = readKey(b.secrets, v.Key, v.KeySecret); err != nil {
+			return nil, errors.Wrap(err, "failed to read the verification key")
+		}
+	}
+	key, err := signing.LoadPublicKey(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load the verification key")
+	}
+	return key, nil
+}
+
+// readKey reads a PEM encoded key from the file or, if the file isn't specified, the secret with the ID.
+// The secret must have been resolved when the task was loaded.
+func readKey(secrets []*secretmgmt.Secret, file string, secretID string) ([]byte, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read key file %s", file)
+		}
+		return data, nil
+	}
+
+	var secret *secretmgmt.Secret
+	for _, s := range secrets {
+		if s.ID == secretID {
+			secret = s
+			break
+		}
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("the key secret %s isn't defined by the task", secretID)
+	}
+	if secret.ResolvedValue == "" {
+		return nil, fmt.Errorf("the key secret %s wasn't resolved when the task was loaded", secretID)
+	}
+	return []byte(secret.ResolvedValue), nil
+}
+
+// resolveImage resolves the image, which is referenced by a tag or a digest, to its manifest.
+func resolveImage(ctx context.Context, img string, client remote.Client) (*remote.Repository, reference.Named, ocispec.Descriptor, error) {
+	named, err := reference.ParseNormalizedNamed(img)
+	if err != nil {
+		return nil, nil, ocispec.Descriptor{}, errors.Wrapf(err, "failed to parse image reference %s", img)
+	}
+	ref := ""
+	if canonical, ok := named.(reference.Canonical); ok {
+		ref = canonical.Digest().String()
+	} else {
+		named = reference.TagNameOnly(named)
+		ref = named.(reference.NamedTagged).Tag()
+	}
+
+	repo, err := NewRemoteRepository(named, client)
+	if err != nil {
+		return nil, nil, ocispec.Descriptor{}, err
+	}
+	desc, err := repo.Resolve(ctx, ref)
+	if err != nil {
+		return nil, nil, ocispec.Descriptor{}, errors.Wrapf(err, "failed to resolve image %s", img)
+	}
+	return repo, named, desc, nil
+}
+
+// registryClient returns a client of the registry API which authenticates with the credentials of the registries
+// which the Task logged in to.
+func (b *Builder) registryClient() remote.Client {
+	return &auth.Client{
+		Client: &http.Client{Transport: retry.NewTransport(b.registryTransport())},
+		Header: http.Header{
+			"X-Meta-Source-Client": {"azure/acr/tasks"},
+		},
+		Cache:      auth.NewCache(),
+		Credential: b.registryCredential,
+	}
+}
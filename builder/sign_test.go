@@ -0,0 +1,189 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/image"
+	"github.com/Azure/acr-builder/pkg/procmanager"
+	"github.com/Azure/acr-builder/pkg/signing"
+	"github.com/Azure/acr-builder/secretmgmt"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestSignImages(t *testing.T) {
+	registry := &testRegistry{blobs: make(map[string]map[string]bool), manifests: make(map[string]string)}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	img := host + "/foo:v1"
+	unsigned := host + "/bar:v1"
+
+	store := loadTestSBOMImage(t, img)
+	store.tags[unsigned] = store.tags[img]
+	client := &http.Client{}
+	desc, err := pushImage(context.Background(), store, img, client, make(map[string][]string))
+	if err != nil {
+		t.Fatalf("failed to push %s: %v", img, err)
+	}
+	if _, err = pushImage(context.Background(), store, unsigned, client, make(map[string][]string)); err != nil {
+		t.Fatalf("failed to push %s: %v", unsigned, err)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(key)
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	otherDER, _ := x509.MarshalECPrivateKey(other)
+	b := &Builder{
+		procManager: procmanager.NewProcManager(false),
+		secrets:     []*secretmgmt.Secret{{ID: "other", ResolvedValue: string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: otherDER}))}},
+	}
+
+	// Signing an image again with the same key doesn't add another signature, while signing it with another key does.
+	for _, sign := range []*graph.Sign{
+		{Images: []string{img}, Key: keyFile},
+		{Images: []string{img}, Key: keyFile},
+		{Images: []string{img}, KeySecret: "other"},
+	} {
+		if err = b.signImages(context.Background(), &graph.Step{ID: "sign", Sign: sign}); err != nil {
+			t.Fatalf("failed to sign %s: %v", img, err)
+		}
+	}
+	var manifest ocispec.Manifest
+	if err = json.Unmarshal(registry.contents[registry.manifests["foo:"+signing.SignatureTag(desc.Digest)]], &manifest); err != nil {
+		t.Fatalf("failed to parse the signature manifest: %v", err)
+	}
+	if len(manifest.Layers) != 2 {
+		t.Errorf("expected 2 signatures but got %d", len(manifest.Layers))
+	}
+
+	pinned, err := verifyImageSignature(context.Background(), img, key.Public(), client)
+	if err != nil {
+		t.Fatalf("failed to verify %s: %v", img, err)
+	}
+	if expected := host + "/foo@" + desc.Digest.String(); pinned != expected {
+		t.Errorf("expected the verified image to be %s but got %s", expected, pinned)
+	}
+	if _, err = verifyImageSignature(context.Background(), img, other.Public(), client); err != nil {
+		t.Errorf("expected the signature of the other key to be verified but got %v", err)
+	}
+	stranger, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err = verifyImageSignature(context.Background(), img, stranger.Public(), client); err == nil {
+		t.Errorf("expected the verification of %s with a key which didn't sign it to fail", img)
+	}
+	if _, err = verifyImageSignature(context.Background(), unsigned, key.Public(), client); err == nil {
+		t.Errorf("expected the verification of %s, which isn't signed, to fail", unsigned)
+	}
+}
+
+func TestVerifyCmdImage(t *testing.T) {
+	registry := &testRegistry{blobs: make(map[string]map[string]bool), manifests: make(map[string]string)}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	img := host + "/foo:v1"
+
+	store := loadTestSBOMImage(t, img)
+	desc, err := pushImage(context.Background(), store, img, &http.Client{}, make(map[string][]string))
+	if err != nil {
+		t.Fatalf("failed to push %s: %v", img, err)
+	}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(key)
+	b := &Builder{
+		procManager: procmanager.NewProcManager(false),
+		secrets:     []*secretmgmt.Secret{{ID: "key", ResolvedValue: string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))}},
+	}
+	if err = b.signImages(context.Background(), &graph.Step{ID: "sign", Sign: &graph.Sign{Images: []string{img}, KeySecret: "key"}}); err != nil {
+		t.Fatalf("failed to sign %s: %v", img, err)
+	}
+
+	pub, err := signing.MarshalPublicKey(key.Public())
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %v", err)
+	}
+	step := &graph.Step{ID: "run", Cmd: img + " echo " + img, VerifySignature: &graph.VerifySignature{Key: string(pub)}}
+	if err = b.verifyCmdImage(context.Background(), step); err != nil {
+		t.Fatalf("failed to verify the image of the step: %v", err)
+	}
+	// Only the step's image is pinned to the verified digest.
+	if expected := host + "/foo@" + desc.Digest.String() + " echo " + img; step.Cmd != expected {
+		t.Errorf("expected the step's cmd to be %s but got %s", expected, step.Cmd)
+	}
+
+	// A quoted image is pinned too.
+	step = &graph.Step{ID: "run", Cmd: `"` + img + `" echo hi`, VerifySignature: &graph.VerifySignature{Key: string(pub)}}
+	if err = b.verifyCmdImage(context.Background(), step); err != nil {
+		t.Fatalf("failed to verify the quoted image of the step: %v", err)
+	}
+	if expected := host + "/foo@" + desc.Digest.String() + " echo hi"; step.Cmd != expected {
+		t.Errorf("expected the step's cmd to be %s but got %s", expected, step.Cmd)
+	}
+}
+
+func TestReplaceFirstWord(t *testing.T) {
+	tests := []struct {
+		cmd      string
+		expected string
+		fail     bool
+	}{
+		{"foo:v1 echo foo:v1", "foo@sha256:1 echo foo:v1", false},
+		{"  'foo:v1'\techo", "  foo@sha256:1\techo", false},
+		{"foo:v1", "foo@sha256:1", false},
+		{`"foo v1" echo`, "", true},
+	}
+	for _, test := range tests {
+		actual, err := replaceFirstWord(test.cmd, "foo@sha256:1")
+		if test.fail {
+			if err == nil {
+				t.Errorf("expected replacing the first word of %q to fail", test.cmd)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("failed to replace the first word of %q: %v", test.cmd, err)
+		} else if actual != test.expected {
+			t.Errorf("expected %q but got %q", test.expected, actual)
+		}
+	}
+}
+
+func TestVerifyBaseImages_RequiresDigests(t *testing.T) {
+	b := &Builder{procManager: procmanager.NewProcManager(true)}
+	step := &graph.Step{
+		ID:              "build",
+		Build:           ".",
+		VerifySignature: &graph.VerifySignature{Key: "key.pem"},
+		ImageDependencies: []*image.Dependencies{{
+			Runtime:   &image.Reference{Reference: "alpine@sha256:" + strings.Repeat("a", 64)},
+			Buildtime: []*image.Reference{{Reference: "golang:1.22"}},
+		}},
+	}
+	if err := b.verifyBaseImages(context.Background(), step); err == nil || !strings.Contains(err.Error(), "golang:1.22") {
+		t.Errorf("expected the base image referenced by tag to be rejected, got %v", err)
+	}
+
+	step.ImageDependencies[0].Buildtime = nil
+	if err := b.verifyBaseImages(context.Background(), step); err != nil {
+		t.Errorf("unexpected error verifying base images referenced by digest: %v", err)
+	}
+}
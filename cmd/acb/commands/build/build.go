@@ -14,6 +14,7 @@ import (
 
 	"github.com/Azure/acr-builder/builder"
 	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/interrupt"
 	"github.com/Azure/acr-builder/pkg/procmanager"
 	"github.com/Azure/acr-builder/pkg/volume"
 	"github.com/Azure/acr-builder/secretmgmt"
@@ -88,6 +89,18 @@ var Command = cli.Command{
 			Name:  "push",
 			Usage: "push the image on success",
 		},
+		cli.BoolFlag{
+			Name:  "sbom",
+			Usage: "generate an SPDX SBOM for the image and attach it to the pushed image, requires --push",
+		},
+		cli.StringFlag{
+			Name:  "provenance-key",
+			Usage: "the path to a PEM encoded private key which signs the SLSA provenance attached to the pushed image, requires --push",
+		},
+		cli.StringFlag{
+			Name:  "policy",
+			Usage: "the path to a security policy file which the build must comply with",
+		},
 		cli.BoolFlag{
 			Name:  "dry-run",
 			Usage: "evaluates the command, but doesn't execute it",
@@ -96,6 +109,10 @@ var Command = cli.Command{
 			Name:  "debug",
 			Usage: "enables diagnostic logging",
 		},
+		cli.StringFlag{
+			Name:  "report",
+			Usage: "the path to write a JSON report of the run to",
+		},
 
 		// Rendering options
 		cli.StringFlag{
@@ -164,8 +181,12 @@ var Command = cli.Command{
 			pull                    = context.Bool("pull")
 			noCache                 = context.Bool("no-cache")
 			push                    = context.Bool("push")
+			sbom                    = context.Bool("sbom")
+			provenanceKey           = context.String("provenance-key")
+			policyFile              = context.String("policy")
 			dryRun                  = context.Bool("dry-run")
 			debug                   = context.Bool("debug")
+			reportFile              = context.String("report")
 
 			// Rendering options
 			values        = context.String("values")
@@ -191,8 +212,23 @@ var Command = cli.Command{
 		if err := validatePush(push, creds); err != nil {
 			return err
 		}
+		if err := validateSBOM(sbom, push); err != nil {
+			return err
+		}
+		if err := validateProvenance(provenanceKey, push); err != nil {
+			return err
+		}
+
+		var policy *graph.Policy
+		if policyFile != "" {
+			var err error
+			if policy, err = graph.LoadPolicy(policyFile); err != nil {
+				return err
+			}
+		}
 
-		ctx := gocontext.Background()
+		ctx, interrupts := interrupt.NotifyContext(gocontext.Background())
+		defer interrupts.Stop()
 		pm := procmanager.NewProcManager(dryRun)
 
 		if homevol == "" {
@@ -203,7 +239,11 @@ var Command = cli.Command{
 					return fmt.Errorf("failed to create volume. Msg: %s, Err: %v", msg, err)
 				}
 				defer func() {
-					_, _ = v.Delete(ctx)
+					teardownCtx, cancel := interrupt.TeardownContext()
+					defer cancel()
+					if msg, err := v.Delete(teardownCtx); err != nil {
+						log.Printf("Failed to delete volume: %s, msg: %s, err: %v\n", homevol, msg, err)
+					}
 				}()
 			}
 		}
@@ -244,15 +284,36 @@ var Command = cli.Command{
 			debug,
 			registry,
 			push,
+			sbom,
 			creds,
 			defaultWorkingDirectory)
 		if err != nil {
 			return err
 		}
+		if err := task.EnforcePolicy(policy); err != nil {
+			return err
+		}
+
+		var provenance *builder.ProvenanceOptions
+		if provenanceKey != "" {
+			provenance = &builder.ProvenanceOptions{KeyFile: provenanceKey, Run: renderOpts}
+		}
 
 		builder := builder.NewBuilder(pm, debug, homevol)
-		defer builder.CleanTask(gocontext.Background(), task) // Use a separate context since the other may have expired.
-		return builder.RunTask(gocontext.Background(), task)
+		builder.ReportFile = reportFile
+		builder.Provenance = provenance
+		defer func() {
+			teardownCtx, cancel := interrupt.TeardownContext()
+			defer cancel()
+			builder.CleanTask(teardownCtx, task)
+		}()
+		if err := builder.RunTask(ctx, task); err != nil {
+			if sig := interrupts.Signal(); sig != nil {
+				return &interrupt.Error{Signal: sig, Steps: task.InterruptedSteps()}
+			}
+			return err
+		}
+		return nil
 	},
 }
 
@@ -273,6 +334,7 @@ func createBuildTask(
 	debug bool,
 	registry string,
 	push bool,
+	sbom bool,
 	creds []string,
 	workingDirectory string,
 ) (*graph.Task, error) {
@@ -345,6 +407,7 @@ func createBuildTask(
 		Build:   rendered,
 		Timeout: buildTimeoutInSec,
 		Tags:    tags,
+		SBOM:    sbom,
 	}
 
 	steps := []*graph.Step{buildStep}
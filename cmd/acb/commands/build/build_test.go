@@ -31,6 +31,7 @@ func TestCreateBuildTask(t *testing.T) {
 		}
 		debug      = false
 		push       = true
+		sbom       = true
 		creds      = []string{`{"registry":"foo.azurecr.io","username":"user","userNameProviderType":"opaque","password":"pw","passwordProviderType":"opaque"}`}
 		workingDir = ""
 	)
@@ -52,6 +53,7 @@ func TestCreateBuildTask(t *testing.T) {
 		debug,
 		registry,
 		push,
+		sbom,
 		creds,
 		workingDir)
 	if err != nil {
@@ -89,4 +91,7 @@ func TestCreateBuildTask(t *testing.T) {
 	if expectedCmd != buildStep.Build {
 		t.Fatalf("expected %s as the build command, but got %s", expectedCmd, buildStep.Build)
 	}
+	if !buildStep.SBOM {
+		t.Fatalf("expected the build step to generate an SBOM")
+	}
 }
@@ -28,3 +28,17 @@ func validatePush(push bool, credentials []string) error {
 	}
 	return nil
 }
+
+func validateSBOM(sbom bool, push bool) error {
+	if sbom && !push {
+		return errors.New("when specifying sbom, push is required since the SBOM is attached to the pushed image")
+	}
+	return nil
+}
+
+func validateProvenance(provenanceKey string, push bool) error {
+	if provenanceKey != "" && !push {
+		return errors.New("when specifying provenance-key, push is required since the provenance is attached to the pushed image")
+	}
+	return nil
+}
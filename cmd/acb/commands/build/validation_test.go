@@ -51,3 +51,35 @@ func TestValidatePush_Invalid(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateSBOM(t *testing.T) {
+	for _, test := range []struct {
+		sbom        bool
+		push        bool
+		shouldError bool
+	}{
+		{false, false, false},
+		{true, true, false},
+		{true, false, true},
+	} {
+		if err := validateSBOM(test.sbom, test.push); (err != nil) != test.shouldError {
+			t.Errorf("expected error to be %v for sbom: %v, push: %v but got %v", test.shouldError, test.sbom, test.push, err)
+		}
+	}
+}
+
+func TestValidateProvenance(t *testing.T) {
+	for _, test := range []struct {
+		provenanceKey string
+		push          bool
+		shouldError   bool
+	}{
+		{"", false, false},
+		{"key.pem", true, false},
+		{"key.pem", false, true},
+	} {
+		if err := validateProvenance(test.provenanceKey, test.push); (err != nil) != test.shouldError {
+			t.Errorf("expected error to be %v for provenance key: %s, push: %v but got %v", test.shouldError, test.provenanceKey, test.push, err)
+		}
+	}
+}
@@ -8,10 +8,12 @@ import (
 	"fmt"
 	"log"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/Azure/acr-builder/builder"
 	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/interrupt"
 	"github.com/Azure/acr-builder/pkg/procmanager"
 	"github.com/Azure/acr-builder/pkg/volume"
 	"github.com/Azure/acr-builder/secretmgmt"
@@ -49,7 +51,11 @@ var Command = cli.Command{
 		},
 		cli.StringSliceFlag{
 			Name:  "env",
-			Usage: "the default environment variables which are applied to each step (use --env multiple times or use commas: env1=val1,env2=val2)",
+			Usage: "a default environment variable which is applied to each step, in the format of env1=val1 (use --env multiple times); the value is used as is, even if it contains commas",
+		},
+		cli.StringSliceFlag{
+			Name:  "env-list",
+			Usage: "a comma separated list of default environment variables which are applied to each step: env1=val1,env2=val2; double quote a variable whose value contains commas: \"env3=a,b\"",
 		},
 		cli.StringSliceFlag{
 			Name:  "credential",
@@ -63,6 +69,34 @@ var Command = cli.Command{
 			Name:  "debug",
 			Usage: "enables diagnostic logging",
 		},
+		cli.StringFlag{
+			Name:  "report",
+			Usage: "the path to write a JSON report of the run to",
+		},
+		cli.StringFlag{
+			Name:  "policy",
+			Usage: "the path to a security policy file which the task must comply with",
+		},
+		cli.StringFlag{
+			Name:  "logs-dir",
+			Usage: "the directory to save the logs of detached steps to, instead of printing them",
+		},
+		cli.StringFlag{
+			Name:  "resume-from",
+			Usage: "only run the specified step ID and the steps which depend on it, assuming the steps it depends on already succeeded, requires --homevol",
+		},
+		cli.StringFlag{
+			Name:  "provenance-key",
+			Usage: "the path to a PEM encoded private key which signs the SLSA provenance attached to each image built and pushed by the task",
+		},
+		cli.StringFlag{
+			Name:  "provenance-key-secret",
+			Usage: "the ID of the task's secret which contains the PEM encoded private key which signs the SLSA provenance",
+		},
+		cli.StringSliceFlag{
+			Name:  "only",
+			Usage: "only run the specified step IDs, assuming the steps they depend on already succeeded (use --only multiple times or use commas: id1,id2)",
+		},
 
 		// Rendering options
 		cli.StringFlag{
@@ -126,9 +160,17 @@ var Command = cli.Command{
 			defaultWorkingDirectory = context.String("working-directory")
 			defaultNetwork          = context.String("network")
 			defaultEnvs             = context.StringSlice("env")
+			envLists                = context.StringSlice("env-list")
 			creds                   = context.StringSlice("credential")
 			dryRun                  = context.Bool("dry-run")
 			debug                   = context.Bool("debug")
+			reportFile              = context.String("report")
+			logsDir                 = context.String("logs-dir")
+			policyFile              = context.String("policy")
+			resumeFrom              = context.String("resume-from")
+			only                    = context.StringSlice("only")
+			provenanceKey           = context.String("provenance-key")
+			provenanceKeySecret     = context.String("provenance-key-secret")
 
 			// Rendering options
 			values        = context.String("values")
@@ -149,8 +191,33 @@ var Command = cli.Command{
 		if taskFile == "" && encodedTaskFile == "" {
 			taskFile = defaultTaskFile
 		}
+		for _, list := range envLists {
+			envs, err := graph.ParseEnvList(list)
+			if err != nil {
+				return err
+			}
+			defaultEnvs = append(defaultEnvs, envs...)
+		}
+		if resumeFrom != "" && len(only) > 0 {
+			return errors.New("--resume-from and --only can't be used together")
+		}
+		if resumeFrom != "" && homevol == "" && !dryRun {
+			return errors.New("--resume-from requires the --homevol of the run being resumed, which holds the artifacts of the steps which already completed and identifies their outputs")
+		}
+		if provenanceKey != "" && provenanceKeySecret != "" {
+			return errors.New("--provenance-key and --provenance-key-secret can't be used together")
+		}
 
-		ctx := gocontext.Background()
+		var policy *graph.Policy
+		if policyFile != "" {
+			var err error
+			if policy, err = graph.LoadPolicy(policyFile); err != nil {
+				return err
+			}
+		}
+
+		ctx, interrupts := interrupt.NotifyContext(gocontext.Background())
+		defer interrupts.Stop()
 		pm := procmanager.NewProcManager(dryRun)
 
 		if homevol == "" {
@@ -161,7 +228,11 @@ var Command = cli.Command{
 					return fmt.Errorf("failed to create volume. Msg: %s, Err: %v", msg, err)
 				}
 				defer func() {
-					_, _ = v.Delete(ctx)
+					teardownCtx, cancel := interrupt.TeardownContext()
+					defer cancel()
+					if msg, err := v.Delete(teardownCtx); err != nil {
+						log.Printf("Failed to delete volume: %s, msg: %s, err: %v\n", homevol, msg, err)
+					}
 				}()
 			}
 		}
@@ -206,61 +277,58 @@ var Command = cli.Command{
 			return errors.Wrap(err, "error creating registry credentials from given list")
 		}
 
-		var task *graph.Task
-		var alias *graph.Alias
-
-		versionInUse := graph.FindVersion(template.GetData())
-		shouldIncludeAlias := versionInUse >= "v1.1.0"
-		if shouldIncludeAlias {
-			log.Printf("Alias support enabled for version >= 1.1.0, please see https://aka.ms/acr/tasks/task-aliases for more information.")
-			// separate alias and remaining data from the Task
-			aliasData, taskData := graph.SeparateAliasFromRest(template.GetData())
-
-			// render alias data
-			renderedAlias, renderAliasErr := templating.LoadAndRenderSteps(ctx, templating.NewTemplate("aliasData", aliasData), renderOpts)
-			if renderAliasErr != nil {
-				return errors.Wrap(renderAliasErr, "unable to render alias data")
-			}
-			aliasData = []byte(renderedAlias)
-			// Preprocess the task to replace all aliases based on the alias sources.
-			processedTask, _alias, aliasErr := graph.SearchReplaceAlias(template.GetData(), aliasData, taskData)
-			alias = _alias
-			if aliasErr != nil {
-				return errors.Wrap(aliasErr, "unable to search/replace aliases in task")
-			}
-			if debug {
-				log.Printf("Processed task before rendering data:\n%s", processedTask)
-			}
-			// update the template.Data
-			template.Data = processedTask
-		}
-
-		rendered, err := templating.LoadAndRenderSteps(ctx, template, renderOpts)
-		if err != nil {
-			return errors.Wrap(err, "unable to render task")
-		}
-		if debug {
-			log.Printf("Rendered template:\n%s", rendered)
-		}
-
-		task, errUnmarshal := graph.UnmarshalTaskFromString(ctx, rendered, &graph.TaskOptions{
+		task, err := templating.LoadAndRenderTask(ctx, template, renderOpts, &graph.TaskOptions{
 			DefaultWorkingDir: defaultWorkingDirectory,
 			Network:           defaultNetwork,
 			Envs:              defaultEnvs,
 			Credentials:       credentials,
 			TaskName:          taskName,
 			Registry:          registry,
-		})
-		if errUnmarshal != nil {
-			return errors.Wrap(errUnmarshal, "failed to unmarshal task before running")
+			RunValues:         renderOpts.RunValues(),
+			Policy:            policy,
+		}, debug)
+		if err != nil {
+			return err
 		}
 
-		if shouldIncludeAlias {
-			graph.ExpandCommandAliases(alias, task)
+		for _, warning := range task.Warnings() {
+			log.Printf("WARNING: %s\n", warning)
+		}
+
+		if resumeFrom != "" {
+			if err := task.ResumeFrom(resumeFrom); err != nil {
+				return errors.Wrap(err, "failed to resume the task")
+			}
+		} else if len(only) > 0 {
+			var ids []string
+			for _, o := range only {
+				ids = append(ids, strings.Split(o, ",")...)
+			}
+			if err := task.Prune(ids); err != nil {
+				return errors.Wrap(err, "failed to select the steps to run")
+			}
+		}
+
+		var provenance *builder.ProvenanceOptions
+		if provenanceKey != "" || provenanceKeySecret != "" {
+			provenance = &builder.ProvenanceOptions{KeyFile: provenanceKey, KeySecret: provenanceKeySecret, Run: renderOpts}
 		}
 
 		builder := builder.NewBuilder(pm, debug, homevol)
-		defer builder.CleanTask(gocontext.Background(), task) // Use a separate context since the other may have expired.
-		return builder.RunTask(gocontext.Background(), task)
+		builder.ReportFile = reportFile
+		builder.LogsDir = logsDir
+		builder.Provenance = provenance
+		defer func() {
+			teardownCtx, cancel := interrupt.TeardownContext()
+			defer cancel()
+			builder.CleanTask(teardownCtx, task)
+		}()
+		if err := builder.RunTask(ctx, task); err != nil {
+			if sig := interrupts.Signal(); sig != nil {
+				return &interrupt.Error{Signal: sig, Steps: task.InterruptedSteps()}
+			}
+			return err
+		}
+		return nil
 	},
 }
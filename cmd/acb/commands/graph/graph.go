@@ -0,0 +1,219 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	gocontext "context"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/secretmgmt"
+	"github.com/Azure/acr-builder/templating"
+	"github.com/urfave/cli"
+)
+
+const (
+	defaultTaskFile = "acb.yaml"
+
+	formatDOT     = "dot"
+	formatMermaid = "mermaid"
+	formatASCII   = "ascii"
+)
+
+// Command prints a task's execution plan.
+var Command = cli.Command{
+	Name:  "graph",
+	Usage: "print the execution plan of a task file",
+	Flags: []cli.Flag{
+		// Task options
+		cli.StringFlag{
+			Name:  "file,f",
+			Usage: "the path to the task file",
+		},
+		cli.StringFlag{
+			Name:  "encoded-file",
+			Usage: "a base64 encoded task file",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "the output format, one of: ascii, dot, mermaid",
+			Value: formatASCII,
+		},
+		cli.StringFlag{
+			Name:  "working-directory",
+			Usage: "the default working directory to use if the underlying Task doesn't have one specified",
+		},
+		cli.StringFlag{
+			Name:  "network",
+			Usage: "the default network to use",
+		},
+		cli.StringSliceFlag{
+			Name:  "env",
+			Usage: "a default environment variable which is applied to each step, in the format of env1=val1 (use --env multiple times); the value is used as is, even if it contains commas",
+		},
+		cli.StringSliceFlag{
+			Name:  "env-list",
+			Usage: "a comma separated list of default environment variables which are applied to each step: env1=val1,env2=val2; double quote a variable whose value contains commas: \"env3=a,b\"",
+		},
+		cli.BoolFlag{
+			Name:  "debug",
+			Usage: "enables diagnostic logging",
+		},
+
+		// Rendering options
+		cli.StringFlag{
+			Name:  "values",
+			Usage: "the path to the values file to use for rendering",
+		},
+		cli.StringFlag{
+			Name:  "encoded-values",
+			Usage: "a base64 encoded values file to use for rendering",
+		},
+		cli.StringFlag{
+			Name:  "homevol",
+			Usage: "the home volume to use",
+		},
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "the unique run identifier",
+		},
+		cli.StringFlag{
+			Name:  "commit,c",
+			Usage: "the commit SHA that triggered the run",
+		},
+		cli.StringFlag{
+			Name:  "repository",
+			Usage: "the run's repository",
+		},
+		cli.StringFlag{
+			Name:  "branch",
+			Usage: "the git branch",
+		},
+		cli.StringFlag{
+			Name:  "triggered-by",
+			Usage: "describes what the run was triggered by",
+		},
+		cli.StringFlag{
+			Name:  "git-tag",
+			Usage: "the git tag that triggered the run",
+		},
+		cli.StringFlag{
+			Name:  "registry,r",
+			Usage: "the fully qualified name of the registry",
+		},
+		cli.StringFlag{
+			Name:  "os-version",
+			Usage: "the version of the OS",
+		},
+		cli.StringSliceFlag{
+			Name:  "set",
+			Usage: "set values on the command line (use --set multiple times or use commas: key1=val1,key2=val2)",
+		},
+		cli.StringFlag{
+			Name:  "name",
+			Usage: "the name of the task",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		var (
+			// Task options
+			taskFile                = context.String("file")
+			encodedTaskFile         = context.String("encoded-file")
+			format                  = context.String("format")
+			defaultWorkingDirectory = context.String("working-directory")
+			defaultNetwork          = context.String("network")
+			defaultEnvs             = context.StringSlice("env")
+			envLists                = context.StringSlice("env-list")
+			debug                   = context.Bool("debug")
+
+			// Rendering options
+			values        = context.String("values")
+			encodedValues = context.String("encoded-values")
+			homevol       = context.String("homevol")
+			id            = context.String("id")
+			commit        = context.String("commit")
+			repository    = context.String("repository")
+			branch        = context.String("branch")
+			triggeredBy   = context.String("triggered-by")
+			tag           = context.String("git-tag")
+			registry      = context.String("registry")
+			osVersion     = context.String("os-version")
+			setVals       = context.StringSlice("set")
+			taskName      = context.String("name")
+		)
+
+		if format != formatASCII && format != formatDOT && format != formatMermaid {
+			return fmt.Errorf("invalid format %q, must be one of: %s, %s, %s", format, formatASCII, formatDOT, formatMermaid)
+		}
+
+		if taskFile == "" && encodedTaskFile == "" {
+			taskFile = defaultTaskFile
+		}
+		for _, list := range envLists {
+			envs, err := graph.ParseEnvList(list)
+			if err != nil {
+				return err
+			}
+			defaultEnvs = append(defaultEnvs, envs...)
+		}
+
+		renderOpts := &templating.BaseRenderOptions{
+			TaskFile:                taskFile,
+			Base64EncodedTaskFile:   encodedTaskFile,
+			ValuesFile:              values,
+			Base64EncodedValuesFile: encodedValues,
+			TemplateValues:          setVals,
+			ID:                      id,
+			Commit:                  commit,
+			Repository:              repository,
+			Branch:                  branch,
+			TriggeredBy:             triggeredBy,
+			GitTag:                  tag,
+			Registry:                registry,
+			Date:                    time.Now().UTC(),
+			SharedVolume:            homevol,
+			OS:                      runtime.GOOS,
+			OSVersion:               osVersion,
+			Architecture:            runtime.GOARCH,
+			SecretResolveTimeout:    secretmgmt.DefaultSecretResolveTimeout,
+			TaskName:                taskName,
+		}
+
+		var template *templating.Template
+		var err error
+		if taskFile == "" {
+			if template, err = templating.DecodeTemplate(encodedTaskFile); err != nil {
+				return err
+			}
+		} else {
+			if template, err = templating.LoadTemplate(taskFile); err != nil {
+				return err
+			}
+		}
+
+		task, err := templating.LoadAndRenderTask(gocontext.Background(), template, renderOpts, &graph.TaskOptions{
+			DefaultWorkingDir: defaultWorkingDirectory,
+			Network:           defaultNetwork,
+			Envs:              defaultEnvs,
+			TaskName:          taskName,
+			Registry:          registry,
+			RunValues:         renderOpts.RunValues(),
+		}, debug)
+		if err != nil {
+			return err
+		}
+
+		switch format {
+		case formatDOT:
+			fmt.Print(task.DOT())
+		case formatMermaid:
+			fmt.Print(task.Mermaid())
+		default:
+			fmt.Print(task.ASCII())
+		}
+		return nil
+	},
+}
@@ -5,13 +5,14 @@ package render
 
 import (
 	gocontext "context"
-	"errors"
 	"log"
 	"runtime"
 	"time"
 
+	"github.com/Azure/acr-builder/graph"
 	"github.com/Azure/acr-builder/secretmgmt"
 	"github.com/Azure/acr-builder/templating"
+	"github.com/pkg/errors"
 	"github.com/urfave/cli"
 )
 
@@ -138,13 +139,16 @@ var Command = cli.Command{
 			}
 		}
 
-		rendered, err := templating.LoadAndRenderSteps(gocontext.Background(), template, renderOpts)
+		// The task is loaded like it is by exec, including its aliases, and the rendered template is logged.
+		task, err := templating.LoadAndRenderTask(gocontext.Background(), template, renderOpts, &graph.TaskOptions{
+			RunValues: renderOpts.RunValues(),
+		}, true)
 		if err != nil {
 			return err
 		}
-
-		log.Println("Rendered template:")
-		log.Println(rendered)
+		for _, warning := range task.Warnings() {
+			log.Printf("WARNING: %s\n", warning)
+		}
 		return nil
 	},
 }
@@ -0,0 +1,141 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package verifyprovenance
+
+import (
+	gocontext "context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/Azure/acr-builder/builder"
+	"github.com/Azure/acr-builder/pkg/provenance"
+	"github.com/Azure/acr-builder/pkg/signing"
+	"github.com/docker/distribution/reference"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+// Command verifies the signed SLSA provenance of an image.
+var Command = cli.Command{
+	Name:      "verify-provenance",
+	Usage:     "verify the signed SLSA provenance of an image and print its statement",
+	ArgsUsage: "<image>",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "key",
+			Usage: "the path to the PEM encoded public key, or private key, which signed the provenance",
+		},
+		cli.StringFlag{
+			Name:  "attestation",
+			Usage: "the path to a provenance envelope to verify instead of the one attached to the image, which must be referenced by its digest",
+		},
+		cli.StringFlag{
+			Name:  "username,u",
+			Usage: "the username to log in to the image's registry with",
+		},
+		cli.StringFlag{
+			Name:  "password,p",
+			Usage: "the password to log in to the image's registry with",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		var (
+			img         = context.Args().First()
+			keyFile     = context.String("key")
+			attestation = context.String("attestation")
+			username    = context.String("username")
+			password    = context.String("password")
+		)
+
+		if img == "" {
+			return errors.New("verify-provenance requires exactly 1 argument, see verify-provenance --help")
+		}
+		if keyFile == "" {
+			return errors.New("--key is required")
+		}
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read key file %s", keyFile)
+		}
+		key, err := signing.LoadPublicKey(data)
+		if err != nil {
+			return err
+		}
+
+		var s *provenance.Statement
+		if attestation != "" {
+			s, err = verifyAttestation(img, key, attestation)
+		} else {
+			s, err = verifyAttached(gocontext.Background(), img, key, username, password)
+		}
+		if err != nil {
+			return err
+		}
+
+		out, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	},
+}
+
+// verifyAttestation verifies the provenance envelope in the file, without accessing the image's registry.
+func verifyAttestation(img string, key crypto.PublicKey, attestation string) (*provenance.Statement, error) {
+	named, err := reference.ParseNormalizedNamed(img)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse image reference %s", img)
+	}
+	canonical, ok := named.(reference.Canonical)
+	if !ok {
+		return nil, fmt.Errorf("when specifying attestation, image %s must be referenced by its digest, i.e. name@sha256:<digest>", img)
+	}
+
+	data, err := os.ReadFile(attestation)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read attestation %s", attestation)
+	}
+	var e provenance.Envelope
+	if err = json.Unmarshal(data, &e); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse attestation %s", attestation)
+	}
+	return provenance.VerifySubject([]*provenance.Envelope{&e}, key, canonical.Digest().String())
+}
+
+// verifyAttached resolves the image and verifies the provenance attached to it in its registry.
+func verifyAttached(ctx gocontext.Context, img string, key crypto.PublicKey, username, password string) (*provenance.Statement, error) {
+	named, err := reference.ParseNormalizedNamed(img)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse image reference %s", img)
+	}
+	ref := ""
+	if canonical, ok := named.(reference.Canonical); ok {
+		ref = canonical.Digest().String()
+	} else {
+		ref = reference.TagNameOnly(named).(reference.NamedTagged).Tag()
+	}
+
+	client := &auth.Client{Cache: auth.NewCache()}
+	repo, err := builder.NewRemoteRepository(named, client)
+	if err != nil {
+		return nil, err
+	}
+	if username != "" {
+		client.Credential = auth.StaticCredential(repo.Reference.Registry, auth.Credential{Username: username, Password: password})
+	}
+
+	desc, err := repo.Resolve(ctx, ref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve image %s", img)
+	}
+	envelopes, err := provenance.Fetch(ctx, repo, desc)
+	if err != nil {
+		return nil, err
+	}
+	return provenance.VerifySubject(envelopes, key, desc.Digest.String())
+}
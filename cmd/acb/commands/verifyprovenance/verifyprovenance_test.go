@@ -0,0 +1,57 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package verifyprovenance
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/acr-builder/pkg/image"
+	"github.com/Azure/acr-builder/pkg/provenance"
+)
+
+const testDigest = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+
+func TestVerifyAttestation(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s, err := provenance.NewStatement(&provenance.Build{
+		Image:        "foo.azurecr.io/app:v1",
+		Digest:       testDigest,
+		Dependencies: &image.Dependencies{Image: &image.Reference{Reference: "foo.azurecr.io/app:v1"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create statement: %v", err)
+	}
+	e, err := provenance.Sign(s, key)
+	if err != nil {
+		t.Fatalf("failed to sign statement: %v", err)
+	}
+	data, _ := json.Marshal(e)
+	attestation := filepath.Join(t.TempDir(), "provenance.json")
+	if err = os.WriteFile(attestation, data, 0600); err != nil {
+		t.Fatalf("failed to write attestation: %v", err)
+	}
+
+	tests := []struct {
+		img         string
+		attestation string
+		shouldError bool
+	}{
+		{"foo.azurecr.io/app@" + testDigest, attestation, false},
+		// The image must be referenced by its digest.
+		{"foo.azurecr.io/app:v1", attestation, true},
+		{"foo.azurecr.io/app@sha256:1111111111111111111111111111111111111111111111111111111111111111", attestation, true},
+		{"foo.azurecr.io/app@" + testDigest, filepath.Join(t.TempDir(), "missing.json"), true},
+	}
+	for _, test := range tests {
+		if _, err := verifyAttestation(test.img, key.Public(), test.attestation); (err != nil) != test.shouldError {
+			t.Errorf("expected error to be %v for %s but got %v", test.shouldError, test.img, err)
+		}
+	}
+}
@@ -6,6 +6,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"log"
 	"os"
 	"strings"
 
@@ -13,17 +14,34 @@ import (
 	downloadCmd "github.com/Azure/acr-builder/cmd/acb/commands/download"
 	execCmd "github.com/Azure/acr-builder/cmd/acb/commands/exec"
 	getsecretCmd "github.com/Azure/acr-builder/cmd/acb/commands/getsecret"
+	graphCmd "github.com/Azure/acr-builder/cmd/acb/commands/graph"
 	renderCmd "github.com/Azure/acr-builder/cmd/acb/commands/render"
 	scanCmd "github.com/Azure/acr-builder/cmd/acb/commands/scan"
+	verifyprovenanceCmd "github.com/Azure/acr-builder/cmd/acb/commands/verifyprovenance"
 	versionCmd "github.com/Azure/acr-builder/cmd/acb/commands/version"
+	"github.com/Azure/acr-builder/pkg/interrupt"
+	"github.com/Azure/acr-builder/pkg/redact"
 	"github.com/Azure/acr-builder/version"
+	"github.com/pkg/errors"
 	"github.com/urfave/cli"
 )
 
 func main() {
+	// Secrets are redacted from all logs, including the rendered task logged in debug mode.
+	logWriter := redact.NewWriter(os.Stderr)
+	log.SetOutput(logWriter)
+
 	app := New()
-	if err := app.Run(os.Args); err != nil {
-		fmt.Fprintln(os.Stderr, formatErrorMessage(err))
+	err := app.Run(os.Args)
+	// The writer holds back output which could be the start of a secret, so it must be flushed before exiting.
+	_ = logWriter.Flush()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, redact.String(formatErrorMessage(err)))
+		// Interrupted runs exit with a distinct code, so callers can tell them apart from failed runs.
+		var interrupted *interrupt.Error
+		if errors.As(err, &interrupted) {
+			os.Exit(interrupted.ExitStatus())
+		}
 		os.Exit(1)
 	}
 }
@@ -38,8 +56,10 @@ func New() *cli.App {
 		buildCmd.Command,
 		downloadCmd.Command,
 		execCmd.Command,
+		graphCmd.Command,
 		renderCmd.Command,
 		scanCmd.Command,
+		verifyprovenanceCmd.Command,
 		versionCmd.Command,
 		getsecretCmd.Command,
 	}
@@ -0,0 +1,120 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/acr-builder/pkg/image"
+)
+
+// dockerHubRegistry is the registry of Docker Hub's images in the references produced by the scanner,
+// which policies refer to as docker.io.
+const dockerHubRegistry = "registry.hub.docker.com"
+
+// BaseImagePolicy restricts the base images which build steps can use. It's enforced after a build step's
+// Dockerfile is scanned, before the image is built.
+type BaseImagePolicy struct {
+	// AllowedRegistries are the registries which base images can come from, e.g. mcr.microsoft.com or *.azurecr.io.
+	// Images from Docker Hub are in the docker.io registry. All registries are allowed if it's empty.
+	AllowedRegistries []string `yaml:"allowedRegistries"`
+
+	// AllowedRepositories are the repositories which base images can come from, including their registry,
+	// e.g. docker.io/library/golang or mcr.microsoft.com/dotnet/*. All repositories are allowed if it's empty.
+	AllowedRepositories []string `yaml:"allowedRepositories"`
+
+	// ForbiddenTags are the tags which base images which aren't pinned by digest can't use, e.g. latest.
+	ForbiddenTags []string `yaml:"forbiddenTags"`
+
+	// RequireDigest requires base images to be pinned by digest.
+	RequireDigest bool `yaml:"requireDigest"`
+
+	// MaxAge is the maximum time since base images were created, e.g. 720h or 30d.
+	MaxAge string `yaml:"maxAge"`
+
+	// Audit only warns about violations, rather than failing the build step.
+	Audit bool `yaml:"audit"`
+}
+
+// BaseImageCreatedFunc returns the time the base image was created.
+type BaseImageCreatedFunc func(ref *image.Reference) (time.Time, error)
+
+// Validate validates the BaseImagePolicy and returns an error if it has problems.
+func (p *BaseImagePolicy) Validate() error {
+	if p != nil && p.MaxAge != "" {
+		if _, err := parseAge(p.MaxAge); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Evaluate returns the violations of the BaseImagePolicy by the base images. created is only called if the
+// BaseImagePolicy limits the age of base images, and the age isn't checked if it's nil.
+func (p *BaseImagePolicy) Evaluate(refs []*image.Reference, now time.Time, created BaseImageCreatedFunc) []string {
+	if p == nil {
+		return nil
+	}
+	// The max age is validated when the policy is loaded.
+	var maxAge time.Duration
+	if p.MaxAge != "" && created != nil {
+		maxAge, _ = parseAge(p.MaxAge)
+	}
+	var violations []string
+	for _, ref := range refs {
+		registry := ref.Registry
+		if registry == dockerHubRegistry {
+			registry = dockerHubDomain
+		}
+		if len(p.AllowedRegistries) > 0 && !matchesAny(p.AllowedRegistries, registry) {
+			violations = append(violations, fmt.Sprintf("base image %s is from registry %s, which isn't allowed", ref.Reference, registry))
+		}
+		if repository := registry + "/" + ref.Repository; len(p.AllowedRepositories) > 0 && !matchesAny(p.AllowedRepositories, repository) {
+			violations = append(violations, fmt.Sprintf("base image %s is from repository %s, which isn't allowed", ref.Reference, repository))
+		}
+		if ref.Digest == "" {
+			if p.RequireDigest {
+				violations = append(violations, fmt.Sprintf("base image %s isn't pinned by digest", ref.Reference))
+			}
+			for _, tag := range p.ForbiddenTags {
+				if ref.Tag == tag {
+					violations = append(violations, fmt.Sprintf("base image %s uses the forbidden tag %s", ref.Reference, tag))
+				}
+			}
+		}
+		if maxAge > 0 {
+			t, err := created(ref)
+			if err != nil {
+				violations = append(violations, fmt.Sprintf("the age of base image %s couldn't be determined: %v", ref.Reference, err))
+			} else if age := now.Sub(t); age > maxAge {
+				violations = append(violations, fmt.Sprintf("base image %s was created %s ago, which is older than %s", ref.Reference, age.Round(time.Hour), p.MaxAge))
+			}
+		}
+	}
+	return violations
+}
+
+// parseAge parses a duration, which may also be a number of days, e.g. 30d.
+func parseAge(age string) (time.Duration, error) {
+	var d time.Duration
+	if days := strings.TrimSuffix(age, "d"); days != age {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid maxAge %s", age)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(age); err != nil {
+			return 0, fmt.Errorf("invalid maxAge %s", age)
+		}
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("maxAge %s must be positive", age)
+	}
+	return d, nil
+}
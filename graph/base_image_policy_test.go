@@ -0,0 +1,120 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/acr-builder/pkg/image"
+)
+
+const baseImageTestDigest = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+
+var (
+	golangLatest = &image.Reference{Registry: "registry.hub.docker.com", Repository: "library/golang", Tag: "latest", Reference: "golang:latest"}
+	golangPinned = &image.Reference{Registry: "registry.hub.docker.com", Repository: "library/golang", Tag: "latest", Digest: baseImageTestDigest, Reference: "golang:latest@" + baseImageTestDigest}
+	dotnetSDK    = &image.Reference{Registry: "mcr.microsoft.com", Repository: "dotnet/sdk", Tag: "8.0", Reference: "mcr.microsoft.com/dotnet/sdk:8.0"}
+	acrApp       = &image.Reference{Registry: "foo.azurecr.io", Repository: "base/app", Tag: "v1", Reference: "foo.azurecr.io/base/app:v1"}
+)
+
+func TestBaseImagePolicy_Evaluate(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   *BaseImagePolicy
+		refs     []*image.Reference
+		expected []string
+	}{
+		{"no policy", nil, []*image.Reference{golangLatest}, nil},
+		{"empty policy", &BaseImagePolicy{}, []*image.Reference{golangLatest, dotnetSDK}, nil},
+		{
+			"allowed registries",
+			&BaseImagePolicy{AllowedRegistries: []string{"docker.io", "*.azurecr.io"}},
+			[]*image.Reference{golangLatest, acrApp, dotnetSDK},
+			[]string{"base image mcr.microsoft.com/dotnet/sdk:8.0 is from registry mcr.microsoft.com, which isn't allowed"},
+		},
+		{
+			"allowed repositories",
+			&BaseImagePolicy{AllowedRepositories: []string{"mcr.microsoft.com/dotnet/*", "docker.io/library/alpine"}},
+			[]*image.Reference{dotnetSDK, golangLatest},
+			[]string{"base image golang:latest is from repository docker.io/library/golang, which isn't allowed"},
+		},
+		{
+			"forbidden tags",
+			&BaseImagePolicy{ForbiddenTags: []string{"latest"}},
+			[]*image.Reference{golangLatest, golangPinned, dotnetSDK},
+			[]string{"base image golang:latest uses the forbidden tag latest"},
+		},
+		{
+			"require digest",
+			&BaseImagePolicy{RequireDigest: true},
+			[]*image.Reference{golangPinned, dotnetSDK},
+			[]string{"base image mcr.microsoft.com/dotnet/sdk:8.0 isn't pinned by digest"},
+		},
+	}
+	for _, test := range tests {
+		if violations := test.policy.Evaluate(test.refs, time.Now(), nil); !reflect.DeepEqual(violations, test.expected) {
+			t.Errorf("%s: expected violations %v but got %v", test.name, test.expected, violations)
+		}
+	}
+}
+
+func TestBaseImagePolicy_EvaluateMaxAge(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	created := map[string]time.Time{
+		golangLatest.Reference: now.Add(-10 * 24 * time.Hour),
+		dotnetSDK.Reference:    now.Add(-40 * 24 * time.Hour),
+	}
+	createdFunc := func(ref *image.Reference) (time.Time, error) {
+		t, ok := created[ref.Reference]
+		if !ok {
+			return time.Time{}, errors.New("not found")
+		}
+		return t, nil
+	}
+
+	p := &BaseImagePolicy{MaxAge: "30d"}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("failed to validate policy: %v", err)
+	}
+	expected := []string{
+		"base image mcr.microsoft.com/dotnet/sdk:8.0 was created 960h0m0s ago, which is older than 30d",
+		"the age of base image foo.azurecr.io/base/app:v1 couldn't be determined: not found",
+	}
+	if violations := p.Evaluate([]*image.Reference{golangLatest, dotnetSDK, acrApp}, now, createdFunc); !reflect.DeepEqual(violations, expected) {
+		t.Errorf("expected violations %v but got %v", expected, violations)
+	}
+
+	// The age isn't checked without a way to determine it.
+	if violations := p.Evaluate([]*image.Reference{dotnetSDK}, now, nil); len(violations) > 0 {
+		t.Errorf("expected no violations but got %v", violations)
+	}
+}
+
+func TestLoadPolicy_BaseImages(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		content     string
+		shouldError bool
+	}{
+		{"baseImages:\n  allowedRegistries: [docker.io]\n  forbiddenTags: [latest]\n  requireDigest: true\n  maxAge: 720h\n  audit: true\n", false},
+		{"baseImages:\n  maxAge: 90d\n", false},
+		{"baseImages:\n  maxAge: 90 days\n", true},
+		{"baseImages:\n  maxAge: -1h\n", true},
+		{"baseImages:\n  requireDigests: true\n", true},
+	}
+	for i, test := range tests {
+		file := filepath.Join(dir, "policy.yaml")
+		if err := os.WriteFile(file, []byte(test.content), 0600); err != nil {
+			t.Fatalf("failed to write policy: %v", err)
+		}
+		if _, err := LoadPolicy(file); (err != nil) != test.shouldError {
+			t.Errorf("test %d: expected error to be %v but got %v", i, test.shouldError, err)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	trueValue  = "true"
+	falseValue = "false"
+)
+
+// Condition is a parsed `if` expression which determines whether or not a step runs.
+//
+// The expression language supports string, number and boolean literals, the operators
+// ==, !=, &&, || and !, parentheses, and the following references:
+//   - Run.<property>, i.e. Run.Branch or Run.Commit
+//   - steps.<id>.status, steps.<id>.exitCode and steps.<id>.outputs.<key>
+//   - env.<name>, which resolves from the step's environment and then the host's environment
+//
+// A value is considered true unless it is empty, "false" or "0".
+type Condition struct {
+	expression string
+	root       conditionNode
+}
+
+// ConditionContext provides the values which a Condition can reference.
+type ConditionContext struct {
+	Run   map[string]string
+	Steps map[string]*Step
+	Envs  []string
+}
+
+// ParseCondition parses the specified expression into a Condition.
+func ParseCondition(expression string) (*Condition, error) {
+	tokens, err := tokenizeCondition(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &conditionParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q in condition %q", p.peek().text, expression)
+	}
+	return &Condition{expression: expression, root: root}, nil
+}
+
+// Evaluate evaluates the Condition against the specified context.
+func (c *Condition) Evaluate(ctx *ConditionContext) (bool, error) {
+	val, err := c.root.eval(ctx)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to evaluate condition %q", c.expression)
+	}
+	return isTruthy(val), nil
+}
+
+// StepReferences returns the IDs of the steps which the Condition references, in the order they're referenced.
+func (c *Condition) StepReferences() []string {
+	var ids []string
+	seen := make(map[string]bool)
+	var walk func(n conditionNode)
+	walk = func(n conditionNode) {
+		switch n := n.(type) {
+		case *referenceNode:
+			if !strings.HasPrefix(strings.ToLower(n.ref), "steps.") {
+				return
+			}
+			if id, _, _, ok := parseStepReference(n.ref); ok && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		case *notNode:
+			walk(n.operand)
+		case *binaryNode:
+			walk(n.left)
+			walk(n.right)
+		}
+	}
+	walk(c.root)
+	return ids
+}
+
+// String returns the Condition's original expression.
+func (c *Condition) String() string {
+	return c.expression
+}
+
+// lookup resolves a dotted reference to its value.
+func (ctx *ConditionContext) lookup(ref string) (string, error) {
+	parts := strings.Split(ref, ".")
+	switch strings.ToLower(parts[0]) {
+	case "run":
+		if len(parts) != 2 {
+			return "", fmt.Errorf("invalid reference %q, expected Run.<property>", ref)
+		}
+		val, ok := ctx.Run[parts[1]]
+		if !ok {
+			return "", fmt.Errorf("unknown Run property %q", parts[1])
+		}
+		return val, nil
+	case "steps":
+		id, property, key, ok := parseStepReference(ref)
+		if !ok {
+			return "", fmt.Errorf("invalid reference %q, expected steps.<id>.<property>", ref)
+		}
+		step, ok := ctx.Steps[id]
+		if !ok {
+			return "", fmt.Errorf("unknown step ID %q", id)
+		}
+		switch strings.ToLower(property) {
+		case "outputs":
+			return step.Outputs[key], nil
+		case "status":
+			return string(step.StepStatus), nil
+		case "exitcode":
+			return strconv.Itoa(step.ExitCode), nil
+		}
+		return "", fmt.Errorf("unknown step property %q", property)
+	case "env":
+		if len(parts) != 2 {
+			return "", fmt.Errorf("invalid reference %q, expected env.<name>", ref)
+		}
+		// Later values take precedence, matching how the container runtime treats duplicated variables.
+		for i := len(ctx.Envs) - 1; i >= 0; i-- {
+			pair := strings.SplitN(ctx.Envs[i], "=", 2)
+			if len(pair) == 2 && pair[0] == parts[1] {
+				return pair[1], nil
+			}
+		}
+		return os.Getenv(parts[1]), nil
+	}
+	return "", fmt.Errorf("unknown reference %q", ref)
+}
+
+// parseStepReference parses a reference to a step, i.e. steps.<id>.<property> or steps.<id>.outputs.<key>.
+// The ID can contain dots, e.g. the ID of an expanded matrix step such as build-1.21.
+func parseStepReference(ref string) (id string, property string, key string, ok bool) {
+	rest := ref[len("steps."):]
+	if i := strings.LastIndex(strings.ToLower(rest), ".outputs."); i > 0 {
+		id, property, key = rest[:i], rest[i+1:i+len(".outputs")], rest[i+len(".outputs."):]
+		return id, property, key, key != "" && !strings.Contains(key, ".")
+	}
+	i := strings.LastIndex(rest, ".")
+	if i <= 0 {
+		return "", "", "", false
+	}
+	return rest[:i], rest[i+1:], "", true
+}
+
+func isTruthy(val string) bool {
+	return val != "" && val != falseValue && val != "0"
+}
+
+func boolValue(b bool) string {
+	if b {
+		return trueValue
+	}
+	return falseValue
+}
+
+type conditionNode interface {
+	eval(ctx *ConditionContext) (string, error)
+}
+
+type literalNode struct {
+	value string
+}
+
+func (n *literalNode) eval(_ *ConditionContext) (string, error) {
+	return n.value, nil
+}
+
+type referenceNode struct {
+	ref string
+}
+
+func (n *referenceNode) eval(ctx *ConditionContext) (string, error) {
+	if ctx == nil {
+		return "", fmt.Errorf("unknown reference %q", n.ref)
+	}
+	return ctx.lookup(n.ref)
+}
+
+type notNode struct {
+	operand conditionNode
+}
+
+func (n *notNode) eval(ctx *ConditionContext) (string, error) {
+	val, err := n.operand.eval(ctx)
+	if err != nil {
+		return "", err
+	}
+	return boolValue(!isTruthy(val)), nil
+}
+
+type binaryNode struct {
+	op          string
+	left, right conditionNode
+}
+
+func (n *binaryNode) eval(ctx *ConditionContext) (string, error) {
+	left, err := n.left.eval(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// Short circuit logical operators.
+	switch n.op {
+	case "&&":
+		if !isTruthy(left) {
+			return falseValue, nil
+		}
+	case "||":
+		if isTruthy(left) {
+			return trueValue, nil
+		}
+	}
+
+	right, err := n.right.eval(ctx)
+	if err != nil {
+		return "", err
+	}
+	switch n.op {
+	case "==":
+		return boolValue(left == right), nil
+	case "!=":
+		return boolValue(left != right), nil
+	default:
+		return boolValue(isTruthy(right)), nil
+	}
+}
+
+type conditionTokenKind int
+
+const (
+	tokenEOF conditionTokenKind = iota
+	tokenString
+	tokenIdentifier
+	tokenOperator
+	tokenLeftParen
+	tokenRightParen
+)
+
+type conditionToken struct {
+	kind conditionTokenKind
+	text string
+}
+
+func tokenizeCondition(expression string) ([]conditionToken, error) {
+	var tokens []conditionToken
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, conditionToken{kind: tokenLeftParen, text: "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, conditionToken{kind: tokenRightParen, text: ")"})
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != c {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated string in condition %q", expression)
+			}
+			tokens = append(tokens, conditionToken{kind: tokenString, text: string(runes[i+1 : end])})
+			i = end + 1
+		case c == '=' || c == '!' || c == '&' || c == '|':
+			if i+1 < len(runes) {
+				op := string(runes[i : i+2])
+				if op == "==" || op == "!=" || op == "&&" || op == "||" {
+					tokens = append(tokens, conditionToken{kind: tokenOperator, text: op})
+					i += 2
+					continue
+				}
+			}
+			if c == '!' {
+				tokens = append(tokens, conditionToken{kind: tokenOperator, text: "!"})
+				i++
+				continue
+			}
+			return nil, fmt.Errorf("unexpected %q in condition %q", string(c), expression)
+		case isIdentifierRune(c):
+			end := i
+			for end < len(runes) && isIdentifierRune(runes[end]) {
+				end++
+			}
+			tokens = append(tokens, conditionToken{kind: tokenIdentifier, text: string(runes[i:end])})
+			i = end
+		default:
+			return nil, fmt.Errorf("unexpected %q in condition %q", string(c), expression)
+		}
+	}
+	return append(tokens, conditionToken{kind: tokenEOF}), nil
+}
+
+func isIdentifierRune(c rune) bool {
+	return isAlphanumeric(c) || c == '_' || c == '-' || c == '.'
+}
+
+type conditionParser struct {
+	tokens []conditionToken
+	pos    int
+}
+
+func (p *conditionParser) peek() conditionToken {
+	return p.tokens[p.pos]
+}
+
+func (p *conditionParser) next() conditionToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *conditionParser) parseOr() (conditionNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOperator && p.peek().text == "||" {
+		p.next()
+		var right conditionNode
+		right, err = p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseAnd() (conditionNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOperator && p.peek().text == "&&" {
+		p.next()
+		var right conditionNode
+		right, err = p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseUnary() (conditionNode, error) {
+	if p.peek().kind == tokenOperator && p.peek().text == "!" {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *conditionParser) parseComparison() (conditionNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind == tokenOperator && (p.peek().text == "==" || p.peek().text == "!=") {
+		op := p.next().text
+		var right conditionNode
+		right, err = p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryNode{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parsePrimary() (conditionNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenLeftParen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokenRightParen {
+			return nil, errors.New("missing closing parenthesis in condition")
+		}
+		return node, nil
+	case tokenString:
+		return &literalNode{value: t.text}, nil
+	case tokenIdentifier:
+		if t.text == trueValue || t.text == falseValue {
+			return &literalNode{value: t.text}, nil
+		}
+		if _, err := strconv.ParseFloat(t.text, 64); err == nil {
+			return &literalNode{value: t.text}, nil
+		}
+		return &referenceNode{ref: t.text}, nil
+	case tokenEOF:
+		return nil, errors.New("unexpected end of condition")
+	}
+	return nil, fmt.Errorf("unexpected %q in condition", t.text)
+}
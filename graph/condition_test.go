@@ -0,0 +1,127 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCondition(t *testing.T) {
+	tests := []struct {
+		expression  string
+		shouldError bool
+	}{
+		{`Run.Branch == "main"`, false},
+		{`steps.build.status == 'failed' || !(env.FOO == "bar" && true)`, false},
+		{`steps.build-foo.exitCode != 0`, false},
+		{`Run.Branch ==`, true},
+		{`Run.Branch == "main`, true},
+		{`(Run.Branch == "main"`, true},
+		{`Run.Branch = "main"`, true},
+		{`Run.Branch == "main" "dev"`, true},
+		{`Run.Branch > 1`, true},
+		{``, true},
+	}
+
+	for _, test := range tests {
+		_, err := ParseCondition(test.expression)
+		if test.shouldError && err == nil {
+			t.Errorf("Expected %q to fail parsing but it didn't", test.expression)
+		}
+		if !test.shouldError && err != nil {
+			t.Errorf("Expected %q to parse but got: %v", test.expression, err)
+		}
+	}
+}
+
+func TestConditionEvaluate(t *testing.T) {
+	t.Setenv("ACB_CONDITION_TEST", "host")
+	ctx := &ConditionContext{
+		Run: map[string]string{
+			"Branch": "main",
+			"GitTag": "",
+		},
+		Steps: map[string]*Step{
+			"build": {ID: "build", StepStatus: Failed, ExitCode: 2},
+			"test":  {ID: "test", StepStatus: Successful, Outputs: map[string]string{"coverage": "80"}},
+
+			"build-alpine-1.21": {ID: "build-alpine-1.21", StepStatus: Successful, Outputs: map[string]string{"tag": "1.0"}},
+		},
+		Envs: []string{"FOO=bar", "FOO=baz"},
+	}
+
+	tests := []struct {
+		expression  string
+		expected    bool
+		shouldError bool
+	}{
+		{`Run.Branch == "main"`, true, false},
+		{`Run.Branch != "main"`, false, false},
+		{`run.Branch == 'main'`, true, false},
+		{`Run.GitTag`, false, false},
+		{`!Run.GitTag`, true, false},
+		{`steps.build.status == "failed"`, true, false},
+		{`steps.build.exitCode == 2`, true, false},
+		{`steps.test.exitCode`, false, false},
+		{`steps.test.outputs.coverage == "80"`, true, false},
+		{`steps.test.outputs.missing`, false, false},
+		{`steps.test.foo.coverage`, false, true},
+		{`steps.build-alpine-1.21.status == "successful"`, true, false},
+		{`steps.build-alpine-1.21.outputs.tag == "1.0"`, true, false},
+		{`steps.build-alpine-1.21.outputs.tag.x`, false, true},
+		{`steps.build`, false, true},
+		{`steps.build.status == "failed" && steps.test.status == "successful"`, true, false},
+		{`steps.build.status == "successful" || Run.Branch == "main"`, true, false},
+		{`!(steps.build.status == "failed")`, false, false},
+		{`env.FOO == "baz"`, true, false},
+		{`env.ACB_CONDITION_TEST == "host"`, true, false},
+		{`env.ACB_CONDITION_MISSING`, false, false},
+		{`true && false`, false, false},
+		{`Run.Branch == "dev" && steps.missing.status`, false, false},
+		{`steps.missing.status == "failed"`, false, true},
+		{`steps.build.foo`, false, true},
+		{`Run.Missing`, false, true},
+		{`foo.bar`, false, true},
+	}
+
+	for _, test := range tests {
+		condition, err := ParseCondition(test.expression)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", test.expression, err)
+		}
+		actual, err := condition.Evaluate(ctx)
+		if test.shouldError {
+			if err == nil {
+				t.Errorf("Expected %q to fail evaluation but it didn't", test.expression)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error evaluating %q: %v", test.expression, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("Expected %q to evaluate to %v but got %v", test.expression, test.expected, actual)
+		}
+	}
+}
+
+func TestConditionStepReferences(t *testing.T) {
+	condition, err := ParseCondition(`!(steps.build.status == "failed") && (Run.Branch == "main" || steps.test.outputs.coverage == steps.build.exitCode)`)
+	if err != nil {
+		t.Fatalf("Failed to parse the condition: %v", err)
+	}
+	if expected, actual := []string{"build", "test"}, condition.StepReferences(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected step references %v but got %v", expected, actual)
+	}
+
+	condition, err = ParseCondition(`steps.build-alpine-1.21.outputs.tag == "1.0" && steps.test-1.x.status`)
+	if err != nil {
+		t.Fatalf("Failed to parse the condition: %v", err)
+	}
+	if expected, actual := []string{"build-alpine-1.21", "test-1.x"}, condition.StepReferences(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected step references %v but got %v", expected, actual)
+	}
+}
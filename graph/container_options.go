@@ -0,0 +1,218 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	units "github.com/docker/go-units"
+	"github.com/pkg/errors"
+)
+
+var (
+	errInvalidContainerOptionsUse = errors.New("resource limits and security options can only be used for cmd or build steps")
+	errInvalidBuildOptionsUse     = errors.New("build steps only support the memory, shmSize and ulimits options")
+
+	capabilityRE = regexp.MustCompile(`^(?i)(CAP_)?[A-Z][A-Z0-9_]*$`)
+
+	// securityOptValues are the supported security options and whether or not they require a value.
+	securityOptValues = map[string]bool{
+		"no-new-privileges": false,
+		"seccomp":           true,
+		"apparmor":          true,
+		"label":             true,
+		"systempaths":       true,
+	}
+)
+
+// HasContainerOptions returns true if the Step specifies any resource limits or security options for its container.
+func (s *Step) HasContainerOptions() bool {
+	return s.Memory != "" ||
+		s.MemorySwap != "" ||
+		s.ShmSize != "" ||
+		s.PidsLimit != 0 ||
+		len(s.Ulimits) > 0 ||
+		len(s.CapAdd) > 0 ||
+		len(s.CapDrop) > 0 ||
+		len(s.SecurityOpt) > 0 ||
+		s.ReadOnlyRootFilesystem ||
+		len(s.Tmpfs) > 0 ||
+		len(s.DNS) > 0 ||
+		len(s.ExtraHosts) > 0 ||
+		len(s.Labels) > 0 ||
+		len(s.Devices) > 0
+}
+
+// hasContainerOnlyOptions returns true if the Step specifies any options which docker build doesn't support.
+func (s *Step) hasContainerOnlyOptions() bool {
+	return s.MemorySwap != "" ||
+		s.PidsLimit != 0 ||
+		len(s.CapAdd) > 0 ||
+		len(s.CapDrop) > 0 ||
+		len(s.SecurityOpt) > 0 ||
+		s.ReadOnlyRootFilesystem ||
+		len(s.Tmpfs) > 0 ||
+		len(s.DNS) > 0 ||
+		len(s.ExtraHosts) > 0 ||
+		len(s.Labels) > 0 ||
+		len(s.Devices) > 0
+}
+
+// validateContainerOptions validates the resource limits and security options of the Step's container.
+func (s *Step) validateContainerOptions() error {
+	if !s.HasContainerOptions() {
+		return nil
+	}
+	if !s.IsCmdStep() && !s.IsBuildStep() {
+		return errInvalidContainerOptionsUse
+	}
+	if s.IsBuildStep() && s.hasContainerOnlyOptions() {
+		return errInvalidBuildOptionsUse
+	}
+
+	var memory int64
+	if s.Memory != "" {
+		var err error
+		if memory, err = parseSize(s.Memory); err != nil {
+			return errors.Wrap(err, "invalid memory")
+		}
+	}
+	if s.MemorySwap != "" && s.MemorySwap != "-1" {
+		if s.Memory == "" {
+			return errors.New("memorySwap requires memory to be specified")
+		}
+		swap, err := parseSize(s.MemorySwap)
+		if err != nil {
+			return errors.Wrap(err, "invalid memorySwap")
+		}
+		if swap < memory {
+			return fmt.Errorf("memorySwap %s must be at least memory %s", s.MemorySwap, s.Memory)
+		}
+	}
+	if s.ShmSize != "" {
+		if _, err := parseSize(s.ShmSize); err != nil {
+			return errors.Wrap(err, "invalid shmSize")
+		}
+	}
+	if s.PidsLimit < -1 {
+		return fmt.Errorf("pidsLimit must be -1 for unlimited or > 0, got %d", s.PidsLimit)
+	}
+
+	ulimits := make(map[string]bool, len(s.Ulimits))
+	for _, u := range s.Ulimits {
+		ulimit, err := units.ParseUlimit(u)
+		if err != nil {
+			return errors.Wrapf(err, "invalid ulimit %s", u)
+		}
+		if ulimits[ulimit.Name] {
+			return fmt.Errorf("ulimit %s is specified more than once", ulimit.Name)
+		}
+		ulimits[ulimit.Name] = true
+	}
+
+	for _, c := range append(append([]string{}, s.CapAdd...), s.CapDrop...) {
+		if !strings.EqualFold(c, "ALL") && !capabilityRE.MatchString(c) {
+			return fmt.Errorf("invalid capability %q", c)
+		}
+	}
+
+	for _, opt := range s.SecurityOpt {
+		if err := validateSecurityOpt(opt); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range s.Tmpfs {
+		if dest := strings.SplitN(t, ":", 2)[0]; !strings.HasPrefix(dest, "/") {
+			return fmt.Errorf("invalid tmpfs %q, the mount path must be absolute", t)
+		}
+	}
+
+	for _, dns := range s.DNS {
+		if net.ParseIP(dns) == nil {
+			return fmt.Errorf("invalid dns %q, it must be an IP address", dns)
+		}
+	}
+
+	for _, h := range s.ExtraHosts {
+		host, ip, found := strings.Cut(h, ":")
+		if !found || host == "" || (ip != "host-gateway" && net.ParseIP(strings.Trim(ip, "[]")) == nil) {
+			return fmt.Errorf("invalid extra host %q, it must be in the form of host:ip", h)
+		}
+	}
+
+	for _, l := range s.Labels {
+		if key, _, _ := strings.Cut(l, "="); key == "" {
+			return fmt.Errorf("invalid label %q, it must be in the form of key=value", l)
+		}
+	}
+
+	for _, d := range s.Devices {
+		if err := validateDevice(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseSize parses a size such as 512m, which must be positive.
+func parseSize(size string) (int64, error) {
+	bytes, err := units.RAMInBytes(size)
+	if err != nil {
+		return 0, err
+	}
+	if bytes <= 0 {
+		return 0, fmt.Errorf("size %s must be positive", size)
+	}
+	return bytes, nil
+}
+
+// validateSecurityOpt validates a security option in the form of key, key=value or key:value.
+func validateSecurityOpt(opt string) error {
+	key, value, found := strings.Cut(opt, "=")
+	if !found {
+		key, value, found = strings.Cut(opt, ":")
+	}
+	requiresValue, ok := securityOptValues[key]
+	if !ok {
+		return fmt.Errorf("unsupported security option %q", opt)
+	}
+	if requiresValue && value == "" {
+		return fmt.Errorf("security option %q requires a value", opt)
+	}
+	if !requiresValue && found && value != "true" && value != "false" {
+		return fmt.Errorf("invalid security option %q, its value must be true or false", opt)
+	}
+	return nil
+}
+
+// validateDevice validates a device in the form of host path[:container path][:permissions].
+func validateDevice(device string) error {
+	parts := strings.Split(device, ":")
+	if len(parts) > 3 || !strings.HasPrefix(parts[0], "/") {
+		return fmt.Errorf("invalid device %q, it must be in the form of /host/path[:/container/path][:permissions]", device)
+	}
+	perms := ""
+	switch {
+	case len(parts) == 3:
+		perms = parts[2]
+		if !strings.HasPrefix(parts[1], "/") {
+			return fmt.Errorf("invalid device %q, the container path must be absolute", device)
+		}
+	case len(parts) == 2 && !strings.HasPrefix(parts[1], "/"):
+		perms = parts[1]
+	}
+	if len(parts) == 3 && perms == "" {
+		return fmt.Errorf("invalid device %q, permissions must be specified", device)
+	}
+	for _, p := range perms {
+		if !strings.ContainsRune("rwm", p) {
+			return fmt.Errorf("invalid device %q, permissions must be a combination of r, w and m", device)
+		}
+	}
+	return nil
+}
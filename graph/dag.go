@@ -21,6 +21,10 @@ type Node struct {
 	children map[string]*Node
 	mu       sync.Mutex
 	degree   int
+
+	// parents are the nodes this node depends on. Unlike children, parents aren't
+	// modified when an edge is removed, so dependencies can be determined while the Dag is processed.
+	parents map[string]*Node
 }
 
 // NewNode creates a new Node based on the provided name and value.
@@ -31,6 +35,7 @@ func NewNode(value *Step) *Node {
 		children: make(map[string]*Node),
 		mu:       sync.Mutex{},
 		degree:   0,
+		parents:  make(map[string]*Node),
 	}
 }
 
@@ -60,7 +65,7 @@ func NewDag() *Dag {
 func NewDagFromTask(t *Task) (*Dag, error) {
 	dag := NewDag()
 
-	var prevStep *Step
+	// Add all vertices before any edges so that steps can depend on steps which are defined after them.
 	for _, step := range t.Steps {
 		if err := step.Validate(); err != nil {
 			return dag, err
@@ -68,7 +73,10 @@ func NewDagFromTask(t *Task) (*Dag, error) {
 		if _, err := dag.AddVertex(step); err != nil {
 			return dag, err
 		}
+	}
 
+	var prevStep *Step
+	for _, step := range t.Steps {
 		// If the step is parallel, add it to the root
 		if step.ShouldExecuteImmediately() {
 			if err := dag.AddEdge(rootNodeID, step.ID); err != nil {
@@ -97,6 +105,12 @@ func NewDagFromTask(t *Task) (*Dag, error) {
 		prevStep = step
 	}
 
+	if err := dag.validateAcyclic(); err != nil {
+		return dag, err
+	}
+	if err := dag.validateConditions(); err != nil {
+		return dag, err
+	}
 	return dag, nil
 }
 
@@ -128,6 +142,7 @@ func (d *Dag) AddEdge(from string, to string) error {
 
 	toNode.mu.Lock()
 	toNode.degree++
+	toNode.parents[from] = fromNode
 	toNode.mu.Unlock()
 
 	return nil
@@ -164,6 +179,49 @@ func (n *Node) Children() []*Node {
 	return childNodes
 }
 
+// HasDependents returns true if any step depends on the specified step.
+func (d *Dag) HasDependents(id string) bool {
+	d.mu.Lock()
+	node, ok := d.Nodes[id]
+	d.mu.Unlock()
+	return ok && len(node.Children()) > 0
+}
+
+// Dependencies returns the steps which the specified step depends on, directly or transitively.
+func (d *Dag) Dependencies(id string) []*Step {
+	d.mu.Lock()
+	node, ok := d.Nodes[id]
+	d.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	var deps []*Step
+	visited := map[string]bool{}
+	queue := []*Node{node}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+
+		n.mu.Lock()
+		parents := make([]*Node, 0, len(n.parents))
+		for _, p := range n.parents {
+			parents = append(parents, p)
+		}
+		n.mu.Unlock()
+
+		for _, p := range parents {
+			if p.Name == rootNodeID || visited[p.Name] {
+				continue
+			}
+			visited[p.Name] = true
+			deps = append(deps, p.Value)
+			queue = append(queue, p)
+		}
+	}
+	return deps
+}
+
 func (d *Dag) validateFromAndTo(from string, to string) (fromNode *Node, toNode *Node, err error) {
 	if from == "" {
 		return nil, nil, errors.New("from cannot be empty")
@@ -37,6 +37,7 @@ func TestDagCreation_ValidFile(t *testing.T) {
 		Network:             DefaultNetworkName,
 		Retries:             5,
 		RetryDelayInSeconds: 90,
+		StopTimeout:         defaultStepStopTimeoutInSeconds,
 		Pull:                true,
 	}
 
@@ -54,6 +55,7 @@ func TestDagCreation_ValidFile(t *testing.T) {
 		Network:             DefaultNetworkName,
 		Envs:                []string{"foo=taskEnv"},
 		RetryDelayInSeconds: defaultStepRetryDelayInSeconds,
+		StopTimeout:         defaultStepStopTimeoutInSeconds,
 	}
 
 	bStep := &Step{
@@ -67,6 +69,7 @@ func TestDagCreation_ValidFile(t *testing.T) {
 		DisableWorkingDirectoryOverride: true,
 		Envs:                            []string{"foo=taskEnv"},
 		RetryDelayInSeconds:             defaultStepRetryDelayInSeconds,
+		StopTimeout:                     defaultStepStopTimeoutInSeconds,
 	}
 
 	fooStep := &Step{
@@ -78,6 +81,7 @@ func TestDagCreation_ValidFile(t *testing.T) {
 		Timeout:             defaultStepTimeoutInSeconds,
 		Network:             DefaultNetworkName,
 		RetryDelayInSeconds: defaultStepRetryDelayInSeconds,
+		StopTimeout:         defaultStepStopTimeoutInSeconds,
 	}
 
 	barStep := &Step{
@@ -89,6 +93,7 @@ func TestDagCreation_ValidFile(t *testing.T) {
 		Network:             DefaultNetworkName,
 		Envs:                []string{"foo=taskEnv"},
 		RetryDelayInSeconds: defaultStepRetryDelayInSeconds,
+		StopTimeout:         defaultStepStopTimeoutInSeconds,
 	}
 
 	quxStep := &Step{
@@ -102,6 +107,7 @@ func TestDagCreation_ValidFile(t *testing.T) {
 		Network:             DefaultNetworkName,
 		Envs:                []string{"foo=taskEnv"},
 		RetryDelayInSeconds: defaultStepRetryDelayInSeconds,
+		StopTimeout:         defaultStepStopTimeoutInSeconds,
 	}
 
 	qazStep := &Step{
@@ -114,6 +120,7 @@ func TestDagCreation_ValidFile(t *testing.T) {
 		Network:             "host",
 		Envs:                []string{"foo=taskEnv"},
 		RetryDelayInSeconds: defaultStepRetryDelayInSeconds,
+		StopTimeout:         defaultStepStopTimeoutInSeconds,
 		Repeat:              2,
 	}
 
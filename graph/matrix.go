@@ -0,0 +1,279 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/acr-builder/pkg/volume"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// matrixReferenceRE matches references to a step's matrix values, i.e. {{.Matrix.os}}.
+var matrixReferenceRE = regexp.MustCompile(`\{\{\s*\.Matrix\.([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// MatrixAxis is a named list of values which a step is expanded over.
+type MatrixAxis struct {
+	Name   string
+	Values []string
+}
+
+// Matrix expands a step into one step per combination of its axes' values.
+type Matrix []*MatrixAxis
+
+// UnmarshalYAML unmarshals a Matrix from a mapping of axis names to values, preserving the order of the axes.
+func (m *Matrix) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// The MapSlice provides the order of the axes, and the map their values as strings.
+	var order yaml.MapSlice
+	if err := unmarshal(&order); err != nil {
+		return err
+	}
+	var values map[string][]string
+	if err := unmarshal(&values); err != nil {
+		return err
+	}
+	for _, item := range order {
+		name := fmt.Sprint(item.Key)
+		*m = append(*m, &MatrixAxis{Name: name, Values: values[name]})
+	}
+	return nil
+}
+
+// MarshalYAML marshals a Matrix as a mapping of axis names to values.
+func (m Matrix) MarshalYAML() (interface{}, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	var slice yaml.MapSlice
+	for _, axis := range m {
+		slice = append(slice, yaml.MapItem{Key: axis.Name, Value: axis.Values})
+	}
+	return slice, nil
+}
+
+// Validate validates the Matrix and returns an error if it has problems.
+func (m Matrix) Validate() error {
+	names := make(map[string]bool, len(m))
+	for _, axis := range m {
+		if !identifierRE.MatchString(axis.Name) {
+			return fmt.Errorf("invalid matrix key %q, keys may only contain letters, digits and underscores", axis.Name)
+		}
+		if names[axis.Name] {
+			return fmt.Errorf("matrix key %q is specified multiple times", axis.Name)
+		}
+		names[axis.Name] = true
+		if len(axis.Values) == 0 {
+			return fmt.Errorf("matrix key %q must have at least one value", axis.Name)
+		}
+	}
+	return nil
+}
+
+// Combinations returns every combination of the Matrix's values. Each combination
+// contains one value per axis, in the order of the axes.
+func (m Matrix) Combinations() [][]string {
+	combinations := [][]string{{}}
+	for _, axis := range m {
+		var next [][]string
+		for _, combination := range combinations {
+			for _, val := range axis.Values {
+				c := make([]string, len(combination), len(combination)+1)
+				copy(c, combination)
+				next = append(next, append(c, val))
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+// MatrixReference returns the reference to the specified matrix value
+// which is resolved when a step is expanded.
+func MatrixReference(key string) string {
+	return fmt.Sprintf("{{.Matrix.%s}}", key)
+}
+
+// expandMatrixSteps replaces each step which has a matrix with one step per combination of its values.
+// Expanded steps run in parallel, and steps which depend on the original step depend on all of its expansions.
+func (t *Task) expandMatrixSteps() error {
+	var expandedSteps []*Step
+
+	// expansions maps the ID of each step with a matrix to the IDs of its expansions.
+	expansions := make(map[string][]string)
+
+	// prevIDs are the IDs of the previous step, or of its expansions.
+	var prevIDs []string
+	prevExpanded := false
+	for _, s := range t.Steps {
+		if len(s.Matrix) == 0 {
+			// Steps without a when implicitly depend on the previous step, which must include all of its expansions.
+			if prevExpanded && s.HasNoWhen() {
+				s.When = append([]string{}, prevIDs...)
+			}
+			expandedSteps = append(expandedSteps, s)
+			prevIDs = []string{s.ID}
+			prevExpanded = false
+			continue
+		}
+
+		if err := s.Matrix.Validate(); err != nil {
+			return fmt.Errorf("step ID: %s has an invalid matrix: %v", s.ID, err)
+		}
+
+		var ids []string
+		for _, values := range s.Matrix.Combinations() {
+			expanded, err := s.expand(values)
+			if err != nil {
+				return err
+			}
+			if s.HasNoWhen() {
+				if len(prevIDs) > 0 {
+					expanded.When = append([]string{}, prevIDs...)
+				} else {
+					expanded.When = []string{ImmediateExecutionToken}
+				}
+			}
+			expandedSteps = append(expandedSteps, expanded)
+			ids = append(ids, expanded.ID)
+		}
+		expansions[s.ID] = ids
+		prevIDs = ids
+		prevExpanded = true
+	}
+
+	t.Steps = expandedSteps
+	if len(expansions) == 0 {
+		return nil
+	}
+	for _, s := range expandedSteps {
+		var when []string
+		for _, dep := range s.When {
+			if ids, ok := expansions[dep]; ok {
+				when = append(when, ids...)
+			} else {
+				when = append(when, dep)
+			}
+		}
+		s.When = when
+	}
+	return nil
+}
+
+// expand creates a copy of the step for the specified combination of its matrix values.
+// The copy's ID is suffixed with the values, references to the values are substituted,
+// and each value is exposed as a MATRIX_<KEY> environment variable.
+func (s *Step) expand(values []string) (*Step, error) {
+	vals := make(map[string]string, len(values))
+	idSuffix := ""
+	var envs []string
+	for i, axis := range s.Matrix {
+		vals[axis.Name] = values[i]
+		idSuffix += "-" + matrixIDSegment(values[i])
+		envs = append(envs, fmt.Sprintf("MATRIX_%s=%s", envNameSegment(axis.Name), values[i]))
+	}
+
+	var substituteErr error
+	substitute := func(str string) string {
+		return matrixReferenceRE.ReplaceAllStringFunc(str, func(ref string) string {
+			key := matrixReferenceRE.FindStringSubmatch(ref)[1]
+			val, ok := vals[key]
+			if !ok && substituteErr == nil {
+				substituteErr = fmt.Errorf("step ID: %s references matrix key %q, which doesn't exist", s.ID, key)
+			}
+			return val
+		})
+	}
+
+	substituteAll := func(strs []string) []string {
+		if strs == nil {
+			return nil
+		}
+		substituted := make([]string, len(strs))
+		for i, str := range strs {
+			substituted[i] = substitute(str)
+		}
+		return substituted
+	}
+
+	// Every field which the copy shares with the step by reference is copied, so that the expanded steps are independent.
+	expanded := *s
+	expanded.ID = s.ID + idSuffix
+	expanded.Matrix = nil
+	expanded.Cmd = substitute(s.Cmd)
+	expanded.Build = substitute(s.Build)
+	expanded.WorkingDirectory = substitute(s.WorkingDirectory)
+	expanded.EntryPoint = substitute(s.EntryPoint)
+	expanded.User = substitute(s.User)
+	expanded.Network = substitute(s.Network)
+	expanded.Isolation = substitute(s.Isolation)
+	expanded.CPUS = substitute(s.CPUS)
+	expanded.Cache = substitute(s.Cache)
+	expanded.If = substitute(s.If)
+	expanded.Memory = substitute(s.Memory)
+	expanded.MemorySwap = substitute(s.MemorySwap)
+	expanded.ShmSize = substitute(s.ShmSize)
+	expanded.Push = substituteAll(s.Push)
+	expanded.Expose = substituteAll(s.Expose)
+	expanded.Ports = substituteAll(s.Ports)
+	expanded.When = append([]string{}, s.When...)
+	expanded.RetryOnErrors = substituteAll(s.RetryOnErrors)
+	expanded.Ulimits = substituteAll(s.Ulimits)
+	expanded.CapAdd = substituteAll(s.CapAdd)
+	expanded.CapDrop = substituteAll(s.CapDrop)
+	expanded.SecurityOpt = substituteAll(s.SecurityOpt)
+	expanded.Tmpfs = substituteAll(s.Tmpfs)
+	expanded.DNS = substituteAll(s.DNS)
+	expanded.ExtraHosts = substituteAll(s.ExtraHosts)
+	expanded.Labels = substituteAll(s.Labels)
+	expanded.Devices = substituteAll(s.Devices)
+	expanded.ExitedWith = append([]int(nil), s.ExitedWith...)
+	expanded.ExitedWithout = append([]int(nil), s.ExitedWithout...)
+	expanded.Mounts = nil
+	for _, m := range s.Mounts {
+		expanded.Mounts = append(expanded.Mounts, &volume.Mount{Name: substitute(m.Name), MountPath: substitute(m.MountPath)})
+	}
+	if s.Sign != nil {
+		sign := *s.Sign
+		sign.Images = substituteAll(s.Sign.Images)
+		sign.Key = substitute(s.Sign.Key)
+		expanded.Sign = &sign
+	}
+	if s.VerifySignature != nil {
+		verify := *s.VerifySignature
+		verify.Key = substitute(s.VerifySignature.Key)
+		expanded.VerifySignature = &verify
+	}
+	if s.Readiness != nil {
+		readiness := *s.Readiness
+		readiness.Exec = substituteAll(s.Readiness.Exec)
+		if s.Readiness.HTTP != nil {
+			http := *s.Readiness.HTTP
+			http.Path = substitute(s.Readiness.HTTP.Path)
+			readiness.HTTP = &http
+		}
+		expanded.Readiness = &readiness
+	}
+	// Matrix values come before the step's own environment variables so that they can be overridden.
+	for _, env := range s.Envs {
+		envs = append(envs, substitute(env))
+	}
+	expanded.Envs = envs
+
+	if substituteErr != nil {
+		return nil, substituteErr
+	}
+	return &expanded, nil
+}
+
+// matrixIDSegment converts a matrix value into a segment of a step ID, i.e. linux/amd64 becomes linux-amd64.
+func matrixIDSegment(val string) string {
+	return strings.Map(func(c rune) rune {
+		if isAlphanumeric(c) || c == '-' || c == '_' || c == '.' {
+			return c
+		}
+		return '-'
+	}, val)
+}
@@ -0,0 +1,140 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	gocontext "context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/Azure/acr-builder/pkg/volume"
+)
+
+func TestMatrixExpansion(t *testing.T) {
+	data := `
+steps:
+  - id: setup
+    cmd: setup
+  - id: build
+    build: -t app:{{.Matrix.os}}-{{ .Matrix.ver }} --build-arg VER={{.Matrix.ver}} .
+    env: ["OS={{.Matrix.os}}"]
+    matrix:
+      os: [linux, windows/amd64]
+      ver: [1.20, "1.21"]
+  - id: test
+    cmd: test
+  - id: publish
+    cmd: publish
+    when: ["build", "setup"]
+`
+	task, err := UnmarshalTaskFromString(gocontext.Background(), data, &TaskOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+
+	expectedIDs := []string{
+		"setup",
+		"build-linux-1.20",
+		"build-linux-1.21",
+		"build-windows-amd64-1.20",
+		"build-windows-amd64-1.21",
+		"test",
+		"publish",
+	}
+	var actualIDs []string
+	for _, step := range task.Steps {
+		actualIDs = append(actualIDs, step.ID)
+	}
+	if !reflect.DeepEqual(expectedIDs, actualIDs) {
+		t.Fatalf("Expected steps %v but got %v", expectedIDs, actualIDs)
+	}
+
+	build := task.Steps[3]
+	if expected := "-t app:windows/amd64-1.20 --build-arg VER=1.20 ."; build.Build != expected {
+		t.Errorf("Expected build to be %s but got %s", expected, build.Build)
+	}
+	if expected := []string{"app:windows/amd64-1.20"}; !reflect.DeepEqual(expected, build.Tags) {
+		t.Errorf("Expected tags to be %v but got %v", expected, build.Tags)
+	}
+	if expected := []string{"MATRIX_OS=windows/amd64", "MATRIX_VER=1.20", "OS=windows/amd64"}; !reflect.DeepEqual(expected, build.Envs) {
+		t.Errorf("Expected envs to be %v but got %v", expected, build.Envs)
+	}
+	if expected := []string{"setup"}; !reflect.DeepEqual(expected, build.When) {
+		t.Errorf("Expected when to be %v but got %v", expected, build.When)
+	}
+	if len(build.Matrix) != 0 {
+		t.Errorf("Expected expanded steps to have no matrix")
+	}
+
+	// Steps which depend on the matrix step, explicitly or implicitly, wait on all of its expansions.
+	for _, id := range []string{"test", "publish"} {
+		var deps []string
+		for _, dep := range task.Dag.Dependencies(id) {
+			deps = append(deps, dep.ID)
+		}
+		sort.Strings(deps)
+		expected := []string{"build-linux-1.20", "build-linux-1.21", "build-windows-amd64-1.20", "build-windows-amd64-1.21", "setup"}
+		if !reflect.DeepEqual(expected, deps) {
+			t.Errorf("Expected %s to depend on %v but got %v", id, expected, deps)
+		}
+	}
+}
+
+func TestMatrixExpansion_Invalid(t *testing.T) {
+	tests := []string{
+		"steps:\n  - id: a\n    cmd: a\n    matrix:\n      os: []",
+		"steps:\n  - id: a\n    cmd: a\n    matrix:\n      my-os: [linux]",
+		"steps:\n  - id: a\n    cmd: a {{.Matrix.ver}}\n    matrix:\n      os: [linux]",
+		"steps:\n  - id: a\n    cmd: a\n    matrix:\n      os: [linux/amd64, linux-amd64]",
+	}
+
+	for _, test := range tests {
+		if _, err := UnmarshalTaskFromString(gocontext.Background(), test, &TaskOptions{}); err == nil {
+			t.Errorf("Expected task to error but it didn't: %s", test)
+		}
+	}
+}
+
+func TestStepExpand(t *testing.T) {
+	s := &Step{
+		ID:        "test",
+		Cmd:       "golang:{{.Matrix.go}} go test",
+		Network:   "net-{{.Matrix.go}}",
+		If:        `Run.Branch == "release-{{.Matrix.go}}"`,
+		Ports:     []string{"80{{.Matrix.go}}:80"},
+		Labels:    []string{"go={{.Matrix.go}}"},
+		Mounts:    []*volume.Mount{{Name: "cache", MountPath: "/cache/{{.Matrix.go}}"}},
+		Readiness: &Readiness{Exec: []string{"check", "{{.Matrix.go}}"}},
+		Matrix:    Matrix{{Name: "go", Values: []string{"21", "22"}}},
+	}
+
+	expanded, err := s.expand([]string{"21"})
+	if err != nil {
+		t.Fatalf("Failed to expand step: %v", err)
+	}
+	expected := &Step{
+		ID:        "test-21",
+		Cmd:       "golang:21 go test",
+		Network:   "net-21",
+		If:        `Run.Branch == "release-21"`,
+		Ports:     []string{"8021:80"},
+		Labels:    []string{"go=21"},
+		Mounts:    []*volume.Mount{{Name: "cache", MountPath: "/cache/21"}},
+		Readiness: &Readiness{Exec: []string{"check", "21"}},
+		Envs:      []string{"MATRIX_GO=21"},
+		When:      []string{},
+	}
+	if !reflect.DeepEqual(expected, expanded) {
+		t.Errorf("Expected expanded step %+v but got %+v", expected, expanded)
+	}
+
+	// The expanded step doesn't share any of its values with the original step.
+	expanded.Ports[0] = "changed"
+	expanded.Mounts[0].Name = "changed"
+	expanded.Readiness.Exec[0] = "changed"
+	if s.Ports[0] == "changed" || s.Mounts[0].Name == "changed" || s.Readiness.Exec[0] == "changed" {
+		t.Errorf("Expected changes to the expanded step not to affect the original step %+v", s)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/Azure/acr-builder/util"
+)
+
+const (
+	// OutputEnvName is the name of the environment variable containing the path
+	// of the file a step can write its outputs to.
+	OutputEnvName = "ACB_OUTPUT"
+
+	// StepIDPattern matches the ID of a step in a reference to its outputs, including the IDs of expanded
+	// matrix steps such as build-alpine-1.21. The ID ends at the first .Outputs. which follows it.
+	StepIDPattern = `[^\s{}()"'|]+?`
+)
+
+var (
+	identifierRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// outputReferenceRE matches references to step outputs, i.e. {{.Steps.build.Outputs.version}},
+	// which are left in place when the task is rendered and resolved when the step starts.
+	outputReferenceRE = regexp.MustCompile(`\{\{\s*\.Steps\.(` + StepIDPattern + `)\.Outputs\.([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+)
+
+// ParseOutputs parses the contents of a step's output file, which consists of key=value lines.
+// Empty lines are ignored and if a key is specified multiple times, the last value is used.
+func ParseOutputs(data string) (map[string]string, error) {
+	outputs := make(map[string]string)
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		pair := strings.SplitN(line, "=", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("invalid output %q, expected key=value", line)
+		}
+		if !identifierRE.MatchString(pair[0]) {
+			return nil, fmt.Errorf("invalid output key %q, keys may only contain letters, digits and underscores", pair[0])
+		}
+		outputs[pair[0]] = pair[1]
+	}
+	return outputs, nil
+}
+
+// OutputReference returns the reference to the specified step's output
+// which is resolved when a dependent step starts.
+func OutputReference(id string, key string) string {
+	return fmt.Sprintf("{{.Steps.%s.Outputs.%s}}", id, key)
+}
+
+// OutputEnv returns the environment variable exposing the specified step's output to its dependents,
+// i.e. STEPS_BUILD_OUTPUTS_VERSION=1.0.
+func OutputEnv(id string, key string, value string) string {
+	return fmt.Sprintf("STEPS_%s_OUTPUTS_%s=%s", envNameSegment(id), envNameSegment(key), value)
+}
+
+func envNameSegment(s string) string {
+	var sb strings.Builder
+	for _, c := range strings.ToUpper(s) {
+		if isAlphanumeric(c) {
+			sb.WriteRune(c)
+		} else {
+			sb.WriteRune('_')
+		}
+	}
+	return sb.String()
+}
+
+// ResolveStepOutputs substitutes references to other steps' outputs in the step's properties
+// and exposes the outputs of the steps it depends on as environment variables.
+// It must be called once all of the step's dependencies have completed.
+func (t *Task) ResolveStepOutputs(step *Step) error {
+	deps := make(map[string]*Step)
+	var outputEnvs []string
+	for _, dep := range t.Dag.Dependencies(step.ID) {
+		deps[dep.ID] = dep
+		keys := make([]string, 0, len(dep.Outputs))
+		for key := range dep.Outputs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			outputEnvs = append(outputEnvs, OutputEnv(dep.ID, key, dep.Outputs[key]))
+		}
+	}
+
+	var resolveErr error
+	lookup := func(ref string) (string, bool) {
+		matches := outputReferenceRE.FindStringSubmatch(ref)
+		dep, ok := deps[matches[1]]
+		if !ok {
+			if resolveErr == nil {
+				resolveErr = fmt.Errorf("step ID: %s references the outputs of step ID: %s, which it doesn't depend on", step.ID, matches[1])
+			}
+			return "", false
+		}
+		val, ok := dep.Outputs[matches[2]]
+		if !ok {
+			if resolveErr == nil {
+				resolveErr = fmt.Errorf("step ID: %s references output %q of step ID: %s, which doesn't exist", step.ID, matches[2], dep.ID)
+			}
+			return "", false
+		}
+		return val, true
+	}
+	resolve := func(s string) string {
+		return outputReferenceRE.ReplaceAllStringFunc(s, func(ref string) string {
+			if val, ok := lookup(ref); ok {
+				return val
+			}
+			return ref
+		})
+	}
+	// Commands are split into words, so the values substituted into them are quoted.
+	resolveWords := func(s string) string {
+		return replaceQuoted(s, outputReferenceRE, lookup)
+	}
+
+	step.Cmd = resolveWords(step.Cmd)
+	step.EntryPoint = resolve(step.EntryPoint)
+	step.WorkingDirectory = resolve(step.WorkingDirectory)
+	for i, env := range step.Envs {
+		step.Envs[i] = resolve(env)
+	}
+	for i, push := range step.Push {
+		step.Push[i] = resolve(push)
+	}
+	if build := resolveWords(step.Build); build != step.Build {
+		step.Build = build
+		step.Tags = util.ParseTags(build)
+		step.BuildArgs = util.ParseBuildArgs(build)
+	}
+	if resolveErr != nil {
+		return resolveErr
+	}
+
+	// Outputs come before the step's own environment variables so that they can be overridden.
+	step.Envs = append(outputEnvs, step.Envs...)
+	return nil
+}
+
+// replaceQuoted replaces the matches of re in a command which is split into words by util.SplitWords with the
+// values returned by lookup, quoting each value for the quotes it's in so that it can't add words to the command
+// or end its quotes. Matches which lookup returns false for are left in place.
+func replaceQuoted(cmd string, re *regexp.Regexp, lookup func(string) (string, bool)) string {
+	escapes := runtime.GOOS != util.WindowsOS
+	var sb strings.Builder
+	var quote rune
+	last := 0
+	for _, loc := range re.FindAllStringIndex(cmd, -1) {
+		quote = scanQuotes(cmd[last:loc[0]], quote, escapes)
+		sb.WriteString(cmd[last:loc[0]])
+		if val, ok := lookup(cmd[loc[0]:loc[1]]); ok {
+			sb.WriteString(quoteWord(val, quote, escapes))
+		} else {
+			sb.WriteString(cmd[loc[0]:loc[1]])
+		}
+		last = loc[1]
+	}
+	sb.WriteString(cmd[last:])
+	return sb.String()
+}
+
+// scanQuotes returns the quote which is open at the end of s, given the quote which is open at its start.
+func scanQuotes(s string, quote rune, escapes bool) rune {
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case quote == '\'' && c == '\'', quote == '"' && c == '"':
+			quote = 0
+		case c == '\\' && escapes && quote != '\'':
+			i++
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		}
+	}
+	return quote
+}
+
+// quoteWord quotes the value for the quote it's in. An unquoted value which could be split or unescaped is
+// single quoted. Quotes in the value are closed, quoted with the other quote and reopened.
+func quoteWord(val string, quote rune, escapes bool) string {
+	switch quote {
+	case '\'':
+		return strings.ReplaceAll(val, "'", `'"'"'`)
+	case '"':
+		if escapes {
+			val = strings.ReplaceAll(val, `\`, `\\`)
+		}
+		return strings.ReplaceAll(val, `"`, `"'"'"`)
+	}
+	if val != "" && strings.IndexFunc(val, func(c rune) bool { return !isAlphanumeric(c) && !strings.ContainsRune("_-./:@%+=,", c) }) < 0 {
+		return val
+	}
+	return "'" + strings.ReplaceAll(val, "'", `'"'"'`) + "'"
+}
@@ -0,0 +1,185 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	gocontext "context"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/acr-builder/util"
+)
+
+func TestParseOutputs(t *testing.T) {
+	tests := []struct {
+		data        string
+		expected    map[string]string
+		shouldError bool
+	}{
+		{"", map[string]string{}, false},
+		{"version=1.0\n\ndigest=sha256:abc=\r\n", map[string]string{"version": "1.0", "digest": "sha256:abc="}, false},
+		{"version=1.0\nversion=2.0\n", map[string]string{"version": "2.0"}, false},
+		{"empty=", map[string]string{"empty": ""}, false},
+		{"version", nil, true},
+		{"my-key=foo", nil, true},
+		{"=foo", nil, true},
+	}
+
+	for _, test := range tests {
+		actual, err := ParseOutputs(test.data)
+		if test.shouldError {
+			if err == nil {
+				t.Errorf("Expected %q to error but it didn't", test.data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %v", test.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(test.expected, actual) {
+			t.Errorf("Expected %v but got %v", test.expected, actual)
+		}
+	}
+}
+
+func TestResolveStepOutputs(t *testing.T) {
+	steps := []*Step{
+		{ID: "version", Cmd: "version"},
+		{ID: "build", Build: "-t app:" + OutputReference("version", "tag") + " ."},
+		{ID: "other", Cmd: "other", When: []string{"-"}},
+		{ID: "test", Cmd: "test {{ .Steps.version.Outputs.tag }}", When: []string{"build"}, Envs: []string{"STEPS_VERSION_OUTPUTS_TAG=override"}},
+	}
+	task, err := NewTask(gocontext.Background(), steps, nil, "", nil, true, "", "")
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	steps[0].Outputs = map[string]string{"tag": "1.0", "commit": "abc"}
+	steps[2].Outputs = map[string]string{"unrelated": "true"}
+
+	if err := task.ResolveStepOutputs(steps[1]); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "-t app:1.0 ."; steps[1].Build != expected {
+		t.Errorf("Expected build to be %s but got %s", expected, steps[1].Build)
+	}
+	if expected := []string{"app:1.0"}; !reflect.DeepEqual(expected, steps[1].Tags) {
+		t.Errorf("Expected tags to be %v but got %v", expected, steps[1].Tags)
+	}
+
+	if err := task.ResolveStepOutputs(steps[3]); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "test 1.0"; steps[3].Cmd != expected {
+		t.Errorf("Expected cmd to be %s but got %s", expected, steps[3].Cmd)
+	}
+	expectedEnvs := []string{
+		"STEPS_VERSION_OUTPUTS_COMMIT=abc",
+		"STEPS_VERSION_OUTPUTS_TAG=1.0",
+		"STEPS_VERSION_OUTPUTS_TAG=override",
+	}
+	if !reflect.DeepEqual(expectedEnvs, steps[3].Envs) {
+		t.Errorf("Expected envs to be %v but got %v", expectedEnvs, steps[3].Envs)
+	}
+
+	// The IDs of expanded matrix steps can contain dashes and dots.
+	matrix := []*Step{
+		{ID: "build-alpine-1.21", Cmd: "build"},
+		{ID: "test", Cmd: "test {{ .Steps.build-alpine-1.21.Outputs.tag }}"},
+	}
+	task, err = NewTask(gocontext.Background(), matrix, nil, "", nil, true, "", "")
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	matrix[0].Outputs = map[string]string{"tag": "1.0"}
+	if err := task.ResolveStepOutputs(matrix[1]); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "test 1.0"; matrix[1].Cmd != expected {
+		t.Errorf("Expected cmd to be %s but got %s", expected, matrix[1].Cmd)
+	}
+
+	invalid := []*Step{
+		{ID: "other", Cmd: "other", When: []string{"-"}},
+		{ID: "a", Cmd: "echo " + OutputReference("other", "tag"), When: []string{"-"}},
+		{ID: "b", Cmd: "echo " + OutputReference("other", "missing"), When: []string{"other"}},
+	}
+	task, err = NewTask(gocontext.Background(), invalid, nil, "", nil, true, "", "")
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	invalid[0].Outputs = map[string]string{"tag": "1.0"}
+	for _, step := range invalid[1:] {
+		if err := task.ResolveStepOutputs(step); err == nil {
+			t.Errorf("Expected resolving step %s's outputs to error but it didn't", step.ID)
+		}
+	}
+}
+
+// TestResolveStepOutputs_Quoted verifies that an output substituted into a command can't add words to it,
+// whether the reference is unquoted or in single or double quotes.
+func TestResolveStepOutputs_Quoted(t *testing.T) {
+	value := `it's "1 0"`
+	ref := OutputReference("version", "tag")
+	tests := []struct {
+		cmd      string
+		expected []string
+	}{
+		{"echo " + ref, []string{"echo", value}},
+		{"echo v" + ref + "-x", []string{"echo", "v" + value + "-x"}},
+		{"bash -c 'echo " + ref + "'", []string{"bash", "-c", "echo " + value}},
+		{`bash -c "echo ` + ref + `"`, []string{"bash", "-c", "echo " + value}},
+	}
+	for _, test := range tests {
+		steps := []*Step{
+			{ID: "version", Cmd: "version"},
+			{ID: "test", Cmd: test.cmd},
+		}
+		task, err := NewTask(gocontext.Background(), steps, nil, "", nil, true, "", "")
+		if err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+		steps[0].Outputs = map[string]string{"tag": value}
+		if err := task.ResolveStepOutputs(steps[1]); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		words, err := util.SplitWords(steps[1].Cmd)
+		if err != nil {
+			t.Fatalf("Failed to split cmd %s: %v", steps[1].Cmd, err)
+		}
+		if !reflect.DeepEqual(test.expected, words) {
+			t.Errorf("Expected cmd %s to be resolved to the words %q but got %q", test.cmd, test.expected, words)
+		}
+	}
+}
+
+func TestDagDependencies(t *testing.T) {
+	steps := []*Step{
+		{ID: "a", Cmd: "a"},
+		{ID: "b", Cmd: "b"},
+		{ID: "c", Cmd: "c", When: []string{"-"}},
+		{ID: "d", Cmd: "d", When: []string{"b", "c"}},
+	}
+	task, err := NewTask(gocontext.Background(), steps, nil, "", nil, true, "", "")
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+
+	// Dependencies must survive edges being removed while the Dag is processed.
+	if err := task.Dag.RemoveEdge("b", "d"); err != nil {
+		t.Fatalf("Failed to remove edge: %v", err)
+	}
+
+	actual := map[string]bool{}
+	for _, dep := range task.Dag.Dependencies("d") {
+		actual[dep.ID] = true
+	}
+	expected := map[string]bool{"a": true, "b": true, "c": true}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected dependencies %v but got %v", expected, actual)
+	}
+	if deps := task.Dag.Dependencies("a"); len(deps) != 0 {
+		t.Errorf("Expected a to have no dependencies but got %d", len(deps))
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Azure/acr-builder/util"
+	"github.com/docker/distribution/reference"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// hostNetwork is the network which shares the host's network stack.
+	hostNetwork = "host"
+
+	// dockerHubDomain is the registry of Docker Hub's images in normalized references.
+	dockerHubDomain = "docker.io"
+)
+
+// Policy restricts what the steps of a Task are allowed to do. It's enforced when the Task is loaded,
+// before any of its steps run.
+type Policy struct {
+	// ForbidPrivileged forbids privileged steps.
+	ForbidPrivileged bool `yaml:"forbidPrivileged"`
+
+	// ForbidDockerSocket forbids steps which have access to the Docker socket, i.e. build steps
+	// and cmd steps which don't specify disableDockerSocket.
+	ForbidDockerSocket bool `yaml:"forbidDockerSocket"`
+
+	// ForbidHostNetwork forbids steps which use the host's network.
+	ForbidHostNetwork bool `yaml:"forbidHostNetwork"`
+
+	// AllowedRegistries are the registries which the images of cmd steps can come from, e.g. mcr.microsoft.com
+	// or *.azurecr.io. Images from Docker Hub are in the docker.io registry. All registries are allowed if it's empty.
+	AllowedRegistries []string `yaml:"allowedRegistries"`
+
+	// BaseImages restricts the base images of build steps. Unlike the other restrictions, it's enforced when
+	// each build step runs, once its Dockerfile has been scanned.
+	BaseImages *BaseImagePolicy `yaml:"baseImages"`
+}
+
+// PolicyViolationError is returned when a Task violates a Policy.
+type PolicyViolationError struct {
+	Violations []string
+}
+
+// Error returns all of the violations.
+func (e *PolicyViolationError) Error() string {
+	return "task violates the security policy: " + strings.Join(e.Violations, "; ")
+}
+
+// LoadPolicy loads a Policy from a YAML file. Unknown properties are rejected, so a misspelled
+// restriction isn't silently ignored.
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read policy file %s", file)
+	}
+	p := &Policy{}
+	if err := yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse policy file %s", file)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid policy file %s", file)
+	}
+	return p, nil
+}
+
+// Validate validates the Policy and returns an error if it has problems.
+func (p *Policy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	return errors.Wrap(p.BaseImages.Validate(), "invalid baseImages")
+}
+
+// Enforce returns a *PolicyViolationError describing every way in which the Task violates the Policy,
+// or nil if it doesn't.
+func (p *Policy) Enforce(t *Task) error {
+	if p == nil {
+		return nil
+	}
+	var violations []string
+	for _, s := range t.Steps {
+		if p.ForbidPrivileged && s.Privileged {
+			violations = append(violations, fmt.Sprintf("step ID: %s is privileged", s.ID))
+		}
+		if p.ForbidDockerSocket && s.MountsDockerSocket() {
+			violations = append(violations, fmt.Sprintf("step ID: %s has access to the Docker socket", s.ID))
+		}
+		if p.ForbidHostNetwork && s.Network == hostNetwork {
+			violations = append(violations, fmt.Sprintf("step ID: %s uses the host network", s.ID))
+		}
+		if words, _ := util.SplitWords(s.Cmd); len(p.AllowedRegistries) > 0 && len(words) > 0 {
+			img := words[0]
+			registry, err := imageRegistry(img)
+			if err != nil {
+				violations = append(violations, fmt.Sprintf("step ID: %s has an invalid image %s: %v", s.ID, img, err))
+			} else if !matchesAny(p.AllowedRegistries, registry) {
+				violations = append(violations, fmt.Sprintf("step ID: %s uses image %s from registry %s, which isn't allowed", s.ID, img, registry))
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return &PolicyViolationError{Violations: violations}
+	}
+	return nil
+}
+
+// matchesAny returns true if the value matches one of the patterns, ignoring case. A pattern starting with * matches
+// values with the rest of the pattern as a suffix, e.g. *.azurecr.io, and a pattern ending with * matches values with
+// the rest of the pattern as a prefix, e.g. mcr.microsoft.com/dotnet/*. Other patterns must equal the value.
+func matchesAny(patterns []string, value string) bool {
+	value = strings.ToLower(value)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		switch {
+		case strings.HasPrefix(pattern, "*"):
+			if strings.HasSuffix(value, pattern[1:]) {
+				return true
+			}
+		case strings.HasSuffix(pattern, "*"):
+			if strings.HasPrefix(value, pattern[:len(pattern)-1]) {
+				return true
+			}
+		case pattern == value:
+			return true
+		}
+	}
+	return false
+}
+
+// imageRegistry returns the registry of an image, e.g. docker.io for ubuntu.
+func imageRegistry(img string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(img)
+	if err != nil {
+		return "", err
+	}
+	return reference.Domain(named), nil
+}
@@ -0,0 +1,138 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+const policyTestTask = `
+steps:
+  - id: build
+    build: -t foo .
+  - id: test
+    cmd: golang go test
+    privileged: true
+  - id: scan
+    cmd: myregistry.azurecr.io/scanner
+    disableDockerSocket: true
+    network: host
+  - id: lint
+    cmd: mcr.microsoft.com/golangci/lint
+    disableDockerSocket: true
+    disableHomeVolume: true
+`
+
+func TestPolicy_Enforce(t *testing.T) {
+	tests := []struct {
+		policy             *Policy
+		expectedViolations []string
+	}{
+		{nil, nil},
+		{&Policy{}, nil},
+		{&Policy{ForbidPrivileged: true}, []string{"step ID: test is privileged"}},
+		{&Policy{ForbidDockerSocket: true}, []string{
+			"step ID: build has access to the Docker socket",
+			"step ID: test has access to the Docker socket",
+		}},
+		{&Policy{ForbidHostNetwork: true}, []string{"step ID: scan uses the host network"}},
+		{&Policy{AllowedRegistries: []string{"*.azurecr.io", "MCR.microsoft.com"}}, []string{
+			"step ID: test uses image golang from registry docker.io, which isn't allowed",
+		}},
+		{&Policy{AllowedRegistries: []string{"docker.io", "mcr.microsoft.com", "myregistry.azurecr.io"}}, nil},
+	}
+	for _, test := range tests {
+		_, err := UnmarshalTaskFromString(context.Background(), policyTestTask, &TaskOptions{Policy: test.policy})
+		var violationErr *PolicyViolationError
+		if test.expectedViolations == nil {
+			if err != nil {
+				t.Errorf("expected policy %+v to be satisfied but got %v", test.policy, err)
+			}
+			continue
+		}
+		if !errors.As(err, &violationErr) {
+			t.Errorf("expected policy %+v to be violated but got %v", test.policy, err)
+			continue
+		}
+		if !reflect.DeepEqual(violationErr.Violations, test.expectedViolations) {
+			t.Errorf("expected violations %v but got %v", test.expectedViolations, violationErr.Violations)
+		}
+	}
+}
+
+func TestPolicy_Task(t *testing.T) {
+	task := `
+policy:
+  forbidPrivileged: true
+steps:
+  - cmd: alpine echo hello
+    privileged: true
+`
+	_, err := UnmarshalTaskFromString(context.Background(), task, &TaskOptions{})
+	var violationErr *PolicyViolationError
+	if !errors.As(err, &violationErr) {
+		t.Errorf("expected the task's own policy to be enforced but got %v", err)
+	}
+}
+
+func TestTask_BaseImagePolicies(t *testing.T) {
+	task := `
+policy:
+  baseImages:
+    forbiddenTags: [latest]
+steps:
+  - build: -t foo .
+`
+	external := &Policy{BaseImages: &BaseImagePolicy{RequireDigest: true}}
+	tk, err := UnmarshalTaskFromString(context.Background(), task, &TaskOptions{Policy: external})
+	if err != nil {
+		t.Fatalf("failed to load task: %v", err)
+	}
+	expected := []*BaseImagePolicy{{ForbiddenTags: []string{"latest"}}, external.BaseImages}
+	if policies := tk.BaseImagePolicies(); !reflect.DeepEqual(policies, expected) {
+		t.Errorf("expected base image policies %+v but got %+v", expected, policies)
+	}
+
+	invalid := "policy:\n  baseImages:\n    maxAge: 90 days\nsteps:\n  - cmd: alpine echo hello\n"
+	if _, err := UnmarshalTaskFromString(context.Background(), invalid, &TaskOptions{}); err == nil {
+		t.Error("expected a task with an invalid base image policy to fail to load")
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.yaml")
+	if err := os.WriteFile(valid, []byte("forbidPrivileged: true\nallowedRegistries:\n  - mcr.microsoft.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := LoadPolicy(valid)
+	if err != nil {
+		t.Fatalf("failed to load policy: %v", err)
+	}
+	expected := &Policy{ForbidPrivileged: true, AllowedRegistries: []string{"mcr.microsoft.com"}}
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("expected policy %+v but got %+v", expected, p)
+	}
+
+	misspelled := filepath.Join(dir, "misspelled.yaml")
+	if err := os.WriteFile(misspelled, []byte("forbidPriviledged: true\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPolicy(misspelled); err == nil {
+		t.Error("expected a policy with an unknown property to fail to load")
+	}
+}
+
+func TestValidate_DisableDockerSocket(t *testing.T) {
+	step := &Step{ID: "build", Build: "-t foo .", DisableDockerSocket: true}
+	if err := step.Validate(); err != errBuildRequiresDockerSocket {
+		t.Errorf("expected %v but got %v", errBuildRequiresDockerSocket, err)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"fmt"
+	"log"
+)
+
+// Dependents returns the steps which depend on the specified step, directly or transitively.
+func (d *Dag) Dependents(id string) []*Step {
+	d.mu.Lock()
+	node, ok := d.Nodes[id]
+	d.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	var dependents []*Step
+	visited := map[string]bool{}
+	queue := []*Node{node}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, child := range sortedNodes(n.Children()) {
+			if visited[child.Name] {
+				continue
+			}
+			visited[child.Name] = true
+			dependents = append(dependents, child.Value)
+			queue = append(queue, child)
+		}
+	}
+	return dependents
+}
+
+// Prune removes all nodes which aren't in keep from the Dag. Kept nodes whose dependencies
+// were all removed are attached to the root so that they run immediately.
+// The nodes' dependencies, as returned by Dependencies, are left intact.
+func (d *Dag) Prune(keep map[string]bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for name, n := range d.Nodes {
+		if keep[name] {
+			continue
+		}
+		delete(d.Nodes, name)
+		for _, child := range n.Children() {
+			child.mu.Lock()
+			child.degree--
+			child.mu.Unlock()
+		}
+	}
+
+	for _, n := range append([]*Node{d.Root}, mapValues(d.Nodes)...) {
+		n.mu.Lock()
+		for name := range n.children {
+			if !keep[name] {
+				delete(n.children, name)
+			}
+		}
+		n.mu.Unlock()
+	}
+
+	for name, n := range d.Nodes {
+		n.mu.Lock()
+		if n.degree == 0 {
+			n.degree++
+			d.Root.mu.Lock()
+			d.Root.children[name] = n
+			d.Root.mu.Unlock()
+		}
+		n.mu.Unlock()
+	}
+}
+
+// Prune restricts the Task's run to the specified steps. The steps they depend on are marked as
+// successful without being run, and all other steps are skipped.
+func (t *Task) Prune(ids []string) error {
+	keep, err := t.stepSet(ids)
+	if err != nil {
+		return err
+	}
+
+	completed := map[string]bool{}
+	for id := range keep {
+		for _, dep := range t.Dag.Dependencies(id) {
+			if !keep[dep.ID] {
+				completed[dep.ID] = true
+			}
+		}
+	}
+	t.prune(keep, completed)
+	return nil
+}
+
+// ResumeFrom restricts the Task's run to the specified step and the steps which depend on it.
+// The steps it depends on are marked as successful without being run. Any other steps which the
+// steps that depend on it need are run again, since the failed run may not have reached them.
+func (t *Task) ResumeFrom(id string) error {
+	if _, err := t.stepSet([]string{id}); err != nil {
+		return err
+	}
+
+	completed := map[string]bool{}
+	for _, dep := range t.Dag.Dependencies(id) {
+		completed[dep.ID] = true
+	}
+	keep := map[string]bool{id: true}
+	for _, step := range t.Dag.Dependents(id) {
+		keep[step.ID] = true
+		for _, dep := range t.Dag.Dependencies(step.ID) {
+			if !completed[dep.ID] {
+				keep[dep.ID] = true
+			}
+		}
+	}
+	t.prune(keep, completed)
+	return nil
+}
+
+// stepSet returns the set of the specified step IDs, or an error if one of them doesn't exist.
+func (t *Task) stepSet(ids []string) (map[string]bool, error) {
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if _, ok := t.Dag.Nodes[id]; !ok {
+			return nil, fmt.Errorf("step ID: %s does not exist", id)
+		}
+		set[id] = true
+	}
+	return set, nil
+}
+
+// prune marks the completed steps as successful, skips the steps which are neither kept nor completed
+// and removes all but the kept steps from the Dag.
+func (t *Task) prune(keep map[string]bool, completed map[string]bool) {
+	for _, step := range t.Steps {
+		switch {
+		case keep[step.ID]:
+			continue
+		case completed[step.ID]:
+			log.Printf("Step ID: %s is a dependency of the selected steps, assuming it has already completed\n", step.ID)
+			step.StepStatus = Successful
+		default:
+			step.StepStatus = Skipped
+			step.SkipReason = "it was not selected to run"
+		}
+	}
+
+	t.Dag.Prune(keep)
+}
+
+func mapValues(nodes map[string]*Node) []*Node {
+	values := make([]*Node, 0, len(nodes))
+	for _, n := range nodes {
+		values = append(values, n)
+	}
+	return values
+}
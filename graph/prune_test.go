@@ -0,0 +1,105 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	gocontext "context"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func newPruneTestTask(t *testing.T) *Task {
+	steps := []*Step{
+		{ID: "a", Cmd: "a"},
+		{ID: "b", Cmd: "b", When: []string{"a"}},
+		{ID: "c", Cmd: "c", When: []string{"-"}},
+		{ID: "d", Cmd: "d", When: []string{"b", "c"}},
+		{ID: "e", Cmd: "e", When: []string{"d"}},
+		{ID: "f", Cmd: "f", When: []string{"-"}},
+	}
+	task, err := NewTask(gocontext.Background(), steps, nil, "", nil, true, "", "")
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	return task
+}
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		resumeFrom       string
+		only             []string
+		expectedStatuses map[string]StepStatus
+		expectedLevels   [][]string
+	}{
+		{
+			resumeFrom: "b",
+			expectedStatuses: map[string]StepStatus{
+				"a": Successful, "b": Skipped, "c": Skipped, "d": Skipped, "e": Skipped, "f": Skipped,
+			},
+			// c isn't a dependency of b, so the failed run may not have reached it.
+			expectedLevels: [][]string{{"b", "c"}, {"d"}, {"e"}},
+		},
+		{
+			resumeFrom: "d",
+			expectedStatuses: map[string]StepStatus{
+				"a": Successful, "b": Successful, "c": Successful, "d": Skipped, "e": Skipped, "f": Skipped,
+			},
+			expectedLevels: [][]string{{"d"}, {"e"}},
+		},
+		{
+			only: []string{"d", "f"},
+			expectedStatuses: map[string]StepStatus{
+				"a": Successful, "b": Successful, "c": Successful, "d": Skipped, "e": Skipped, "f": Skipped,
+			},
+			expectedLevels: [][]string{{"d", "f"}},
+		},
+	}
+
+	for _, test := range tests {
+		task := newPruneTestTask(t)
+		var err error
+		if test.resumeFrom != "" {
+			err = task.ResumeFrom(test.resumeFrom)
+		} else {
+			err = task.Prune(test.only)
+		}
+		if err != nil {
+			t.Fatalf("Unexpected err: %v", err)
+		}
+
+		// Selected steps keep their initial status until they run.
+		for _, step := range task.Steps {
+			if step.StepStatus != test.expectedStatuses[step.ID] {
+				t.Errorf("Expected step %s to be %s but got %s", step.ID, test.expectedStatuses[step.ID], step.StepStatus)
+			}
+		}
+
+		var levels [][]string
+		for _, level := range task.Levels() {
+			var ids []string
+			for _, step := range level {
+				ids = append(ids, step.ID)
+			}
+			sort.Strings(ids)
+			levels = append(levels, ids)
+		}
+		if !reflect.DeepEqual(test.expectedLevels, levels) {
+			t.Errorf("Expected levels %v but got %v", test.expectedLevels, levels)
+		}
+		if warnings := task.Dag.unreachable(); len(warnings) > 0 {
+			t.Errorf("Expected all remaining steps to be reachable but got %v", warnings)
+		}
+	}
+}
+
+func TestPrune_InvalidID(t *testing.T) {
+	task := newPruneTestTask(t)
+	if err := task.Prune([]string{"missing"}); err == nil {
+		t.Error("Expected an error for a step ID which doesn't exist")
+	}
+	if err := task.ResumeFrom("missing"); err == nil {
+		t.Error("Expected an error for a step ID which doesn't exist")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/acr-builder/util"
+	"github.com/pkg/errors"
+)
+
+const (
+	// The default time between readiness probes is 2 seconds.
+	defaultReadinessIntervalInSeconds = 2
+
+	// The default time a readiness probe is given to succeed is 5 seconds.
+	defaultReadinessTimeoutInSeconds = 5
+
+	// By default, a step is probed 30 times before it's considered to have failed to become ready.
+	defaultReadinessRetries = 30
+)
+
+var (
+	errReadinessNotDetached  = errors.New("readiness can only be specified for detached steps")
+	errInvalidReadinessProbe = errors.New("readiness must specify exactly one of tcp, http or exec")
+)
+
+// Readiness describes how to determine that a detached step is ready, e.g. that the database it runs accepts
+// connections. The steps which depend on a detached step with Readiness don't run until it's ready.
+type Readiness struct {
+	// TCP is a port of the step's container which accepts connections once it's ready.
+	TCP int `yaml:"tcp"`
+
+	// HTTP is an endpoint of the step's container which responds with a 2xx or 3xx status once it's ready.
+	HTTP *HTTPProbe `yaml:"http"`
+
+	// Exec is a command run in the step's container which exits with 0 once it's ready.
+	Exec []string `yaml:"exec"`
+
+	// Interval is the number of seconds between probes.
+	Interval int `yaml:"interval"`
+
+	// Timeout is the number of seconds a probe is given to succeed.
+	Timeout int `yaml:"timeout"`
+
+	// Retries is the number of failed probes after which the step fails.
+	Retries int `yaml:"retries"`
+}
+
+// HTTPProbe is an HTTP GET request made to a step's container.
+type HTTPProbe struct {
+	Port int    `yaml:"port"`
+	Path string `yaml:"path"`
+}
+
+// Validate validates the Readiness of the specified step.
+func (r *Readiness) Validate(s *Step) error {
+	if r == nil {
+		return nil
+	}
+	if !s.Detach {
+		return errReadinessNotDetached
+	}
+	probes := 0
+	if r.TCP != 0 {
+		probes++
+		if err := validatePort(r.TCP); err != nil {
+			return errors.Wrap(err, "invalid tcp readiness probe")
+		}
+	}
+	if r.HTTP != nil {
+		probes++
+		if err := validatePort(r.HTTP.Port); err != nil {
+			return errors.Wrap(err, "invalid http readiness probe")
+		}
+		if r.HTTP.Path != "" && !strings.HasPrefix(r.HTTP.Path, "/") {
+			return fmt.Errorf("invalid http readiness probe: path %s must start with /", r.HTTP.Path)
+		}
+	}
+	if len(r.Exec) > 0 {
+		probes++
+	}
+	if probes != 1 {
+		return errInvalidReadinessProbe
+	}
+	if r.Interval < 0 || r.Timeout < 0 || r.Retries < 0 {
+		return errors.New("readiness interval, timeout and retries must be >= 0")
+	}
+	return nil
+}
+
+// setDefaults stamps the default interval, timeout and retries on the Readiness if they're unspecified.
+func (r *Readiness) setDefaults() {
+	if r.Interval <= 0 {
+		r.Interval = defaultReadinessIntervalInSeconds
+	}
+	if r.Timeout <= 0 {
+		r.Timeout = defaultReadinessTimeoutInSeconds
+	}
+	if r.Retries <= 0 {
+		r.Retries = defaultReadinessRetries
+	}
+}
+
+// IntervalDuration returns the time between probes.
+func (r *Readiness) IntervalDuration() time.Duration {
+	return time.Duration(r.Interval) * time.Second
+}
+
+// TimeoutDuration returns the time a probe is given to succeed.
+func (r *Readiness) TimeoutDuration() time.Duration {
+	return time.Duration(r.Timeout) * time.Second
+}
+
+// Equals determines whether or not two Readiness probes are equal.
+func (r *Readiness) Equals(t *Readiness) bool {
+	if r == nil || t == nil {
+		return r == t
+	}
+	if (r.HTTP == nil) != (t.HTTP == nil) || (r.HTTP != nil && *r.HTTP != *t.HTTP) {
+		return false
+	}
+	return r.TCP == t.TCP &&
+		util.StringSequenceEquals(r.Exec, t.Exec) &&
+		r.Interval == t.Interval &&
+		r.Timeout == t.Timeout &&
+		r.Retries == t.Retries
+}
+
+func validatePort(port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("port %d must be between 1 and 65535", port)
+	}
+	return nil
+}
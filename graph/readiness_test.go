@@ -0,0 +1,71 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReadiness_Validate(t *testing.T) {
+	tests := []struct {
+		readiness   *Readiness
+		detach      bool
+		shouldError bool
+	}{
+		{nil, false, false},
+		{&Readiness{TCP: 5432}, true, false},
+		{&Readiness{HTTP: &HTTPProbe{Port: 8080, Path: "/healthz"}}, true, false},
+		{&Readiness{HTTP: &HTTPProbe{Port: 8080}}, true, false},
+		{&Readiness{Exec: []string{"pg_isready"}, Interval: 1, Timeout: 2, Retries: 3}, true, false},
+		{&Readiness{TCP: 5432}, false, true},
+		{&Readiness{}, true, true},
+		{&Readiness{TCP: 5432, Exec: []string{"pg_isready"}}, true, true},
+		{&Readiness{TCP: 70000}, true, true},
+		{&Readiness{HTTP: &HTTPProbe{Path: "/healthz"}}, true, true},
+		{&Readiness{HTTP: &HTTPProbe{Port: 8080, Path: "healthz"}}, true, true},
+		{&Readiness{TCP: 5432, Retries: -1}, true, true},
+	}
+	for _, test := range tests {
+		step := &Step{ID: "db", Cmd: "postgres", Detach: test.detach, Readiness: test.readiness}
+		err := step.Validate()
+		if test.shouldError && err == nil {
+			t.Errorf("expected readiness %+v to be invalid", test.readiness)
+		}
+		if !test.shouldError && err != nil {
+			t.Errorf("expected readiness %+v to be valid but got %v", test.readiness, err)
+		}
+	}
+}
+
+func TestReadiness_Defaults(t *testing.T) {
+	task, err := UnmarshalTaskFromString(context.Background(), `
+steps:
+  - id: db
+    cmd: postgres
+    detach: true
+    readiness:
+      tcp: 5432
+  - id: cache
+    cmd: redis
+    detach: true
+    readiness:
+      exec: ["redis-cli", "ping"]
+      interval: 1
+      timeout: 3
+      retries: 10
+`, &TaskOptions{})
+	if err != nil {
+		t.Fatalf("failed to load task: %v", err)
+	}
+	expected := []Readiness{
+		{TCP: 5432, Interval: defaultReadinessIntervalInSeconds, Timeout: defaultReadinessTimeoutInSeconds, Retries: defaultReadinessRetries},
+		{Exec: []string{"redis-cli", "ping"}, Interval: 1, Timeout: 3, Retries: 10},
+	}
+	for i, step := range task.Steps {
+		if !step.Readiness.Equals(&expected[i]) {
+			t.Errorf("expected readiness %+v but got %+v", expected[i], step.Readiness)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"fmt"
+
+	"github.com/Azure/acr-builder/secretmgmt"
+	"github.com/Azure/acr-builder/util"
+	"github.com/pkg/errors"
+)
+
+var (
+	errSignMissingImages        = errors.New("sign must specify at least one image")
+	errInvalidSigningKey        = errors.New("sign must specify exactly one of key or keySecret")
+	errInvalidVerificationKey   = errors.New("verifySignature must specify exactly one of key or keySecret")
+	errVerifySignatureNotForUse = errors.New("verifySignature can only be used for cmd or build steps")
+)
+
+// Sign describes the images which a sign step signs and the private key which signs them.
+// The images' manifest digests are signed in cosign's format, and the signatures are pushed to the images' repositories.
+type Sign struct {
+	// Images are the pushed images to sign. Tags are resolved to the digests of the images' manifests.
+	Images []string `yaml:"images"`
+
+	// Key is the path of a PEM encoded private key.
+	Key string `yaml:"key"`
+
+	// KeySecret is the ID of the Task's secret whose value is a PEM encoded private key.
+	KeySecret string `yaml:"keySecret"`
+}
+
+// Validate validates the Sign of a step.
+func (s *Sign) Validate() error {
+	if s == nil {
+		return nil
+	}
+	if len(s.Images) == 0 {
+		return errSignMissingImages
+	}
+	if (s.Key == "") == (s.KeySecret == "") {
+		return errInvalidSigningKey
+	}
+	return nil
+}
+
+// Equals determines whether or not two Signs are equal.
+func (s *Sign) Equals(t *Sign) bool {
+	if s == nil || t == nil {
+		return s == t
+	}
+	return util.StringSequenceEquals(s.Images, t.Images) &&
+		s.Key == t.Key &&
+		s.KeySecret == t.KeySecret
+}
+
+// VerifySignature describes the public key which must have signed the images a step uses before it uses them,
+// i.e. a cmd step's image or a build step's base images.
+type VerifySignature struct {
+	// Key is the path of a PEM encoded public key, or the PEM encoded public key itself.
+	Key string `yaml:"key"`
+
+	// KeySecret is the ID of the Task's secret whose value is a PEM encoded public key.
+	KeySecret string `yaml:"keySecret"`
+}
+
+// Validate validates the VerifySignature of the specified step.
+func (v *VerifySignature) Validate(s *Step) error {
+	if v == nil {
+		return nil
+	}
+	if !s.IsCmdStep() && !s.IsBuildStep() {
+		return errVerifySignatureNotForUse
+	}
+	if (v.Key == "") == (v.KeySecret == "") {
+		return errInvalidVerificationKey
+	}
+	return nil
+}
+
+// Equals determines whether or not two VerifySignatures are equal.
+func (v *VerifySignature) Equals(t *VerifySignature) bool {
+	if v == nil || t == nil {
+		return v == t
+	}
+	return *v == *t
+}
+
+// validateKeySecrets returns an error if the step's signing or verification key is a secret which isn't defined.
+func (s *Step) validateKeySecrets(secrets []*secretmgmt.Secret) error {
+	var ids []string
+	if s.Sign != nil && s.Sign.KeySecret != "" {
+		ids = append(ids, s.Sign.KeySecret)
+	}
+	if s.VerifySignature != nil && s.VerifySignature.KeySecret != "" {
+		ids = append(ids, s.VerifySignature.KeySecret)
+	}
+	for _, id := range ids {
+		found := false
+		for _, secret := range secrets {
+			if secret.ID == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("step ID: %s uses the key secret %s, which isn't defined", s.ID, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestSign_Validate(t *testing.T) {
+	tests := []struct {
+		step        *Step
+		shouldError bool
+	}{
+		{&Step{ID: "sign", Sign: &Sign{Images: []string{"foo.azurecr.io/app:v1"}, Key: "key.pem"}}, false},
+		{&Step{ID: "sign", Sign: &Sign{Images: []string{"foo.azurecr.io/app:v1"}, KeySecret: "key"}}, false},
+		{&Step{ID: "sign", Sign: &Sign{Key: "key.pem"}}, true},
+		{&Step{ID: "sign", Sign: &Sign{Images: []string{"foo.azurecr.io/app:v1"}}}, true},
+		{&Step{ID: "sign", Sign: &Sign{Images: []string{"foo.azurecr.io/app:v1"}, Key: "key.pem", KeySecret: "key"}}, true},
+		{&Step{ID: "sign", Cmd: "bash", Sign: &Sign{Images: []string{"foo.azurecr.io/app:v1"}, Key: "key.pem"}}, true},
+		{&Step{ID: "sign", Push: []string{"foo.azurecr.io/app:v1"}, Sign: &Sign{Images: []string{"foo.azurecr.io/app:v1"}, Key: "key.pem"}}, true},
+	}
+	for _, test := range tests {
+		if err := test.step.Validate(); (err != nil) != test.shouldError {
+			t.Errorf("expected error to be %v for sign %+v but got %v", test.shouldError, test.step.Sign, err)
+		}
+	}
+}
+
+func TestVerifySignature_Validate(t *testing.T) {
+	tests := []struct {
+		step        *Step
+		shouldError bool
+	}{
+		{&Step{ID: "run", Cmd: "foo.azurecr.io/app:v1", VerifySignature: &VerifySignature{Key: "key.pub"}}, false},
+		{&Step{ID: "build", Build: "-t app .", VerifySignature: &VerifySignature{KeySecret: "key"}}, false},
+		{&Step{ID: "push", Push: []string{"app"}, VerifySignature: &VerifySignature{Key: "key.pub"}}, true},
+		{&Step{ID: "run", Cmd: "app", VerifySignature: &VerifySignature{}}, true},
+		{&Step{ID: "run", Cmd: "app", VerifySignature: &VerifySignature{Key: "key.pub", KeySecret: "key"}}, true},
+	}
+	for _, test := range tests {
+		if err := test.step.Validate(); (err != nil) != test.shouldError {
+			t.Errorf("expected error to be %v for step %s with verifySignature %+v but got %v", test.shouldError, test.step.ID, test.step.VerifySignature, err)
+		}
+	}
+}
+
+func TestSign_Task(t *testing.T) {
+	task, err := UnmarshalTaskFromString(context.Background(), `
+secrets:
+  - id: signingKey
+    keyvault: https://myvault.vault.azure.net/secrets/signing-key
+steps:
+  - id: sign
+    sign:
+      images: ["foo.azurecr.io/app", "foo.azurecr.io/app:v1"]
+      keySecret: signingKey
+  - id: run
+    cmd: foo.azurecr.io/app:v1
+    verifySignature:
+      key: key.pub
+`, &TaskOptions{})
+	if err != nil {
+		t.Fatalf("failed to load task: %v", err)
+	}
+	expected := []string{"foo.azurecr.io/app:latest", "foo.azurecr.io/app:v1"}
+	if !reflect.DeepEqual(task.Steps[0].Sign.Images, expected) {
+		t.Errorf("expected the images to sign to be %v but got %v", expected, task.Steps[0].Sign.Images)
+	}
+	if !task.Steps[0].IsSignStep() || task.Steps[1].IsSignStep() {
+		t.Errorf("expected only step ID: sign to be a sign step")
+	}
+
+	_, err = UnmarshalTaskFromString(context.Background(), `
+steps:
+  - id: sign
+    sign:
+      images: ["foo.azurecr.io/app:v1"]
+      keySecret: missing
+`, &TaskOptions{})
+	if err == nil {
+		t.Errorf("expected a key secret which isn't defined to fail")
+	}
+}
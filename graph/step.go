@@ -26,14 +26,18 @@ const (
 
 var (
 	errMissingID         = errors.New("step is missing an ID")
-	errMissingProps      = errors.New("step is missing a cmd, build, or push property")
+	errMissingProps      = errors.New("step is missing a cmd, build, push, or sign property")
 	errIDContainsSpace   = errors.New("step ID cannot contain spaces")
 	errInvalidDeps       = errors.New("step cannot contain other IDs in when if the immediate execution token is specified")
-	errInvalidStepType   = errors.New("step must only contain a single build, cmd, or push property")
+	errInvalidStepType   = errors.New("step must only contain a single build, cmd, push, or sign property")
 	errInvalidRetries    = errors.New("step must specify retries >= 0")
 	errInvalidRepeat     = errors.New("step must specify repeat >= 0")
 	errInvalidCacheValue = errors.New("invalid value for cache property. Valid values are 'enabled', 'disabled'")
 	errInvalidMountsUse  = errors.New("invalid use of Mounts. Mounts must have unique container paths and only used for cmd or build steps")
+
+	errBuildRequiresDockerSocket = errors.New("build steps require the Docker socket, disableDockerSocket can only be used for cmd steps")
+	errSBOMRequiresBuild         = errors.New("sbom can only be used for build steps")
+	errExitedWithAndWithout      = errors.New("step can't specify both exitedWith and exitedWithout")
 )
 
 type chanBool chan bool
@@ -64,13 +68,21 @@ type Step struct {
 	Cache            string          `yaml:"cache"`
 	Mounts           []*volume.Mount `yaml:"volumeMounts"`
 	Push             []string        `yaml:"push"`
+	Sign             *Sign           `yaml:"sign"`
 	Envs             []string        `yaml:"env"`
 	Expose           []string        `yaml:"expose"`
 	Ports            []string        `yaml:"ports"`
 	When             []string        `yaml:"when"`
+	If               string          `yaml:"if"`
+	Matrix           Matrix          `yaml:"matrix"`
 	ExitedWith       []int           `yaml:"exitedWith"`
 	ExitedWithout    []int           `yaml:"exitedWithout"`
 	Timeout          int             `yaml:"timeout"`
+	// Readiness determines when a detached Step is ready, so the Steps which depend on it can run.
+	Readiness *Readiness `yaml:"readiness"`
+	// StopTimeout is how many seconds a Step's container is given to exit after SIGTERM before it's killed,
+	// when the Step times out or the run is cancelled.
+	StopTimeout int `yaml:"stopTimeout"`
 	// CmdDownloadRetries specifies how many times a download in a step will be retried
 	CmdDownloadRetries             int `yaml:"cmdDownloadRetries"`
 	CmdDownloadRetryDelayInSeconds int `yaml:"cmdDownloadRetryDelay"`
@@ -87,6 +99,38 @@ type Step struct {
 	IgnoreErrors                    bool `yaml:"ignoreErrors"`
 	DisableWorkingDirectoryOverride bool `yaml:"disableWorkingDirectoryOverride"`
 	Pull                            bool `yaml:"pull"`
+	// SBOM generates an SBOM for each of a build Step's images when it's pushed, and attaches it to the image.
+	SBOM bool `yaml:"sbom"`
+	// VerifySignature verifies the signatures of the images a cmd or build Step uses before it uses them.
+	VerifySignature *VerifySignature `yaml:"verifySignature"`
+	// DisableDockerSocket and DisableHomeVolume stop the Docker socket and the home volume from being mounted
+	// into the Step's container.
+	DisableDockerSocket bool `yaml:"disableDockerSocket"`
+	DisableHomeVolume   bool `yaml:"disableHomeVolume"`
+
+	// Resource limits and security options of the Step's container.
+	// Memory, MemorySwap and ShmSize are sizes such as 512m or 2g. MemorySwap is the total of memory and swap,
+	// or -1 for unlimited swap.
+	Memory     string `yaml:"memory"`
+	MemorySwap string `yaml:"memorySwap"`
+	ShmSize    string `yaml:"shmSize"`
+	// PidsLimit is the maximum number of processes in the container, or -1 for unlimited.
+	PidsLimit int64 `yaml:"pidsLimit"`
+	// Ulimits are in the form of name=soft[:hard], e.g. nofile=1024:2048.
+	Ulimits     []string `yaml:"ulimits"`
+	CapAdd      []string `yaml:"capAdd"`
+	CapDrop     []string `yaml:"capDrop"`
+	SecurityOpt []string `yaml:"securityOpt"`
+	// Tmpfs are mounts in the form of path[:options].
+	Tmpfs []string `yaml:"tmpfs"`
+	DNS   []string `yaml:"dns"`
+	// ExtraHosts are in the form of host:ip.
+	ExtraHosts []string `yaml:"extraHosts"`
+	// Labels are in the form of key=value.
+	Labels []string `yaml:"labels"`
+	// Devices are in the form of host path[:container path][:permissions].
+	Devices                []string `yaml:"devices"`
+	ReadOnlyRootFilesystem bool     `yaml:"readOnlyRootFilesystem"`
 
 	UsesBuildkit bool
 
@@ -94,6 +138,27 @@ type Step struct {
 	EndTime    time.Time
 	StepStatus StepStatus
 
+	// ExitCode is the exit code of the step's last container execution.
+	ExitCode int
+
+	// RetryCount is the number of times the step's container was retried.
+	RetryCount int
+
+	// ContainerName is the name of the container that last executed the step.
+	ContainerName string
+
+	// PushedTags are the image tags which were pushed successfully by the step.
+	PushedTags []string
+
+	// PushedDigests are the manifest digests of the images which were pushed successfully by the step, by tag.
+	PushedDigests map[string]string
+
+	// SkipReason describes why the step was skipped, if it was.
+	SkipReason string
+
+	// Outputs are the key value pairs which the step wrote to its output file.
+	Outputs map[string]string
+
 	// CompletedChan can be used to signal to readers
 	// that the step has been processed.
 	CompletedChan chanBool
@@ -118,15 +183,45 @@ func (s *Step) Validate() error {
 	if s.Repeat < 0 {
 		return errInvalidRepeat
 	}
-	if (s.IsCmdStep() && s.IsPushStep()) || (s.IsCmdStep() && s.IsBuildStep()) || (s.IsBuildStep() && s.IsPushStep()) {
+	stepTypes := 0
+	for _, isType := range []bool{s.IsCmdStep(), s.IsBuildStep(), s.IsPushStep(), s.IsSignStep()} {
+		if isType {
+			stepTypes++
+		}
+	}
+	if stepTypes > 1 {
 		return errInvalidStepType
 	}
 	if util.ContainsSpace(s.ID) {
 		return errIDContainsSpace
 	}
-	if !s.IsCmdStep() && !s.IsBuildStep() && !s.IsPushStep() {
+	if stepTypes == 0 {
 		return errMissingProps
 	}
+	if len(s.ExitedWith) > 0 && len(s.ExitedWithout) > 0 {
+		return errExitedWithAndWithout
+	}
+	// The command is split into the container's arguments when the step runs.
+	for _, cmd := range []string{s.Cmd, s.Build} {
+		if _, err := util.SplitWords(cmd); err != nil {
+			return errors.Wrapf(err, "step ID: %s has an invalid command", s.ID)
+		}
+	}
+	if s.IsBuildStep() && s.DisableDockerSocket {
+		return errBuildRequiresDockerSocket
+	}
+	if s.SBOM && !s.IsBuildStep() {
+		return errSBOMRequiresBuild
+	}
+	if err := s.Sign.Validate(); err != nil {
+		return errors.Wrapf(err, "step ID: %s has an invalid sign", s.ID)
+	}
+	if err := s.VerifySignature.Validate(s); err != nil {
+		return errors.Wrapf(err, "step ID: %s has an invalid verifySignature", s.ID)
+	}
+	if err := s.validateContainerOptions(); err != nil {
+		return errors.Wrapf(err, "step ID: %s has invalid container options", s.ID)
+	}
 	if s.HasMounts() {
 		if !s.IsCmdStep() && !s.IsBuildStep() {
 			return errInvalidMountsUse
@@ -149,6 +244,16 @@ func (s *Step) Validate() error {
 		return errInvalidCacheValue
 	}
 
+	if err := s.Readiness.Validate(s); err != nil {
+		return errors.Wrapf(err, "step ID: %s has an invalid readiness probe", s.ID)
+	}
+
+	if s.If != "" {
+		if _, err := ParseCondition(s.If); err != nil {
+			return errors.Wrapf(err, "step ID: %s has an invalid if condition", s.ID)
+		}
+	}
+
 	// check if the build step contains buildkit ENV var
 	if s.IsBuildStep() {
 		s.UsesBuildkit = invokesBuildkit(s.Envs)
@@ -204,13 +309,18 @@ func (s *Step) Equals(t *Step) bool {
 		s.Cmd == t.Cmd &&
 		s.Build == t.Build &&
 		util.StringSequenceEquals(s.Push, t.Push) &&
+		s.Sign.Equals(t.Sign) &&
+		s.VerifySignature.Equals(t.VerifySignature) &&
 		s.WorkingDirectory == t.WorkingDirectory &&
 		s.EntryPoint == t.EntryPoint &&
 		util.StringSequenceEquals(s.Ports, t.Ports) &&
 		util.StringSequenceEquals(s.Expose, t.Expose) &&
 		util.StringSequenceEquals(s.Envs, t.Envs) &&
 		s.Timeout == t.Timeout &&
+		s.StopTimeout == t.StopTimeout &&
+		s.Readiness.Equals(t.Readiness) &&
 		util.StringSequenceEquals(s.When, t.When) &&
+		s.If == t.If &&
 		util.IntSequenceEquals(s.ExitedWith, t.ExitedWith) &&
 		util.IntSequenceEquals(s.ExitedWithout, t.ExitedWithout) &&
 		s.StartDelay == t.StartDelay &&
@@ -221,12 +331,29 @@ func (s *Step) Equals(t *Step) bool {
 		s.User == t.User &&
 		s.Network == t.Network &&
 		s.Isolation == t.Isolation &&
+		s.Memory == t.Memory &&
+		s.MemorySwap == t.MemorySwap &&
+		s.ShmSize == t.ShmSize &&
+		s.PidsLimit == t.PidsLimit &&
+		util.StringSequenceEquals(s.Ulimits, t.Ulimits) &&
+		util.StringSequenceEquals(s.CapAdd, t.CapAdd) &&
+		util.StringSequenceEquals(s.CapDrop, t.CapDrop) &&
+		util.StringSequenceEquals(s.SecurityOpt, t.SecurityOpt) &&
+		s.ReadOnlyRootFilesystem == t.ReadOnlyRootFilesystem &&
+		util.StringSequenceEquals(s.Tmpfs, t.Tmpfs) &&
+		util.StringSequenceEquals(s.DNS, t.DNS) &&
+		util.StringSequenceEquals(s.ExtraHosts, t.ExtraHosts) &&
+		util.StringSequenceEquals(s.Labels, t.Labels) &&
+		util.StringSequenceEquals(s.Devices, t.Devices) &&
 		s.Cache == t.Cache &&
 		s.IgnoreErrors == t.IgnoreErrors &&
 		s.Retries == t.Retries &&
 		s.RetryDelayInSeconds == t.RetryDelayInSeconds &&
 		s.DisableWorkingDirectoryOverride == t.DisableWorkingDirectoryOverride &&
 		s.Pull == t.Pull &&
+		s.SBOM == t.SBOM &&
+		s.DisableDockerSocket == t.DisableDockerSocket &&
+		s.DisableHomeVolume == t.DisableHomeVolume &&
 		s.Repeat == t.Repeat
 }
 
@@ -249,6 +376,31 @@ func (s *Step) HasNoWhen() bool {
 	return len(s.When) == 0
 }
 
+// IsSuccessfulExitCode returns true if the Step's container exiting with the specified code means the Step succeeded.
+// If exitedWith is specified, the code must be one of its codes. If exitedWithout is specified, the code must not
+// be one of its codes. If neither is specified, only 0 is successful. Validate rejects steps which specify both.
+func (s *Step) IsSuccessfulExitCode(code int) bool {
+	if s == nil {
+		return code == 0
+	}
+	if len(s.ExitedWith) > 0 {
+		return containsInt(s.ExitedWith, code)
+	}
+	if len(s.ExitedWithout) > 0 {
+		return !containsInt(s.ExitedWithout, code)
+	}
+	return code == 0
+}
+
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
 // HasMounts returns true if the Step has at least 1 mount listed, false otherwise
 func (s *Step) HasMounts() bool {
 	if s == nil {
@@ -257,6 +409,12 @@ func (s *Step) HasMounts() bool {
 	return len(s.Mounts) > 0
 }
 
+// MountsDockerSocket returns true if the Docker socket is mounted into the Step's container.
+// Push steps don't run a container, while build steps always need the socket.
+func (s *Step) MountsDockerSocket() bool {
+	return s.IsBuildStep() || (s.IsCmdStep() && !s.DisableDockerSocket)
+}
+
 // IsCmdStep returns true if the Step is a command step, false otherwise.
 func (s *Step) IsCmdStep() bool {
 	if s == nil {
@@ -281,6 +439,14 @@ func (s *Step) IsPushStep() bool {
 	return len(s.Push) > 0
 }
 
+// IsSignStep returns true if a Step is a sign step, false otherwise.
+func (s *Step) IsSignStep() bool {
+	if s == nil {
+		return false
+	}
+	return s.Sign != nil
+}
+
 // UpdateBuildStepWithDefaults updates a build step with hyperv isolation on Windows.
 func (s *Step) UpdateBuildStepWithDefaults() {
 	if s.IsBuildStep() && runtime.GOOS == util.WindowsOS && !strings.Contains(s.Build, "--isolation") {
@@ -18,4 +18,10 @@ const (
 
 	// Failed means the step failed because of an error.
 	Failed StepStatus = "failed"
+
+	// Cancelled means the step was stopped before it completed because the run was cancelled.
+	Cancelled StepStatus = "cancelled"
+
+	// TimedOut means the step was stopped because it didn't complete within its timeout.
+	TimedOut StepStatus = "timedout"
 )
@@ -28,6 +28,16 @@ func TestValidate(t *testing.T) {
 			},
 			true,
 		},
+		{
+			// exitedWith and exitedWithout can't be combined.
+			&Step{
+				ID:            "a",
+				Cmd:           "b",
+				ExitedWith:    []int{0, 1},
+				ExitedWithout: []int{2},
+			},
+			true,
+		},
 		{
 			// ID cannot contain spaces.
 			&Step{
@@ -58,6 +68,21 @@ func TestValidate(t *testing.T) {
 			},
 			false,
 		},
+		{
+			// The command must be parsable into arguments.
+			&Step{
+				ID:  "a",
+				Cmd: `bash -c "echo 'hello'`,
+			},
+			true,
+		},
+		{
+			&Step{
+				ID:    "a",
+				Build: `-t foo --build-arg 'a=b .`,
+			},
+			true,
+		},
 		{
 			&Step{
 				ID:   "a",
@@ -72,6 +97,23 @@ func TestValidate(t *testing.T) {
 			},
 			false,
 		},
+		{
+			&Step{
+				ID:    "a",
+				Build: "b",
+				SBOM:  true,
+			},
+			false,
+		},
+		{
+			// Only build steps can generate SBOMs.
+			&Step{
+				ID:   "a",
+				Push: []string{"b"},
+				SBOM: true,
+			},
+			true,
+		},
 		{
 			&Step{
 				ID:   "a",
@@ -104,6 +146,22 @@ func TestValidate(t *testing.T) {
 			},
 			true,
 		},
+		{
+			&Step{
+				ID:  "a",
+				Cmd: "b",
+				If:  `Run.Branch == "main"`,
+			},
+			false,
+		},
+		{
+			&Step{
+				ID:  "a",
+				Cmd: "b",
+				If:  `Run.Branch = "main"`,
+			},
+			true,
+		},
 		{
 			&Step{
 				ID:      "retries",
@@ -711,3 +769,88 @@ func TestGetCmdForBuildCache(t *testing.T) {
 		}
 	}
 }
+
+func TestIsSuccessfulExitCode(t *testing.T) {
+	tests := []struct {
+		step     *Step
+		code     int
+		expected bool
+	}{
+		{&Step{}, 0, true},
+		{&Step{}, 1, false},
+		{&Step{ExitedWith: []int{0, 1}}, 1, true},
+		{&Step{ExitedWith: []int{0, 1}}, 2, false},
+		{&Step{ExitedWith: []int{1}}, 0, false},
+		{&Step{ExitedWithout: []int{2}}, 1, true},
+		{&Step{ExitedWithout: []int{2}}, 2, false},
+		{&Step{ExitedWithout: []int{0}}, 0, false},
+	}
+	for _, test := range tests {
+		if actual := test.step.IsSuccessfulExitCode(test.code); actual != test.expected {
+			t.Errorf("Expected exit code %d to be successful: %v for exitedWith: %v, exitedWithout: %v, but got %v",
+				test.code, test.expected, test.step.ExitedWith, test.step.ExitedWithout, actual)
+		}
+	}
+}
+
+func TestValidateContainerOptions(t *testing.T) {
+	tests := []struct {
+		step        *Step
+		shouldError bool
+	}{
+		{&Step{Memory: "512m", MemorySwap: "1g", ShmSize: "64m", PidsLimit: 100}, false},
+		{&Step{Memory: "512m", MemorySwap: "-1", PidsLimit: -1}, false},
+		{&Step{Ulimits: []string{"nofile=1024:2048", "nproc=512"}}, false},
+		{&Step{CapAdd: []string{"NET_ADMIN", "cap_sys_ptrace"}, CapDrop: []string{"ALL"}}, false},
+		{&Step{SecurityOpt: []string{"no-new-privileges", "no-new-privileges:true", "seccomp=unconfined", "apparmor=docker-default"}}, false},
+		{&Step{ReadOnlyRootFilesystem: true, Tmpfs: []string{"/tmp", "/run:rw,size=64m"}}, false},
+		{&Step{DNS: []string{"8.8.8.8", "2001:4860:4860::8888"}, ExtraHosts: []string{"db:10.0.0.2", "host.docker.internal:host-gateway", "v6:::1"}}, false},
+		{&Step{Labels: []string{"team=acr", "empty="}}, false},
+		{&Step{Devices: []string{"/dev/fuse", "/dev/sda:/dev/xvda", "/dev/sda:/dev/xvda:rw", "/dev/fuse:rwm"}}, false},
+		{&Step{Memory: "lots"}, true},
+		{&Step{Memory: "0"}, true},
+		{&Step{MemorySwap: "1g"}, true},
+		{&Step{Memory: "1g", MemorySwap: "512m"}, true},
+		{&Step{ShmSize: "-1"}, true},
+		{&Step{PidsLimit: -2}, true},
+		{&Step{Ulimits: []string{"nofile"}}, true},
+		{&Step{Ulimits: []string{"nofile=1024", "nofile=2048"}}, true},
+		{&Step{CapDrop: []string{"NET ADMIN"}}, true},
+		{&Step{SecurityOpt: []string{"privileged"}}, true},
+		{&Step{SecurityOpt: []string{"seccomp"}}, true},
+		{&Step{SecurityOpt: []string{"no-new-privileges=yes"}}, true},
+		{&Step{Tmpfs: []string{"tmp"}}, true},
+		{&Step{DNS: []string{"dns.google"}}, true},
+		{&Step{ExtraHosts: []string{"db"}}, true},
+		{&Step{ExtraHosts: []string{"db:localhost"}}, true},
+		{&Step{Labels: []string{"=acr"}}, true},
+		{&Step{Devices: []string{"fuse"}}, true},
+		{&Step{Devices: []string{"/dev/sda:xvda:rw"}}, true},
+		{&Step{Devices: []string{"/dev/fuse:rwx"}}, true},
+	}
+	for _, test := range tests {
+		test.step.ID = "id"
+		test.step.Cmd = "hello-world"
+		err := test.step.Validate()
+		if test.shouldError && err == nil {
+			t.Errorf("expected step %+v to be invalid", test.step)
+		}
+		if !test.shouldError && err != nil {
+			t.Errorf("expected step %+v to be valid but got %v", test.step, err)
+		}
+	}
+
+	push := &Step{ID: "id", Push: []string{"foo"}, Memory: "512m"}
+	if err := push.Validate(); err == nil {
+		t.Error("expected container options to be invalid for a push step")
+	}
+
+	build := &Step{ID: "id", Build: ".", Memory: "512m", ShmSize: "64m", Ulimits: []string{"nofile=1024:2048"}}
+	if err := build.Validate(); err != nil {
+		t.Errorf("expected the build options to be valid for a build step but got %v", err)
+	}
+	build.CapDrop = []string{"ALL"}
+	if err := build.Validate(); err == nil {
+		t.Error("expected container only options to be invalid for a build step")
+	}
+}
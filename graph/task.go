@@ -5,12 +5,15 @@ package graph
 
 import (
 	"context"
+	"encoding/csv"
 	"fmt"
 	"log"
 	"os"
+	"regexp"
 	"runtime"
 	"strings"
 
+	"github.com/Azure/acr-builder/pkg/redact"
 	"github.com/Azure/acr-builder/pkg/volume"
 	"github.com/Azure/acr-builder/secretmgmt"
 	"github.com/Azure/acr-builder/util"
@@ -25,6 +28,9 @@ const (
 	// The default step retry delay is 5 seconds.
 	defaultStepRetryDelayInSeconds = 5
 
+	// The default time a step's container is given to stop is 10 seconds, the same as `docker stop`.
+	defaultStepStopTimeoutInSeconds = 10
+
 	// currentTaskVersion is the most recent Task version
 	currentTaskVersion = "v1.0.0"
 
@@ -37,6 +43,9 @@ var (
 		currentTaskVersion: true,
 		"v1.1.0":           true,
 	}
+
+	// envNameRE matches the name of an environment variable.
+	envNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
 )
 
 // ResolvedRegistryCred is a credential with resolved username/password for the registry
@@ -58,6 +67,9 @@ type Task struct {
 	Envs                     []string             `yaml:"env,omitempty"`
 	WorkingDirectory         string               `yaml:"workingDirectory,omitempty"`
 	Version                  string               `yaml:"version,omitempty"`
+	FailFast                 *bool                `yaml:"failFast,omitempty"`
+	MaxParallelism           int                  `yaml:"maxParallelism,omitempty"`
+	Policy                   *Policy              `yaml:"policy,omitempty"`
 	RegistryName             string
 	Registry                 string
 	TaskName                 string // Used to form the build cache image tag.
@@ -66,6 +78,10 @@ type Task struct {
 	Dag                      *Dag
 	IsBuildTask              bool // Used to skip the default network creation for build.
 	InitBuildkitContainer    bool // Used to initialize buildkit container if a build step is using build cache.
+	RunValues                map[string]string
+
+	// externalPolicy is the Policy passed in TaskOptions, which is enforced in addition to the Task's own Policy.
+	externalPolicy *Policy
 }
 
 // TaskOptions are used to configure a new Task
@@ -93,6 +109,12 @@ type TaskOptions struct {
 
 	// GlobalAliases keeps track of all the Task native global aliases
 	GlobalAliases []byte
+
+	// RunValues are the Run properties which step conditions can reference
+	RunValues map[string]string
+
+	// Policy is a security policy the Task must comply with, in addition to its own policy
+	Policy *Policy
 }
 
 // UnmarshalTaskFromString unmarshals a Task from a raw string.
@@ -130,6 +152,8 @@ func (t *Task) AddTaskDefaults(ctx context.Context, opts *TaskOptions) error {
 	}
 
 	t.Registry = opts.Registry
+	t.RunValues = opts.RunValues
+	t.externalPolicy = opts.Policy
 
 	// External network parsed in from CLI will be set as default network, it will be used for any step if no network provide for them
 	// The external network is append at the end of the list of networks, later we will do reverse iteration to get this network
@@ -201,6 +225,14 @@ func (t *Task) Validate() error {
 		idMap[secret.ID] = struct{}{}
 	}
 
+	if t.MaxParallelism < 0 {
+		return errors.New("task must specify maxParallelism >= 0")
+	}
+
+	if err := t.Policy.Validate(); err != nil {
+		return errors.Wrap(err, "invalid policy")
+	}
+
 	// Validate Volumes if exists
 	if err := ValidateVolumes(t.Volumes); err != nil {
 		return err
@@ -210,6 +242,9 @@ func (t *Task) Validate() error {
 		if err := s.ValidateMountVolumeNames(t.Volumes); err != nil {
 			return err
 		}
+		if err := s.validateKeySecrets(t.Secrets); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -287,6 +322,17 @@ func (t *Task) initialize(ctx context.Context) error {
 	}
 
 	for i, s := range t.Steps {
+		if s.ID == "" {
+			s.ID = fmt.Sprintf("acb_step_%d", i)
+		}
+	}
+
+	// Expand matrices before the Dag is created so that validation covers the expanded steps.
+	if err := t.expandMatrixSteps(); err != nil {
+		return err
+	}
+
+	for _, s := range t.Steps {
 		// If individual steps don't have step timeouts specified,
 		// stamp the global timeout on them.
 		if s.Timeout <= 0 {
@@ -297,6 +343,14 @@ func (t *Task) initialize(ctx context.Context) error {
 			s.RetryDelayInSeconds = defaultStepRetryDelayInSeconds
 		}
 
+		if s.StopTimeout <= 0 {
+			s.StopTimeout = defaultStepStopTimeoutInSeconds
+		}
+
+		if s.Readiness != nil {
+			s.Readiness.setDefaults()
+		}
+
 		if addDefaultNetworkToSteps && s.Network == "" {
 			s.Network = newDefaultNetworkName
 		}
@@ -307,10 +361,6 @@ func (t *Task) initialize(ctx context.Context) error {
 		}
 		s.Envs = newEnvs
 
-		if s.ID == "" {
-			s.ID = fmt.Sprintf("acb_step_%d", i)
-		}
-
 		// Override the step's working directory to be the parent's working directory.
 		if s.WorkingDirectory == "" && t.WorkingDirectory != "" {
 			s.WorkingDirectory = t.WorkingDirectory
@@ -345,10 +395,19 @@ func (t *Task) initialize(ctx context.Context) error {
 			}
 		} else if s.IsPushStep() {
 			s.Push = getNormalizedDockerImageNames(s.Push)
+		} else if s.IsSignStep() {
+			s.Sign.Images = getNormalizedDockerImageNames(s.Sign.Images)
+		}
+	}
+
+	// Enforce the security policies before resolving any credentials, so a violation is reported before anything runs.
+	for _, p := range []*Policy{t.Policy, t.externalPolicy} {
+		if err := p.Enforce(t); err != nil {
+			return err
 		}
 	}
-	var err error
 
+	var err error
 	t.RegistryLoginCredentials, err = ResolveCustomRegistryCredentials(ctx, t.Credentials)
 	if err != nil {
 		return err
@@ -357,11 +416,68 @@ func (t *Task) initialize(ctx context.Context) error {
 	return err
 }
 
+// BaseImagePolicies returns the policies which the base images of the Task's build steps must comply with,
+// from the Task's own Policy and the Policy passed in TaskOptions.
+func (t *Task) BaseImagePolicies() []*BaseImagePolicy {
+	var policies []*BaseImagePolicy
+	for _, p := range []*Policy{t.Policy, t.externalPolicy} {
+		if p != nil && p.BaseImages != nil {
+			policies = append(policies, p.BaseImages)
+		}
+	}
+	return policies
+}
+
+// EnforcePolicy enforces an additional Policy on the initialized Task, like the Policy passed in TaskOptions.
+func (t *Task) EnforcePolicy(p *Policy) error {
+	if err := p.Enforce(t); err != nil {
+		return err
+	}
+	t.externalPolicy = p
+	return nil
+}
+
+// NewConditionContext creates a ConditionContext to evaluate the specified step's condition.
+// Only the steps which the step depends on can be referenced, since the others may not have completed.
+func (t *Task) NewConditionContext(step *Step) *ConditionContext {
+	steps := make(map[string]*Step)
+	if t.Dag != nil {
+		for _, s := range t.Dag.Dependencies(step.ID) {
+			steps[s.ID] = s
+		}
+	}
+	return &ConditionContext{
+		Run:   t.RunValues,
+		Steps: steps,
+		Envs:  step.Envs,
+	}
+}
+
+// IsFailFast determines whether or not the Task should stop running all of its steps
+// as soon as any step fails. Defaults to true if unspecified.
+func (t *Task) IsFailFast() bool {
+	return t.FailFast == nil || *t.FailFast
+}
+
 // UsingRegistryCreds determines whether or not the Task is using registry creds.
 func (t *Task) UsingRegistryCreds() bool {
 	return len(t.RegistryLoginCredentials) > 0
 }
 
+// InterruptedSteps returns the IDs of the steps which were cancelled, still running or never started,
+// in the order they're declared in the Task.
+func (t *Task) InterruptedSteps() []string {
+	var ids []string
+	for _, step := range t.Steps {
+		// Steps are marked as skipped until they run, so a skipped step without a reason never started.
+		notStarted := step.StepStatus == Skipped && step.SkipReason == ""
+		if step.StepStatus == Cancelled || step.StepStatus == InProgress || notStarted {
+			ids = append(ids, step.ID)
+		}
+	}
+	return ids
+}
+
 // getNormalizedDockerImageNames normalizes the list of docker images
 // and removes any duplicates.
 func getNormalizedDockerImageNames(dockerImages []string) []string {
@@ -384,18 +500,33 @@ func getNormalizedDockerImageNames(dockerImages []string) []string {
 	return normalizedDockerImages
 }
 
+// ParseEnvList parses a comma separated list of environment variables, e.g. env1=val1,env2=val2.
+// A variable whose value contains a comma must be double quoted, e.g. env1=val1,"env2=a,b",
+// and every variable must have a name, so a value can't be split into separate variables by mistake.
+func ParseEnvList(list string) ([]string, error) {
+	if list == "" {
+		return nil, nil
+	}
+	r := csv.NewReader(strings.NewReader(list))
+	envs, err := r.Read()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse environment variable list %s", list)
+	}
+	for _, env := range envs {
+		pair := strings.SplitN(env, "=", 2)
+		if len(pair) != 2 || !envNameRE.MatchString(pair[0]) {
+			return nil, fmt.Errorf("invalid environment variable %q in list %s, variables must be in the format of NAME=value, and values containing commas must be double quoted", env, list)
+		}
+	}
+	return envs, nil
+}
+
 // mergeEnvs merges the src environment variables into dest.
 func mergeEnvs(dest []string, src []string) ([]string, error) {
 	if len(src) < 1 {
 		return dest, nil
 	}
 
-	var newEnvs []string
-	for _, env := range src {
-		newEnv := strings.Split(env, ",")
-		newEnvs = append(newEnvs, newEnv...)
-	}
-
 	var stepmap = make(map[string]string)
 	for _, env := range dest {
 		pair := strings.SplitN(env, "=", 2)
@@ -406,7 +537,7 @@ func mergeEnvs(dest []string, src []string) ([]string, error) {
 		stepmap[pair[0]] = pair[1]
 	}
 
-	for _, env := range newEnvs {
+	for _, env := range src {
 		pair := strings.SplitN(env, "=", 2)
 		if len(pair) != 2 {
 			err := fmt.Errorf("cannot parse task environment variable %s correctly", env)
@@ -465,6 +596,7 @@ func ResolveCustomRegistryCredentials(ctx context.Context, credentials []*Regist
 		switch cred.PasswordType {
 		case Opaque:
 			passwordSecretObject.ResolvedValue = cred.Password
+			redact.Add(cred.Password)
 		case VaultSecret:
 			passwordSecretObject.KeyVault = cred.Password
 			passwordSecretObject.MsiClientID = cred.Identity
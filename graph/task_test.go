@@ -55,6 +55,23 @@ func TestUsingRegistryCreds(t *testing.T) {
 	}
 }
 
+func TestInterruptedSteps(t *testing.T) {
+	task := &Task{
+		Steps: []*Step{
+			{ID: "a", StepStatus: Successful},
+			{ID: "b", StepStatus: Cancelled},
+			{ID: "c", StepStatus: Failed},
+			{ID: "d", StepStatus: InProgress},
+			{ID: "e", StepStatus: Skipped, SkipReason: "it was not selected to run"},
+			{ID: "f", StepStatus: Skipped},
+		},
+	}
+	expected := []string{"b", "d", "f"}
+	if actual := task.InterruptedSteps(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected interrupted steps %v but got %v", expected, actual)
+	}
+}
+
 func TestNewTask(t *testing.T) {
 	tests := []struct {
 		steps            []*Step
@@ -138,6 +155,36 @@ func TestInitializeTimeouts(t *testing.T) {
 	}
 }
 
+func TestParseEnvList(t *testing.T) {
+	tests := []struct {
+		list     string
+		expected []string
+	}{
+		{"", nil},
+		{"key1=val1", []string{"key1=val1"}},
+		{"key1=val1,key2=val2", []string{"key1=val1", "key2=val2"}},
+		{"key1=,key2=val2", []string{"key1=", "key2=val2"}},
+		{`key1=val1,"LABELS=a=1,b=2"`, []string{"key1=val1", "LABELS=a=1,b=2"}},
+		{`"JSON={""a"": 1,""b"": [2, 3]}"`, []string{`JSON={"a": 1,"b": [2, 3]}`}},
+	}
+	for _, test := range tests {
+		actual, err := ParseEnvList(test.list)
+		if err != nil {
+			t.Errorf("failed to parse %s: %v", test.list, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("expected %q but got %q", test.expected, actual)
+		}
+	}
+
+	for _, list := range []string{"key1=val1,val2", "LABELS=a=1,b", `key1="val1`, "=val1"} {
+		if _, err := ParseEnvList(list); err == nil {
+			t.Errorf("expected %s to fail to parse", list)
+		}
+	}
+}
+
 func TestMergeEnvs(t *testing.T) {
 	tests := []struct {
 		taskEnvs     []string
@@ -162,17 +209,17 @@ func TestMergeEnvs(t *testing.T) {
 		{
 			[]string{"key1=val1,key2=val2,key3=val3"},
 			[]string{},
-			[]string{"key1=val1", "key2=val2", "key3=val3"},
+			[]string{"key1=val1,key2=val2,key3=val3"},
 		},
 		{
 			[]string{"key1=val1,key2=val2", "key3=val3,key4=val4"},
 			[]string{"key1=newVal1", "key2=newVal2"},
-			[]string{"key1=newVal1", "key2=newVal2", "key3=val3", "key4=val4"},
+			[]string{"key1=newVal1", "key2=newVal2", "key3=val3,key4=val4"},
 		},
 		{
-			[]string{"key1=val1,key2=val2", "key3=val3,key4=val4"},
-			[]string{"key1=newVal1", "key2=newVal2", "key3=newVal3="},
-			[]string{"key1=newVal1", "key2=newVal2", "key3=newVal3=", "key4=val4"},
+			[]string{`JSON={"a": 1, "b": [2, 3]}`, "CONN=Server=tcp:db,1433;User ID=admin"},
+			[]string{"key1=val1"},
+			[]string{"key1=val1", `JSON={"a": 1, "b": [2, 3]}`, "CONN=Server=tcp:db,1433;User ID=admin"},
 		},
 		{
 			[]string{},
@@ -439,3 +486,36 @@ env: ["a=b", "c=d"]
 		}
 	}
 }
+
+func TestNewTaskFromString_Scheduling(t *testing.T) {
+	tests := []struct {
+		data                   string
+		expectedFailFast       bool
+		expectedMaxParallelism int
+		shouldError            bool
+	}{
+		{"steps:\n  - cmd: foo", true, 0, false},
+		{"failFast: false\nmaxParallelism: 4\nsteps:\n  - cmd: foo", false, 4, false},
+		{"failFast: true\nsteps:\n  - cmd: foo", true, 0, false},
+		{"maxParallelism: -1\nsteps:\n  - cmd: foo", true, 0, true},
+	}
+
+	for _, test := range tests {
+		task, err := NewTaskFromString(test.data)
+		if test.shouldError {
+			if err == nil {
+				t.Errorf("Expected task to error but it didn't: %s", test.data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected err: %v", err)
+		}
+		if task.IsFailFast() != test.expectedFailFast {
+			t.Errorf("Expected failFast to be %v but got %v", test.expectedFailFast, task.IsFailFast())
+		}
+		if task.MaxParallelism != test.expectedMaxParallelism {
+			t.Errorf("Expected maxParallelism to be %d but got %d", test.expectedMaxParallelism, task.MaxParallelism)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// validateAcyclic returns an error naming the first cycle found in the Dag, if any.
+func (d *Dag) validateAcyclic() error {
+	cycle := d.findCycle()
+	if cycle == nil {
+		return nil
+	}
+
+	msg := fmt.Sprintf("cycle detected in step dependencies: %s", strings.Join(cycle, " -> "))
+	inCycle := make(map[string]bool, len(cycle))
+	for _, id := range cycle {
+		inCycle[id] = true
+	}
+	var blocked []string
+	for _, id := range d.unreachable() {
+		if !inCycle[id] {
+			blocked = append(blocked, id)
+		}
+	}
+	if len(blocked) > 0 {
+		msg += fmt.Sprintf(". The following steps depend on a cycle and can never run: %s", strings.Join(blocked, ", "))
+	}
+	return errors.New(msg)
+}
+
+// validateConditions returns an error if a step's condition references a step which isn't one of its dependencies,
+// since such a step may not have completed when the condition is evaluated.
+func (d *Dag) validateConditions() error {
+	for _, n := range d.sortedNodes() {
+		if n.Value.If == "" {
+			continue
+		}
+		condition, err := ParseCondition(n.Value.If)
+		if err != nil {
+			return errors.Wrapf(err, "step ID: %s has an invalid if condition", n.Name)
+		}
+		deps := make(map[string]bool)
+		for _, dep := range d.Dependencies(n.Name) {
+			deps[dep.ID] = true
+		}
+		for _, id := range condition.StepReferences() {
+			if _, ok := d.Nodes[id]; !ok {
+				return fmt.Errorf("step ID: %s has an if condition which references unknown step ID: %s", n.Name, id)
+			}
+			if !deps[id] {
+				return fmt.Errorf("step ID: %s has an if condition which references step ID: %s, which it doesn't depend on", n.Name, id)
+			}
+		}
+	}
+	return nil
+}
+
+// findCycle returns the IDs of the steps forming a cycle, starting and ending with the same step,
+// or nil if the Dag is acyclic.
+func (d *Dag) findCycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(d.Nodes))
+	var path []string
+
+	var visit func(n *Node) []string
+	visit = func(n *Node) []string {
+		state[n.Name] = visiting
+		path = append(path, n.Name)
+		for _, child := range sortedNodes(n.Children()) {
+			switch state[child.Name] {
+			case visiting:
+				for i, id := range path {
+					if id == child.Name {
+						return append(append([]string{}, path[i:]...), child.Name)
+					}
+				}
+			case unvisited:
+				if cycle := visit(child); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[n.Name] = visited
+		return nil
+	}
+
+	for _, n := range d.sortedNodes() {
+		if state[n.Name] == unvisited {
+			if cycle := visit(n); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// unreachable returns the IDs of the steps which can never run, since they aren't reachable
+// from the Dag's root or depend on a step which is part of a cycle.
+func (d *Dag) unreachable() []string {
+	nodes := d.sortedNodes()
+	remaining := make(map[string]int, len(nodes))
+	for _, n := range append(nodes, d.Root) {
+		for _, child := range n.Children() {
+			remaining[child.Name]++
+		}
+	}
+
+	// A step runs once all of its dependencies have run, starting from the root.
+	ran := map[string]bool{}
+	queue := []*Node{d.Root}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, child := range n.Children() {
+			remaining[child.Name]--
+			if remaining[child.Name] == 0 {
+				ran[child.Name] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	var ids []string
+	for _, n := range nodes {
+		if !ran[n.Name] {
+			ids = append(ids, n.Name)
+		}
+	}
+	return ids
+}
+
+// sortedNodes returns the Dag's nodes sorted by name, to make traversals deterministic.
+func (d *Dag) sortedNodes() []*Node {
+	d.mu.Lock()
+	nodes := make([]*Node, 0, len(d.Nodes))
+	for _, n := range d.Nodes {
+		nodes = append(nodes, n)
+	}
+	d.mu.Unlock()
+	return sortedNodes(nodes)
+}
+
+func sortedNodes(nodes []*Node) []*Node {
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+	return nodes
+}
+
+// Warnings returns descriptions of dependencies in the Task which are valid,
+// but are likely to behave differently than intended.
+func (t *Task) Warnings() []string {
+	var warnings []string
+
+	index := make(map[string]int, len(t.Steps))
+	for i, step := range t.Steps {
+		index[step.ID] = i
+	}
+
+	for i, step := range t.Steps {
+		for _, dep := range step.When {
+			if depIndex, ok := index[dep]; ok && depIndex > i {
+				warnings = append(warnings, fmt.Sprintf("step ID: %s depends on step ID: %s, which is defined after it", step.ID, dep))
+			}
+		}
+
+		// A step without a when only depends on the previous step. If the previous step runs immediately,
+		// the step doesn't wait for any of the steps defined before it.
+		if i > 1 && step.HasNoWhen() && t.Steps[i-1].ShouldExecuteImmediately() {
+			prev := t.Steps[i-1]
+			warnings = append(warnings, fmt.Sprintf("step ID: %s has no when and only depends on the previous step ID: %s, which specifies when: [\"%s\"], so it doesn't wait for the steps defined before it. Specify when explicitly", step.ID, prev.ID, ImmediateExecutionToken))
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,134 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	gocontext "context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewDagFromTask_Cycles(t *testing.T) {
+	tests := []struct {
+		steps         []*Step
+		expectedError string
+	}{
+		{
+			[]*Step{
+				{ID: "a", Cmd: "a", When: []string{"b"}},
+				{ID: "b", Cmd: "b", When: []string{"a"}},
+			},
+			"cycle detected in step dependencies: a -> b -> a",
+		},
+		{
+			[]*Step{
+				{ID: "a", Cmd: "a", When: []string{"-"}},
+				{ID: "b", Cmd: "b", When: []string{"a", "d"}},
+				{ID: "c", Cmd: "c", When: []string{"b"}},
+				{ID: "d", Cmd: "d", When: []string{"c"}},
+				{ID: "e", Cmd: "e", When: []string{"d"}},
+			},
+			"cycle detected in step dependencies: b -> c -> d -> b. The following steps depend on a cycle and can never run: e",
+		},
+		{
+			[]*Step{
+				{ID: "a", Cmd: "a", When: []string{"missing"}},
+			},
+			"missing does not exist as a vertex",
+		},
+		{
+			[]*Step{
+				{ID: "a", Cmd: "a", When: []string{"b"}},
+				{ID: "b", Cmd: "b", When: []string{"-"}},
+			},
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		_, err := NewTask(gocontext.Background(), test.steps, nil, "", nil, true, "", "")
+		if test.expectedError == "" {
+			if err != nil {
+				t.Errorf("Unexpected err: %v", err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.expectedError) {
+			t.Errorf("Expected error containing %q but got %v", test.expectedError, err)
+		}
+	}
+}
+
+func TestNewDagFromTask_Conditions(t *testing.T) {
+	tests := []struct {
+		steps         []*Step
+		expectedError string
+	}{
+		{
+			[]*Step{
+				{ID: "a", Cmd: "a"},
+				{ID: "b", Cmd: "b", When: []string{"a"}},
+				{ID: "c", Cmd: "c", When: []string{"b"}, If: `steps.a.status == "failed" || steps.b.exitCode == 1`},
+			},
+			"",
+		},
+		{
+			[]*Step{
+				{ID: "a", Cmd: "a"},
+				{ID: "b", Cmd: "b", If: `steps.a.status == "successful"`},
+			},
+			"",
+		},
+		{
+			[]*Step{
+				{ID: "a", Cmd: "a", When: []string{"-"}},
+				{ID: "b", Cmd: "b", When: []string{"-"}, If: `steps.a.status == "failed"`},
+			},
+			"step ID: b has an if condition which references step ID: a, which it doesn't depend on",
+		},
+		{
+			[]*Step{
+				{ID: "a", Cmd: "a", If: `Run.Branch == "main" && steps.missing.status == "failed"`},
+			},
+			"step ID: a has an if condition which references unknown step ID: missing",
+		},
+	}
+
+	for _, test := range tests {
+		_, err := NewTask(gocontext.Background(), test.steps, nil, "", nil, true, "", "")
+		if test.expectedError == "" {
+			if err != nil {
+				t.Errorf("Unexpected err: %v", err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.expectedError) {
+			t.Errorf("Expected error containing %q but got %v", test.expectedError, err)
+		}
+	}
+}
+
+func TestTaskWarnings(t *testing.T) {
+	steps := []*Step{
+		{ID: "a", Cmd: "a"},
+		{ID: "b", Cmd: "b", When: []string{"d"}},
+		{ID: "c", Cmd: "c", When: []string{"-"}},
+		{ID: "d", Cmd: "d"},
+		{ID: "e", Cmd: "e", When: []string{"-"}},
+	}
+	task, err := NewTask(gocontext.Background(), steps, nil, "", nil, true, "", "")
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+
+	expected := []string{
+		"step ID: b depends on step ID: d, which is defined after it",
+		"step ID: d has no when and only depends on the previous step ID: c, which specifies when: [\"-\"], so it doesn't wait for the steps defined before it. Specify when explicitly",
+	}
+	if actual := task.Warnings(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected warnings %v but got %v", expected, actual)
+	}
+
+}
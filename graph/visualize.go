@@ -0,0 +1,157 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Levels groups the Task's steps by the order in which they can run. Every step in a level only depends
+// on steps in earlier levels, so the steps in a level can run in parallel. Steps which can never run are omitted.
+func (t *Task) Levels() [][]*Step {
+	level := make(map[string]int, len(t.Steps))
+	remaining := make(map[string]int, len(t.Steps))
+	for _, n := range append(t.Dag.sortedNodes(), t.Dag.Root) {
+		for _, child := range n.Children() {
+			remaining[child.Name]++
+		}
+	}
+
+	ran := make(map[string]bool, len(t.Steps))
+	maxLevel := -1
+	queue := []*Node{t.Dag.Root}
+	level[t.Dag.Root.Name] = -1
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, child := range n.Children() {
+			if level[n.Name]+1 > level[child.Name] {
+				level[child.Name] = level[n.Name] + 1
+			}
+			remaining[child.Name]--
+			if remaining[child.Name] == 0 {
+				ran[child.Name] = true
+				queue = append(queue, child)
+				if level[child.Name] > maxLevel {
+					maxLevel = level[child.Name]
+				}
+			}
+		}
+	}
+
+	levels := make([][]*Step, maxLevel+1)
+	for _, step := range t.Steps {
+		if ran[step.ID] {
+			levels[level[step.ID]] = append(levels[level[step.ID]], step)
+		}
+	}
+	return levels
+}
+
+// DOT renders the Task's steps and their dependencies as a Graphviz DOT graph.
+func (t *Task) DOT() string {
+	var sb strings.Builder
+	sb.WriteString("digraph task {\n")
+	sb.WriteString("  node [shape=box];\n")
+	for _, step := range t.Steps {
+		label := strings.Join(append([]string{step.ID}, stepDetails(step)...), "\n")
+		sb.WriteString(fmt.Sprintf("  %q [label=%q];\n", step.ID, label))
+	}
+	t.forEachEdge(func(from, to string) {
+		sb.WriteString(fmt.Sprintf("  %q -> %q;\n", from, to))
+	})
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// Mermaid renders the Task's steps and their dependencies as a Mermaid flowchart.
+func (t *Task) Mermaid() string {
+	// Mermaid node IDs can't contain all of the characters a step ID can, so steps are identified by their index.
+	ids := make(map[string]string, len(t.Steps))
+	var sb strings.Builder
+	sb.WriteString("flowchart TD\n")
+	for i, step := range t.Steps {
+		ids[step.ID] = fmt.Sprintf("step%d", i)
+		label := strings.Join(append([]string{step.ID}, stepDetails(step)...), "<br/>")
+		sb.WriteString(fmt.Sprintf("  %s[\"%s\"]\n", ids[step.ID], strings.ReplaceAll(label, `"`, "#quot;")))
+	}
+	t.forEachEdge(func(from, to string) {
+		sb.WriteString(fmt.Sprintf("  %s --> %s\n", ids[from], ids[to]))
+	})
+	return sb.String()
+}
+
+// ASCII renders the Task's steps as a tree grouped by the levels in which they can run in parallel.
+func (t *Task) ASCII() string {
+	var sb strings.Builder
+	sb.WriteString(t.TaskName + "\n")
+	levels := t.Levels()
+	for i, steps := range levels {
+		branch, indent := "├── ", "│   "
+		if i == len(levels)-1 {
+			branch, indent = "└── ", "    "
+		}
+		sb.WriteString(fmt.Sprintf("%sLevel %d (%d step(s) in parallel)\n", branch, i+1, len(steps)))
+		for j, step := range steps {
+			stepBranch := "├── "
+			if j == len(steps)-1 {
+				stepBranch = "└── "
+			}
+			line := fmt.Sprintf("%s [%s]", step.ID, strings.Join(stepDetails(step), ", "))
+			if deps := t.directDependencies(step.ID); len(deps) > 0 {
+				line += " after: " + strings.Join(deps, ", ")
+			}
+			sb.WriteString(indent + stepBranch + line + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// stepDetails describes the step's type, timeout, retries and network.
+func stepDetails(step *Step) []string {
+	stepType := "cmd"
+	if step.IsBuildStep() {
+		stepType = "build"
+	} else if step.IsPushStep() {
+		stepType = "push"
+	} else if step.IsSignStep() {
+		stepType = "sign"
+	}
+	details := []string{
+		stepType,
+		fmt.Sprintf("timeout: %ds", step.Timeout),
+		fmt.Sprintf("retries: %d", step.Retries),
+	}
+	if step.Network != "" {
+		details = append(details, "network: "+step.Network)
+	}
+	return details
+}
+
+// forEachEdge calls fn for every dependency between two steps, in the order of the Task's steps.
+func (t *Task) forEachEdge(fn func(from, to string)) {
+	for _, step := range t.Steps {
+		node, ok := t.Dag.Nodes[step.ID]
+		if !ok {
+			continue
+		}
+		for _, child := range sortedNodes(node.Children()) {
+			fn(step.ID, child.Name)
+		}
+	}
+}
+
+// directDependencies returns the IDs of the steps which the specified step directly depends on.
+func (t *Task) directDependencies(id string) []string {
+	var deps []string
+	t.forEachEdge(func(from, to string) {
+		if to == id {
+			deps = append(deps, from)
+		}
+	})
+	sort.Strings(deps)
+	return deps
+}
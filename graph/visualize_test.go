@@ -0,0 +1,95 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	gocontext "context"
+	"testing"
+)
+
+func newVisualizeTestTask(t *testing.T) *Task {
+	steps := []*Step{
+		{ID: "a", Build: "-t a ."},
+		{ID: "b", Cmd: "b", Retries: 2},
+		{ID: "c", Cmd: "c", When: []string{"-"}},
+		{ID: "d", Push: []string{"a"}, When: []string{"b", "c"}},
+	}
+	task, err := NewTask(gocontext.Background(), steps, nil, "", nil, true, "", "")
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	task.TaskName = "test"
+	return task
+}
+
+func TestLevels(t *testing.T) {
+	task := newVisualizeTestTask(t)
+	expected := [][]string{{"a", "c"}, {"b"}, {"d"}}
+
+	levels := task.Levels()
+	if len(levels) != len(expected) {
+		t.Fatalf("Expected %d levels but got %d", len(expected), len(levels))
+	}
+	for i, steps := range levels {
+		var ids []string
+		for _, step := range steps {
+			ids = append(ids, step.ID)
+		}
+		if len(ids) != len(expected[i]) {
+			t.Errorf("Expected level %d to be %v but got %v", i, expected[i], ids)
+			continue
+		}
+		for j := range ids {
+			if ids[j] != expected[i][j] {
+				t.Errorf("Expected level %d to be %v but got %v", i, expected[i], ids)
+				break
+			}
+		}
+	}
+}
+
+func TestVisualize(t *testing.T) {
+	task := newVisualizeTestTask(t)
+
+	expectedDOT := `digraph task {
+  node [shape=box];
+  "a" [label="a\nbuild\ntimeout: 600s\nretries: 0"];
+  "b" [label="b\ncmd\ntimeout: 600s\nretries: 2"];
+  "c" [label="c\ncmd\ntimeout: 600s\nretries: 0"];
+  "d" [label="d\npush\ntimeout: 600s\nretries: 0"];
+  "a" -> "b";
+  "b" -> "d";
+  "c" -> "d";
+}
+`
+	if actual := task.DOT(); actual != expectedDOT {
+		t.Errorf("Expected DOT:\n%s\nbut got:\n%s", expectedDOT, actual)
+	}
+
+	expectedMermaid := `flowchart TD
+  step0["a<br/>build<br/>timeout: 600s<br/>retries: 0"]
+  step1["b<br/>cmd<br/>timeout: 600s<br/>retries: 2"]
+  step2["c<br/>cmd<br/>timeout: 600s<br/>retries: 0"]
+  step3["d<br/>push<br/>timeout: 600s<br/>retries: 0"]
+  step0 --> step1
+  step1 --> step3
+  step2 --> step3
+`
+	if actual := task.Mermaid(); actual != expectedMermaid {
+		t.Errorf("Expected Mermaid:\n%s\nbut got:\n%s", expectedMermaid, actual)
+	}
+
+	expectedASCII := `test
+├── Level 1 (2 step(s) in parallel)
+│   ├── a [build, timeout: 600s, retries: 0]
+│   └── c [cmd, timeout: 600s, retries: 0]
+├── Level 2 (1 step(s) in parallel)
+│   └── b [cmd, timeout: 600s, retries: 2] after: a
+└── Level 3 (1 step(s) in parallel)
+    └── d [push, timeout: 600s, retries: 0] after: b, c
+`
+	if actual := task.ASCII(); actual != expectedASCII {
+		t.Errorf("Expected ASCII:\n%s\nbut got:\n%s", expectedASCII, actual)
+	}
+}
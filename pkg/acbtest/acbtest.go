@@ -0,0 +1,327 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+// Package acbtest runs Tasks against a fake container runtime, which replays scripted step results
+// and records the operations issued by the Builder, so Task files can be tested without Docker.
+package acbtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Azure/acr-builder/builder"
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/container"
+	"github.com/Azure/acr-builder/pkg/procmanager"
+)
+
+const workspaceVolume = "acbtest_workspace"
+
+// OperationKind identifies an operation issued by the Builder.
+type OperationKind string
+
+const (
+	// RunStep is the run of a step's container.
+	RunStep OperationKind = "runStep"
+
+	// RunProcess is a process launched by the Builder rather than a container, e.g. docker save.
+	RunProcess OperationKind = "runProcess"
+
+	// RunContainer, StopContainer, RemoveContainer, InspectContainer, ContainerLogs, ExecContainer, PullImage,
+	// PushImage, CreateNetwork, RemoveNetwork, CreateVolume and RemoveVolume are operations of the container runtime.
+	RunContainer     OperationKind = "run"
+	StopContainer    OperationKind = "stop"
+	RemoveContainer  OperationKind = "remove"
+	InspectContainer OperationKind = "inspect"
+	ContainerLogs    OperationKind = "logs"
+	ExecContainer    OperationKind = "exec"
+	PullImage        OperationKind = "pull"
+	PushImage        OperationKind = "push"
+	CreateNetwork    OperationKind = "createNetwork"
+	RemoveNetwork    OperationKind = "removeNetwork"
+	CreateVolume     OperationKind = "createVolume"
+	RemoveVolume     OperationKind = "removeVolume"
+)
+
+// outputFileRE matches the path of a step's output file in the directory of the run's workspace volume.
+var outputFileRE = regexp.MustCompile(`\.acb[/\\]outputs[/\\][^\s;"'/\\]+[/\\]([^\s;"'/\\]+)`)
+
+// StepResult is the scripted outcome of running a step's container once.
+type StepResult struct {
+	// ExitCode is the exit code of the container.
+	ExitCode int
+
+	// Stdout and Stderr are written to the step's output.
+	Stdout string
+	Stderr string
+
+	// Delay is how long the container runs. If the step times out or is canceled first, the run fails.
+	Delay time.Duration
+
+	// Outputs are the outputs the step writes to its output file.
+	Outputs map[string]string
+}
+
+// Operation is an operation issued by the Builder while running a Task.
+type Operation struct {
+	Kind OperationKind
+
+	// Name is the ID of the step, or the name of the container, image, network or volume.
+	Name string
+
+	// Env is the environment of a step's container.
+	Env []string
+
+	// Args are the arguments of a process, or the image and command of a container.
+	Args []string
+}
+
+// Runtime is a fake container runtime which runs steps by replaying their scripted results.
+type Runtime struct {
+	mu          sync.Mutex
+	results     map[string][]StepResult
+	runs        map[string]int
+	last        map[string]StepResult
+	execResults map[string][]StepResult
+	execs       map[string]int
+	steps       map[string]bool
+	ops         []Operation
+}
+
+var _ container.Runtime = &Runtime{}
+
+// NewRuntime creates a new Runtime.
+func NewRuntime() *Runtime {
+	return &Runtime{
+		results:     make(map[string][]StepResult),
+		runs:        make(map[string]int),
+		last:        make(map[string]StepResult),
+		execResults: make(map[string][]StepResult),
+		execs:       make(map[string]int),
+		steps:       make(map[string]bool),
+	}
+}
+
+// SetStepResults scripts the results of a step's runs. The nth run of the step, counting retries and repeats,
+// uses the nth result and the last result is used for any further runs. Steps without results exit with 0.
+func (r *Runtime) SetStepResults(id string, results ...StepResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results[id] = results
+}
+
+// SetExecResults scripts the results of the commands run in a step's container, e.g. its readiness probes.
+// The nth command uses the nth result and the last result is used for any further commands.
+// Commands without results exit with 0.
+func (r *Runtime) SetExecResults(id string, results ...StepResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.execResults[id] = results
+}
+
+// RunTask runs and then cleans up a Task like `acb exec`, with a Builder which uses the Runtime.
+// It's a dry run, so no processes are launched either.
+func (r *Runtime) RunTask(ctx context.Context, task *graph.Task) error {
+	r.mu.Lock()
+	for _, step := range task.Steps {
+		r.steps[step.ID] = true
+	}
+	r.mu.Unlock()
+	pm := procmanager.NewProcManager(true)
+	pm.Executor = r.execute
+	b := builder.NewBuilderWithRuntime(pm, false, workspaceVolume, r)
+	defer b.CleanTask(context.Background(), task)
+	return b.RunTask(ctx, task)
+}
+
+// Operations returns the operations issued so far, in order.
+func (r *Runtime) Operations() []Operation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Operation(nil), r.ops...)
+}
+
+// StepRuns returns the IDs of the steps whose containers were run, in order, including retries and repeats.
+func (r *Runtime) StepRuns() []string {
+	var ids []string
+	for _, op := range r.Operations() {
+		if op.Kind == RunStep {
+			ids = append(ids, op.Name)
+		}
+	}
+	return ids
+}
+
+// Env returns the environment of the last run of the specified step, sorted, or nil if it didn't run.
+func (r *Runtime) Env(id string) []string {
+	var env []string
+	for _, op := range r.Operations() {
+		if op.Kind == RunStep && op.Name == id {
+			env = append([]string(nil), op.Env...)
+		}
+	}
+	sort.Strings(env)
+	return env
+}
+
+func (r *Runtime) record(op Operation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, op)
+}
+
+// execute replaces the processes launched by the Builder, which all succeed.
+func (r *Runtime) execute(_ context.Context, args []string, _ io.Reader, _ io.Writer, _ io.Writer, _ string) error {
+	r.record(Operation{Kind: RunProcess, Args: append([]string(nil), args...)})
+	return nil
+}
+
+// isStep returns true if the container is a step's rather than, e.g., one the Builder runs to read outputs.
+func (r *Runtime) isStep(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.steps[name]
+}
+
+func (r *Runtime) runStep(ctx context.Context, id string, env []string, stdOut io.Writer, stdErr io.Writer) error {
+	r.mu.Lock()
+	result := nextResult(r.results[id], r.runs[id])
+	r.runs[id]++
+	r.last[id] = result
+	r.ops = append(r.ops, Operation{Kind: RunStep, Name: id, Env: env})
+	r.mu.Unlock()
+	return replay(ctx, result, stdOut, stdErr)
+}
+
+// nextResult returns the result of the nth run, counting from 0, or an empty result if there are none.
+func nextResult(results []StepResult, n int) StepResult {
+	if len(results) == 0 {
+		return StepResult{}
+	}
+	if n >= len(results) {
+		n = len(results) - 1
+	}
+	return results[n]
+}
+
+// replay waits for the result's delay, writes its output and returns its exit code as an error.
+func replay(ctx context.Context, result StepResult, stdOut io.Writer, stdErr io.Writer) error {
+	if result.Delay > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(result.Delay):
+		}
+	}
+	if stdOut != nil {
+		_, _ = io.WriteString(stdOut, result.Stdout)
+	}
+	if stdErr != nil {
+		_, _ = io.WriteString(stdErr, result.Stderr)
+	}
+	if result.ExitCode != 0 {
+		return &procmanager.ExitError{Code: result.ExitCode, Err: fmt.Errorf("exit status %d", result.ExitCode)}
+	}
+	return nil
+}
+
+// Run replays the next scripted result of a step's container. Any other container is recorded and succeeds,
+// and a container which reads a step's output file writes the step's scripted outputs.
+func (r *Runtime) Run(ctx context.Context, opts *container.RunOptions) (string, error) {
+	if r.isStep(opts.Name) {
+		return opts.Name, r.runStep(ctx, opts.Name, opts.Env, opts.Stdout, opts.Stderr)
+	}
+
+	r.record(Operation{Kind: RunContainer, Name: opts.Name, Env: opts.Env, Args: append(append([]string{opts.Image}, opts.Entrypoint...), opts.Cmd...)})
+	if len(opts.Cmd) > 0 && opts.Stdout != nil {
+		if matches := outputFileRE.FindStringSubmatch(opts.Cmd[len(opts.Cmd)-1]); len(matches) == 2 {
+			r.mu.Lock()
+			outputs := r.last[matches[1]].Outputs
+			r.mu.Unlock()
+			for key, value := range outputs {
+				_, _ = fmt.Fprintf(opts.Stdout, "%s=%s\n", key, value)
+			}
+		}
+	}
+	return opts.Name, nil
+}
+
+// Stop records stopping a container.
+func (r *Runtime) Stop(_ context.Context, id string, _ time.Duration) error {
+	r.record(Operation{Kind: StopContainer, Name: id})
+	return nil
+}
+
+// Remove records removing a container.
+func (r *Runtime) Remove(_ context.Context, id string) error {
+	r.record(Operation{Kind: RemoveContainer, Name: id})
+	return nil
+}
+
+// Inspect records inspecting a container, which is reported as exited.
+func (r *Runtime) Inspect(_ context.Context, id string) (*container.Info, error) {
+	r.record(Operation{Kind: InspectContainer, Name: id})
+	return &container.Info{ID: id, Name: id, Status: "exited"}, nil
+}
+
+// Logs records reading the logs of a container, which are empty.
+func (r *Runtime) Logs(_ context.Context, id string, _ bool, _ io.Writer, _ io.Writer) error {
+	r.record(Operation{Kind: ContainerLogs, Name: id})
+	return nil
+}
+
+// Exec records running a command in a container and replays the container's next scripted exec result.
+func (r *Runtime) Exec(ctx context.Context, id string, cmd []string, stdout io.Writer, stderr io.Writer) error {
+	r.mu.Lock()
+	result := nextResult(r.execResults[id], r.execs[id])
+	r.execs[id]++
+	r.ops = append(r.ops, Operation{Kind: ExecContainer, Name: id, Args: append([]string(nil), cmd...)})
+	r.mu.Unlock()
+	return replay(ctx, result, stdout, stderr)
+}
+
+// Pull records pulling an image.
+func (r *Runtime) Pull(_ context.Context, image string, _ *container.AuthConfig, _ io.Writer) error {
+	r.record(Operation{Kind: PullImage, Name: image})
+	return nil
+}
+
+// Push records pushing an image.
+func (r *Runtime) Push(_ context.Context, image string, _ *container.AuthConfig, _ io.Writer) error {
+	r.record(Operation{Kind: PushImage, Name: image})
+	return nil
+}
+
+// CreateNetwork records creating a network.
+func (r *Runtime) CreateNetwork(_ context.Context, name string, _ *container.NetworkOptions) error {
+	r.record(Operation{Kind: CreateNetwork, Name: name})
+	return nil
+}
+
+// RemoveNetwork records removing a network.
+func (r *Runtime) RemoveNetwork(_ context.Context, name string) error {
+	r.record(Operation{Kind: RemoveNetwork, Name: name})
+	return nil
+}
+
+// CreateVolume records creating a volume.
+func (r *Runtime) CreateVolume(_ context.Context, name string) error {
+	r.record(Operation{Kind: CreateVolume, Name: name})
+	return nil
+}
+
+// RemoveVolume records removing a volume.
+func (r *Runtime) RemoveVolume(_ context.Context, name string) error {
+	r.record(Operation{Kind: RemoveVolume, Name: name})
+	return nil
+}
+
+// RegistryConfig returns a configuration without any insecure registries.
+func (r *Runtime) RegistryConfig(_ context.Context) (*container.RegistryConfig, error) {
+	return &container.RegistryConfig{}, nil
+}
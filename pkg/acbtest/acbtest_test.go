@@ -0,0 +1,264 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package acbtest
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/acr-builder/graph"
+)
+
+const testTask = `
+version: v1.1.0
+env:
+  - TASK_ENV=task
+steps:
+  - id: build
+    cmd: golang go build
+    retries: 2
+  - id: lint
+    cmd: golang go vet
+    ignoreErrors: true
+    when: ["-"]
+  - id: test
+    cmd: golang go test
+    env:
+      - GOFLAGS=-mod=vendor
+    when: ["build"]
+  - id: release
+    cmd: bash release.sh
+    when: ["build", "lint"]
+    if: steps.build.outputs.channel == "stable"
+  - id: notify
+    cmd: bash notify.sh
+    when: ["lint"]
+    if: steps.lint.exitCode == 0
+`
+
+func loadTestTask(t *testing.T) *graph.Task {
+	task, err := graph.UnmarshalTaskFromString(context.Background(), testTask, &graph.TaskOptions{})
+	if err != nil {
+		t.Fatalf("failed to load task: %v", err)
+	}
+	for _, step := range task.Steps {
+		step.RetryDelayInSeconds = 0
+	}
+	return task
+}
+
+func TestRunTask(t *testing.T) {
+	task := loadTestTask(t)
+	rt := NewRuntime()
+	rt.SetStepResults("build",
+		StepResult{ExitCode: 1, Stderr: "flaky\n"},
+		StepResult{Stdout: "built\n", Outputs: map[string]string{"channel": "stable"}})
+	rt.SetStepResults("lint", StepResult{ExitCode: 2})
+
+	if err := rt.RunTask(context.Background(), task); err != nil {
+		t.Fatalf("failed to run task: %v", err)
+	}
+
+	runs := map[string]int{}
+	for _, id := range rt.StepRuns() {
+		runs[id]++
+	}
+	expectedRuns := map[string]int{"build": 2, "lint": 1, "test": 1, "release": 1}
+	if !reflect.DeepEqual(runs, expectedRuns) {
+		t.Errorf("expected runs %v but got %v", expectedRuns, runs)
+	}
+
+	expectedStatuses := map[string]graph.StepStatus{
+		"build": graph.Successful, "lint": graph.Successful, "test": graph.Successful, "release": graph.Successful, "notify": graph.Skipped,
+	}
+	for _, step := range task.Steps {
+		if step.StepStatus != expectedStatuses[step.ID] {
+			t.Errorf("expected step %s to be %s but got %s", step.ID, expectedStatuses[step.ID], step.StepStatus)
+		}
+	}
+
+	if lint := task.Steps[1]; lint.ExitCode != 2 {
+		t.Errorf("expected lint to exit with 2 but got %d", lint.ExitCode)
+	}
+
+	env := rt.Env("test")
+	for _, expected := range []string{"GOFLAGS=-mod=vendor", "TASK_ENV=task"} {
+		found := false
+		for _, e := range env {
+			if e == expected {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected step test to have env %s but got %v", expected, env)
+		}
+	}
+
+	var removed []string
+	var outputReads int
+	for _, op := range rt.Operations() {
+		if op.Kind == RemoveContainer {
+			removed = append(removed, op.Name)
+		}
+		if op.Kind == RunContainer && strings.HasPrefix(op.Name, "acb_read_outputs_") {
+			outputReads++
+		}
+	}
+	if len(removed) != 4 {
+		t.Errorf("expected the containers of all steps which weren't skipped to be removed but got %v", removed)
+	}
+	// Only the outputs of build are read, since lint failed and no step depends on the others.
+	if outputReads != 1 {
+		t.Errorf("expected the outputs of 1 step to be read but got %d", outputReads)
+	}
+}
+
+func TestRunTask_Timeout(t *testing.T) {
+	task := loadTestTask(t)
+	for _, step := range task.Steps {
+		step.Timeout = 1
+	}
+	rt := NewRuntime()
+	rt.SetStepResults("build", StepResult{Delay: time.Minute})
+
+	if err := rt.RunTask(context.Background(), task); err == nil {
+		t.Fatal("expected the task to fail when build times out")
+	}
+	// Steps aren't retried once they've timed out.
+	if runs := rt.StepRuns(); len(runs) != 3 {
+		t.Errorf("expected build, lint and notify to run once but got %v", runs)
+	}
+	if build := task.Steps[0]; build.StepStatus != graph.TimedOut {
+		t.Errorf("expected build to time out but got %s", build.StepStatus)
+	}
+
+	stopped := false
+	for _, op := range rt.Operations() {
+		if op.Kind == StopContainer && op.Name == "build" {
+			stopped = true
+		}
+	}
+	if !stopped {
+		t.Errorf("expected the container of build to be stopped but got %v", rt.Operations())
+	}
+}
+
+func TestRunTask_Cancelled(t *testing.T) {
+	task, err := graph.UnmarshalTaskFromString(context.Background(), `
+version: v1.1.0
+networks:
+  - name: acbtest
+steps:
+  - id: db
+    cmd: postgres
+    detach: true
+  - id: test
+    cmd: golang go test
+    network: acbtest
+`, &graph.TaskOptions{})
+	if err != nil {
+		t.Fatalf("failed to load task: %v", err)
+	}
+	rt := NewRuntime()
+	rt.SetStepResults("test", StepResult{Delay: time.Minute})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err = rt.RunTask(ctx, task); err == nil {
+		t.Fatal("expected the task to fail when it's cancelled")
+	}
+	if interrupted := task.InterruptedSteps(); !reflect.DeepEqual(interrupted, []string{"test"}) {
+		t.Errorf("expected test to be interrupted but got %v", interrupted)
+	}
+
+	// The detached step is stopped before the containers and the network are removed.
+	var teardown []Operation
+	for _, op := range rt.Operations() {
+		switch op.Kind {
+		case StopContainer, RemoveContainer, RemoveNetwork:
+			if op.Name == "db" || op.Name == "acbtest" {
+				teardown = append(teardown, Operation{Kind: op.Kind, Name: op.Name})
+			}
+		}
+	}
+	expected := []Operation{
+		{Kind: StopContainer, Name: "db"},
+		{Kind: RemoveContainer, Name: "db"},
+		{Kind: RemoveNetwork, Name: "acbtest"},
+	}
+	if !reflect.DeepEqual(teardown, expected) {
+		t.Errorf("expected teardown %v but got %v", expected, teardown)
+	}
+}
+
+const readinessTask = `
+version: v1.1.0
+steps:
+  - id: db
+    cmd: postgres
+    detach: true
+    readiness:
+      exec: ["pg_isready", "-U", "postgres"]
+      interval: 1
+      retries: 3
+  - id: test
+    cmd: golang go test
+    when: ["db"]
+`
+
+func TestRunTask_Readiness(t *testing.T) {
+	tests := []struct {
+		name             string
+		execResults      []StepResult
+		expectedExecs    int
+		expectedStatuses map[string]graph.StepStatus
+	}{
+		{
+			"ready after retries",
+			[]StepResult{{ExitCode: 2}, {ExitCode: 2}, {}},
+			3,
+			map[string]graph.StepStatus{"db": graph.Successful, "test": graph.Successful},
+		},
+		{
+			"never ready",
+			[]StepResult{{ExitCode: 2}},
+			3,
+			map[string]graph.StepStatus{"db": graph.Failed, "test": graph.Skipped},
+		},
+	}
+	for _, test := range tests {
+		task, err := graph.UnmarshalTaskFromString(context.Background(), readinessTask, &graph.TaskOptions{})
+		if err != nil {
+			t.Fatalf("failed to load task: %v", err)
+		}
+		rt := NewRuntime()
+		rt.SetExecResults("db", test.execResults...)
+		_ = rt.RunTask(context.Background(), task)
+
+		for _, step := range task.Steps {
+			if step.StepStatus != test.expectedStatuses[step.ID] {
+				t.Errorf("%s: expected step %s to be %s but got %s", test.name, step.ID, test.expectedStatuses[step.ID], step.StepStatus)
+			}
+		}
+		var execs int
+		var logs bool
+		for _, op := range rt.Operations() {
+			switch {
+			case op.Kind == ExecContainer && op.Name == "db":
+				execs++
+			case op.Kind == ContainerLogs && op.Name == "db":
+				logs = true
+			}
+		}
+		if execs != test.expectedExecs {
+			t.Errorf("%s: expected %d probes but got %d", test.name, test.expectedExecs, execs)
+		}
+		if !logs {
+			t.Errorf("%s: expected the logs of db to be collected", test.name)
+		}
+	}
+}
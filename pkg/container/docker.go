@@ -0,0 +1,573 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package container
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/acr-builder/pkg/procmanager"
+	"github.com/docker/docker/api/types"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+)
+
+const (
+	// dockerAPIVersion is the version of the Docker Engine API used, which is supported by Docker 19.03 and later.
+	dockerAPIVersion = "1.40"
+
+	// dockerHostEnv overrides the address of the Docker daemon.
+	dockerHostEnv = "DOCKER_HOST"
+
+	// removeTimeout bounds removing a container once its run is over, since the run's context may be done.
+	removeTimeout = 30 * time.Second
+)
+
+// DefaultDockerHost is the address of the Docker daemon if DOCKER_HOST isn't set.
+const DefaultDockerHost = client.DefaultDockerHost
+
+// DockerRuntime is a Runtime which uses the Docker Engine API.
+type DockerRuntime struct {
+	host   string
+	client *client.Client
+
+	// err is the error creating the client, e.g. for an invalid host, which is returned by every operation.
+	err error
+}
+
+// NewDockerRuntime creates a DockerRuntime which connects to the Docker daemon at the specified host,
+// e.g. unix:///var/run/docker.sock or tcp://127.0.0.1:2375.
+// If host is empty, the DOCKER_HOST environment variable or DefaultDockerHost is used.
+func NewDockerRuntime(host string) *DockerRuntime {
+	if host == "" {
+		host = os.Getenv(dockerHostEnv)
+	}
+	if host == "" {
+		host = DefaultDockerHost
+	}
+	c, err := client.NewClientWithOpts(client.WithHost(host), client.WithVersion(dockerAPIVersion))
+	if err != nil {
+		err = errors.Wrapf(err, "unsupported Docker host: %s", host)
+	}
+	return &DockerRuntime{host: host, client: c, err: err}
+}
+
+// Run creates and starts a container and returns its ID.
+func (d *DockerRuntime) Run(ctx context.Context, opts *RunOptions) (string, error) {
+	if d.err != nil {
+		return "", d.err
+	}
+	config, hostConfig, err := containerConfig(opts)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid options for container %s", opts.Name)
+	}
+	if opts.Pull {
+		if err = d.Pull(ctx, opts.Image, opts.PullAuth, opts.Stderr); err != nil {
+			return "", err
+		}
+	}
+	created, err := d.client.ContainerCreate(ctx, config, hostConfig, nil, nil, opts.Name)
+	if IsNotFound(err) {
+		// Pull the image if it doesn't exist locally, like `docker run`.
+		if pullErr := d.Pull(ctx, opts.Image, opts.PullAuth, opts.Stderr); pullErr != nil {
+			return "", pullErr
+		}
+		created, err = d.client.ContainerCreate(ctx, config, hostConfig, nil, nil, opts.Name)
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create container %s", opts.Name)
+	}
+	id := created.ID
+
+	if opts.Remove && !opts.Detach {
+		defer func() {
+			// The context may be done, but the container still has to be removed.
+			removeCtx, cancel := context.WithTimeout(context.Background(), removeTimeout)
+			defer cancel()
+			_ = d.Remove(removeCtx, id)
+		}()
+	}
+
+	// The container's standard input can only be written to by attaching to it before it starts.
+	var stream *types.HijackedResponse
+	if opts.Stdin != nil && !opts.Detach {
+		attached, attachErr := d.client.ContainerAttach(ctx, id, dockercontainer.AttachOptions{Stream: true, Stdin: true, Stdout: true, Stderr: true})
+		if attachErr != nil {
+			return id, errors.Wrapf(attachErr, "failed to attach to container %s", opts.Name)
+		}
+		stream = &attached
+		defer stream.Close()
+		// The attached connection must not outlive the run.
+		stop := context.AfterFunc(ctx, stream.Close)
+		defer stop()
+	}
+	if err = d.client.ContainerStart(ctx, id, dockercontainer.StartOptions{}); err != nil {
+		return id, errors.Wrapf(err, "failed to start container %s", opts.Name)
+	}
+	if opts.Detach {
+		return id, nil
+	}
+
+	outputDone := make(chan error, 1)
+	if stream != nil {
+		go func() {
+			_, _ = io.Copy(stream.Conn, opts.Stdin)
+			_ = stream.CloseWrite()
+		}()
+		go func() {
+			_, copyErr := stdcopy.StdCopy(writerOrDiscard(opts.Stdout), writerOrDiscard(opts.Stderr), stream.Reader)
+			outputDone <- copyErr
+		}()
+	} else {
+		go func() {
+			outputDone <- d.Logs(ctx, id, true, opts.Stdout, opts.Stderr)
+		}()
+	}
+
+	code, err := d.wait(ctx, id)
+	if err != nil {
+		if ctx.Err() == nil {
+			return id, err
+		}
+		timeout := opts.StopTimeout
+		if timeout <= 0 {
+			timeout = DefaultStopTimeout
+		}
+		stopCtx, cancel := context.WithTimeout(context.Background(), timeout+DefaultStopTimeout)
+		defer cancel()
+		if stopErr := d.Stop(stopCtx, id, timeout); stopErr != nil {
+			return id, errors.Wrapf(stopErr, "failed to stop container %s", opts.Name)
+		}
+		return id, errors.Wrapf(ctx.Err(), "stopped container %s", opts.Name)
+	}
+	if err = <-outputDone; err != nil {
+		return id, errors.Wrapf(err, "failed to read the output of container %s", opts.Name)
+	}
+	if code != 0 {
+		return id, &procmanager.ExitError{Code: code, Err: fmt.Errorf("container %s exited with code %d", opts.Name, code)}
+	}
+	return id, nil
+}
+
+// containerConfig returns the configuration of the described container, parsing its options
+// like the docker CLI does.
+func containerConfig(opts *RunOptions) (*dockercontainer.Config, *dockercontainer.HostConfig, error) {
+	exposedPorts, portBindings, err := nat.ParsePortSpecs(opts.Ports)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid ports")
+	}
+	for _, expose := range opts.Expose {
+		proto, port := nat.SplitProtoPort(expose)
+		start, end, err := nat.ParsePortRange(port)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid exposed port %s", expose)
+		}
+		for i := start; i <= end; i++ {
+			p, err := nat.NewPort(proto, strconv.FormatUint(i, 10))
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "invalid exposed port %s", expose)
+			}
+			exposedPorts[p] = struct{}{}
+		}
+	}
+
+	hostConfig := &dockercontainer.HostConfig{
+		Binds:          opts.Binds,
+		NetworkMode:    dockercontainer.NetworkMode(opts.Network),
+		Privileged:     opts.Privileged,
+		Isolation:      dockercontainer.Isolation(opts.Isolation),
+		PortBindings:   portBindings,
+		CapAdd:         opts.CapAdd,
+		CapDrop:        opts.CapDrop,
+		SecurityOpt:    opts.SecurityOpt,
+		ReadonlyRootfs: opts.ReadOnly,
+		DNS:            opts.DNS,
+		ExtraHosts:     opts.ExtraHosts,
+	}
+	if opts.CPUs != "" {
+		cpus, err := strconv.ParseFloat(opts.CPUs, 64)
+		if err != nil || cpus < 0 {
+			return nil, nil, fmt.Errorf("invalid CPUs %s", opts.CPUs)
+		}
+		hostConfig.NanoCPUs = int64(cpus * 1e9)
+	}
+	for _, size := range []struct {
+		name  string
+		value string
+		bytes *int64
+	}{{"Memory", opts.Memory, &hostConfig.Memory}, {"ShmSize", opts.ShmSize, &hostConfig.ShmSize}} {
+		if size.value == "" {
+			continue
+		}
+		bytes, err := units.RAMInBytes(size.value)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid %s", size.name)
+		}
+		*size.bytes = bytes
+	}
+	if opts.MemorySwap == "-1" {
+		hostConfig.MemorySwap = -1
+	} else if opts.MemorySwap != "" {
+		bytes, err := units.RAMInBytes(opts.MemorySwap)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid MemorySwap")
+		}
+		hostConfig.MemorySwap = bytes
+	}
+	if opts.PidsLimit != 0 {
+		hostConfig.PidsLimit = &opts.PidsLimit
+	}
+	for _, u := range opts.Ulimits {
+		ulimit, err := units.ParseUlimit(u)
+		if err != nil {
+			return nil, nil, err
+		}
+		hostConfig.Ulimits = append(hostConfig.Ulimits, ulimit)
+	}
+	if len(opts.Tmpfs) > 0 {
+		hostConfig.Tmpfs = make(map[string]string)
+	}
+	for _, t := range opts.Tmpfs {
+		path, options, _ := strings.Cut(t, ":")
+		hostConfig.Tmpfs[path] = options
+	}
+	for _, device := range opts.Devices {
+		mapping, err := parseDevice(device)
+		if err != nil {
+			return nil, nil, err
+		}
+		hostConfig.Devices = append(hostConfig.Devices, mapping)
+	}
+	labels := make(map[string]string)
+	for _, label := range opts.Labels {
+		key, value, _ := strings.Cut(label, "=")
+		labels[key] = value
+	}
+
+	config := &dockercontainer.Config{
+		Image:        opts.Image,
+		Entrypoint:   opts.Entrypoint,
+		Cmd:          opts.Cmd,
+		Env:          opts.Env,
+		WorkingDir:   opts.WorkingDir,
+		User:         opts.User,
+		ExposedPorts: exposedPorts,
+		Labels:       labels,
+	}
+	if opts.Stdin != nil && !opts.Detach {
+		config.AttachStdin = true
+		config.AttachStdout = true
+		config.AttachStderr = true
+		config.OpenStdin = true
+		config.StdinOnce = true
+	}
+	return config, hostConfig, nil
+}
+
+// parseDevice parses a device in the form of hostPath[:containerPath][:permissions].
+func parseDevice(device string) (dockercontainer.DeviceMapping, error) {
+	parts := strings.Split(device, ":")
+	mapping := dockercontainer.DeviceMapping{PathOnHost: parts[0], PathInContainer: parts[0], CgroupPermissions: "rwm"}
+	switch len(parts) {
+	case 1:
+	case 2:
+		if isDevicePermissions(parts[1]) {
+			mapping.CgroupPermissions = parts[1]
+		} else {
+			mapping.PathInContainer = parts[1]
+		}
+	case 3:
+		if !isDevicePermissions(parts[2]) {
+			return mapping, fmt.Errorf("invalid device permissions in %s", device)
+		}
+		mapping.PathInContainer = parts[1]
+		mapping.CgroupPermissions = parts[2]
+	default:
+		return mapping, fmt.Errorf("invalid device %s", device)
+	}
+	return mapping, nil
+}
+
+func isDevicePermissions(permissions string) bool {
+	if permissions == "" {
+		return false
+	}
+	for _, c := range permissions {
+		if c != 'r' && c != 'w' && c != 'm' {
+			return false
+		}
+	}
+	return true
+}
+
+func writerOrDiscard(w io.Writer) io.Writer {
+	if w == nil {
+		return io.Discard
+	}
+	return w
+}
+
+// wait waits for a container to exit and returns its exit code.
+func (d *DockerRuntime) wait(ctx context.Context, id string) (int, error) {
+	statusCh, errCh := d.client.ContainerWait(ctx, id, dockercontainer.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return -1, errors.Wrapf(err, "failed to wait for container %s", id)
+	case status := <-statusCh:
+		if status.Error != nil && status.Error.Message != "" {
+			return -1, fmt.Errorf("failed to wait for container %s: %s", id, status.Error.Message)
+		}
+		return int(status.StatusCode), nil
+	}
+}
+
+// Stop stops a container, killing it if it doesn't exit within the timeout.
+func (d *DockerRuntime) Stop(ctx context.Context, id string, timeout time.Duration) error {
+	if d.err != nil {
+		return d.err
+	}
+	seconds := int(timeout.Seconds())
+	return d.client.ContainerStop(ctx, id, dockercontainer.StopOptions{Timeout: &seconds})
+}
+
+// Remove forcibly removes a container.
+func (d *DockerRuntime) Remove(ctx context.Context, id string) error {
+	if d.err != nil {
+		return d.err
+	}
+	return d.client.ContainerRemove(ctx, id, dockercontainer.RemoveOptions{Force: true})
+}
+
+// Inspect returns the state of a container.
+func (d *DockerRuntime) Inspect(ctx context.Context, id string) (*Info, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	c, err := d.client.ContainerInspect(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{
+		ID:   c.ID,
+		Name: strings.TrimPrefix(c.Name, "/"),
+	}
+	if c.Config != nil {
+		info.Image = c.Config.Image
+	}
+	if c.State != nil {
+		info.Status = c.State.Status
+		info.Running = c.State.Running
+		info.ExitCode = c.State.ExitCode
+		info.StartedAt, _ = time.Parse(time.RFC3339Nano, c.State.StartedAt)
+		info.FinishedAt, _ = time.Parse(time.RFC3339Nano, c.State.FinishedAt)
+	}
+	if c.NetworkSettings != nil {
+		for network, settings := range c.NetworkSettings.Networks {
+			if settings == nil || settings.IPAddress == "" {
+				continue
+			}
+			if info.IPAddresses == nil {
+				info.IPAddresses = make(map[string]string)
+			}
+			info.IPAddresses[network] = settings.IPAddress
+		}
+	}
+	return info, nil
+}
+
+// Logs writes the output of a container to stdout and stderr.
+func (d *DockerRuntime) Logs(ctx context.Context, id string, follow bool, stdout io.Writer, stderr io.Writer) error {
+	if d.err != nil {
+		return d.err
+	}
+	c, err := d.client.ContainerInspect(ctx, id)
+	if err != nil {
+		return err
+	}
+	stdout, stderr = writerOrDiscard(stdout), writerOrDiscard(stderr)
+
+	rc, err := d.client.ContainerLogs(ctx, id, dockercontainer.LogsOptions{ShowStdout: true, ShowStderr: true, Follow: follow})
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	// The output of containers without a TTY is multiplexed.
+	if c.Config != nil && c.Config.Tty {
+		_, err = io.Copy(stdout, rc)
+		return err
+	}
+	_, err = stdcopy.StdCopy(stdout, stderr, rc)
+	return err
+}
+
+// Exec runs a command in a running container, writing its output to stdout and stderr.
+func (d *DockerRuntime) Exec(ctx context.Context, id string, cmd []string, stdout io.Writer, stderr io.Writer) error {
+	if d.err != nil {
+		return d.err
+	}
+	created, err := d.client.ContainerExecCreate(ctx, id, types.ExecConfig{AttachStdout: true, AttachStderr: true, Cmd: cmd})
+	if err != nil {
+		return errors.Wrapf(err, "failed to create exec in container %s", id)
+	}
+
+	resp, err := d.client.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to start exec in container %s", id)
+	}
+	defer resp.Close()
+	stop := context.AfterFunc(ctx, resp.Close)
+	defer stop()
+	if _, err = stdcopy.StdCopy(writerOrDiscard(stdout), writerOrDiscard(stderr), resp.Reader); err != nil {
+		return errors.Wrapf(err, "failed to read the output of exec in container %s", id)
+	}
+
+	inspected, err := d.client.ContainerExecInspect(ctx, created.ID)
+	if err != nil {
+		return errors.Wrapf(err, "failed to inspect exec in container %s", id)
+	}
+	if inspected.ExitCode != 0 {
+		return &procmanager.ExitError{Code: inspected.ExitCode, Err: fmt.Errorf("%s exited with code %d", strings.Join(cmd, " "), inspected.ExitCode)}
+	}
+	return nil
+}
+
+// Pull pulls an image, writing its progress to out.
+func (d *DockerRuntime) Pull(ctx context.Context, image string, auth *AuthConfig, out io.Writer) error {
+	if d.err != nil {
+		return d.err
+	}
+	encodedAuth, err := encodeAuthConfig(auth)
+	if err != nil {
+		return err
+	}
+	rc, err := d.client.ImagePull(ctx, image, types.ImagePullOptions{RegistryAuth: encodedAuth})
+	if err != nil {
+		return errors.Wrapf(err, "failed to pull %s", image)
+	}
+	defer rc.Close()
+	return errors.Wrapf(displayProgress(rc, out), "failed to pull %s", image)
+}
+
+// Push pushes an image, writing its progress to out.
+func (d *DockerRuntime) Push(ctx context.Context, image string, auth *AuthConfig, out io.Writer) error {
+	if d.err != nil {
+		return d.err
+	}
+	encodedAuth, err := encodeAuthConfig(auth)
+	if err != nil {
+		return err
+	}
+	rc, err := d.client.ImagePush(ctx, image, types.ImagePushOptions{RegistryAuth: encodedAuth})
+	if err != nil {
+		return errors.Wrapf(err, "failed to push %s", image)
+	}
+	defer rc.Close()
+	return errors.Wrapf(displayProgress(rc, out), "failed to push %s", image)
+}
+
+// encodeAuthConfig encodes the credentials for the X-Registry-Auth header.
+func encodeAuthConfig(auth *AuthConfig) (string, error) {
+	if auth == nil {
+		auth = &AuthConfig{}
+	}
+	encoded, err := registry.EncodeAuthConfig(registry.AuthConfig{
+		Username:      auth.Username,
+		Password:      auth.Password,
+		ServerAddress: auth.ServerAddress,
+		IdentityToken: auth.IdentityToken,
+	})
+	return encoded, errors.Wrap(err, "failed to encode registry credentials")
+}
+
+// displayProgress writes the JSON progress messages of a pull or push and returns the first error reported in them.
+func displayProgress(r io.Reader, out io.Writer) error {
+	if out == nil {
+		out = io.Discard
+	}
+	return jsonmessage.DisplayJSONMessagesStream(r, out, 0, false, nil)
+}
+
+// CreateNetwork creates a network.
+func (d *DockerRuntime) CreateNetwork(ctx context.Context, name string, opts *NetworkOptions) error {
+	if d.err != nil {
+		return d.err
+	}
+	if opts == nil {
+		opts = &NetworkOptions{}
+	}
+	_, err := d.client.NetworkCreate(ctx, name, types.NetworkCreate{
+		Driver:         opts.Driver,
+		EnableIPv6:     opts.IPv6,
+		CheckDuplicate: true,
+	})
+	return err
+}
+
+// RemoveNetwork removes a network.
+func (d *DockerRuntime) RemoveNetwork(ctx context.Context, name string) error {
+	if d.err != nil {
+		return d.err
+	}
+	return d.client.NetworkRemove(ctx, name)
+}
+
+// CreateVolume creates a volume.
+func (d *DockerRuntime) CreateVolume(ctx context.Context, name string) error {
+	if d.err != nil {
+		return d.err
+	}
+	_, err := d.client.VolumeCreate(ctx, volume.CreateOptions{Name: name})
+	return err
+}
+
+// RemoveVolume removes a volume.
+func (d *DockerRuntime) RemoveVolume(ctx context.Context, name string) error {
+	if d.err != nil {
+		return d.err
+	}
+	return d.client.VolumeRemove(ctx, name, false)
+}
+
+// RegistryConfig returns the daemon's registry configuration, i.e. its insecure-registries.
+func (d *DockerRuntime) RegistryConfig(ctx context.Context) (*RegistryConfig, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	info, err := d.client.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &RegistryConfig{}
+	if info.RegistryConfig == nil {
+		return config, nil
+	}
+	for name, index := range info.RegistryConfig.IndexConfigs {
+		if index != nil && !index.Secure {
+			config.InsecureRegistries = append(config.InsecureRegistries, name)
+		}
+	}
+	sort.Strings(config.InsecureRegistries)
+	for _, cidr := range info.RegistryConfig.InsecureRegistryCIDRs {
+		if cidr != nil {
+			config.InsecureRegistryCIDRs = append(config.InsecureRegistryCIDRs, (*net.IPNet)(cidr))
+		}
+	}
+	return config, nil
+}
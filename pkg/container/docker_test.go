@@ -0,0 +1,397 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package container
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/acr-builder/pkg/procmanager"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/registry"
+)
+
+// testEngine is a minimal stand-in for the Docker Engine API.
+type testEngine struct {
+	mu       sync.Mutex
+	requests []string
+	images   map[string]bool
+	pulled   bool
+	exitCode int
+	execCode int
+	block    chan struct{}
+	auth     string
+}
+
+func newTestEngine(t *testing.T) (*testEngine, *DockerRuntime) {
+	e := &testEngine{images: map[string]bool{}}
+	s := httptest.NewServer(e)
+	t.Cleanup(s.Close)
+	return e, NewDockerRuntime("tcp://" + strings.TrimPrefix(s.URL, "http://"))
+}
+
+func (e *testEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v"+dockerAPIVersion)
+	e.mu.Lock()
+	e.requests = append(e.requests, r.Method+" "+path)
+	e.mu.Unlock()
+
+	switch {
+	case path == "/containers/create":
+		var config struct{ Image string }
+		_ = json.NewDecoder(r.Body).Decode(&config)
+		e.mu.Lock()
+		exists := e.images[config.Image] || e.pulled
+		e.mu.Unlock()
+		if !exists {
+			writeError(w, http.StatusNotFound, "No such image: "+config.Image)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"Id": "id-" + r.URL.Query().Get("name")})
+	case path == "/images/create":
+		if r.URL.Query().Get("fromImage") == "missing" {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"errorDetail": map[string]string{"message": "manifest unknown"}, "error": "manifest unknown"})
+			return
+		}
+		e.mu.Lock()
+		e.auth = r.Header.Get(registry.AuthHeader)
+		e.pulled = true
+		e.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": "Downloaded newer image"})
+	case path == "/containers/foo/exec":
+		_ = json.NewEncoder(w).Encode(map[string]string{"Id": "exec-foo"})
+	case path == "/exec/exec-foo/start":
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 101 UPGRADED\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		writeFrame(rw, 1, "ready\n")
+		_ = rw.Flush()
+	case path == "/exec/exec-foo/json":
+		_ = json.NewEncoder(w).Encode(map[string]int{"ExitCode": e.execCode})
+	case strings.HasSuffix(path, "/start"), strings.HasSuffix(path, "/stop"), r.Method == http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasSuffix(path, "/wait"):
+		if e.block != nil {
+			select {
+			case <-e.block:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]int{"StatusCode": e.exitCode})
+	case strings.HasSuffix(path, "/attach"):
+		// Echo the container's standard input to its output once it's closed.
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 101 UPGRADED\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		_ = rw.Flush()
+		stdin, _ := io.ReadAll(rw)
+		writeFrame(rw, 1, "stdin: "+string(stdin))
+		_ = rw.Flush()
+	case strings.HasSuffix(path, "/logs"):
+		writeFrame(w, 1, "hello\n")
+		writeFrame(w, 2, "oops\n")
+	case path == "/containers/foo/json", path == "/containers/id-foo/json":
+		_, _ = fmt.Fprint(w, `{"Id":"id-foo","Name":"/foo","Config":{"Image":"alpine"},"State":{"Status":"exited","ExitCode":3,"FinishedAt":"2021-01-02T03:04:05Z"},"NetworkSettings":{"Networks":{"bridge":{"IPAddress":"172.17.0.2"},"none":{"IPAddress":""}}}}`)
+	case path == "/info":
+		_, _ = fmt.Fprint(w, `{"RegistryConfig":{"InsecureRegistryCIDRs":["127.0.0.0/8"],"IndexConfigs":{"docker.io":{"Secure":true},"myregistry:5000":{"Secure":false}}}}`)
+	case path == "/networks/create":
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]string{"Id": "network-foo"})
+	default:
+		writeError(w, http.StatusNotFound, "not found: "+path)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": msg})
+}
+
+func writeFrame(w io.Writer, stream byte, data string) {
+	header := []byte{stream, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+	_, _ = w.Write(append(header, data...))
+}
+
+func (e *testEngine) Requests() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.requests...)
+}
+
+func TestDockerRuntime_Run(t *testing.T) {
+	e, rt := newTestEngine(t)
+	e.exitCode = 2
+	var stdout, stderr bytes.Buffer
+	auth := &AuthConfig{Username: "user", Password: "pw"}
+
+	id, err := rt.Run(context.Background(), &RunOptions{Name: "foo", Image: "alpine", Remove: true, PullAuth: auth, Stdout: &stdout, Stderr: &stderr})
+	if id != "id-foo" {
+		t.Errorf("expected the container ID id-foo but got %s", id)
+	}
+	exitErr, ok := err.(*procmanager.ExitError)
+	if !ok || exitErr.Code != 2 {
+		t.Fatalf("expected an exit error with code 2 but got %v", err)
+	}
+	if stdout.String() != "hello\n" || !strings.HasSuffix(stderr.String(), "oops\n") {
+		t.Errorf("unexpected output, stdout: %q, stderr: %q", stdout.String(), stderr.String())
+	}
+
+	e.mu.Lock()
+	data, _ := base64.URLEncoding.DecodeString(e.auth)
+	e.mu.Unlock()
+	var pullAuth AuthConfig
+	if err = json.Unmarshal(data, &pullAuth); err != nil || pullAuth != *auth {
+		t.Errorf("expected the image to be pulled with %v but got %s", auth, data)
+	}
+
+	expected := []string{
+		"POST /containers/create",
+		"POST /images/create",
+		"POST /containers/create",
+		"POST /containers/id-foo/start",
+		"POST /containers/id-foo/wait",
+		"DELETE /containers/id-foo",
+	}
+	for _, req := range expected {
+		found := false
+		for _, actual := range e.Requests() {
+			if actual == req {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected request %s but got %v", req, e.Requests())
+		}
+	}
+}
+
+func TestDockerRuntime_RunWithStdin(t *testing.T) {
+	e, rt := newTestEngine(t)
+	e.images["docker"] = true
+	var stdout bytes.Buffer
+	opts := &RunOptions{Name: "foo", Image: "docker", Stdin: strings.NewReader("pw\n"), Stdout: &stdout}
+	if _, err := rt.Run(context.Background(), opts); err != nil {
+		t.Fatalf("failed to run container: %v", err)
+	}
+	if stdout.String() != "stdin: pw\n" {
+		t.Errorf("expected the container to read its standard input but got %q", stdout.String())
+	}
+	for _, req := range e.Requests() {
+		if strings.HasSuffix(req, "/logs") {
+			t.Errorf("expected the output to be read from the attached stream but got %v", e.Requests())
+		}
+	}
+}
+
+func TestDockerRuntime_RunPull(t *testing.T) {
+	e, rt := newTestEngine(t)
+	e.images["alpine"] = true
+	if _, err := rt.Run(context.Background(), &RunOptions{Name: "foo", Image: "alpine", Pull: true}); err != nil {
+		t.Fatalf("failed to run container: %v", err)
+	}
+	if requests := e.Requests(); len(requests) == 0 || requests[0] != "POST /images/create" {
+		t.Errorf("expected the image to be pulled before creating the container but got %v", requests)
+	}
+}
+
+func TestContainerConfig(t *testing.T) {
+	opts := &RunOptions{
+		Image:      "alpine",
+		Ports:      []string{"8080:80"},
+		Expose:     []string{"90-91/udp"},
+		CPUs:       "1.5",
+		Memory:     "512m",
+		MemorySwap: "-1",
+		ShmSize:    "64m",
+		PidsLimit:  100,
+		Ulimits:    []string{"nofile=1024:2048"},
+		ReadOnly:   true,
+		Tmpfs:      []string{"/tmp:size=64m", "/run"},
+		Labels:     []string{"team=acr"},
+		Devices:    []string{"/dev/fuse", "/dev/sda:/dev/xvda:r"},
+	}
+	config, hostConfig, err := containerConfig(opts)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	data, _ := json.Marshal(struct {
+		*dockercontainer.Config
+		HostConfig *dockercontainer.HostConfig
+	}{config, hostConfig})
+	var actual struct {
+		ExposedPorts map[string]struct{}
+		Labels       map[string]string
+		HostConfig   struct {
+			PortBindings   map[string][]map[string]string
+			NanoCpus       int64
+			Memory         int64
+			MemorySwap     int64
+			ShmSize        int64
+			PidsLimit      int64
+			Ulimits        []map[string]interface{}
+			ReadonlyRootfs bool
+			Tmpfs          map[string]string
+			Devices        []map[string]string
+		}
+	}
+	if err = json.Unmarshal(data, &actual); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if len(actual.ExposedPorts) != 3 || actual.HostConfig.PortBindings["80/tcp"][0]["HostPort"] != "8080" {
+		t.Errorf("unexpected ports, exposed: %v, bindings: %v", actual.ExposedPorts, actual.HostConfig.PortBindings)
+	}
+	hc := actual.HostConfig
+	if hc.NanoCpus != 1500000000 || hc.Memory != 512<<20 || hc.MemorySwap != -1 || hc.ShmSize != 64<<20 || hc.PidsLimit != 100 {
+		t.Errorf("unexpected resource limits: %+v", hc)
+	}
+	if len(hc.Ulimits) != 1 || hc.Ulimits[0]["Name"] != "nofile" || hc.Ulimits[0]["Soft"] != float64(1024) || hc.Ulimits[0]["Hard"] != float64(2048) {
+		t.Errorf("unexpected ulimits: %v", hc.Ulimits)
+	}
+	if !hc.ReadonlyRootfs || !reflect.DeepEqual(hc.Tmpfs, map[string]string{"/tmp": "size=64m", "/run": ""}) {
+		t.Errorf("unexpected filesystem options, read only: %v, tmpfs: %v", hc.ReadonlyRootfs, hc.Tmpfs)
+	}
+	expectedDevices := []map[string]string{
+		{"PathOnHost": "/dev/fuse", "PathInContainer": "/dev/fuse", "CgroupPermissions": "rwm"},
+		{"PathOnHost": "/dev/sda", "PathInContainer": "/dev/xvda", "CgroupPermissions": "r"},
+	}
+	if !reflect.DeepEqual(hc.Devices, expectedDevices) {
+		t.Errorf("expected devices %v but got %v", expectedDevices, hc.Devices)
+	}
+	if !reflect.DeepEqual(actual.Labels, map[string]string{"team": "acr"}) {
+		t.Errorf("unexpected labels: %v", actual.Labels)
+	}
+
+	for _, invalid := range []*RunOptions{
+		{Image: "alpine", Memory: "lots"},
+		{Image: "alpine", CPUs: "one"},
+		{Image: "alpine", Ulimits: []string{"nofile"}},
+		{Image: "alpine", Ports: []string{"80:80:80:80"}},
+		{Image: "alpine", Devices: []string{"/dev/sda:/dev/xvda:x"}},
+	} {
+		if _, _, err = containerConfig(invalid); err == nil {
+			t.Errorf("expected options %+v to be invalid", invalid)
+		}
+	}
+}
+
+func TestDockerRuntime_RunCanceled(t *testing.T) {
+	e, rt := newTestEngine(t)
+	e.images["alpine"] = true
+	e.block = make(chan struct{})
+	defer close(e.block)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := rt.Run(ctx, &RunOptions{Name: "foo", Image: "alpine", StopTimeout: time.Second}); err == nil {
+		t.Fatal("expected an error running a canceled container")
+	}
+	stopped := false
+	for _, req := range e.Requests() {
+		if req == "POST /containers/id-foo/stop" {
+			stopped = true
+		}
+	}
+	if !stopped {
+		t.Errorf("expected the container to be stopped but got %v", e.Requests())
+	}
+}
+
+func TestDockerRuntime_Inspect(t *testing.T) {
+	_, rt := newTestEngine(t)
+	info, err := rt.Inspect(context.Background(), "foo")
+	if err != nil {
+		t.Fatalf("failed to inspect container: %v", err)
+	}
+	expected := &Info{
+		ID:          "id-foo",
+		Name:        "foo",
+		Image:       "alpine",
+		Status:      "exited",
+		ExitCode:    3,
+		FinishedAt:  time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC),
+		IPAddresses: map[string]string{"bridge": "172.17.0.2"},
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("expected %+v but got %+v", expected, info)
+	}
+
+	if _, err = rt.Inspect(context.Background(), "bar"); !IsNotFound(err) {
+		t.Errorf("expected a not found error but got %v", err)
+	}
+}
+
+func TestDockerRuntime_Exec(t *testing.T) {
+	e, rt := newTestEngine(t)
+	var stdout bytes.Buffer
+	if err := rt.Exec(context.Background(), "foo", []string{"pg_isready"}, &stdout, nil); err != nil {
+		t.Fatalf("failed to exec: %v", err)
+	}
+	if stdout.String() != "ready\n" {
+		t.Errorf("expected the output ready but got %q", stdout.String())
+	}
+
+	e.execCode = 2
+	err := rt.Exec(context.Background(), "foo", []string{"pg_isready"}, nil, nil)
+	if exitErr, ok := err.(*procmanager.ExitError); !ok || exitErr.Code != 2 {
+		t.Errorf("expected an exit error with code 2 but got %v", err)
+	}
+}
+
+func TestDockerRuntime_Pull(t *testing.T) {
+	_, rt := newTestEngine(t)
+	if err := rt.Pull(context.Background(), "missing", nil, nil); err == nil || !strings.Contains(err.Error(), "manifest unknown") {
+		t.Errorf("expected the pull error to be returned but got %v", err)
+	}
+	if err := rt.CreateNetwork(context.Background(), "foo", &NetworkOptions{Driver: "bridge"}); err != nil {
+		t.Errorf("failed to create network: %v", err)
+	}
+}
+
+func TestDockerRuntime_RegistryConfig(t *testing.T) {
+	_, rt := newTestEngine(t)
+	config, err := rt.RegistryConfig(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if expected := []string{"myregistry:5000"}; !reflect.DeepEqual(expected, config.InsecureRegistries) {
+		t.Errorf("expected insecure registries %v but got %v", expected, config.InsecureRegistries)
+	}
+
+	tests := []struct {
+		host     string
+		insecure bool
+	}{
+		{"myregistry:5000", true},
+		{"myregistry", false},
+		{"127.0.0.1:5000", true},
+		{"10.0.0.1", false},
+	}
+	for _, test := range tests {
+		if insecure := config.IsInsecure(test.host); insecure != test.insecure {
+			t.Errorf("expected %s to be insecure: %v but got %v", test.host, test.insecure, insecure)
+		}
+	}
+}
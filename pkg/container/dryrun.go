@@ -0,0 +1,83 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package container
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+)
+
+// dryRunRuntime logs the operations it's asked to perform without performing them.
+type dryRunRuntime struct{}
+
+// NewDryRunRuntime creates a Runtime which only logs its operations.
+func NewDryRunRuntime() Runtime {
+	return &dryRunRuntime{}
+}
+
+func (r *dryRunRuntime) Run(_ context.Context, opts *RunOptions) (string, error) {
+	log.Printf("[DRY RUN] Running container: %s, image: %s, entrypoint: %v, cmd: %v\n", opts.Name, opts.Image, opts.Entrypoint, opts.Cmd)
+	return opts.Name, nil
+}
+
+func (r *dryRunRuntime) Stop(_ context.Context, id string, timeout time.Duration) error {
+	log.Printf("[DRY RUN] Stopping container: %s, timeout: %v\n", id, timeout)
+	return nil
+}
+
+func (r *dryRunRuntime) Remove(_ context.Context, id string) error {
+	log.Printf("[DRY RUN] Removing container: %s\n", id)
+	return nil
+}
+
+func (r *dryRunRuntime) Inspect(_ context.Context, id string) (*Info, error) {
+	log.Printf("[DRY RUN] Inspecting container: %s\n", id)
+	return &Info{ID: id, Name: id, Status: "exited"}, nil
+}
+
+func (r *dryRunRuntime) Logs(_ context.Context, id string, _ bool, _ io.Writer, _ io.Writer) error {
+	log.Printf("[DRY RUN] Reading logs of container: %s\n", id)
+	return nil
+}
+
+func (r *dryRunRuntime) Exec(_ context.Context, id string, cmd []string, _ io.Writer, _ io.Writer) error {
+	log.Printf("[DRY RUN] Running command in container: %s, cmd: %v\n", id, cmd)
+	return nil
+}
+
+func (r *dryRunRuntime) Pull(_ context.Context, image string, _ *AuthConfig, _ io.Writer) error {
+	log.Printf("[DRY RUN] Pulling image: %s\n", image)
+	return nil
+}
+
+func (r *dryRunRuntime) Push(_ context.Context, image string, _ *AuthConfig, _ io.Writer) error {
+	log.Printf("[DRY RUN] Pushing image: %s\n", image)
+	return nil
+}
+
+func (r *dryRunRuntime) CreateNetwork(_ context.Context, name string, opts *NetworkOptions) error {
+	log.Printf("[DRY RUN] Creating network: %s, options: %+v\n", name, opts)
+	return nil
+}
+
+func (r *dryRunRuntime) RemoveNetwork(_ context.Context, name string) error {
+	log.Printf("[DRY RUN] Removing network: %s\n", name)
+	return nil
+}
+
+func (r *dryRunRuntime) CreateVolume(_ context.Context, name string) error {
+	log.Printf("[DRY RUN] Creating volume: %s\n", name)
+	return nil
+}
+
+func (r *dryRunRuntime) RemoveVolume(_ context.Context, name string) error {
+	log.Printf("[DRY RUN] Removing volume: %s\n", name)
+	return nil
+}
+
+func (r *dryRunRuntime) RegistryConfig(_ context.Context) (*RegistryConfig, error) {
+	return &RegistryConfig{}, nil
+}
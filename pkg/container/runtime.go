@@ -0,0 +1,245 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+// Package container provides an abstraction over the container runtime used to execute steps.
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+)
+
+// DefaultStopTimeout is the time a container is given to exit after being asked to stop before it's killed.
+const DefaultStopTimeout = 10 * time.Second
+
+// Runtime runs and manages containers, images, networks and volumes.
+type Runtime interface {
+	// Run creates and starts a container and returns its ID.
+	// Unless the container is detached, Run streams its output and waits for it to exit.
+	// If it exits with a non-zero exit code, a *procmanager.ExitError is returned.
+	// If the context is done before it exits, the container is stopped.
+	Run(ctx context.Context, opts *RunOptions) (string, error)
+
+	// Stop stops a container, killing it if it doesn't exit within the timeout.
+	Stop(ctx context.Context, id string, timeout time.Duration) error
+
+	// Remove forcibly removes a container.
+	Remove(ctx context.Context, id string) error
+
+	// Inspect returns the state of a container.
+	Inspect(ctx context.Context, id string) (*Info, error)
+
+	// Logs writes the output of a container to stdout and stderr.
+	// If follow is true, Logs streams the output until the container exits.
+	Logs(ctx context.Context, id string, follow bool, stdout io.Writer, stderr io.Writer) error
+
+	// Exec runs a command in a running container, writing its output to stdout and stderr.
+	// If the command exits with a non-zero exit code, a *procmanager.ExitError is returned.
+	Exec(ctx context.Context, id string, cmd []string, stdout io.Writer, stderr io.Writer) error
+
+	// Pull pulls an image, writing its progress to out.
+	Pull(ctx context.Context, image string, auth *AuthConfig, out io.Writer) error
+
+	// Push pushes an image, writing its progress to out.
+	Push(ctx context.Context, image string, auth *AuthConfig, out io.Writer) error
+
+	// CreateNetwork creates a network.
+	CreateNetwork(ctx context.Context, name string, opts *NetworkOptions) error
+
+	// RemoveNetwork removes a network.
+	RemoveNetwork(ctx context.Context, name string) error
+
+	// CreateVolume creates a volume.
+	CreateVolume(ctx context.Context, name string) error
+
+	// RemoveVolume removes a volume.
+	RemoveVolume(ctx context.Context, name string) error
+
+	// RegistryConfig returns how the runtime accesses registries, e.g. which of them are insecure.
+	RegistryConfig(ctx context.Context) (*RegistryConfig, error)
+}
+
+// RunOptions describes a container to run.
+type RunOptions struct {
+	Name       string
+	Image      string
+	Entrypoint []string
+	Cmd        []string
+	Env        []string
+	WorkingDir string
+	User       string
+
+	// Binds are volume mappings in the form of source:destination[:options].
+	Binds      []string
+	Network    string
+	Privileged bool
+	Isolation  string
+
+	// Ports are published ports in the form of [ip:][hostPort:]containerPort[/protocol],
+	// and Expose are exposed ports in the form of port[-port][/protocol].
+	Ports  []string
+	Expose []string
+
+	// CPUs is the number of CPUs the container can use, e.g. 1.5. Memory, MemorySwap and ShmSize are sizes
+	// such as 512m, and MemorySwap can be -1 for unlimited swap. Ulimits are in the form of name=soft[:hard].
+	CPUs       string
+	Memory     string
+	MemorySwap string
+	ShmSize    string
+	PidsLimit  int64
+	Ulimits    []string
+
+	CapAdd      []string
+	CapDrop     []string
+	SecurityOpt []string
+
+	// ReadOnly mounts the container's root filesystem as read only.
+	ReadOnly bool
+
+	// Tmpfs are tmpfs mounts in the form of path[:options].
+	Tmpfs []string
+	DNS   []string
+
+	// ExtraHosts are additional entries of /etc/hosts in the form of host:ip.
+	ExtraHosts []string
+
+	// Labels are in the form of key=value.
+	Labels []string
+
+	// Devices are host devices in the form of hostPath[:containerPath][:permissions].
+	Devices []string
+
+	// Detach returns as soon as the container has started.
+	Detach bool
+
+	// Remove removes the container after it exits. It's ignored for detached containers.
+	Remove bool
+
+	// StopTimeout is the time the container is given to exit when the context is done.
+	// DefaultStopTimeout is used if it's zero.
+	StopTimeout time.Duration
+
+	// Pull pulls the image before creating the container, even if it exists locally.
+	Pull bool
+
+	// PullAuth is used to pull the image if it doesn't exist locally, or if Pull is set.
+	PullAuth *AuthConfig
+
+	// Stdin is written to the container's standard input, which is closed once Stdin is exhausted.
+	// It's ignored for detached containers.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Info describes the state of a container.
+type Info struct {
+	ID         string
+	Name       string
+	Image      string
+	Status     string
+	Running    bool
+	ExitCode   int
+	StartedAt  time.Time
+	FinishedAt time.Time
+
+	// IPAddresses are the IP addresses of the container, by network.
+	IPAddresses map[string]string
+}
+
+// AuthConfig contains the credentials used to authenticate with a registry.
+type AuthConfig struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	ServerAddress string `json:"serveraddress,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// NetworkOptions describes a network to create.
+type NetworkOptions struct {
+	Driver string
+	IPv6   bool
+}
+
+// RegistryConfig describes how the runtime accesses registries.
+type RegistryConfig struct {
+	// InsecureRegistries are the registries, i.e. host[:port], whose TLS certificates aren't verified,
+	// and which are accessed over plain HTTP if they don't serve HTTPS.
+	InsecureRegistries []string
+
+	// InsecureRegistryCIDRs are the subnets whose registries are accessed insecurely.
+	InsecureRegistryCIDRs []*net.IPNet
+}
+
+// IsInsecure returns true if the registry at host, i.e. host[:port], is accessed insecurely.
+// Like the Docker daemon, a registry is insecure if it's one of the InsecureRegistries, or if the IP address
+// it resolves to is in one of the InsecureRegistryCIDRs.
+func (c *RegistryConfig) IsInsecure(host string) bool {
+	if c == nil {
+		return false
+	}
+	for _, r := range c.InsecureRegistries {
+		if r == host {
+			return true
+		}
+	}
+	if len(c.InsecureRegistryCIDRs) == 0 {
+		return false
+	}
+
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	ips := []net.IP{net.ParseIP(hostname)}
+	if ips[0] == nil {
+		var err error
+		if ips, err = net.LookupIP(hostname); err != nil {
+			return false
+		}
+	}
+	for _, ip := range ips {
+		for _, cidr := range c.InsecureRegistryCIDRs {
+			if cidr.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Error is returned when the container runtime rejects a request.
+type Error struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+
+	// Message is the error message returned by the runtime.
+	Message string
+}
+
+// Error returns the runtime's error message.
+func (e *Error) Error() string {
+	return fmt.Sprintf("container runtime error, status: %d, message: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound returns true if the error indicates that the container, image, network or volume doesn't exist.
+func IsNotFound(err error) bool {
+	return errdefs.IsNotFound(err) || hasStatusCode(err, http.StatusNotFound)
+}
+
+// IsConflict returns true if the error indicates that the resource already exists or is in use.
+func IsConflict(err error) bool {
+	return errdefs.IsConflict(err) || hasStatusCode(err, http.StatusConflict)
+}
+
+func hasStatusCode(err error, code int) bool {
+	var runtimeErr *Error
+	return errors.As(err, &runtimeErr) && runtimeErr.StatusCode == code
+}
@@ -0,0 +1,105 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+// Package interrupt cancels a run when acb receives SIGINT or SIGTERM, so it can tear down the resources
+// the run created before exiting.
+package interrupt
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// TeardownTimeout bounds the time spent cleaning up after a run, e.g. stopping and removing its containers,
+// networks and volumes.
+const TeardownTimeout = 2 * time.Minute
+
+// Handler cancels a context when the process receives SIGINT or SIGTERM.
+type Handler struct {
+	mu      sync.Mutex
+	signals chan os.Signal
+	done    chan struct{}
+	signal  os.Signal
+}
+
+// NotifyContext returns a copy of the parent context which is cancelled when the process receives SIGINT or
+// SIGTERM. Once a signal has been received, the default behavior is restored, so a second signal terminates
+// the process without waiting for the teardown. Stop must be called to release the Handler.
+func NotifyContext(parent context.Context) (context.Context, *Handler) {
+	ctx, cancel := context.WithCancel(parent)
+	h := &Handler{
+		signals: make(chan os.Signal, 1),
+		done:    make(chan struct{}),
+	}
+	signal.Notify(h.signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer cancel()
+		select {
+		case sig := <-h.signals:
+			signal.Stop(h.signals)
+			h.mu.Lock()
+			h.signal = sig
+			h.mu.Unlock()
+		case <-h.done:
+		}
+	}()
+	return ctx, h
+}
+
+// Signal returns the signal which cancelled the context, or nil if none was received.
+func (h *Handler) Signal() os.Signal {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.signal
+}
+
+// Stop stops relaying signals to the Handler.
+func (h *Handler) Stop() {
+	signal.Stop(h.signals)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-h.done:
+	default:
+		close(h.done)
+	}
+}
+
+// TeardownContext returns a context for cleaning up after a run. It's independent of the run's context,
+// which may be done, and expires after TeardownTimeout.
+func TeardownContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), TeardownTimeout)
+}
+
+// Error is returned when a run was interrupted by a signal.
+type Error struct {
+	// Signal is the signal which interrupted the run.
+	Signal os.Signal
+
+	// Steps are the IDs of the steps which were running or hadn't started when the run was interrupted.
+	Steps []string
+}
+
+// Error returns the final status of the interrupted run.
+func (e *Error) Error() string {
+	steps := "none"
+	if len(e.Steps) > 0 {
+		steps = strings.Join(e.Steps, ", ")
+	}
+	return fmt.Sprintf("run interrupted by signal: %v, interrupted steps: %s", e.Signal, steps)
+}
+
+// ExitStatus returns the exit code of acb after the run was interrupted, which follows the shell convention
+// of 128 plus the signal number, e.g. 130 for SIGINT and 143 for SIGTERM.
+func (e *Error) ExitStatus() int {
+	if sig, ok := e.Signal.(syscall.Signal); ok {
+		return 128 + int(sig)
+	}
+	return 128 + int(syscall.SIGINT)
+}
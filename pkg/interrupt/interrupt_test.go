@@ -0,0 +1,73 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package interrupt
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNotifyContext(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals can't be sent to the current process on Windows")
+	}
+	ctx, h := NotifyContext(context.Background())
+	defer h.Stop()
+	if h.Signal() != nil {
+		t.Fatalf("expected no signal but got %v", h.Signal())
+	}
+
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatalf("failed to find the current process: %v", err)
+	}
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("failed to send SIGTERM: %v", err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the context to be cancelled by SIGTERM")
+	}
+	if h.Signal() != syscall.SIGTERM {
+		t.Errorf("expected SIGTERM but got %v", h.Signal())
+	}
+}
+
+func TestNotifyContext_Stop(t *testing.T) {
+	ctx, h := NotifyContext(context.Background())
+	h.Stop()
+	h.Stop()
+	select {
+	case <-ctx.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the context to be cancelled when the handler is stopped")
+	}
+	if h.Signal() != nil {
+		t.Errorf("expected no signal but got %v", h.Signal())
+	}
+}
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		err              *Error
+		expectedMessage  string
+		expectedExitCode int
+	}{
+		{&Error{Signal: syscall.SIGINT, Steps: []string{"build", "push"}}, "run interrupted by signal: interrupt, interrupted steps: build, push", 130},
+		{&Error{Signal: syscall.SIGTERM}, "run interrupted by signal: terminated, interrupted steps: none", 143},
+	}
+	for _, test := range tests {
+		if actual := test.err.Error(); actual != test.expectedMessage {
+			t.Errorf("expected %q but got %q", test.expectedMessage, actual)
+		}
+		if actual := test.err.ExitStatus(); actual != test.expectedExitCode {
+			t.Errorf("expected exit code %d but got %d", test.expectedExitCode, actual)
+		}
+	}
+}
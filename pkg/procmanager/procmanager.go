@@ -20,11 +20,19 @@ import (
 
 // ProcManager is a wrapper for os.Process.
 type ProcManager struct {
-	DryRun    bool
+	DryRun bool
+
+	// Executor runs the processes of a dry run instead of only logging them,
+	// which allows scripting the outcome of processes in tests.
+	Executor Executor
+
 	mu        sync.Mutex
 	processes map[int]*os.Process
 }
 
+// Executor runs a process with the same arguments as Run.
+type Executor func(ctx context.Context, args []string, stdIn io.Reader, stdOut io.Writer, stdErr io.Writer, cmdDir string) error
+
 // NewProcManager creates a new ProcManager.
 func NewProcManager(dryRun bool) *ProcManager {
 	return &ProcManager{
@@ -34,8 +42,54 @@ func NewProcManager(dryRun bool) *ProcManager {
 	}
 }
 
+// RunResult describes the outcome of running a process with retries.
+type RunResult struct {
+	// Attempts is the total number of times the process was launched.
+	Attempts int
+
+	// ExitCode is the exit code of the last launched process.
+	ExitCode int
+}
+
+// Retries returns the number of attempts which were retries, given how many times
+// the process was expected to run without any failures.
+func (r *RunResult) Retries(expectedRuns int) int {
+	if r == nil || r.Attempts <= expectedRuns {
+		return 0
+	}
+	return r.Attempts - expectedRuns
+}
+
+// ExitError is returned by Run if the process exited with a non-zero exit code, and by RunWithRetries
+// if the process exited with a code that isn't considered successful.
+type ExitError struct {
+	// Code is the exit code of the process, or -1 if it was terminated by a signal.
+	Code int
+
+	// Err is the underlying *exec.ExitError, or describes the exit code if it was 0.
+	Err error
+}
+
+// Error returns the error message of the underlying error.
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// IsSuccessfulExitCodeFunc determines whether a process which exited with the specified code, including 0, succeeded.
+type IsSuccessfulExitCodeFunc func(code int) bool
+
+// RunFunc runs a process, i.e. a container, writing its output to stdOut and stdErr.
+// If the process exits with a non-zero exit code, an *ExitError is returned.
+type RunFunc func(ctx context.Context, stdOut io.Writer, stdErr io.Writer) error
+
 // RunRepeatWithRetries performs a Run multiple times with retries.
 // If any error occurs during the repetition, all errors will be aggregated and returned.
+// Neither repeats nor retries are run once the context is done.
 func (pm *ProcManager) RunRepeatWithRetries(
 	ctx context.Context,
 	args []string,
@@ -45,36 +99,85 @@ func (pm *ProcManager) RunRepeatWithRetries(
 	cmdDir string,
 	retries int,
 	retryOnErrors []string,
+	isSuccessfulExitCode IsSuccessfulExitCodeFunc,
+	retryDelay int,
+	containerName string,
+	repeat int) (*RunResult, error) {
+	return RepeatWithRetries(ctx, pm.runFunc(args, stdIn, cmdDir), stdOut, stdErr, retries, retryOnErrors, isSuccessfulExitCode, retryDelay, containerName, repeat)
+}
+
+// RunWithRetries performs Run with retries. If isSuccessfulExitCode is specified, it decides whether the process
+// succeeded from its exit code: a non-zero exit code that it accepts is considered successful and isn't retried,
+// while an exit code of 0 that it rejects is considered a failure and returned as an *ExitError.
+func (pm *ProcManager) RunWithRetries(
+	ctx context.Context,
+	args []string,
+	stdIn io.Reader,
+	stdOut io.Writer,
+	stdErr io.Writer,
+	cmdDir string,
+	retries int,
+	retryOnErrors []string,
+	isSuccessfulExitCode IsSuccessfulExitCodeFunc,
+	retryDelay int,
+	containerName string) (*RunResult, error) {
+	return WithRetries(ctx, pm.runFunc(args, stdIn, cmdDir), stdOut, stdErr, retries, retryOnErrors, isSuccessfulExitCode, retryDelay, containerName)
+}
+
+// runFunc returns a RunFunc which performs Run with the specified args.
+func (pm *ProcManager) runFunc(args []string, stdIn io.Reader, cmdDir string) RunFunc {
+	return func(ctx context.Context, stdOut io.Writer, stdErr io.Writer) error {
+		return pm.Run(ctx, args, stdIn, stdOut, stdErr, cmdDir)
+	}
+}
+
+// RepeatWithRetries performs run multiple times with retries, like RunRepeatWithRetries.
+func RepeatWithRetries(
+	ctx context.Context,
+	run RunFunc,
+	stdOut io.Writer,
+	stdErr io.Writer,
+	retries int,
+	retryOnErrors []string,
+	isSuccessfulExitCode IsSuccessfulExitCodeFunc,
 	retryDelay int,
 	containerName string,
-	repeat int) error {
+	repeat int) (*RunResult, error) {
 	var aggErrors util.Errors
+	result := &RunResult{}
 	for i := 0; i <= repeat; i++ {
-		innerErr := pm.RunWithRetries(ctx, args, stdIn, stdOut, stdErr, cmdDir, retries, retryOnErrors, retryDelay, containerName)
+		innerResult, innerErr := WithRetries(ctx, run, stdOut, stdErr, retries, retryOnErrors, isSuccessfulExitCode, retryDelay, containerName)
+		result.Attempts += innerResult.Attempts
+		result.ExitCode = innerResult.ExitCode
 		if innerErr != nil {
 			aggErrors = append(aggErrors, innerErr)
 		}
+		if ctx.Err() != nil {
+			break
+		}
 	}
-	if len(aggErrors) > 0 {
-		return errors.New(aggErrors.String())
+	if len(aggErrors) == 1 {
+		return result, aggErrors[0]
+	} else if len(aggErrors) > 0 {
+		return result, errors.New(aggErrors.String())
 	}
-	return nil
+	return result, nil
 }
 
-// RunWithRetries performs Run with retries.
-func (pm *ProcManager) RunWithRetries(
+// WithRetries performs run with retries, like RunWithRetries.
+func WithRetries(
 	ctx context.Context,
-	args []string,
-	stdIn io.Reader,
+	run RunFunc,
 	stdOut io.Writer,
 	stdErr io.Writer,
-	cmdDir string,
 	retries int,
 	retryOnErrors []string,
+	isSuccessfulExitCode IsSuccessfulExitCodeFunc,
 	retryDelay int,
-	containerName string) error {
+	containerName string) (*RunResult, error) {
 	attempt := 0
 	var err error
+	result := &RunResult{}
 	for attempt <= retries {
 		log.Printf("Launching container with name: %s\n", containerName)
 
@@ -91,28 +194,48 @@ func (pm *ProcManager) RunWithRetries(
 			stdErrWriter = stdErr
 		}
 
-		if err = pm.Run(ctx, args, stdIn, stdOutWriter, stdErrWriter, cmdDir); err == nil {
+		err = run(ctx, stdOutWriter, stdErrWriter)
+		result.Attempts++
+		result.ExitCode = ExitCode(err)
+		if isSuccessfulExitCode != nil {
+			var exitErr *ExitError
+			if err == nil && !isSuccessfulExitCode(0) {
+				log.Printf("Container: %s exited with code 0, which is considered a failure\n", containerName)
+				err = &ExitError{Code: 0, Err: errors.New("exit status 0")}
+			} else if errors.As(err, &exitErr) && isSuccessfulExitCode(exitErr.Code) {
+				log.Printf("Container: %s exited with code %d, which is considered successful\n", containerName, exitErr.Code)
+				err = nil
+			}
+		}
+		if err == nil {
 			log.Printf("Successfully executed container: %s\n", containerName)
 			break
 		}
 
+		// The process can't be retried once the context is done, i.e. if it timed out.
+		if ctx.Err() != nil {
+			log.Printf("Container: %s was stopped, err: %v\n", containerName, ctx.Err())
+			break
+		}
+
 		attempt++
 		if attempt <= retries {
 			if !needToCheckError || containsAnyError(retryOnErrors, &stdOutBuf, &stdErrBuf) {
-				log.Printf("Container failed during run: %s, waiting %d seconds before retrying...\n", containerName, retryDelay)
+				log.Printf("Container failed during run: %s, exit code: %d, waiting %d seconds before retrying...\n", containerName, result.ExitCode, retryDelay)
 				time.Sleep(time.Duration(retryDelay) * time.Second)
 				continue
 			}
 		}
 
-		log.Printf("Container failed during run: %s. No retries remaining.\n", containerName)
+		log.Printf("Container failed during run: %s, exit code: %d. No retries remaining.\n", containerName, result.ExitCode)
 		break
 	}
-	return err
+	return result, err
 }
 
 // Run runs an exec.Command based on the specified args.
 // stdIn, stdOut, stdErr, and cmdDir can be attached to the created exec.Command.
+// If the process exits with a non-zero exit code, an *ExitError is returned.
 func (pm *ProcManager) Run(
 	ctx context.Context,
 	args []string,
@@ -122,6 +245,9 @@ func (pm *ProcManager) Run(
 	cmdDir string) error {
 	if pm.DryRun {
 		log.Printf("[DRY RUN] Args: %v\n", args)
+		if pm.Executor != nil {
+			return pm.Executor(ctx, args, stdIn, stdOut, stdErr, cmdDir)
+		}
 		return nil
 	}
 
@@ -156,6 +282,10 @@ func (pm *ProcManager) Run(
 
 	select {
 	case err := <-errChan:
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return &ExitError{Code: exitErr.ExitCode(), Err: err}
+		}
 		return err
 
 	case <-ctx.Done():
@@ -193,6 +323,23 @@ func (pm *ProcManager) Stop() util.Errors {
 	return errs
 }
 
+// ExitCode returns the exit code of the process which produced the specified error.
+// It returns 0 if there was no error and -1 if the exit code couldn't be determined.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	var execErr *exec.ExitError
+	if errors.As(err, &execErr) {
+		return execErr.ExitCode()
+	}
+	return -1
+}
+
 func containsAnyError(errors []string, stdOutBuf, stdErrBuf *bytes.Buffer) bool {
 	stdOut := stdOutBuf.String()
 	stdErr := stdErrBuf.String()
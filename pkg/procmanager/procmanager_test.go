@@ -6,6 +6,10 @@ package procmanager
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
 	"testing"
 )
 
@@ -16,6 +20,23 @@ func TestDryRun(t *testing.T) {
 	}
 }
 
+func TestDryRun_Executor(t *testing.T) {
+	pm := NewProcManager(true)
+	var executed []string
+	pm.Executor = func(_ context.Context, args []string, _ io.Reader, _ io.Writer, _ io.Writer, _ string) error {
+		executed = append(executed, args...)
+		return &ExitError{Code: 3, Err: errors.New("exit status 3")}
+	}
+
+	result, err := pm.RunWithRetries(context.Background(), []string{"foo"}, nil, nil, nil, "", 1, nil, nil, 0, "test")
+	if result.ExitCode != 3 || result.Attempts != 2 {
+		t.Errorf("Expected exit code 3 and 2 attempts but got %d and %d, err: %v", result.ExitCode, result.Attempts, err)
+	}
+	if len(executed) != 2 {
+		t.Errorf("Expected the executor to run twice but got %v", executed)
+	}
+}
+
 func TestRun_NilArgs(t *testing.T) {
 	pm := NewProcManager(false)
 	if err := pm.Run(context.Background(), nil, nil, nil, nil, ""); err != nil {
@@ -23,6 +44,72 @@ func TestRun_NilArgs(t *testing.T) {
 	}
 }
 
+func TestRunWithRetries_Result(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	pm := NewProcManager(false)
+	result, err := pm.RunRepeatWithRetries(context.Background(), []string{"/bin/sh", "-c", "exit 3"}, nil, nil, nil, "", 2, nil, nil, 0, "test", 1)
+	if err == nil {
+		t.Fatalf("Expected an error but got nil")
+	}
+	if result.ExitCode != 3 {
+		t.Errorf("Expected exit code 3 but got %d", result.ExitCode)
+	}
+	if result.Attempts != 6 {
+		t.Errorf("Expected 6 attempts but got %d", result.Attempts)
+	}
+	if retries := result.Retries(2); retries != 4 {
+		t.Errorf("Expected 4 retries but got %d", retries)
+	}
+}
+
+func TestRunWithRetries_SuccessfulExitCode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	pm := NewProcManager(false)
+	isSuccessful := func(code int) bool { return code == 1 }
+
+	result, err := pm.RunWithRetries(context.Background(), []string{"/bin/sh", "-c", "exit 1"}, nil, nil, nil, "", 2, nil, isSuccessful, 0, "test")
+	if err != nil {
+		t.Fatalf("Expected exit code 1 to be successful but got: %v", err)
+	}
+	if result.ExitCode != 1 || result.Attempts != 1 {
+		t.Errorf("Expected exit code 1 and 1 attempt but got %d and %d", result.ExitCode, result.Attempts)
+	}
+
+	_, err = pm.RunWithRetries(context.Background(), []string{"/bin/sh", "-c", "exit 2"}, nil, nil, nil, "", 0, nil, isSuccessful, 0, "test")
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 2 {
+		t.Fatalf("Expected an *ExitError with code 2 but got: %v", err)
+	}
+	if err.Error() != "exit status 2" {
+		t.Errorf("Expected exit status 2 but got %s", err.Error())
+	}
+
+	// An exit code of 0 is a failure if it isn't considered successful.
+	result, err = pm.RunWithRetries(context.Background(), []string{"/bin/sh", "-c", "exit 0"}, nil, nil, nil, "", 1, nil, isSuccessful, 0, "test")
+	if !errors.As(err, &exitErr) || exitErr.Code != 0 {
+		t.Fatalf("Expected an *ExitError with code 0 but got: %v", err)
+	}
+	if result.ExitCode != 0 || result.Attempts != 2 {
+		t.Errorf("Expected exit code 0 and 2 attempts but got %d and %d", result.ExitCode, result.Attempts)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if code := ExitCode(nil); code != 0 {
+		t.Errorf("Expected 0 but got %d", code)
+	}
+	if code := ExitCode(errors.New("foo")); code != -1 {
+		t.Errorf("Expected -1 but got %d", code)
+	}
+	if code := ExitCode(fmt.Errorf("wrapped: %w", &ExitError{Code: 4, Err: errors.New("exit status 4")})); code != 4 {
+		t.Errorf("Expected 4 but got %d", code)
+	}
+}
+
 func TestContainsAnyError(t *testing.T) {
 	tests := []struct {
 		errors    []string
@@ -0,0 +1,122 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package provenance
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/acr-builder/pkg/signing"
+	"github.com/pkg/errors"
+)
+
+const (
+	// PayloadType is the type of the payload of an Envelope, i.e. an in-toto statement.
+	PayloadType = "application/vnd.in-toto+json"
+
+	// MediaType is the media type of an Envelope.
+	MediaType = "application/vnd.dsse.envelope.v1+json"
+
+	// ArtifactType is the artifact type of the manifests which attach provenance to images.
+	ArtifactType = "application/vnd.in-toto.provenance+dsse"
+)
+
+// Envelope is a DSSE envelope, which contains a signed statement.
+type Envelope struct {
+	PayloadType string      `json:"payloadType"`
+	Payload     string      `json:"payload"`
+	Signatures  []Signature `json:"signatures"`
+}
+
+// Signature is a signature of an Envelope's payload.
+type Signature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// Sign signs the statement with the key and returns an Envelope containing it.
+func Sign(s *Statement, key crypto.Signer) (*Envelope, error) {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal statement")
+	}
+	sig, err := signing.Sign(key, pae(PayloadType, payload))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign statement")
+	}
+	keyID, err := signing.KeyID(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{
+		PayloadType: PayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []Signature{{KeyID: keyID, Sig: base64.StdEncoding.EncodeToString(sig)}},
+	}, nil
+}
+
+// Verify verifies that the Envelope was signed by the key and returns its statement,
+// which must be a SLSA provenance statement.
+func (e *Envelope) Verify(key crypto.PublicKey) (*Statement, error) {
+	if e.PayloadType != PayloadType {
+		return nil, fmt.Errorf("unexpected payload type %s", e.PayloadType)
+	}
+	payload, err := base64.StdEncoding.DecodeString(e.Payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode payload")
+	}
+
+	verified := false
+	for _, s := range e.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(s.Sig)
+		if err != nil {
+			continue
+		}
+		if signing.Verify(key, pae(e.PayloadType, payload), sig) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("the envelope isn't signed by the key")
+	}
+
+	var s Statement
+	if err = json.Unmarshal(payload, &s); err != nil {
+		return nil, errors.Wrap(err, "failed to parse statement")
+	}
+	if s.Type != StatementType || s.PredicateType != PredicateType {
+		return nil, fmt.Errorf("unexpected statement type %s with predicate type %s", s.Type, s.PredicateType)
+	}
+	return &s, nil
+}
+
+// VerifySubject returns the statement of the first Envelope which was signed by the key and whose subject is the digest.
+func VerifySubject(envelopes []*Envelope, key crypto.PublicKey, digest string) (*Statement, error) {
+	if len(envelopes) == 0 {
+		return nil, fmt.Errorf("no provenance was found for %s", digest)
+	}
+	var errs []string
+	for _, e := range envelopes {
+		s, err := e.Verify(key)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if !s.HasSubject(digest) {
+			errs = append(errs, fmt.Sprintf("the statement's subject isn't %s", digest))
+			continue
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("no provenance for %s could be verified: %s", digest, strings.Join(errs, "; "))
+}
+
+// pae returns the pre-authentication encoding of the payload, which is what's signed.
+func pae(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
@@ -0,0 +1,146 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package provenance
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/acr-builder/pkg/image"
+)
+
+const (
+	testDigest     = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	testBaseDigest = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+)
+
+func testBuild() *Build {
+	return &Build{
+		Image:  "foo.azurecr.io/app:v1",
+		Digest: testDigest,
+		Dependencies: &image.Dependencies{
+			Image:     &image.Reference{Reference: "foo.azurecr.io/app:v1"},
+			Runtime:   &image.Reference{Reference: "debian:12", Digest: testBaseDigest},
+			Buildtime: []*image.Reference{{Reference: "golang:1.21"}},
+			Git:       &image.GitReference{GitHeadRev: "033ed90d4e0fa543c1910669dcf205578f957e85"},
+		},
+		Dockerfile:     "Dockerfile",
+		Context:        ".",
+		BuildArgs:      []string{"a=b"},
+		Source:         Source{Repository: "https://github.com/Azure/acr-builder.git", Branch: "main"},
+		RunID:          "cf1",
+		TriggeredBy:    "manual",
+		BuilderVersion: "v1.0.0",
+		StartedOn:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		FinishedOn:     time.Date(2024, 1, 2, 3, 5, 5, 0, time.UTC),
+	}
+}
+
+func TestNewStatement(t *testing.T) {
+	s, err := NewStatement(testBuild())
+	if err != nil {
+		t.Fatalf("failed to create statement: %v", err)
+	}
+
+	expectedSubject := []ResourceDescriptor{{Name: "foo.azurecr.io/app", Digest: map[string]string{"sha256": testDigest[7:]}}}
+	if !reflect.DeepEqual(s.Subject, expectedSubject) {
+		t.Errorf("expected subject %+v but got %+v", expectedSubject, s.Subject)
+	}
+	if !s.HasSubject(testDigest) || s.HasSubject(testBaseDigest) {
+		t.Errorf("expected the statement's subject to be %s", testDigest)
+	}
+
+	// The commit comes from the scanned git revision since it wasn't specified.
+	expectedSource := &Source{Repository: "https://github.com/Azure/acr-builder.git", Commit: "033ed90d4e0fa543c1910669dcf205578f957e85", Branch: "main"}
+	if !reflect.DeepEqual(s.Predicate.BuildDefinition.ExternalParameters.Source, expectedSource) {
+		t.Errorf("expected source %+v but got %+v", expectedSource, s.Predicate.BuildDefinition.ExternalParameters.Source)
+	}
+	expectedDeps := []ResourceDescriptor{
+		{URI: "git+https://github.com/Azure/acr-builder.git", Digest: map[string]string{"gitCommit": "033ed90d4e0fa543c1910669dcf205578f957e85"}},
+		{Name: "debian:12", Digest: map[string]string{"sha256": testBaseDigest[7:]}},
+		{Name: "golang:1.21"},
+	}
+	if !reflect.DeepEqual(s.Predicate.BuildDefinition.ResolvedDependencies, expectedDeps) {
+		t.Errorf("expected resolved dependencies %+v but got %+v", expectedDeps, s.Predicate.BuildDefinition.ResolvedDependencies)
+	}
+	expectedMetadata := Metadata{InvocationID: "cf1", StartedOn: "2024-01-02T03:04:05Z", FinishedOn: "2024-01-02T03:05:05Z"}
+	if s.Predicate.RunDetails.Metadata != expectedMetadata {
+		t.Errorf("expected metadata %+v but got %+v", expectedMetadata, s.Predicate.RunDetails.Metadata)
+	}
+
+	b := testBuild()
+	b.Digest = "invalid"
+	if _, err = NewStatement(b); err == nil {
+		t.Errorf("expected an invalid digest to fail")
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s, err := NewStatement(testBuild())
+	if err != nil {
+		t.Fatalf("failed to create statement: %v", err)
+	}
+	e, err := Sign(s, key)
+	if err != nil {
+		t.Fatalf("failed to sign statement: %v", err)
+	}
+
+	verified, err := e.Verify(key.Public())
+	if err != nil {
+		t.Fatalf("failed to verify envelope: %v", err)
+	}
+	if !reflect.DeepEqual(verified, s) {
+		t.Errorf("expected the verified statement to be %+v but got %+v", s, verified)
+	}
+
+	if _, err = e.Verify(other.Public()); err == nil {
+		t.Errorf("expected an envelope signed by a different key to fail verification")
+	}
+	tampered := *e
+	tampered.Payload = base64.StdEncoding.EncodeToString([]byte(`{"_type":"https://in-toto.io/Statement/v1"}`))
+	if _, err = tampered.Verify(key.Public()); err == nil {
+		t.Errorf("expected a tampered envelope to fail verification")
+	}
+}
+
+func TestVerifySubject(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s, err := NewStatement(testBuild())
+	if err != nil {
+		t.Fatalf("failed to create statement: %v", err)
+	}
+	e, err := Sign(s, key)
+	if err != nil {
+		t.Fatalf("failed to sign statement: %v", err)
+	}
+	unverified, err := Sign(s, other)
+	if err != nil {
+		t.Fatalf("failed to sign statement: %v", err)
+	}
+
+	tests := []struct {
+		envelopes   []*Envelope
+		digest      string
+		shouldError bool
+	}{
+		{[]*Envelope{e}, testDigest, false},
+		{[]*Envelope{unverified, e}, testDigest, false},
+		{[]*Envelope{unverified}, testDigest, true},
+		{[]*Envelope{e}, testBaseDigest, true},
+		{nil, testDigest, true},
+	}
+	for i, test := range tests {
+		if _, err := VerifySubject(test.envelopes, key.Public(), test.digest); (err != nil) != test.shouldError {
+			t.Errorf("test %d: expected error to be %v but got %v", i, test.shouldError, err)
+		}
+	}
+}
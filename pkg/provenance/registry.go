@@ -0,0 +1,49 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package provenance
+
+import (
+	"context"
+	"encoding/json"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry"
+)
+
+// Fetch returns the Envelopes attached to the subject, i.e. an image's manifest. The referrers API is used
+// if the registry supports it, otherwise the referrers tag is read.
+func Fetch(ctx context.Context, store content.ReadOnlyGraphStorage, subject ocispec.Descriptor) ([]*Envelope, error) {
+	referrers, err := registry.Referrers(ctx, store, subject, ArtifactType)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list the image's referrers")
+	}
+
+	var envelopes []*Envelope
+	for _, desc := range referrers {
+		data, err := content.FetchAll(ctx, store, desc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to fetch manifest %s", desc.Digest)
+		}
+		var manifest ocispec.Manifest
+		if err = json.Unmarshal(data, &manifest); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse manifest %s", desc.Digest)
+		}
+		for _, layer := range manifest.Layers {
+			if layer.MediaType != MediaType {
+				continue
+			}
+			if data, err = content.FetchAll(ctx, store, layer); err != nil {
+				return nil, errors.Wrapf(err, "failed to fetch envelope %s", layer.Digest)
+			}
+			var e Envelope
+			if err = json.Unmarshal(data, &e); err != nil {
+				return nil, errors.Wrapf(err, "failed to parse envelope %s", layer.Digest)
+			}
+			envelopes = append(envelopes, &e)
+		}
+	}
+	return envelopes, nil
+}
@@ -0,0 +1,213 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+// Package provenance creates, signs and verifies in-toto statements of the SLSA provenance of images.
+package provenance
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/acr-builder/pkg/image"
+	"github.com/docker/distribution/reference"
+	"github.com/pkg/errors"
+)
+
+const (
+	// StatementType is the type of an in-toto v1 statement.
+	StatementType = "https://in-toto.io/Statement/v1"
+
+	// PredicateType is the type of a SLSA v1 provenance predicate.
+	PredicateType = "https://slsa.dev/provenance/v1"
+
+	// BuildType describes how acb builds images, i.e. what the external parameters of the provenance mean.
+	BuildType = "https://github.com/Azure/acr-builder/build@v1"
+
+	// BuilderID identifies acb as the builder.
+	BuilderID = "https://github.com/Azure/acr-builder"
+)
+
+// Statement is an in-toto statement about the subject, i.e. the images which were built.
+type Statement struct {
+	Type          string               `json:"_type"`
+	Subject       []ResourceDescriptor `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     Predicate            `json:"predicate"`
+}
+
+// ResourceDescriptor describes an artifact by its name or URI and its digests, by algorithm.
+type ResourceDescriptor struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// Predicate is a SLSA v1 provenance predicate.
+type Predicate struct {
+	BuildDefinition BuildDefinition `json:"buildDefinition"`
+	RunDetails      RunDetails      `json:"runDetails"`
+}
+
+// BuildDefinition describes the inputs of the build.
+type BuildDefinition struct {
+	BuildType            string               `json:"buildType"`
+	ExternalParameters   ExternalParameters   `json:"externalParameters"`
+	InternalParameters   map[string]string    `json:"internalParameters,omitempty"`
+	ResolvedDependencies []ResourceDescriptor `json:"resolvedDependencies,omitempty"`
+}
+
+// ExternalParameters are the parameters of the build which were specified by the Task.
+type ExternalParameters struct {
+	Source     *Source  `json:"source,omitempty"`
+	Dockerfile string   `json:"dockerfile,omitempty"`
+	Target     string   `json:"target,omitempty"`
+	Context    string   `json:"context,omitempty"`
+	BuildArgs  []string `json:"buildArgs,omitempty"`
+}
+
+// Source is the source code which the image was built from.
+type Source struct {
+	Repository string `json:"repository,omitempty"`
+	Commit     string `json:"commit,omitempty"`
+	Branch     string `json:"branch,omitempty"`
+	Tag        string `json:"tag,omitempty"`
+}
+
+// RunDetails describes the run which built the image.
+type RunDetails struct {
+	Builder  Builder  `json:"builder"`
+	Metadata Metadata `json:"metadata"`
+}
+
+// Builder identifies the builder.
+type Builder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version,omitempty"`
+}
+
+// Metadata identifies the run and when it built the image.
+type Metadata struct {
+	InvocationID string `json:"invocationId,omitempty"`
+	StartedOn    string `json:"startedOn,omitempty"`
+	FinishedOn   string `json:"finishedOn,omitempty"`
+}
+
+// Build describes how an image was built and pushed.
+type Build struct {
+	// Image is the reference which the image was pushed to, and Digest is its manifest's digest.
+	Image  string
+	Digest string
+
+	// Dependencies are the image's base images, with their digests, and the git revision it was built from.
+	Dependencies *image.Dependencies
+
+	Dockerfile string
+	Target     string
+	Context    string
+	BuildArgs  []string
+	Source     Source
+
+	RunID          string
+	TriggeredBy    string
+	BuilderVersion string
+	StartedOn      time.Time
+	FinishedOn     time.Time
+}
+
+// NewStatement creates a provenance statement for the image described by the Build.
+func NewStatement(b *Build) (*Statement, error) {
+	named, err := reference.ParseNormalizedNamed(b.Image)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse image reference %s", b.Image)
+	}
+	subjectDigest, err := digestSet(b.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	source := b.Source
+	if source.Commit == "" && b.Dependencies != nil && b.Dependencies.Git != nil {
+		source.Commit = b.Dependencies.Git.GitHeadRev
+	}
+	def := BuildDefinition{
+		BuildType: BuildType,
+		ExternalParameters: ExternalParameters{
+			Dockerfile: b.Dockerfile,
+			Target:     b.Target,
+			Context:    b.Context,
+			BuildArgs:  b.BuildArgs,
+		},
+	}
+	if source != (Source{}) {
+		def.ExternalParameters.Source = &source
+	}
+	if b.TriggeredBy != "" {
+		def.InternalParameters = map[string]string{"triggeredBy": b.TriggeredBy}
+	}
+	if source.Repository != "" && source.Commit != "" {
+		def.ResolvedDependencies = append(def.ResolvedDependencies, ResourceDescriptor{
+			URI:    "git+" + source.Repository,
+			Digest: map[string]string{"gitCommit": source.Commit},
+		})
+	}
+	if deps := b.Dependencies; deps != nil {
+		for _, base := range append([]*image.Reference{deps.Runtime}, deps.Buildtime...) {
+			if base == nil {
+				continue
+			}
+			d := ResourceDescriptor{Name: base.Reference}
+			// The digests of base images which couldn't be resolved are unknown.
+			if base.Digest != "" {
+				if d.Digest, err = digestSet(base.Digest); err != nil {
+					return nil, err
+				}
+			}
+			def.ResolvedDependencies = append(def.ResolvedDependencies, d)
+		}
+	}
+
+	run := RunDetails{
+		Builder:  Builder{ID: BuilderID},
+		Metadata: Metadata{InvocationID: b.RunID},
+	}
+	if b.BuilderVersion != "" {
+		run.Builder.Version = map[string]string{"acb": b.BuilderVersion}
+	}
+	if !b.StartedOn.IsZero() {
+		run.Metadata.StartedOn = b.StartedOn.UTC().Format(time.RFC3339)
+	}
+	if !b.FinishedOn.IsZero() {
+		run.Metadata.FinishedOn = b.FinishedOn.UTC().Format(time.RFC3339)
+	}
+
+	return &Statement{
+		Type:          StatementType,
+		Subject:       []ResourceDescriptor{{Name: named.Name(), Digest: subjectDigest}},
+		PredicateType: PredicateType,
+		Predicate:     Predicate{BuildDefinition: def, RunDetails: run},
+	}, nil
+}
+
+// HasSubject returns true if the digest, e.g. sha256:..., is the digest of one of the statement's subjects.
+func (s *Statement) HasSubject(digest string) bool {
+	algorithm, hex, ok := strings.Cut(digest, ":")
+	if !ok {
+		return false
+	}
+	for _, subject := range s.Subject {
+		if subject.Digest[algorithm] == hex {
+			return true
+		}
+	}
+	return false
+}
+
+// digestSet converts a digest such as sha256:... to a set of digests by algorithm.
+func digestSet(digest string) (map[string]string, error) {
+	algorithm, hex, ok := strings.Cut(digest, ":")
+	if !ok || algorithm == "" || hex == "" {
+		return nil, fmt.Errorf("invalid digest %s", digest)
+	}
+	return map[string]string{algorithm: hex}, nil
+}
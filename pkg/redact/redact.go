@@ -0,0 +1,200 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+// Package redact removes secret values from output.
+package redact
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"log"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+const (
+	// Mask replaces redacted values.
+	Mask = "***"
+
+	// MinLength is the length of the shortest value which is redacted. Shorter values are too likely to
+	// occur in unrelated output, which redacting them would make unreadable.
+	MinLength = 4
+)
+
+// Redactor replaces known secret values, as well as their base64 and URL encoded variants, with Mask.
+type Redactor struct {
+	mu sync.RWMutex
+
+	// patterns are the byte sequences to redact, grouped by their first byte and sorted longest first.
+	patterns map[byte][][]byte
+	known    map[string]bool
+}
+
+// New creates a new Redactor without any values.
+func New() *Redactor {
+	return &Redactor{
+		patterns: make(map[byte][][]byte),
+		known:    make(map[string]bool),
+	}
+}
+
+var defaultRedactor = New()
+
+// Add adds values to the default Redactor, which redacts the output of writers created by NewWriter.
+func Add(values ...string) {
+	defaultRedactor.Add(values...)
+}
+
+// String redacts the values added to the default Redactor from s.
+func String(s string) string {
+	return defaultRedactor.String(s)
+}
+
+// NewWriter creates a Writer which redacts the values added to the default Redactor, including values added later.
+func NewWriter(w io.Writer) *Writer {
+	return defaultRedactor.NewWriter(w)
+}
+
+// Add adds values to redact. Empty values are ignored, and values shorter than MinLength are ignored with a warning.
+func (r *Redactor) Add(values ...string) {
+	if short := r.add(values); short > 0 {
+		// The lock must be released first, since the log's output may be redacted.
+		log.Printf("WARNING: %d secret value(s) shorter than %d characters won't be redacted\n", short, MinLength)
+	}
+}
+
+// add adds values to redact and returns the number of non-empty values which were too short to add.
+func (r *Redactor) add(values []string) (short int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		if len(value) < MinLength {
+			short++
+			continue
+		}
+		for _, v := range variants(value) {
+			if r.known[v] {
+				continue
+			}
+			r.known[v] = true
+			patterns := append(r.patterns[v[0]], []byte(v))
+			sort.SliceStable(patterns, func(i, j int) bool {
+				return len(patterns[i]) > len(patterns[j])
+			})
+			r.patterns[v[0]] = patterns
+		}
+	}
+	return short
+}
+
+// variants returns the value and the encoded forms in which it's likely to appear in output.
+func variants(value string) []string {
+	data := []byte(value)
+	return []string{
+		value,
+		base64.StdEncoding.EncodeToString(data),
+		base64.RawStdEncoding.EncodeToString(data),
+		base64.URLEncoding.EncodeToString(data),
+		base64.RawURLEncoding.EncodeToString(data),
+		url.QueryEscape(value),
+		url.PathEscape(value),
+	}
+}
+
+// String returns s with all known values replaced.
+func (r *Redactor) String(s string) string {
+	redacted, _ := r.redact([]byte(s), true)
+	return string(redacted)
+}
+
+// redact replaces all known values in data. Unless final is true, it stops at the first byte which
+// could be the start of a value continuing beyond the end of data, and returns the remaining bytes as pending.
+func (r *Redactor) redact(data []byte, final bool) (redacted []byte, pending []byte) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.patterns) == 0 {
+		return data, nil
+	}
+
+	redacted = make([]byte, 0, len(data))
+	for i := 0; i < len(data); {
+		n, partial := r.match(data[i:], final)
+		switch {
+		case partial:
+			return redacted, data[i:]
+		case n > 0:
+			redacted = append(redacted, Mask...)
+			i += n
+		default:
+			redacted = append(redacted, data[i])
+			i++
+		}
+	}
+	return redacted, nil
+}
+
+// match returns the length of the longest value which data starts with. Unless final is true,
+// partial is true if data is the start of a longer value.
+func (r *Redactor) match(data []byte, final bool) (n int, partial bool) {
+	for _, p := range r.patterns[data[0]] {
+		if len(p) <= len(data) {
+			if bytes.HasPrefix(data, p) {
+				return len(p), false
+			}
+		} else if !final && bytes.HasPrefix(p, data) {
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+// Writer redacts known values from the data written to an underlying writer. Since a value can be split
+// across writes, the data which could be the start of a value is held back until the next write or Flush.
+type Writer struct {
+	mu      sync.Mutex
+	w       io.Writer
+	r       *Redactor
+	pending []byte
+}
+
+// NewWriter creates a Writer which redacts the Redactor's values, including values added later.
+func (r *Redactor) NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, r: r}
+}
+
+// Write redacts p and writes it to the underlying writer.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data := p
+	if len(w.pending) > 0 {
+		data = append(w.pending, p...)
+	}
+	redacted, pending := w.r.redact(data, false)
+	w.pending = append([]byte(nil), pending...)
+	if len(redacted) > 0 {
+		if _, err := w.w.Write(redacted); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes any data held back by the Writer.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.pending) == 0 {
+		return nil
+	}
+	redacted, _ := w.r.redact(w.pending, true)
+	w.pending = nil
+	_, err := w.w.Write(redacted)
+	return err
+}
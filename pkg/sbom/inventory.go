@@ -0,0 +1,440 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package sbom
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"debug/buildinfo"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Package types, which are also the types of their package URLs.
+const (
+	TypeDeb    = "deb"
+	TypeApk    = "apk"
+	TypeRpm    = "rpm"
+	TypeGolang = "golang"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	opaqueWhiteout = ".wh..wh..opq"
+
+	// maxBinarySize is the size of the largest file which is read to find Go build info,
+	// and of the largest rpm database which is read.
+	maxBinarySize = 256 << 20
+)
+
+const (
+	dpkgStatusFile  = "var/lib/dpkg/status"
+	dpkgStatusDir   = "var/lib/dpkg/status.d"
+	apkDatabaseFile = "lib/apk/db/installed"
+	// rpmManifestFile is the package list of distroless RPM based images, such as CBL-Mariner's,
+	// which don't have an rpm database.
+	rpmManifestFile = "var/lib/rpmmanifest/container-manifest-2"
+	rpmSqliteFile   = "rpmdb.sqlite"
+)
+
+var (
+	// osReleaseFiles are the paths of the os-release file, in order of precedence.
+	osReleaseFiles = []string{"etc/os-release", "usr/lib/os-release"}
+
+	// rpmDatabaseDirs are the directories of the rpm database. Newer distributions, e.g. Fedora 36 and later,
+	// keep it in usr/lib/sysimage/rpm and link var/lib/rpm to it.
+	rpmDatabaseDirs = []string{"var/lib/rpm", "usr/lib/sysimage/rpm"}
+
+	executableMagics = [][]byte{
+		[]byte("\x7fELF"),
+		[]byte("MZ"),
+		{0xfe, 0xed, 0xfa, 0xce}, {0xfe, 0xed, 0xfa, 0xcf},
+		{0xce, 0xfa, 0xed, 0xfe}, {0xcf, 0xfa, 0xed, 0xfe},
+	}
+)
+
+// Package is a package installed in an image.
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Type is the package manager or ecosystem of the package, e.g. deb or golang.
+	Type string `json:"type"`
+	Arch string `json:"arch,omitempty"`
+	// Location is the path of the file which the package was found in, e.g. var/lib/dpkg/status or a Go binary.
+	Location string `json:"location"`
+}
+
+// OperatingSystem is the operating system of an image, read from its os-release file.
+type OperatingSystem struct {
+	ID         string `json:"id"`
+	VersionID  string `json:"versionID"`
+	PrettyName string `json:"prettyName"`
+}
+
+// Inventory is the packages installed in an image.
+type Inventory struct {
+	OS       *OperatingSystem
+	Packages []Package
+
+	// Warnings describe the packages which couldn't be inventoried.
+	Warnings []string
+}
+
+// file is a file in an image's filesystem which describes its packages.
+type file struct {
+	packages []Package
+	os       *OperatingSystem
+	warning  string
+}
+
+// Scanner inventories the packages of an image by reading the files of its layers,
+// i.e. the package databases of dpkg, apk and rpm and the build info of Go binaries.
+type Scanner struct {
+	// files are the files of the image's filesystem which describe packages, by path.
+	files map[string]*file
+}
+
+// NewScanner creates a Scanner for an image with no layers.
+func NewScanner() *Scanner {
+	return &Scanner{files: make(map[string]*file)}
+}
+
+// AddLayer reads a layer, which may be gzip compressed. Layers must be added in order, starting with the base layer,
+// so that the files which a layer deletes or replaces are removed from the inventory.
+func (s *Scanner) AddLayer(r io.Reader) error {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return errors.Wrap(err, "failed to decompress layer")
+		}
+		defer gr.Close()
+		r = gr
+	} else {
+		r = br
+	}
+
+	// The files of lower layers are removed before the layer's files are added,
+	// since an opaque directory's whiteout can be after the files of the layer in the directory.
+	var removed []string
+	added := make(map[string]*file)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read layer")
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		dir, base := path.Split(name)
+		switch {
+		case base == opaqueWhiteout:
+			removed = append(removed, path.Clean(dir)+"/")
+		case strings.HasPrefix(base, whiteoutPrefix):
+			deleted := dir + strings.TrimPrefix(base, whiteoutPrefix)
+			removed = append(removed, deleted, deleted+"/")
+		default:
+			// Any other entry replaces the file which was at its path.
+			removed = append(removed, name)
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			f, err := readFile(name, hdr, tr)
+			if err != nil {
+				return err
+			}
+			if f != nil {
+				added[name] = f
+			}
+		}
+	}
+
+	for _, p := range removed {
+		for name := range s.files {
+			if name == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(name, p)) {
+				delete(s.files, name)
+			}
+		}
+	}
+	for name, f := range added {
+		s.files[name] = f
+	}
+	return nil
+}
+
+// Inventory returns the packages of the image's layers which have been added, sorted by type, name and version.
+func (s *Scanner) Inventory() *Inventory {
+	inv := &Inventory{}
+	for _, name := range osReleaseFiles {
+		if f, ok := s.files[name]; ok {
+			inv.OS = f.os
+			break
+		}
+	}
+
+	var names []string
+	for name := range s.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// The rpm database is preferred to the manifest, but the manifest makes up for a database which can't be read.
+	hasRpmManifest := s.files[rpmManifestFile] != nil
+	hasRpmDatabase := false
+	for name, f := range s.files {
+		if isRpmDatabaseDir(path.Dir(name)) && len(f.packages) > 0 {
+			hasRpmDatabase = true
+		}
+	}
+	seen := make(map[Package]bool)
+	for _, name := range names {
+		f := s.files[name]
+		if name == rpmManifestFile && hasRpmDatabase {
+			continue
+		}
+		if f.warning != "" && !(hasRpmManifest && isRpmDatabaseDir(path.Dir(name))) {
+			inv.Warnings = append(inv.Warnings, f.warning)
+		}
+		for _, p := range f.packages {
+			if !seen[p] {
+				seen[p] = true
+				inv.Packages = append(inv.Packages, p)
+			}
+		}
+	}
+	sort.SliceStable(inv.Packages, func(i, j int) bool {
+		a, b := inv.Packages[i], inv.Packages[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Version < b.Version
+	})
+	return inv
+}
+
+// readFile reads the packages described by the regular file, or returns nil if it doesn't describe any.
+func readFile(name string, hdr *tar.Header, r io.Reader) (*file, error) {
+	var (
+		packages []Package
+		err      error
+	)
+	switch {
+	case name == dpkgStatusFile || path.Dir(name) == dpkgStatusDir:
+		packages, err = parseDpkgStatus(r, name)
+	case name == apkDatabaseFile:
+		packages, err = parseApkDatabase(r, name)
+	case name == rpmManifestFile:
+		packages, err = parseRpmManifest(r, name)
+	case isRpmDatabaseDir(path.Dir(name)) && path.Base(name) == rpmSqliteFile:
+		if hdr.Size > maxBinarySize {
+			return &file{warning: fmt.Sprintf("the rpm database %s is too large to read, so its packages aren't included", name)}, nil
+		}
+		var data []byte
+		if data, err = io.ReadAll(r); err == nil {
+			packages, err = parseRpmSqlite(data, name)
+		}
+	case isRpmDatabaseDir(path.Dir(name)) && path.Base(name) == rpmSqliteFile+"-wal" && hdr.Size > 0:
+		return &file{warning: fmt.Sprintf("the rpm database's write-ahead log %s isn't read, so the packages it records aren't included", name)}, nil
+	case isRpmDatabaseDir(path.Dir(name)) && isRpmDatabase(path.Base(name)):
+		return &file{warning: fmt.Sprintf("the Berkeley DB or ndb rpm database %s isn't supported, so its packages aren't included", name)}, nil
+	case isOSRelease(name):
+		release, err := parseOSRelease(r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", name)
+		}
+		return &file{os: release}, nil
+	case hdr.Mode&0111 != 0 && hdr.Size <= maxBinarySize:
+		return readGoBinary(name, hdr, r)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", name)
+	}
+	return &file{packages: packages}, nil
+}
+
+// isRpmDatabase returns true if the file is an rpm database in the Berkeley DB or ndb format.
+func isRpmDatabase(base string) bool {
+	return base == "Packages" || base == "Packages.db"
+}
+
+func isRpmDatabaseDir(dir string) bool {
+	for _, d := range rpmDatabaseDirs {
+		if dir == d {
+			return true
+		}
+	}
+	return false
+}
+
+func isOSRelease(name string) bool {
+	for _, f := range osReleaseFiles {
+		if name == f {
+			return true
+		}
+	}
+	return false
+}
+
+// readGoBinary returns the Go modules which the executable was built from, or nil if it isn't a Go binary.
+func readGoBinary(name string, hdr *tar.Header, r io.Reader) (*file, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	isExecutable := false
+	for _, m := range executableMagics {
+		if bytes.HasPrefix(magic, m) {
+			isExecutable = true
+			break
+		}
+	}
+	if !isExecutable {
+		return nil, nil
+	}
+
+	data := make([]byte, hdr.Size)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", name)
+	}
+	info, err := buildinfo.Read(bytes.NewReader(data))
+	if err != nil {
+		// The executable wasn't built by Go, or was built without module support.
+		return nil, nil
+	}
+
+	packages := []Package{{Name: "stdlib", Version: info.GoVersion, Type: TypeGolang, Location: name}}
+	if info.Main.Path != "" && info.Main.Version != "(devel)" {
+		packages = append(packages, Package{Name: info.Main.Path, Version: info.Main.Version, Type: TypeGolang, Location: name})
+	}
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		packages = append(packages, Package{Name: dep.Path, Version: dep.Version, Type: TypeGolang, Location: name})
+	}
+	return &file{packages: packages}, nil
+}
+
+// parseDpkgStatus parses the installed packages of a dpkg status file, which consists of paragraphs of fields.
+// The files of distroless images in var/lib/dpkg/status.d have the same format, but without the Status field.
+func parseDpkgStatus(r io.Reader, name string) ([]Package, error) {
+	var packages []Package
+	err := parseParagraphs(r, func(fields map[string]string) {
+		status, hasStatus := fields["Status"]
+		if fields["Package"] == "" || (hasStatus && !strings.HasSuffix(status, " installed")) {
+			return
+		}
+		packages = append(packages, Package{
+			Name:     fields["Package"],
+			Version:  fields["Version"],
+			Type:     TypeDeb,
+			Arch:     fields["Architecture"],
+			Location: name,
+		})
+	})
+	return packages, err
+}
+
+// parseApkDatabase parses the installed packages of an apk database, which consists of paragraphs of
+// single letter fields, e.g. P:busybox.
+func parseApkDatabase(r io.Reader, name string) ([]Package, error) {
+	var packages []Package
+	err := parseParagraphs(r, func(fields map[string]string) {
+		if fields["P"] == "" {
+			return
+		}
+		packages = append(packages, Package{
+			Name:     fields["P"],
+			Version:  fields["V"],
+			Type:     TypeApk,
+			Arch:     fields["A"],
+			Location: name,
+		})
+	})
+	return packages, err
+}
+
+// parseParagraphs calls fn with the fields of each paragraph, which are separated by blank lines.
+// Continuation lines, which start with a space, are ignored.
+func parseParagraphs(r io.Reader, fn func(map[string]string)) error {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			if len(fields) > 0 {
+				fn(fields)
+				fields = make(map[string]string)
+			}
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, ":"); ok && key != "" {
+			fields[key] = strings.TrimSpace(value)
+		}
+	}
+	if len(fields) > 0 {
+		fn(fields)
+	}
+	return scanner.Err()
+}
+
+// parseRpmManifest parses an rpm manifest, which has a line of tab separated fields per package:
+// name, version-release, install time, build time, vendor, epoch, size, arch, checksum and source rpm.
+func parseRpmManifest(r io.Reader, name string) ([]Package, error) {
+	var packages []Package
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 2 || fields[0] == "" {
+			continue
+		}
+		p := Package{Name: fields[0], Version: fields[1], Type: TypeRpm, Location: name}
+		if len(fields) > 5 && fields[5] != "" && fields[5] != "(none)" && fields[5] != "0" {
+			p.Version = fields[5] + ":" + p.Version
+		}
+		if len(fields) > 7 {
+			p.Arch = fields[7]
+		}
+		packages = append(packages, p)
+	}
+	return packages, scanner.Err()
+}
+
+// parseOSRelease parses an os-release file, which consists of KEY=value lines whose values may be quoted.
+func parseOSRelease(r io.Reader) (*OperatingSystem, error) {
+	release := &OperatingSystem{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			release.ID = value
+		case "VERSION_ID":
+			release.VersionID = value
+		case "PRETTY_NAME":
+			release.PrettyName = value
+		}
+	}
+	return release, scanner.Err()
+}
@@ -0,0 +1,270 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package sbom
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+const (
+	testOSRelease = `PRETTY_NAME="Debian GNU/Linux 12 (bookworm)"
+NAME="Debian GNU/Linux"
+VERSION_ID="12"
+ID=debian
+`
+	testDpkgStatus = `Package: curl
+Status: install ok installed
+Architecture: amd64
+Version: 7.88.1-10+deb12u5
+Description: command line tool for transferring data with URL syntax
+ curl is a command line tool for transferring data with URL syntax.
+
+Package: removed
+Status: deinstall ok config-files
+Architecture: amd64
+Version: 1.0
+
+Package: zlib1g
+Status: install ok installed
+Architecture: amd64
+Version: 1:1.2.13.dfsg-1
+`
+	testDistrolessStatus = `Package: tzdata
+Version: 2024a-0+deb12u1
+Architecture: all
+`
+	testApkDatabase = `C:Q1abc=
+P:busybox
+V:1.36.1-r15
+A:x86_64
+L:GPL-2.0-only
+
+P:musl
+V:1.2.4-r2
+A:x86_64
+`
+	testRpmManifest = "bash\t5.1.8-4.cm2\t1700000000\t1690000000\tMicrosoft Corporation\t(none)\t7000000\tx86_64\t0\tbash-5.1.8-4.cm2.src.rpm\n" +
+		"tzdata\t2023c-1.cm2\t1700000000\t1690000000\tMicrosoft Corporation\t1\t1000\tnoarch\t0\ttzdata-2023c-1.cm2.src.rpm\n"
+)
+
+type testFile struct {
+	name string
+	data []byte
+	mode int64
+}
+
+// layer creates a layer containing the files, which is compressed if gzipped is true.
+func layer(t *testing.T, gzipped bool, files ...testFile) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		mode := f.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: mode, Size: int64(len(f.data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			t.Fatalf("failed to write tar entry: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+	if !gzipped {
+		return &buf
+	}
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+	if _, err := gw.Write(buf.Bytes()); err != nil {
+		t.Fatalf("failed to compress layer: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("failed to compress layer: %v", err)
+	}
+	return &compressed
+}
+
+func TestScanner(t *testing.T) {
+	// The test binary is a Go binary with build info.
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("failed to find the test binary: %v", err)
+	}
+	binary, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatalf("failed to read the test binary: %v", err)
+	}
+
+	s := NewScanner()
+	layers := []*bytes.Buffer{
+		layer(t, true,
+			testFile{name: "etc/os-release", data: []byte(testOSRelease)},
+			testFile{name: "var/lib/dpkg/status", data: []byte(testDpkgStatus)},
+			testFile{name: "var/lib/dpkg/status.d/tzdata", data: []byte(testDistrolessStatus)},
+			testFile{name: "usr/bin/app", data: binary, mode: 0755},
+			testFile{name: "opt/tool/bin/tool", data: binary, mode: 0755},
+			testFile{name: "usr/bin/script", data: []byte("#!/bin/sh\necho hello\n"), mode: 0755},
+		),
+		// The second layer deletes the tool and the distroless status file and replaces the app.
+		layer(t, false,
+			testFile{name: "opt/.wh.tool"},
+			testFile{name: "var/lib/dpkg/status.d/.wh..wh..opq"},
+			testFile{name: "/usr/bin/app", data: []byte("not a binary"), mode: 0755},
+			testFile{name: "usr/local/bin/app", data: binary, mode: 0755},
+		),
+	}
+	for _, l := range layers {
+		if err := s.AddLayer(l); err != nil {
+			t.Fatalf("failed to add layer: %v", err)
+		}
+	}
+	inv := s.Inventory()
+
+	expectedOS := &OperatingSystem{ID: "debian", VersionID: "12", PrettyName: "Debian GNU/Linux 12 (bookworm)"}
+	if !reflect.DeepEqual(inv.OS, expectedOS) {
+		t.Errorf("expected OS %+v but got %+v", expectedOS, inv.OS)
+	}
+
+	var debs []Package
+	goPackages := make(map[string]Package)
+	for _, p := range inv.Packages {
+		switch p.Type {
+		case TypeDeb:
+			debs = append(debs, p)
+		case TypeGolang:
+			goPackages[p.Name] = p
+			if p.Location != "usr/local/bin/app" {
+				t.Errorf("expected Go package %s to be found in usr/local/bin/app but got %s", p.Name, p.Location)
+			}
+		default:
+			t.Errorf("unexpected package %+v", p)
+		}
+	}
+	expectedDebs := []Package{
+		{Name: "curl", Version: "7.88.1-10+deb12u5", Type: TypeDeb, Arch: "amd64", Location: "var/lib/dpkg/status"},
+		{Name: "zlib1g", Version: "1:1.2.13.dfsg-1", Type: TypeDeb, Arch: "amd64", Location: "var/lib/dpkg/status"},
+	}
+	if !reflect.DeepEqual(debs, expectedDebs) {
+		t.Errorf("expected deb packages %+v but got %+v", expectedDebs, debs)
+	}
+	if p := goPackages["stdlib"]; p.Version != runtime.Version() {
+		t.Errorf("expected stdlib %s but got %+v", runtime.Version(), p)
+	}
+	if _, ok := goPackages["github.com/pkg/errors"]; !ok {
+		t.Errorf("expected the test binary's dependencies to include github.com/pkg/errors but got %v", goPackages)
+	}
+	if len(inv.Warnings) != 0 {
+		t.Errorf("expected no warnings but got %v", inv.Warnings)
+	}
+}
+
+func TestScanner_Databases(t *testing.T) {
+	tests := []struct {
+		files            []testFile
+		expected         []Package
+		expectedWarnings int
+	}{
+		{
+			[]testFile{{name: "lib/apk/db/installed", data: []byte(testApkDatabase)}},
+			[]Package{
+				{Name: "busybox", Version: "1.36.1-r15", Type: TypeApk, Arch: "x86_64", Location: "lib/apk/db/installed"},
+				{Name: "musl", Version: "1.2.4-r2", Type: TypeApk, Arch: "x86_64", Location: "lib/apk/db/installed"},
+			},
+			0,
+		},
+		{
+			// The manifest makes up for an rpm database which can't be read.
+			[]testFile{
+				{name: "var/lib/rpmmanifest/container-manifest-2", data: []byte(testRpmManifest)},
+				{name: "var/lib/rpm/Packages", data: []byte("db")},
+			},
+			[]Package{
+				{Name: "bash", Version: "5.1.8-4.cm2", Type: TypeRpm, Arch: "x86_64", Location: "var/lib/rpmmanifest/container-manifest-2"},
+				{Name: "tzdata", Version: "1:2023c-1.cm2", Type: TypeRpm, Arch: "noarch", Location: "var/lib/rpmmanifest/container-manifest-2"},
+			},
+			0,
+		},
+		{
+			// The packages of a Berkeley DB rpm database can't be read without a manifest.
+			[]testFile{{name: "var/lib/rpm/Packages", data: []byte("db")}},
+			nil,
+			1,
+		},
+		{
+			[]testFile{
+				{name: "usr/lib/sysimage/rpm/rpmdb.sqlite-wal", data: []byte("wal")},
+				{name: "var/lib/rpmmanifest/container-manifest-2", data: []byte("ignored\t1.0\n")},
+			},
+			[]Package{{Name: "ignored", Version: "1.0", Type: TypeRpm, Location: "var/lib/rpmmanifest/container-manifest-2"}},
+			0,
+		},
+	}
+	for _, test := range tests {
+		s := NewScanner()
+		if err := s.AddLayer(layer(t, false, test.files...)); err != nil {
+			t.Fatalf("failed to add layer: %v", err)
+		}
+		inv := s.Inventory()
+		if !reflect.DeepEqual(inv.Packages, test.expected) {
+			t.Errorf("expected packages %+v but got %+v", test.expected, inv.Packages)
+		}
+		if len(inv.Warnings) != test.expectedWarnings {
+			t.Errorf("expected %d warnings but got %v", test.expectedWarnings, inv.Warnings)
+		}
+	}
+}
+
+func TestScanner_RpmSqlite(t *testing.T) {
+	// testdata/rpmdb.sqlite has the schema of rpm's database, 1 KiB pages so that its Packages table has interior
+	// and overflow pages, and the headers of bash, shadow-utils, 30 filler packages and a public key.
+	db, err := os.ReadFile("testdata/rpmdb.sqlite")
+	if err != nil {
+		t.Fatalf("failed to read rpm database: %v", err)
+	}
+
+	for _, dir := range rpmDatabaseDirs {
+		name := dir + "/rpmdb.sqlite"
+		s := NewScanner()
+		files := []testFile{
+			{name: name, data: db},
+			// The database is preferred to the manifest.
+			{name: "var/lib/rpmmanifest/container-manifest-2", data: []byte(testRpmManifest)},
+		}
+		if err := s.AddLayer(layer(t, false, files...)); err != nil {
+			t.Fatalf("failed to add layer: %v", err)
+		}
+		inv := s.Inventory()
+		if len(inv.Packages) != 32 {
+			t.Fatalf("expected 32 packages but got %d: %+v", len(inv.Packages), inv.Packages)
+		}
+		expected := []Package{
+			{Name: "bash", Version: "5.2.26-3.fc40", Type: TypeRpm, Arch: "x86_64", Location: name},
+			{Name: "filler-00", Version: "1.0-1.fc40", Type: TypeRpm, Arch: "noarch", Location: name},
+		}
+		if !reflect.DeepEqual(inv.Packages[:2], expected) {
+			t.Errorf("expected packages %+v but got %+v", expected, inv.Packages[:2])
+		}
+		expectedLast := Package{Name: "shadow-utils", Version: "2:4.15.1-2.fc40", Type: TypeRpm, Arch: "x86_64", Location: name}
+		if last := inv.Packages[len(inv.Packages)-1]; last != expectedLast {
+			t.Errorf("expected package %+v but got %+v", expectedLast, last)
+		}
+		if len(inv.Warnings) != 0 {
+			t.Errorf("expected no warnings but got %v", inv.Warnings)
+		}
+	}
+
+	// A corrupt database fails the scan rather than silently leaving its packages out.
+	corrupt := append([]byte(nil), db[:2048]...)
+	if err := NewScanner().AddLayer(layer(t, false, testFile{name: "var/lib/rpm/rpmdb.sqlite", data: corrupt})); err == nil {
+		t.Error("expected a truncated rpm database to fail")
+	}
+}
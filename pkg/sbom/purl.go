@@ -0,0 +1,84 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package sbom
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/Azure/acr-builder/pkg/image"
+)
+
+// PackageURL returns the package URL of a package, e.g. pkg:deb/debian/curl@7.88.1-10?arch=amd64&distro=debian-12.
+// The namespace of deb and rpm packages is the ID of the image's operating system, if it's known.
+func PackageURL(p Package, release *OperatingSystem) string {
+	var (
+		namespace  string
+		qualifiers []string
+	)
+	if p.Arch != "" {
+		qualifiers = append(qualifiers, "arch="+escapePURL(p.Arch))
+	}
+	switch p.Type {
+	case TypeDeb, TypeRpm:
+		if release != nil && release.ID != "" {
+			namespace = release.ID
+			if release.VersionID != "" {
+				qualifiers = append(qualifiers, "distro="+escapePURL(release.ID+"-"+release.VersionID))
+			}
+		}
+	case TypeApk:
+		namespace = "alpine"
+		if release != nil && release.ID != "" {
+			namespace = release.ID
+		}
+	case TypeGolang:
+		// The namespace of a Go module is its path without its last element.
+		if dir := path.Dir(p.Name); dir != "." {
+			namespace = dir
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("pkg:" + p.Type + "/")
+	if namespace != "" {
+		for _, segment := range strings.Split(namespace, "/") {
+			b.WriteString(escapePURL(segment) + "/")
+		}
+	}
+	b.WriteString(escapePURL(path.Base(p.Name)))
+	if p.Version != "" {
+		b.WriteString("@" + escapePURL(p.Version))
+	}
+	if len(qualifiers) > 0 {
+		b.WriteString("?" + strings.Join(qualifiers, "&"))
+	}
+	return b.String()
+}
+
+// ociPackageURL returns the package URL of an image, e.g.
+// pkg:oci/alpine@sha256%3A...?repository_url=registry.hub.docker.com/library/alpine&tag=3.19.
+func ociPackageURL(ref *image.Reference, digest string) string {
+	purl := fmt.Sprintf("pkg:oci/%s@%s", escapePURL(strings.ToLower(path.Base(ref.Repository))), escapePURL(digest))
+	qualifiers := []string{"repository_url=" + escapePURL(strings.ToLower(path.Join(ref.Registry, ref.Repository)))}
+	if ref.Tag != "" {
+		qualifiers = append(qualifiers, "tag="+escapePURL(ref.Tag))
+	}
+	return purl + "?" + strings.Join(qualifiers, "&")
+}
+
+// escapePURL percent-encodes every character of s other than the unreserved characters of RFC 3986 and /,
+// which is allowed in qualifier values.
+func escapePURL(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
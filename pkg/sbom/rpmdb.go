@@ -0,0 +1,406 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package sbom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	sqliteMagic      = "SQLite format 3\x00"
+	sqliteHeaderSize = 100
+
+	// sqliteSchemaPage is the root page of the sqlite_schema table, which describes the database's tables.
+	sqliteSchemaPage = 1
+
+	// Page types of table b-trees.
+	sqliteInteriorTablePage = 0x05
+	sqliteLeafTablePage     = 0x0d
+
+	// rpmPackagesTable is the table of rpm's sqlite database which contains the header of each installed package.
+	rpmPackagesTable = "Packages"
+
+	// rpmPublicKeyName is the name of the pseudo packages which contain the public keys that rpm trusts.
+	rpmPublicKeyName = "gpg-pubkey"
+
+	// Tags and types of the entries of an rpm header.
+	rpmTagName    = 1000
+	rpmTagVersion = 1001
+	rpmTagRelease = 1002
+	rpmTagEpoch   = 1003
+	rpmTagArch    = 1022
+	rpmTypeInt32  = 4
+	rpmTypeString = 6
+)
+
+// parseRpmSqlite parses an rpm database in the sqlite format, which rpm uses since 4.16, e.g. on Fedora 33 and later,
+// RHEL 9 and CBL-Mariner 2.0.
+func parseRpmSqlite(data []byte, name string) ([]Package, error) {
+	db, err := newSqliteDB(data)
+	if err != nil {
+		return nil, err
+	}
+	root, err := db.tableRoot(rpmPackagesTable)
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []Package
+	err = db.walkTable(root, func(record []byte) error {
+		values, err := parseSqliteRecord(record)
+		if err != nil {
+			return err
+		}
+		// The columns are hnum, which is an alias of the rowid and so is stored as NULL, and blob.
+		if len(values) < 2 {
+			return errors.New("invalid row in the Packages table")
+		}
+		blob, ok := values[1].([]byte)
+		if !ok {
+			return errors.New("invalid header in the Packages table")
+		}
+		p, err := parseRpmHeader(blob)
+		if err != nil {
+			return err
+		}
+		if p != nil {
+			p.Location = name
+			packages = append(packages, *p)
+		}
+		return nil
+	})
+	return packages, err
+}
+
+// parseRpmHeader parses the package described by an rpm header as it's stored in rpm's database, i.e. without
+// the header's magic, or returns nil if it describes one of the public keys which rpm trusts rather than a package.
+func parseRpmHeader(blob []byte) (*Package, error) {
+	if len(blob) < 8 {
+		return nil, errors.New("rpm header is truncated")
+	}
+	count := uint64(binary.BigEndian.Uint32(blob[0:4]))
+	size := uint64(binary.BigEndian.Uint32(blob[4:8]))
+	start := 8 + 16*count
+	if start+size > uint64(len(blob)) {
+		return nil, errors.New("rpm header is truncated")
+	}
+	store := blob[start : start+size]
+
+	var (
+		p                Package
+		version, release string
+		epoch            int32
+	)
+	for i := uint64(0); i < count; i++ {
+		entry := blob[8+16*i : 8+16*(i+1)]
+		tag := binary.BigEndian.Uint32(entry[0:4])
+		typ := binary.BigEndian.Uint32(entry[4:8])
+		offset := uint64(binary.BigEndian.Uint32(entry[8:12]))
+		if offset >= uint64(len(store)) {
+			continue
+		}
+		value := store[offset:]
+		switch {
+		case typ == rpmTypeInt32 && tag == rpmTagEpoch && len(value) >= 4:
+			epoch = int32(binary.BigEndian.Uint32(value))
+		case typ == rpmTypeString:
+			if end := bytes.IndexByte(value, 0); end >= 0 {
+				value = value[:end]
+			}
+			switch tag {
+			case rpmTagName:
+				p.Name = string(value)
+			case rpmTagVersion:
+				version = string(value)
+			case rpmTagRelease:
+				release = string(value)
+			case rpmTagArch:
+				p.Arch = string(value)
+			}
+		}
+	}
+	if p.Name == "" {
+		return nil, errors.New("rpm header doesn't have a name")
+	}
+	if p.Name == rpmPublicKeyName {
+		return nil, nil
+	}
+
+	// The version has the same format as in rpm's manifest, i.e. [epoch:]version-release.
+	p.Type = TypeRpm
+	p.Version = version
+	if release != "" {
+		p.Version += "-" + release
+	}
+	if epoch != 0 {
+		p.Version = fmt.Sprintf("%d:%s", epoch, p.Version)
+	}
+	return &p, nil
+}
+
+// sqliteDB reads the tables of a sqlite database. Only what's needed to read rpm's database is supported,
+// i.e. table b-trees and their records. Pages in a write-ahead log aren't read.
+type sqliteDB struct {
+	data     []byte
+	pageSize int
+
+	// usable is the number of bytes of each page which aren't reserved, e.g. for checksums.
+	usable int
+}
+
+func newSqliteDB(data []byte) (*sqliteDB, error) {
+	if len(data) < sqliteHeaderSize || string(data[:len(sqliteMagic)]) != sqliteMagic {
+		return nil, errors.New("not a sqlite database")
+	}
+	pageSize := int(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("invalid sqlite page size %d", pageSize)
+	}
+	usable := pageSize - int(data[20])
+	if usable < 480 {
+		return nil, fmt.Errorf("invalid sqlite reserved space %d", data[20])
+	}
+	return &sqliteDB{data: data, pageSize: pageSize, usable: usable}, nil
+}
+
+// page returns the page with the specified number, counting from 1.
+func (db *sqliteDB) page(n uint32) ([]byte, error) {
+	start := (int64(n) - 1) * int64(db.pageSize)
+	if n == 0 || start+int64(db.pageSize) > int64(len(db.data)) {
+		return nil, fmt.Errorf("sqlite page %d is out of range", n)
+	}
+	return db.data[start : start+int64(db.pageSize)], nil
+}
+
+// tableRoot returns the root page of the table's b-tree.
+func (db *sqliteDB) tableRoot(table string) (uint32, error) {
+	var root int64
+	err := db.walkTable(sqliteSchemaPage, func(record []byte) error {
+		values, err := parseSqliteRecord(record)
+		if err != nil {
+			return err
+		}
+		// The columns are type, name, tbl_name, rootpage and sql.
+		if len(values) < 4 || root != 0 {
+			return nil
+		}
+		typ, _ := values[0].([]byte)
+		name, _ := values[1].([]byte)
+		if string(typ) == "table" && string(name) == table {
+			root, _ = values[3].(int64)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if root <= 0 || root > int64(^uint32(0)) {
+		return 0, fmt.Errorf("sqlite table %s doesn't exist", table)
+	}
+	return uint32(root), nil
+}
+
+// walkTable calls fn with the record of each row of the table whose b-tree has the specified root page.
+func (db *sqliteDB) walkTable(root uint32, fn func(record []byte) error) error {
+	visited := make(map[uint32]bool)
+	var walk func(n uint32) error
+	walk = func(n uint32) error {
+		if visited[n] {
+			return fmt.Errorf("sqlite page %d is referenced more than once", n)
+		}
+		visited[n] = true
+		page, err := db.page(n)
+		if err != nil {
+			return err
+		}
+		// The first page starts with the database's header.
+		hdr := 0
+		if n == sqliteSchemaPage {
+			hdr = sqliteHeaderSize
+		}
+		cells := int(binary.BigEndian.Uint16(page[hdr+3:]))
+
+		switch page[hdr] {
+		case sqliteInteriorTablePage:
+			for i := 0; i < cells; i++ {
+				off, err := cellOffset(page, hdr+12, i)
+				if err != nil {
+					return err
+				}
+				if off+4 > len(page) {
+					return fmt.Errorf("invalid cell in sqlite page %d", n)
+				}
+				if err := walk(binary.BigEndian.Uint32(page[off:])); err != nil {
+					return err
+				}
+			}
+			return walk(binary.BigEndian.Uint32(page[hdr+8:]))
+		case sqliteLeafTablePage:
+			for i := 0; i < cells; i++ {
+				off, err := cellOffset(page, hdr+8, i)
+				if err != nil {
+					return err
+				}
+				record, err := db.payload(page, off)
+				if err != nil {
+					return errors.Wrapf(err, "invalid cell in sqlite page %d", n)
+				}
+				if err := fn(record); err != nil {
+					return err
+				}
+			}
+			return nil
+		default:
+			return fmt.Errorf("sqlite page %d isn't a table page", n)
+		}
+	}
+	return walk(root)
+}
+
+// cellOffset returns the offset of the ith cell of the page, whose cell pointer array starts at ptrs.
+func cellOffset(page []byte, ptrs int, i int) (int, error) {
+	p := ptrs + 2*i
+	if p+2 > len(page) {
+		return 0, errors.New("sqlite cell pointer is out of range")
+	}
+	off := int(binary.BigEndian.Uint16(page[p:]))
+	if off >= len(page) {
+		return 0, errors.New("sqlite cell is out of range")
+	}
+	return off, nil
+}
+
+// payload returns the payload of the table leaf cell at the offset, including the part stored in overflow pages.
+func (db *sqliteDB) payload(page []byte, off int) ([]byte, error) {
+	size, n := sqliteVarint(page[off:])
+	if n == 0 || size > uint64(len(db.data)) {
+		return nil, errors.New("invalid payload size")
+	}
+	off += n
+	// The payload's size is followed by the row's rowid.
+	if _, n = sqliteVarint(page[off:]); n == 0 {
+		return nil, errors.New("invalid rowid")
+	}
+	off += n
+
+	total := int(size)
+	local := total
+	if maxLocal := db.usable - 35; total > maxLocal {
+		minLocal := (db.usable-12)*32/255 - 23
+		local = minLocal + (total-minLocal)%(db.usable-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+	if off+local > len(page) {
+		return nil, errors.New("payload is out of range")
+	}
+	payload := make([]byte, 0, total)
+	payload = append(payload, page[off:off+local]...)
+	if local == total {
+		return payload, nil
+	}
+
+	if off+local+4 > len(page) {
+		return nil, errors.New("overflow page is out of range")
+	}
+	next := binary.BigEndian.Uint32(page[off+local:])
+	visited := make(map[uint32]bool)
+	for len(payload) < total {
+		if visited[next] {
+			return nil, fmt.Errorf("overflow page %d is referenced more than once", next)
+		}
+		visited[next] = true
+		overflow, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		// Each overflow page starts with the number of the next one.
+		next = binary.BigEndian.Uint32(overflow)
+		n := min(total-len(payload), db.usable-4)
+		payload = append(payload, overflow[4:4+n]...)
+	}
+	return payload, nil
+}
+
+// parseSqliteRecord returns the column values of a record, which are nil for NULL, int64 for integers and []byte for
+// text and blobs. Floating point values aren't used by rpm's database, so they're returned as nil.
+func parseSqliteRecord(record []byte) ([]interface{}, error) {
+	hdrSize, n := sqliteVarint(record)
+	if n == 0 || hdrSize > uint64(len(record)) || hdrSize < uint64(n) {
+		return nil, errors.New("invalid sqlite record header")
+	}
+	hdr, body := record[n:hdrSize], record[hdrSize:]
+
+	var values []interface{}
+	for len(hdr) > 0 {
+		serialType, n := sqliteVarint(hdr)
+		if n == 0 {
+			return nil, errors.New("invalid sqlite record header")
+		}
+		hdr = hdr[n:]
+
+		var (
+			size  int
+			value interface{}
+		)
+		switch {
+		case serialType == 0:
+		case serialType <= 6:
+			size = []int{1, 2, 3, 4, 6, 8}[serialType-1]
+		case serialType == 7:
+			size = 8
+		case serialType == 8 || serialType == 9:
+			value = int64(serialType - 8)
+		case serialType >= 12:
+			if (serialType-12)/2 > uint64(len(body)) {
+				return nil, errors.New("sqlite record is truncated")
+			}
+			size = int((serialType - 12) / 2)
+		default:
+			return nil, fmt.Errorf("invalid sqlite serial type %d", serialType)
+		}
+		if size > len(body) {
+			return nil, errors.New("sqlite record is truncated")
+		}
+		switch {
+		case serialType >= 1 && serialType <= 6:
+			// Integers are big-endian two's complement.
+			var v int64
+			for _, b := range body[:size] {
+				v = v<<8 | int64(b)
+			}
+			shift := 64 - 8*size
+			value = v << shift >> shift
+		case serialType >= 12:
+			value = body[:size]
+		}
+		values = append(values, value)
+		body = body[size:]
+	}
+	return values, nil
+}
+
+// sqliteVarint decodes a sqlite variable-length integer and returns it and its length,
+// or a length of 0 if it's truncated.
+func sqliteVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9 && i < len(b); i++ {
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
@@ -0,0 +1,188 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package sbom
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Azure/acr-builder/pkg/image"
+	"github.com/google/uuid"
+)
+
+const (
+	// MediaType is the media type of an SPDX document, which is also the artifact type of the
+	// manifests which attach SBOMs to images.
+	MediaType = "application/spdx+json"
+
+	spdxVersion     = "SPDX-2.3"
+	spdxDataLicense = "CC0-1.0"
+	spdxDocumentID  = "SPDXRef-DOCUMENT"
+	spdxNoAssertion = "NOASSERTION"
+
+	// spdxNamespace is the prefix of the namespaces of the documents, which are unique by image and document.
+	spdxNamespace = "https://github.com/Azure/acr-builder/spdx/"
+
+	creator = "Tool: acb"
+)
+
+var invalidSPDXIDChars = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// Document is an SPDX 2.3 document describing an image.
+type Document struct {
+	SPDXVersion       string         `json:"spdxVersion"`
+	DataLicense       string         `json:"dataLicense"`
+	SPDXID            string         `json:"SPDXID"`
+	Name              string         `json:"name"`
+	DocumentNamespace string         `json:"documentNamespace"`
+	CreationInfo      CreationInfo   `json:"creationInfo"`
+	Comment           string         `json:"comment,omitempty"`
+	Packages          []SPDXPackage  `json:"packages"`
+	Relationships     []Relationship `json:"relationships"`
+}
+
+// CreationInfo describes when and by what a Document was created.
+type CreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+// SPDXPackage is a package of a Document.
+type SPDXPackage struct {
+	SPDXID                string        `json:"SPDXID"`
+	Name                  string        `json:"name"`
+	VersionInfo           string        `json:"versionInfo,omitempty"`
+	DownloadLocation      string        `json:"downloadLocation"`
+	FilesAnalyzed         bool          `json:"filesAnalyzed"`
+	SourceInfo            string        `json:"sourceInfo,omitempty"`
+	PrimaryPackagePurpose string        `json:"primaryPackagePurpose,omitempty"`
+	Checksums             []Checksum    `json:"checksums,omitempty"`
+	ExternalRefs          []ExternalRef `json:"externalRefs,omitempty"`
+}
+
+// Checksum is the checksum of a package.
+type Checksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+// ExternalRef is a reference to a package outside of a Document, e.g. its package URL.
+type ExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+// Relationship relates two elements of a Document.
+type Relationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// NewDocument creates an SPDX document for the image with the specified manifest digest. The image's package
+// contains the packages of the inventory and is related to its runtime and buildtime dependencies, and the
+// git revision it was built from is its source.
+func NewDocument(deps *image.Dependencies, digest string, inv *Inventory, created time.Time) *Document {
+	name := deps.Image.Reference
+	doc := &Document{
+		SPDXVersion:       spdxVersion,
+		DataLicense:       spdxDataLicense,
+		SPDXID:            spdxDocumentID,
+		Name:              name,
+		DocumentNamespace: spdxNamespace + invalidSPDXIDChars.ReplaceAllString(name, "-") + "-" + uuid.New().String(),
+		CreationInfo: CreationInfo{
+			Created:  created.UTC().Format(time.RFC3339),
+			Creators: []string{creator},
+		},
+	}
+	if len(inv.Warnings) > 0 {
+		doc.Comment = strings.Join(inv.Warnings, "\n")
+	}
+
+	ids := make(map[string]bool)
+	img := imagePackage(deps.Image, digest, "SPDXRef-Image", ids)
+	img.PrimaryPackagePurpose = "CONTAINER"
+	if deps.Git != nil && deps.Git.GitHeadRev != "" {
+		img.SourceInfo = "built from git revision " + deps.Git.GitHeadRev
+	}
+	doc.Packages = append(doc.Packages, img)
+	doc.relate(spdxDocumentID, "DESCRIBES", img.SPDXID)
+
+	if deps.Runtime != nil {
+		base := imagePackage(deps.Runtime, deps.Runtime.Digest, "SPDXRef-Image-"+deps.Runtime.Reference, ids)
+		doc.Packages = append(doc.Packages, base)
+		doc.relate(img.SPDXID, "DESCENDANT_OF", base.SPDXID)
+	}
+	for _, ref := range deps.Buildtime {
+		base := imagePackage(ref, ref.Digest, "SPDXRef-Image-"+ref.Reference, ids)
+		doc.Packages = append(doc.Packages, base)
+		doc.relate(base.SPDXID, "BUILD_DEPENDENCY_OF", img.SPDXID)
+	}
+
+	if inv.OS != nil && inv.OS.ID != "" {
+		system := SPDXPackage{
+			SPDXID:                uniqueSPDXID("SPDXRef-OperatingSystem-"+inv.OS.ID, ids),
+			Name:                  inv.OS.ID,
+			VersionInfo:           inv.OS.VersionID,
+			DownloadLocation:      spdxNoAssertion,
+			PrimaryPackagePurpose: "OPERATING-SYSTEM",
+		}
+		doc.Packages = append(doc.Packages, system)
+		doc.relate(img.SPDXID, "CONTAINS", system.SPDXID)
+	}
+	for _, p := range inv.Packages {
+		pkg := SPDXPackage{
+			SPDXID:           uniqueSPDXID("SPDXRef-Package-"+p.Type+"-"+p.Name+"-"+p.Version, ids),
+			Name:             p.Name,
+			VersionInfo:      p.Version,
+			DownloadLocation: spdxNoAssertion,
+			SourceInfo:       "found in " + p.Location,
+			ExternalRefs: []ExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  PackageURL(p, inv.OS),
+			}},
+		}
+		doc.Packages = append(doc.Packages, pkg)
+		doc.relate(img.SPDXID, "CONTAINS", pkg.SPDXID)
+	}
+	return doc
+}
+
+func (d *Document) relate(from string, relationship string, to string) {
+	d.Relationships = append(d.Relationships, Relationship{SPDXElementID: from, RelationshipType: relationship, RelatedSPDXElement: to})
+}
+
+// imagePackage creates the package of an image. Its digest may be empty if it isn't known.
+func imagePackage(ref *image.Reference, digest string, id string, ids map[string]bool) SPDXPackage {
+	pkg := SPDXPackage{
+		SPDXID:           uniqueSPDXID(id, ids),
+		Name:             ref.Reference,
+		VersionInfo:      digest,
+		DownloadLocation: spdxNoAssertion,
+	}
+	if algorithm, hex, ok := strings.Cut(digest, ":"); ok && algorithm == "sha256" {
+		pkg.Checksums = []Checksum{{Algorithm: "SHA256", ChecksumValue: hex}}
+		pkg.ExternalRefs = []ExternalRef{{
+			ReferenceCategory: "PACKAGE-MANAGER",
+			ReferenceType:     "purl",
+			ReferenceLocator:  ociPackageURL(ref, digest),
+		}}
+	}
+	return pkg
+}
+
+// uniqueSPDXID returns an SPDX ID based on id which only contains valid characters and hasn't been used yet.
+func uniqueSPDXID(id string, ids map[string]bool) string {
+	id = invalidSPDXIDChars.ReplaceAllString(id, "-")
+	unique := id
+	for i := 2; ids[unique]; i++ {
+		unique = fmt.Sprintf("%s-%d", id, i)
+	}
+	ids[unique] = true
+	return unique
+}
@@ -0,0 +1,111 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package sbom
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/acr-builder/pkg/image"
+)
+
+func TestNewDocument(t *testing.T) {
+	deps := &image.Dependencies{
+		Image: &image.Reference{Registry: "foo.azurecr.io", Repository: "app", Tag: "v1", Reference: "foo.azurecr.io/app:v1"},
+		Runtime: &image.Reference{
+			Registry:   "registry.hub.docker.com",
+			Repository: "library/debian",
+			Tag:        "12",
+			Digest:     "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+			Reference:  "debian:12",
+		},
+		Buildtime: []*image.Reference{
+			{Registry: "registry.hub.docker.com", Repository: "library/golang", Tag: "1.21", Reference: "golang:1.21"},
+		},
+		Git: &image.GitReference{GitHeadRev: "033ed90d4e0fa543c1910669dcf205578f957e85"},
+	}
+	inv := &Inventory{
+		OS: &OperatingSystem{ID: "debian", VersionID: "12"},
+		Packages: []Package{
+			{Name: "curl", Version: "7.88.1-10+deb12u5", Type: TypeDeb, Arch: "amd64", Location: "var/lib/dpkg/status"},
+			{Name: "curl", Version: "7.88.1-10+deb12u5", Type: TypeDeb, Arch: "arm64", Location: "var/lib/dpkg/status"},
+		},
+		Warnings: []string{"warning"},
+	}
+	digest := "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	doc := NewDocument(deps, digest, inv, created)
+
+	if doc.SPDXVersion != "SPDX-2.3" || doc.CreationInfo.Created != "2024-01-02T03:04:05Z" || doc.Comment != "warning" {
+		t.Errorf("unexpected document %+v", doc)
+	}
+	if !strings.HasPrefix(doc.DocumentNamespace, "https://github.com/Azure/acr-builder/spdx/foo.azurecr.io-app-v1-") {
+		t.Errorf("unexpected document namespace %s", doc.DocumentNamespace)
+	}
+
+	packages := make(map[string]SPDXPackage)
+	for _, p := range doc.Packages {
+		if _, ok := packages[p.SPDXID]; ok {
+			t.Errorf("duplicate SPDX ID %s", p.SPDXID)
+		}
+		packages[p.SPDXID] = p
+	}
+	img := packages["SPDXRef-Image"]
+	if img.VersionInfo != digest || img.SourceInfo != "built from git revision 033ed90d4e0fa543c1910669dcf205578f957e85" {
+		t.Errorf("unexpected image package %+v", img)
+	}
+	if len(img.Checksums) != 1 || img.Checksums[0].ChecksumValue != strings.TrimPrefix(digest, "sha256:") {
+		t.Errorf("expected the image's checksum to be its digest but got %+v", img.Checksums)
+	}
+	if p := packages["SPDXRef-Package-deb-curl-7.88.1-10-deb12u5-2"]; p.ExternalRefs[0].ReferenceLocator != "pkg:deb/debian/curl@7.88.1-10%2Bdeb12u5?arch=arm64&distro=debian-12" {
+		t.Errorf("unexpected package %+v", p)
+	}
+
+	expectedRelationships := []Relationship{
+		{"SPDXRef-DOCUMENT", "DESCRIBES", "SPDXRef-Image"},
+		{"SPDXRef-Image", "DESCENDANT_OF", "SPDXRef-Image-debian-12"},
+		{"SPDXRef-Image-golang-1.21", "BUILD_DEPENDENCY_OF", "SPDXRef-Image"},
+		{"SPDXRef-Image", "CONTAINS", "SPDXRef-OperatingSystem-debian"},
+		{"SPDXRef-Image", "CONTAINS", "SPDXRef-Package-deb-curl-7.88.1-10-deb12u5"},
+		{"SPDXRef-Image", "CONTAINS", "SPDXRef-Package-deb-curl-7.88.1-10-deb12u5-2"},
+	}
+	if len(doc.Relationships) != len(expectedRelationships) {
+		t.Fatalf("expected relationships %v but got %v", expectedRelationships, doc.Relationships)
+	}
+	for i, r := range expectedRelationships {
+		if doc.Relationships[i] != r {
+			t.Errorf("expected relationship %v but got %v", r, doc.Relationships[i])
+		}
+		if _, ok := packages[r.SPDXElementID]; !ok && r.SPDXElementID != "SPDXRef-DOCUMENT" {
+			t.Errorf("relationship %v refers to a missing package", r)
+		}
+	}
+
+	if _, err := json.Marshal(doc); err != nil {
+		t.Errorf("failed to marshal document: %v", err)
+	}
+}
+
+func TestPackageURL(t *testing.T) {
+	debian := &OperatingSystem{ID: "debian", VersionID: "12"}
+	tests := []struct {
+		p        Package
+		release  *OperatingSystem
+		expected string
+	}{
+		{Package{Name: "zlib1g", Version: "1:1.2.13.dfsg-1", Type: TypeDeb, Arch: "amd64"}, debian, "pkg:deb/debian/zlib1g@1%3A1.2.13.dfsg-1?arch=amd64&distro=debian-12"},
+		{Package{Name: "zlib1g", Version: "1.2.13", Type: TypeDeb}, nil, "pkg:deb/zlib1g@1.2.13"},
+		{Package{Name: "musl", Version: "1.2.4-r2", Type: TypeApk, Arch: "x86_64"}, nil, "pkg:apk/alpine/musl@1.2.4-r2?arch=x86_64"},
+		{Package{Name: "bash", Version: "5.1.8-4.cm2", Type: TypeRpm, Arch: "x86_64"}, &OperatingSystem{ID: "mariner", VersionID: "2.0"}, "pkg:rpm/mariner/bash@5.1.8-4.cm2?arch=x86_64&distro=mariner-2.0"},
+		{Package{Name: "github.com/pkg/errors", Version: "v0.9.1", Type: TypeGolang}, debian, "pkg:golang/github.com/pkg/errors@v0.9.1"},
+		{Package{Name: "stdlib", Version: "go1.21.5", Type: TypeGolang}, nil, "pkg:golang/stdlib@go1.21.5"},
+	}
+	for _, test := range tests {
+		if actual := PackageURL(test.p, test.release); actual != test.expected {
+			t.Errorf("expected %s but got %s", test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package signing
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+)
+
+const (
+	// SimpleSigningMediaType is the media type of the payloads which are signed, i.e. cosign's simple signing format.
+	SimpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+
+	// SignatureAnnotation is the annotation of a signature manifest's layer which contains the base64 encoded
+	// signature of the layer's payload.
+	SignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+	simpleSigningType = "cosign container image signature"
+)
+
+// SimpleSigning is the payload which is signed to sign an image.
+type SimpleSigning struct {
+	Critical Critical               `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+// Critical identifies the signed image.
+type Critical struct {
+	Identity Identity `json:"identity"`
+	Image    Image    `json:"image"`
+	Type     string   `json:"type"`
+}
+
+// Identity is the repository of the signed image.
+type Identity struct {
+	DockerReference string `json:"docker-reference"`
+}
+
+// Image is the manifest digest of the signed image.
+type Image struct {
+	DockerManifestDigest string `json:"docker-manifest-digest"`
+}
+
+// NewPayload returns the payload which is signed to sign the image in the repository with the manifest digest.
+func NewPayload(repository string, d digest.Digest) ([]byte, error) {
+	return json.Marshal(&SimpleSigning{
+		Critical: Critical{
+			Identity: Identity{DockerReference: repository},
+			Image:    Image{DockerManifestDigest: d.String()},
+			Type:     simpleSigningType,
+		},
+	})
+}
+
+// VerifyPayload returns an error if sig isn't a signature of the payload by the key,
+// or if the payload doesn't sign the image in the repository with the manifest digest.
+func VerifyPayload(key crypto.PublicKey, payload []byte, sig []byte, repository string, d digest.Digest) error {
+	if err := Verify(key, payload, sig); err != nil {
+		return err
+	}
+	var s SimpleSigning
+	if err := json.Unmarshal(payload, &s); err != nil {
+		return errors.Wrap(err, "failed to parse the signed payload")
+	}
+	if s.Critical.Type != simpleSigningType {
+		return fmt.Errorf("unexpected payload type %s", s.Critical.Type)
+	}
+	if s.Critical.Image.DockerManifestDigest != d.String() {
+		return fmt.Errorf("the payload signs %s rather than %s", s.Critical.Image.DockerManifestDigest, d)
+	}
+	// The same manifest may be pushed to any repository, so a signature only vouches for the repository it names.
+	if s.Critical.Identity.DockerReference != repository {
+		return fmt.Errorf("the payload signs an image in %s rather than %s", s.Critical.Identity.DockerReference, repository)
+	}
+	return nil
+}
+
+// SignatureTag returns the tag of the manifest which contains the signatures of the image with the manifest digest,
+// e.g. sha256-<hex>.sig.
+func SignatureTag(d digest.Digest) string {
+	return fmt.Sprintf("%s-%s.sig", d.Algorithm(), d.Encoded())
+}
+
+// Attach signs the payload with the key and adds the signature to the signature manifest of the subject, i.e. an image's
+// manifest, creating it if the image hasn't been signed yet, and returns the signature manifest. Nothing is added if the
+// payload has already been signed by the key.
+func Attach(ctx context.Context, target oras.Target, subject ocispec.Descriptor, payload []byte, key crypto.Signer) (ocispec.Descriptor, error) {
+	tag := SignatureTag(subject.Digest)
+	var layers []ocispec.Descriptor
+	existing, err := target.Resolve(ctx, tag)
+	switch {
+	case err == nil:
+		manifest, err := fetchManifest(ctx, target, existing)
+		if err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		layers = manifest.Layers
+	case !errors.Is(err, errdef.ErrNotFound):
+		return ocispec.Descriptor{}, errors.Wrapf(err, "failed to resolve %s", tag)
+	}
+
+	layer := content.NewDescriptorFromBytes(SimpleSigningMediaType, payload)
+	for _, l := range layers {
+		if l.Digest != layer.Digest {
+			continue
+		}
+		if sig, err := base64.StdEncoding.DecodeString(l.Annotations[SignatureAnnotation]); err == nil && Verify(key.Public(), payload, sig) == nil {
+			return existing, nil
+		}
+	}
+	sig, err := Sign(key, payload)
+	if err != nil {
+		return ocispec.Descriptor{}, errors.Wrap(err, "failed to sign the payload")
+	}
+	layer.Annotations = map[string]string{SignatureAnnotation: base64.StdEncoding.EncodeToString(sig)}
+	if err = pushBlob(ctx, target, layer, payload); err != nil {
+		return ocispec.Descriptor{}, errors.Wrap(err, "failed to push the signed payload")
+	}
+	layers = append(layers, layer)
+
+	// The config lists the layers, like an image's config.
+	config := ocispec.Image{RootFS: ocispec.RootFS{Type: "layers"}}
+	for _, l := range layers {
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, l.Digest)
+	}
+	configData, err := json.Marshal(config)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	configDesc := content.NewDescriptorFromBytes(ocispec.MediaTypeImageConfig, configData)
+	if err = pushBlob(ctx, target, configDesc, configData); err != nil {
+		return ocispec.Descriptor{}, errors.Wrap(err, "failed to push the signature's config")
+	}
+
+	data, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers:    layers,
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return oras.TagBytes(ctx, target, ocispec.MediaTypeImageManifest, data, tag)
+}
+
+// VerifyAttached returns an error unless one of the signatures attached to the subject, i.e. an image's manifest
+// in the repository, was made by the key.
+func VerifyAttached(ctx context.Context, target oras.ReadOnlyTarget, repository string, subject ocispec.Descriptor, key crypto.PublicKey) error {
+	tag := SignatureTag(subject.Digest)
+	desc, err := target.Resolve(ctx, tag)
+	if errors.Is(err, errdef.ErrNotFound) {
+		return fmt.Errorf("%s isn't signed", subject.Digest)
+	} else if err != nil {
+		return errors.Wrapf(err, "failed to resolve %s", tag)
+	}
+	manifest, err := fetchManifest(ctx, target, desc)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, layer := range manifest.Layers {
+		encoded, ok := layer.Annotations[SignatureAnnotation]
+		if layer.MediaType != SimpleSigningMediaType || !ok {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			errs = append(errs, errors.Wrap(err, "failed to decode signature").Error())
+			continue
+		}
+		payload, err := content.FetchAll(ctx, target, layer)
+		if err != nil {
+			return errors.Wrapf(err, "failed to fetch the signed payload %s", layer.Digest)
+		}
+		if err = VerifyPayload(key, payload, sig, repository, subject.Digest); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		return nil
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("%s isn't signed", subject.Digest)
+	}
+	return fmt.Errorf("none of the signatures of %s were made by the key: %s", subject.Digest, strings.Join(errs, "; "))
+}
+
+func fetchManifest(ctx context.Context, fetcher content.Fetcher, desc ocispec.Descriptor) (*ocispec.Manifest, error) {
+	data, err := content.FetchAll(ctx, fetcher, desc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch manifest %s", desc.Digest)
+	}
+	var manifest ocispec.Manifest
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse manifest %s", desc.Digest)
+	}
+	return &manifest, nil
+}
+
+func pushBlob(ctx context.Context, pusher content.Pusher, desc ocispec.Descriptor, data []byte) error {
+	if err := pusher.Push(ctx, desc, bytes.NewReader(data)); err != nil && !errors.Is(err, errdef.ErrAlreadyExists) {
+		return err
+	}
+	return nil
+}
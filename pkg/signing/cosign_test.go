@@ -0,0 +1,70 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+const testDigest = digest.Digest("sha256:2222222222222222222222222222222222222222222222222222222222222222")
+
+func TestNewPayload(t *testing.T) {
+	payload, err := NewPayload("foo.azurecr.io/app", testDigest)
+	if err != nil {
+		t.Fatalf("failed to create payload: %v", err)
+	}
+	expected := `{"critical":{"identity":{"docker-reference":"foo.azurecr.io/app"},"image":{"docker-manifest-digest":"sha256:2222222222222222222222222222222222222222222222222222222222222222"},"type":"cosign container image signature"},"optional":null}`
+	if string(payload) != expected {
+		t.Errorf("expected payload %s but got %s", expected, payload)
+	}
+}
+
+func TestVerifyPayload(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	payload, err := NewPayload("foo.azurecr.io/app", testDigest)
+	if err != nil {
+		t.Fatalf("failed to create payload: %v", err)
+	}
+	sig, err := Sign(key, payload)
+	if err != nil {
+		t.Fatalf("failed to sign payload: %v", err)
+	}
+	unsigned := []byte(`{"critical":{"image":{"docker-manifest-digest":"` + testDigest.String() + `"},"type":"other"}}`)
+	unsignedSig, _ := Sign(key, unsigned)
+
+	tests := []struct {
+		name        string
+		payload     []byte
+		sig         []byte
+		repository  string
+		digest      digest.Digest
+		verifier    *ecdsa.PrivateKey
+		shouldError bool
+	}{
+		{"valid", payload, sig, "foo.azurecr.io/app", testDigest, key, false},
+		{"different key", payload, sig, "foo.azurecr.io/app", testDigest, other, true},
+		{"different repository", payload, sig, "foo.azurecr.io/other", testDigest, key, true},
+		{"different digest", payload, sig, "foo.azurecr.io/app", digest.FromString("other"), key, true},
+		{"tampered payload", append([]byte(" "), payload...), sig, "foo.azurecr.io/app", testDigest, key, true},
+		{"different type", unsigned, unsignedSig, "foo.azurecr.io/app", testDigest, key, true},
+	}
+	for _, test := range tests {
+		if err := VerifyPayload(test.verifier.Public(), test.payload, test.sig, test.repository, test.digest); (err != nil) != test.shouldError {
+			t.Errorf("%s: expected error to be %v but got %v", test.name, test.shouldError, err)
+		}
+	}
+}
+
+func TestSignatureTag(t *testing.T) {
+	expected := "sha256-2222222222222222222222222222222222222222222222222222222222222222.sig"
+	if tag := SignatureTag(testDigest); tag != expected {
+		t.Errorf("expected tag %s but got %s", expected, tag)
+	}
+}
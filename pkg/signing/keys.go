@@ -0,0 +1,140 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+// Package signing signs and verifies data with ECDSA, RSA and Ed25519 keys in the formats used by cosign,
+// i.e. ECDSA and RSA PKCS #1 v1.5 signatures of the data's SHA-256 digest and Ed25519 signatures of the data.
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var errNoPEMBlock = errors.New("no PEM encoded key was found")
+
+// LoadPrivateKey parses a PEM encoded private key, which may be a PKCS #8, EC or PKCS #1 RSA private key.
+// Encrypted keys aren't supported.
+func LoadPrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errNoPEMBlock
+	}
+	// Keys encrypted by OpenSSL have a Proc-Type header, and keys encrypted by cosign have an ENCRYPTED type.
+	if _, ok := block.Headers["Proc-Type"]; ok || strings.Contains(block.Type, "ENCRYPTED") {
+		return nil, errors.New("encrypted private keys aren't supported")
+	}
+
+	var (
+		key interface{}
+		err error
+	)
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported private key type %s", block.Type)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse private key")
+	}
+
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return k, nil
+	case *rsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key %T", key)
+	}
+}
+
+// LoadPublicKey parses a PEM encoded PKIX public key. If it's a private key, its public key is returned instead,
+// so that signatures can be verified using the key which created them.
+func LoadPublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errNoPEMBlock
+	}
+	if block.Type != "PUBLIC KEY" {
+		signer, err := LoadPrivateKey(data)
+		if err != nil {
+			return nil, err
+		}
+		return signer.Public(), nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse public key")
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key %T", key)
+	}
+}
+
+// MarshalPublicKey returns the PEM encoded PKIX form of the public key.
+func MarshalPublicKey(key crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal public key")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// KeyID returns the hex encoded SHA-256 digest of the public key's PKIX form.
+func KeyID(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal public key")
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Sign signs the data with the key.
+func Sign(key crypto.Signer, data []byte) ([]byte, error) {
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		return key.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(data)
+	return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// Verify returns an error if sig isn't a signature of the data by the key.
+func Verify(key crypto.PublicKey, data []byte, sig []byte) error {
+	digest := sha256.Sum256(data)
+	var ok bool
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(k, digest[:], sig)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(k, data, sig)
+	default:
+		return fmt.Errorf("unsupported public key %T", key)
+	}
+	if !ok {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
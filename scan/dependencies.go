@@ -4,30 +4,26 @@
 package scan
 
 import (
-	"bufio"
-	"bytes"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 
 	"github.com/Azure/acr-builder/pkg/image"
 	"github.com/Azure/acr-builder/util"
 	"github.com/docker/distribution/reference"
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/moby/buildkit/frontend/dockerfile/shell"
 	"github.com/pkg/errors"
 )
 
 const (
-	dockerfileComment = "#"
 	defaultDockerfile = "Dockerfile"
 )
 
-var (
-	utf8BOM = []byte{0xEF, 0xBB, 0xBF}
-)
-
 // ScanForDependencies scans for base image dependencies.
 func (s *Scanner) ScanForDependencies(context string, workingDir string, dockerfile string, buildArgs []string, pushTo []string, target string) (deps []*image.Dependencies, err error) {
 	dockerfilePath := createDockerfilePath(context, workingDir, dockerfile)
@@ -154,103 +150,207 @@ func NewImageReference(imagePath string) (*image.Reference, error) {
 }
 
 // resolveDockerfileDependencies resolves dependencies given an io.Reader for a Dockerfile.
+// The runtime dependency is the image the target stage, or the last stage if there's no target, is based on.
+// The buildtime dependencies are the other images used by the stages which the target stage depends on,
+// i.e. the images they're based on and the images referenced by COPY --from and RUN --mount=from.
 func resolveDockerfileDependencies(r io.Reader, buildArgs []string, target string) (origin string, buildtimeDependencies []string, err error) {
-	scanner := bufio.NewScanner(r)
+	result, err := parser.Parse(r)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to parse dockerfile")
+	}
+	stages, metaArgs, err := instructions.Parse(result.AST)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to parse dockerfile")
+	}
+	if len(stages) == 0 {
+		return "", nil, errors.New("unexpected dockerfile format")
+	}
 	context, err := parseBuildArgs(buildArgs)
 	if err != nil {
 		return "", nil, err
 	}
-	originLookup := map[string]string{} // given an alias, look up its origin
-	allOrigins := map[string]bool{}     // set of all origins
-	firstLine := true
-
-SCAN: // label for the scan loop
-	for scanner.Scan() {
-		var line string
-		// Trim UTF-8 BOM if necessary.
-		if firstLine {
-			scannedBytes := scanner.Bytes()
-			scannedBytes = bytes.TrimPrefix(scannedBytes, utf8BOM)
-			line = string(scannedBytes)
-			firstLine = false
-		} else {
-			line = scanner.Text()
+
+	lex := shell.NewLex(result.EscapeToken)
+	// The ARGs declared before the first FROM are the only ones in scope of FROM.
+	// Build args override their defaults.
+	metaEnv := copyEnv(context)
+	if err := declareArgs(lex, metaEnv, context, metaArgs); err != nil {
+		return "", nil, err
+	}
+
+	refs := make([]*stageRefs, len(stages))
+	for i := range stages {
+		if refs[i], err = resolveStageRefs(lex, stages, i, metaEnv, context); err != nil {
+			return "", nil, err
 		}
+	}
 
-		line = strings.TrimSpace(line)
-		// Skip comments.
-		if line == "" || strings.HasPrefix(line, dockerfileComment) {
+	targetIndex := len(stages) - 1
+	if target != "" {
+		if targetIndex = stageIndex(stages, target, len(stages)); targetIndex < 0 {
+			return "", nil, fmt.Errorf("target stage %s could not be found", target)
+		}
+	}
+
+	// Walk the stages which the target stage depends on, which are the only ones that are built.
+	visited := make([]bool, len(stages))
+	var visit func(i int)
+	visit = func(i int) {
+		if visited[i] {
+			return
+		}
+		visited[i] = true
+		for _, dep := range refs[i].stages {
+			visit(dep)
+		}
+	}
+	visit(targetIndex)
+
+	origin = refs[targetIndex].image
+	for i := targetIndex; origin == ""; {
+		i = refs[i].stages[0]
+		origin = refs[i].image
+	}
+
+	seen := map[string]bool{origin: true}
+	for i, ref := range refs {
+		if !visited[i] {
 			continue
 		}
+		for _, img := range ref.images() {
+			if !seen[img] {
+				seen[img] = true
+				buildtimeDependencies = append(buildtimeDependencies, img)
+			}
+		}
+	}
+	return origin, buildtimeDependencies, nil
+}
 
-		tokens := strings.Fields(line)
-		if len(tokens) > 0 {
-			switch strings.ToUpper(tokens[0]) {
-			case "FROM":
-				if len(tokens) < 2 {
-					return "", nil, fmt.Errorf("unable to understand line %s", line)
-				}
-				// trim surrounds single and double quotes from the image reference
-				var imageToken = os.Expand(util.TrimQuotes(tokens[1]), func(key string) string {
-					return context[key]
-				})
-				var found bool
-				origin, found = originLookup[imageToken]
-				if !found {
-					allOrigins[imageToken] = true
-					origin = imageToken
-				}
+// stageRefs are the references of a Dockerfile stage to images and to other stages.
+type stageRefs struct {
+	// image is the image the stage is based on, or empty if it's based on another stage,
+	// in which case that stage is the first of stages.
+	image string
 
-				if len(tokens) > 2 {
-					if len(tokens) < 4 || !strings.EqualFold(tokens[2], "as") {
-						return "", nil, fmt.Errorf("unable to understand line %s", line)
-					}
-					// alias cannot contain variables it seems. So we don't call context.Expand on it
-					alias := tokens[3]
-					originLookup[alias] = origin
-					// Just ignore the rest of the tokens...
-					if len(tokens) > 4 {
-						log.Printf("Ignoring chunks from FROM clause: %v\n", tokens[4:])
-					}
-
-					// reach the target, stop the scanning
-					if len(target) > 0 && target == alias {
-						break SCAN
-					}
-				}
-			case "ARG":
-				if len(tokens) < 2 {
-					return "", nil, fmt.Errorf("dockerfile syntax requires ARG directive to have exactly 1 argument. LINE: %s", line)
+	// stages are the indexes of the stages the stage depends on.
+	stages []int
+
+	// from are the images referenced by COPY --from and RUN --mount=from.
+	from []string
+}
+
+// images returns all of the images referenced by the stage.
+func (r *stageRefs) images() []string {
+	if r.image == "" {
+		return r.from
+	}
+	return append([]string{r.image}, r.from...)
+}
+
+// resolveStageRefs resolves the references of the ith stage. References to stages are by name or, for COPY --from
+// and RUN --mount=from, by index, and can only be to earlier stages. Any other reference is to an image.
+func resolveStageRefs(lex *shell.Lex, stages []instructions.Stage, i int, metaEnv map[string]string, buildArgs map[string]string) (*stageRefs, error) {
+	stage := stages[i]
+	refs := &stageRefs{}
+	base, err := lex.ProcessWordWithMap(stage.BaseName, metaEnv)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to expand %s", stage.SourceCode)
+	}
+	if base == "" {
+		return nil, fmt.Errorf("%s has an empty base image", stage.SourceCode)
+	}
+	if dep := stageIndex(stages, base, i); dep >= 0 {
+		refs.stages = append(refs.stages, dep)
+	} else {
+		refs.image = base
+	}
+
+	env := copyEnv(metaEnv)
+	addRef := func(from string) error {
+		from, err := lex.ProcessWordWithMap(from, env)
+		if err != nil {
+			return errors.Wrapf(err, "failed to expand %s", from)
+		}
+		if from == "" {
+			return nil
+		}
+		if dep, err := strconv.Atoi(from); err == nil && dep >= 0 && dep < i {
+			refs.stages = append(refs.stages, dep)
+		} else if dep := stageIndex(stages, from, i); dep >= 0 {
+			refs.stages = append(refs.stages, dep)
+		} else {
+			refs.from = append(refs.from, from)
+		}
+		return nil
+	}
+	for _, cmd := range stage.Commands {
+		switch c := cmd.(type) {
+		case *instructions.ArgCommand:
+			if err := declareArgs(lex, env, buildArgs, []instructions.ArgCommand{*c}); err != nil {
+				return nil, err
+			}
+		case *instructions.EnvCommand:
+			for _, kv := range c.Env {
+				value, err := lex.ProcessWordWithMap(kv.Value, env)
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to expand ENV %s", kv.Key)
 				}
-				if strings.Contains(tokens[1], "=") {
-					varName, varValue, err := parseAssignment(tokens[1])
-					if err != nil {
-						return "", nil, fmt.Errorf("unable to parse assignment %s, error: %s", tokens[1], err)
-					}
-					// This line matches docker's behavior here
-					// 1. If build arg is passed in, the value will not override
-					// 2. It is actually allowed for same ARG to be specified more than once in a Dockerfile
-					//    However the subsequent value would be ignored instead of overriding the previous
-					if _, found := context[varName]; !found {
-						context[varName] = varValue
-					}
+				env[kv.Key] = value
+			}
+		case *instructions.CopyCommand:
+			if err := addRef(c.From); err != nil {
+				return nil, err
+			}
+		case *instructions.RunCommand:
+			for _, m := range instructions.GetMounts(c) {
+				if err := addRef(m.From); err != nil {
+					return nil, err
 				}
 			}
 		}
 	}
+	return refs, nil
+}
 
-	if len(allOrigins) == 0 {
-		return "", nil, errors.New("unexpected dockerfile format")
+// stageIndex returns the index of the stage with the specified name among the first n stages, or -1 if there isn't one.
+func stageIndex(stages []instructions.Stage, name string, n int) int {
+	for i := 0; i < n && i < len(stages); i++ {
+		if stages[i].Name != "" && strings.EqualFold(stages[i].Name, name) {
+			return i
+		}
 	}
+	return -1
+}
 
-	// note that origin variable now points to the runtime origin
-	for terminal := range allOrigins {
-		if terminal != origin {
-			buildtimeDependencies = append(buildtimeDependencies, terminal)
+// declareArgs adds the ARGs to env. An ARG's value is the build arg with the same name if there is one,
+// otherwise its default.
+func declareArgs(lex *shell.Lex, env map[string]string, buildArgs map[string]string, args []instructions.ArgCommand) error {
+	for _, arg := range args {
+		for _, kv := range arg.Args {
+			if value, ok := buildArgs[kv.Key]; ok {
+				env[kv.Key] = value
+				continue
+			}
+			if kv.Value == nil {
+				continue
+			}
+			value, err := lex.ProcessWordWithMap(*kv.Value, env)
+			if err != nil {
+				return errors.Wrapf(err, "failed to expand ARG %s", kv.Key)
+			}
+			env[kv.Key] = value
 		}
 	}
+	return nil
+}
 
-	return origin, buildtimeDependencies, nil
+func copyEnv(env map[string]string) map[string]string {
+	c := make(map[string]string, len(env))
+	for k, v := range env {
+		c[k] = v
+	}
+	return c
 }
 
 func parseBuildArgs(args []string) (map[string]string, error) {
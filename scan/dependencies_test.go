@@ -6,11 +6,15 @@ package scan
 import (
 	"bytes"
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/Azure/acr-builder/pkg/image"
 )
 
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
 // TestResolveDockerfileDependencies tests resolving runtime and build time dependencies from a Dockerfile.
 func TestResolveDockerfileDependencies(t *testing.T) {
 	ver := "2.0.6"
@@ -132,6 +136,132 @@ func TestResolveDockerfileDependencies_WithTarget(t *testing.T) {
 	}
 }
 
+func TestResolveDockerfileDependencies_Syntax(t *testing.T) {
+	tests := []struct {
+		name              string
+		dockerfile        string
+		buildArgs         []string
+		target            string
+		expectedRuntime   string
+		expectedBuildtime []string
+	}{
+		{
+			name: "platform and line continuations",
+			dockerfile: `FROM --platform=$BUILDPLATFORM golang:1.21 AS build
+RUN go build \
+	-o /app \
+	./...
+FROM --platform=$TARGETPLATFORM \
+	mcr.microsoft.com/cbl-mariner/distroless/minimal:2.0
+COPY --from=build /app /app`,
+			expectedRuntime:   "mcr.microsoft.com/cbl-mariner/distroless/minimal:2.0",
+			expectedBuildtime: []string{"golang:1.21"},
+		},
+		{
+			name: "escape directive",
+			dockerfile: "# escape=`\n" +
+				"FROM node:18 AS build\n" +
+				"RUN npm ci `\n" +
+				"	&& npm run build\n" +
+				"FROM nginx:stable\n" +
+				"COPY --from=build /dist /usr/share/nginx/html",
+			expectedRuntime:   "nginx:stable",
+			expectedBuildtime: []string{"node:18"},
+		},
+		{
+			name: "default and alternate values",
+			dockerfile: `ARG REGISTRY
+ARG GO_VERSION=1.21
+ARG VARIANT
+FROM ${REGISTRY:-docker.io}/library/golang:${GO_VERSION}${VARIANT:+-$VARIANT} AS build
+FROM ${REGISTRY:-docker.io}/library/alpine:3.19
+COPY --from=build /app /app`,
+			buildArgs:         []string{"VARIANT=alpine"},
+			expectedRuntime:   "docker.io/library/alpine:3.19",
+			expectedBuildtime: []string{"docker.io/library/golang:1.21-alpine"},
+		},
+		{
+			name: "heredocs",
+			dockerfile: `FROM alpine:3.19
+RUN <<EOF
+FROM this-is-not-an-image
+echo hello
+EOF
+COPY <<EOF /etc/motd
+FROM neither-is-this
+EOF`,
+			expectedRuntime: "alpine:3.19",
+		},
+		{
+			name: "images referenced by COPY --from and RUN --mount=from",
+			dockerfile: `ARG TOOLS=tools:1.0
+FROM golang:1.21 AS build
+RUN --mount=type=bind,from=golangci/golangci-lint:v1.55,source=/usr/bin/golangci-lint,target=/usr/bin/golangci-lint golangci-lint run
+RUN --mount=type=cache,target=/root/.cache/go-build go build -o /app
+FROM gcr.io/distroless/static
+COPY --from=BUILD /app /app
+COPY --from=0 /app /app2
+COPY --from=${TOOLS} /bin/tool /bin/tool
+COPY --from=busybox:1.36 /bin/busybox /bin/busybox`,
+			expectedRuntime:   "gcr.io/distroless/static",
+			expectedBuildtime: []string{"golang:1.21", "golangci/golangci-lint:v1.55", "tools:1.0", "busybox:1.36"},
+		},
+		{
+			name: "target pruning",
+			dockerfile: `FROM golang:1.21 AS base
+FROM base AS test
+COPY --from=golangci/golangci-lint:v1.55 /usr/bin/golangci-lint /usr/bin/golangci-lint
+FROM base AS build
+FROM alpine:3.19 AS release
+COPY --from=build /app /app
+FROM ubuntu:22.04 AS debug`,
+			target:            "release",
+			expectedRuntime:   "alpine:3.19",
+			expectedBuildtime: []string{"golang:1.21"},
+		},
+		{
+			name: "runtime image from a base stage",
+			dockerfile: `FROM golang:1.21 AS base
+FROM base AS build
+FROM build
+COPY --from=golang:1.21 /usr/local/go /usr/local/go`,
+			expectedRuntime: "golang:1.21",
+		},
+	}
+
+	for _, test := range tests {
+		runtimeDep, buildDeps, err := resolveDockerfileDependencies(strings.NewReader(test.dockerfile), test.buildArgs, test.target)
+		if err != nil {
+			t.Errorf("%s: failed to resolve dependencies: %v", test.name, err)
+			continue
+		}
+		if runtimeDep != test.expectedRuntime {
+			t.Errorf("%s: expected runtime %s but got %s", test.name, test.expectedRuntime, runtimeDep)
+		}
+		if !reflect.DeepEqual(buildDeps, test.expectedBuildtime) {
+			t.Errorf("%s: expected build-time dependencies %v but got %v", test.name, test.expectedBuildtime, buildDeps)
+		}
+	}
+}
+
+func TestResolveDockerfileDependencies_Errors(t *testing.T) {
+	tests := []struct {
+		dockerfile string
+		target     string
+	}{
+		{"RUN echo hello", ""},
+		{"FROM", ""},
+		{"FROM alpine AS 1invalid", ""},
+		{"FROM alpine AS base\nFROM base", "missing"},
+		{"ARG IMAGE\nFROM $IMAGE", ""},
+	}
+	for _, test := range tests {
+		if _, _, err := resolveDockerfileDependencies(strings.NewReader(test.dockerfile), nil, test.target); err == nil {
+			t.Errorf("expected %q with target %q to fail", test.dockerfile, test.target)
+		}
+	}
+}
+
 func TestCreateDockerfilePath(t *testing.T) {
 	tests := []struct {
 		context    string
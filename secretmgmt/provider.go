@@ -0,0 +1,113 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package secretmgmt
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Azure/acr-builder/tokenutil"
+	"github.com/Azure/acr-builder/vaults"
+)
+
+const (
+	// KeyVaultProvider resolves secrets from Azure Key Vault. It's the default provider of secrets with a keyvault property.
+	KeyVaultProvider = "keyvault"
+
+	// MsiProvider resolves registry refresh tokens using a managed identity. It's the default provider of secrets with an AadResourceID.
+	MsiProvider = "msi"
+
+	// EnvProvider resolves secrets from environment variables.
+	EnvProvider = "env"
+
+	// FileProvider resolves secrets from local files.
+	FileProvider = "file"
+
+	// VaultProvider resolves secrets from HashiCorp Vault's KV version 2 secrets engine.
+	VaultProvider = "vault"
+)
+
+// SecretProvider resolves secrets from a secret store.
+type SecretProvider interface {
+	// Validate returns an error if the secret's properties are invalid for the provider.
+	Validate(secret *Secret) error
+
+	// Resolve returns the value of the secret.
+	Resolve(ctx context.Context, secret *Secret) (string, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]SecretProvider{
+		KeyVaultProvider: &keyVaultProvider{},
+		MsiProvider:      &msiProvider{},
+		EnvProvider:      &envProvider{},
+		FileProvider:     &fileProvider{},
+		VaultProvider:    &vaultProvider{},
+	}
+)
+
+// RegisterSecretProvider registers a provider, which secrets select by specifying its name as their provider.
+func RegisterSecretProvider(name string, provider SecretProvider) error {
+	if name == "" || provider == nil {
+		return fmt.Errorf("a secret provider requires a name and an implementation")
+	}
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	if _, ok := providers[name]; ok {
+		return fmt.Errorf("secret provider %s is already registered", name)
+	}
+	providers[name] = provider
+	return nil
+}
+
+// GetSecretProvider returns the provider registered with the specified name.
+func GetSecretProvider(name string) (SecretProvider, error) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	provider, ok := providers[name]
+	if !ok {
+		names := make([]string, 0, len(providers))
+		for n := range providers {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown secret provider %s, supported providers are: %v", name, names)
+	}
+	return provider, nil
+}
+
+// keyVaultProvider resolves the secret identified by the secret's keyvault URL, using its client ID if specified.
+type keyVaultProvider struct{}
+
+func (p *keyVaultProvider) Validate(secret *Secret) error {
+	if secret.KeyVault == "" {
+		return fmt.Errorf("secret ID: %s requires a keyvault URL", secret.ID)
+	}
+	return nil
+}
+
+func (p *keyVaultProvider) Resolve(ctx context.Context, secret *Secret) (string, error) {
+	secretConfig, err := vaults.NewAKVSecretConfig(secret.KeyVault, secret.MsiClientID)
+	if err != nil {
+		return "", err
+	}
+	return secretConfig.GetValue(ctx)
+}
+
+// msiProvider resolves a registry refresh token for the registry identified by the secret's ID.
+type msiProvider struct{}
+
+func (p *msiProvider) Validate(secret *Secret) error {
+	if secret.AadResourceID == "" {
+		return fmt.Errorf("secret ID: %s requires an AAD resource ID", secret.ID)
+	}
+	return nil
+}
+
+func (p *msiProvider) Resolve(_ context.Context, secret *Secret) (string, error) {
+	return tokenutil.GetRegistryRefreshToken(secret.ID, secret.AadResourceID, secret.MsiClientID)
+}
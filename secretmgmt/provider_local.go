@@ -0,0 +1,60 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package secretmgmt
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	envNameOption  = "name"
+	filePathOption = "path"
+)
+
+// envProvider resolves the environment variable specified by the secret's name option.
+type envProvider struct{}
+
+func (p *envProvider) Validate(secret *Secret) error {
+	return requireOptions(secret, envNameOption)
+}
+
+func (p *envProvider) Resolve(_ context.Context, secret *Secret) (string, error) {
+	name := secret.Options[envNameOption]
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s for secret ID: %s is not set", name, secret.ID)
+	}
+	return value, nil
+}
+
+// fileProvider resolves the content of the file specified by the secret's path option,
+// without any trailing newlines.
+type fileProvider struct{}
+
+func (p *fileProvider) Validate(secret *Secret) error {
+	return requireOptions(secret, filePathOption)
+}
+
+func (p *fileProvider) Resolve(_ context.Context, secret *Secret) (string, error) {
+	data, err := os.ReadFile(secret.Options[filePathOption])
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read secret ID: %s", secret.ID)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// requireOptions returns an error if any of the specified options of the secret are empty.
+func requireOptions(secret *Secret, names ...string) error {
+	for _, name := range names {
+		if secret.Options[name] == "" {
+			return fmt.Errorf("secret ID: %s requires the %s option for provider %s", secret.ID, name, secret.ProviderName())
+		}
+	}
+	return nil
+}
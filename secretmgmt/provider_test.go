@@ -0,0 +1,91 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package secretmgmt
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type staticProvider struct {
+	value string
+}
+
+func (p *staticProvider) Validate(_ *Secret) error { return nil }
+
+func (p *staticProvider) Resolve(_ context.Context, _ *Secret) (string, error) {
+	return p.value, nil
+}
+
+func TestRegisterSecretProvider(t *testing.T) {
+	if err := RegisterSecretProvider("static", &staticProvider{value: "foo"}); err != nil {
+		t.Fatalf("Failed to register provider: %v", err)
+	}
+	if err := RegisterSecretProvider("static", &staticProvider{}); err == nil {
+		t.Error("Expected registering a provider twice to fail")
+	}
+	if err := RegisterSecretProvider(EnvProvider, &staticProvider{}); err == nil {
+		t.Error("Expected replacing a built-in provider to fail")
+	}
+
+	secretResolver, err := NewSecretResolver(nil, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create secret resolver: %v", err)
+	}
+	secret := &Secret{ID: "a", Provider: "static"}
+	if err := secret.Validate(); err != nil {
+		t.Fatalf("Unexpected validation error: %v", err)
+	}
+	if err := secretResolver.ResolveSecrets(context.Background(), []*Secret{secret}); err != nil {
+		t.Fatalf("Failed to resolve secret: %v", err)
+	}
+	if secret.ResolvedValue != "foo" {
+		t.Errorf("Expected foo but got %s", secret.ResolvedValue)
+	}
+}
+
+func TestLocalProviders(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(file, []byte("from-file\n"), 0600); err != nil {
+		t.Fatalf("Failed to write secret file: %v", err)
+	}
+	t.Setenv("ACB_TEST_SECRET", "from-env")
+
+	tests := []struct {
+		secret      *Secret
+		expected    string
+		shouldError bool
+	}{
+		{&Secret{ID: "a", Provider: EnvProvider, Options: map[string]string{"name": "ACB_TEST_SECRET"}}, "from-env", false},
+		{&Secret{ID: "b", Provider: EnvProvider, Options: map[string]string{"name": "ACB_TEST_MISSING"}}, "", true},
+		{&Secret{ID: "c", Provider: FileProvider, Options: map[string]string{"path": file}}, "from-file", false},
+		{&Secret{ID: "d", Provider: FileProvider, Options: map[string]string{"path": file + ".missing"}}, "", true},
+	}
+
+	secretResolver, err := NewSecretResolver(nil, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create secret resolver: %v", err)
+	}
+	for _, test := range tests {
+		if err := test.secret.Validate(); err != nil {
+			t.Fatalf("Unexpected validation error for secret %s: %v", test.secret.ID, err)
+		}
+		err := secretResolver.ResolveSecrets(context.Background(), []*Secret{test.secret})
+		if test.shouldError {
+			if err == nil {
+				t.Errorf("Expected secret %s to error but it didn't", test.secret.ID)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Failed to resolve secret %s: %v", test.secret.ID, err)
+		}
+		if test.secret.ResolvedValue != test.expected {
+			t.Errorf("Expected %s but got %s", test.expected, test.secret.ResolvedValue)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package secretmgmt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	vaultAddressOption   = "address"
+	vaultTokenOption     = "token"
+	vaultNamespaceOption = "namespace"
+	vaultMountOption     = "mount"
+	vaultPathOption      = "path"
+	vaultKeyOption       = "key"
+	vaultVersionOption   = "version"
+
+	vaultAddressEnv   = "VAULT_ADDR"
+	vaultTokenEnv     = "VAULT_TOKEN"
+	vaultNamespaceEnv = "VAULT_NAMESPACE"
+
+	defaultVaultMount = "secret"
+
+	vaultTimeout = 30 * time.Second
+)
+
+// vaultClient sends the requests to Vault.
+var vaultClient = &http.Client{Timeout: vaultTimeout}
+
+// vaultProvider resolves a key of a secret stored in HashiCorp Vault's KV version 2 secrets engine.
+// The Vault address and namespace default to the VAULT_ADDR and VAULT_NAMESPACE environment variables.
+// The token is read from the VAULT_TOKEN environment variable, and it's only sent to the VAULT_ADDR address,
+// so that a Task can't send the host's token to an address of its choice.
+type vaultProvider struct{}
+
+// vaultSecretResponse is the response of reading a secret from the KV version 2 secrets engine.
+type vaultSecretResponse struct {
+	Data struct {
+		Data map[string]interface{} `json:"data"`
+	} `json:"data"`
+}
+
+// vaultErrorResponse is the response of a failed Vault request.
+type vaultErrorResponse struct {
+	Errors []string `json:"errors"`
+}
+
+func (p *vaultProvider) Validate(secret *Secret) error {
+	if err := requireOptions(secret, vaultPathOption, vaultKeyOption); err != nil {
+		return err
+	}
+	if _, ok := secret.Options[vaultTokenOption]; ok {
+		return fmt.Errorf("secret ID: %s can't set the %s option for provider %s, the token is read from the %s environment variable", secret.ID, vaultTokenOption, VaultProvider, vaultTokenEnv)
+	}
+	if vaultOption(secret, vaultAddressOption, vaultAddressEnv) == "" {
+		return fmt.Errorf("secret ID: %s requires the %s option or the %s environment variable for provider %s", secret.ID, vaultAddressOption, vaultAddressEnv, VaultProvider)
+	}
+	return nil
+}
+
+func (p *vaultProvider) Resolve(ctx context.Context, secret *Secret) (string, error) {
+	mount := secret.Options[vaultMountOption]
+	if mount == "" {
+		mount = defaultVaultMount
+	}
+	address := strings.TrimRight(vaultOption(secret, vaultAddressOption, vaultAddressEnv), "/")
+	u := fmt.Sprintf("%s/v1/%s/data/%s",
+		address,
+		strings.Trim(mount, "/"),
+		strings.Trim(secret.Options[vaultPathOption], "/"))
+	if version := secret.Options[vaultVersionOption]; version != "" {
+		u += "?version=" + url.QueryEscape(version)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create the Vault request for secret ID: %s", secret.ID)
+	}
+	if token := os.Getenv(vaultTokenEnv); token != "" && address == strings.TrimRight(os.Getenv(vaultAddressEnv), "/") {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if namespace := vaultOption(secret, vaultNamespaceOption, vaultNamespaceEnv); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := vaultClient.Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read secret ID: %s from Vault", secret.ID)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read the Vault response for secret ID: %s", secret.ID)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp vaultErrorResponse
+		_ = json.Unmarshal(body, &errResp)
+		return "", fmt.Errorf("failed to read secret ID: %s from Vault, status: %d, errors: %v", secret.ID, resp.StatusCode, errResp.Errors)
+	}
+
+	var secretResp vaultSecretResponse
+	if err = json.Unmarshal(body, &secretResp); err != nil {
+		return "", errors.Wrapf(err, "failed to parse the Vault response for secret ID: %s", secret.ID)
+	}
+	value, ok := secretResp.Data.Data[secret.Options[vaultKeyOption]]
+	if !ok {
+		return "", fmt.Errorf("key %s doesn't exist in the Vault secret for secret ID: %s", secret.Options[vaultKeyOption], secret.ID)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	// Non-string values are resolved to their JSON representation.
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to marshal the value of secret ID: %s", secret.ID)
+	}
+	return string(data), nil
+}
+
+// vaultOption returns the secret's option with the specified name, or the value of the environment variable if it isn't set.
+func vaultOption(secret *Secret, name string, env string) string {
+	if value := secret.Options[name]; value != "" {
+		return value
+	}
+	return os.Getenv(env)
+}
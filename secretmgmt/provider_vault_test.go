@@ -0,0 +1,110 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package secretmgmt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newVaultServer creates a stand-in for Vault's KV version 2 secrets engine which serves the secret at secret/myapp.
+func newVaultServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "test-token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/myapp":
+			if r.URL.Query().Get("version") == "1" {
+				_, _ = w.Write([]byte(`{"data":{"data":{"password":"old"},"metadata":{"version":1}}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"data":{"data":{"password":"s3cr3t","port":5432},"metadata":{"version":2}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+}
+
+func TestVaultProvider(t *testing.T) {
+	server := newVaultServer()
+	defer server.Close()
+
+	tests := []struct {
+		options     map[string]string
+		expected    string
+		shouldError bool
+	}{
+		{map[string]string{"path": "myapp", "key": "password"}, "s3cr3t", false},
+		{map[string]string{"path": "myapp", "key": "password", "version": "1"}, "old", false},
+		{map[string]string{"path": "myapp", "key": "port"}, "5432", false},
+		{map[string]string{"path": "myapp", "key": "missing"}, "", true},
+		{map[string]string{"path": "other", "key": "password"}, "", true},
+	}
+
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "test-token")
+	provider := &vaultProvider{}
+	for _, test := range tests {
+		secret := &Secret{ID: "a", Provider: VaultProvider, Options: test.options}
+		if err := secret.Validate(); err != nil {
+			t.Fatalf("Unexpected validation error: %v", err)
+		}
+		actual, err := provider.Resolve(context.Background(), secret)
+		if test.shouldError {
+			if err == nil {
+				t.Errorf("Expected options %v to error but got %s", test.options, actual)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Failed to resolve options %v: %v", test.options, err)
+		}
+		if actual != test.expected {
+			t.Errorf("Expected %s but got %s", test.expected, actual)
+		}
+	}
+}
+
+// TestVaultProvider_Address verifies that the host's token is only sent to the VAULT_ADDR address.
+func TestVaultProvider_Address(t *testing.T) {
+	server := newVaultServer()
+	defer server.Close()
+	other := newVaultServer()
+	defer other.Close()
+
+	t.Setenv("VAULT_ADDR", server.URL+"/")
+	t.Setenv("VAULT_TOKEN", "test-token")
+	provider := &vaultProvider{}
+	secret := &Secret{ID: "a", Provider: VaultProvider, Options: map[string]string{"path": "myapp", "key": "password", "address": server.URL}}
+	if actual, err := provider.Resolve(context.Background(), secret); err != nil || actual != "s3cr3t" {
+		t.Errorf("Expected the token to be sent to VAULT_ADDR but got %s, err: %v", actual, err)
+	}
+
+	secret.Options["address"] = other.URL
+	if _, err := provider.Resolve(context.Background(), secret); err == nil {
+		t.Error("Expected the token not to be sent to an address other than VAULT_ADDR")
+	}
+}
+
+func TestVaultProvider_Validate(t *testing.T) {
+	t.Setenv("VAULT_ADDR", "")
+	tests := []map[string]string{
+		{"key": "password", "address": "http://localhost:8200"},
+		{"path": "myapp", "address": "http://localhost:8200"},
+		{"path": "myapp", "key": "password"},
+		{"path": "myapp", "key": "password", "address": "http://localhost:8200", "token": "plaintext"},
+	}
+	for _, options := range tests {
+		secret := &Secret{ID: "a", Provider: VaultProvider, Options: options}
+		if err := secret.Validate(); err == nil {
+			t.Errorf("Expected options %v to be invalid", options)
+		}
+	}
+}
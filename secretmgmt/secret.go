@@ -4,13 +4,15 @@
 package secretmgmt
 
 import (
+	"reflect"
+
 	"github.com/Azure/acr-builder/util"
 	"github.com/pkg/errors"
 )
 
 var (
 	errMissingSecretIDs      = errors.New("secret is missing an ID as well as auto-generated ID")
-	errMissingSecretProps    = errors.New("secret should contain either keyvault property for vault secret, or msi clientID/aadResourceId for msi authentication")
+	errMissingSecretProps    = errors.New("secret should contain either a provider, keyvault property for vault secret, or msi clientID/aadResourceId for msi authentication")
 	errSecretIDContainsSpace = errors.New("secret ID cannot contain spaces")
 	errInvalidUUID           = errors.New("msi client ID is not a valid guid")
 )
@@ -21,6 +23,13 @@ type Secret struct {
 	KeyVault    string `yaml:"keyvault,omitempty"`
 	MsiClientID string `yaml:"clientID,omitempty"`
 
+	// Provider is the name of the SecretProvider which resolves the secret. If it's empty,
+	// the provider is determined by the keyvault property or the AadResourceID.
+	Provider string `yaml:"provider,omitempty"`
+
+	// Options are the provider specific properties of the secret.
+	Options map[string]string `yaml:"options,omitempty"`
+
 	// After the Secret is resolved, the value can be found here.
 	ResolvedValue string
 
@@ -48,14 +57,36 @@ func (s *Secret) Validate() error {
 	if util.ContainsSpace(s.ID) {
 		return errSecretIDContainsSpace
 	}
-	if !s.IsKeyVaultSecret() && !s.IsMsiSecret() {
-		return errMissingSecretProps
-	}
 	if s.MsiClientID != "" && !util.IsValidUUID(s.MsiClientID) {
 		return errInvalidUUID
 	}
 
-	return nil
+	name := s.ProviderName()
+	if name == "" {
+		return errMissingSecretProps
+	}
+	provider, err := GetSecretProvider(name)
+	if err != nil {
+		return err
+	}
+	return provider.Validate(s)
+}
+
+// ProviderName returns the name of the SecretProvider which resolves the secret,
+// or an empty string if it can't be determined.
+func (s *Secret) ProviderName() string {
+	if s == nil {
+		return ""
+	}
+	switch {
+	case s.Provider != "":
+		return s.Provider
+	case s.IsKeyVaultSecret():
+		return KeyVaultProvider
+	case s.IsMsiSecret():
+		return MsiProvider
+	}
+	return ""
 }
 
 // IsKeyVaultSecret returns true if a Secret is a key vault, false otherwise.
@@ -86,5 +117,7 @@ func (s *Secret) Equals(t *Secret) bool {
 	return s.ID == t.ID &&
 		s.KeyVault == t.KeyVault &&
 		s.MsiClientID == t.MsiClientID &&
-		s.AadResourceID == t.AadResourceID
+		s.AadResourceID == t.AadResourceID &&
+		s.Provider == t.Provider &&
+		(len(s.Options) == 0 && len(t.Options) == 0 || reflect.DeepEqual(s.Options, t.Options))
 }
@@ -57,6 +57,30 @@ func TestValidateSecret(t *testing.T) {
 			},
 			false,
 		},
+		{
+			&Secret{
+				ID:       "a",
+				Provider: "env",
+				Options:  map[string]string{"name": "FOO"},
+			},
+			false,
+		},
+		{
+			// Missing provider options
+			&Secret{
+				ID:       "a",
+				Provider: "file",
+			},
+			true,
+		},
+		{
+			// Unknown provider
+			&Secret{
+				ID:       "a",
+				Provider: "unknown",
+			},
+			true,
+		},
 	}
 
 	for _, test := range tests {
@@ -8,8 +8,7 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/Azure/acr-builder/tokenutil"
-	"github.com/Azure/acr-builder/vaults"
+	"github.com/Azure/acr-builder/pkg/redact"
 	"github.com/pkg/errors"
 )
 
@@ -90,6 +89,12 @@ func (secretResolver *SecretResolver) ResolveSecrets(ctx context.Context, secret
 		}
 	}
 
+	// Resolved values are redacted from all output.
+	for _, secret := range secrets {
+		if secret != nil {
+			redact.Add(secret.ResolvedValue)
+		}
+	}
 	return nil
 }
 
@@ -99,32 +104,22 @@ func resolveSecret(ctx context.Context, secret *Secret, errorChan chan error) {
 		return
 	}
 
-	if secret.IsKeyVaultSecret() {
-		secretConfig, err := vaults.NewAKVSecretConfig(secret.KeyVault, secret.MsiClientID)
-		if err != nil {
-			errorChan <- err
-			return
-		}
-
-		secretValue, err := secretConfig.GetValue(ctx)
-		if err != nil {
-			errorChan <- err
-			return
-		}
-
-		secret.ResolvedValue = secretValue
-		secret.ResolvedChan <- true
+	name := secret.ProviderName()
+	if name == "" {
+		errorChan <- fmt.Errorf("cannot resolve secret with ID: %s", secret.ID)
 		return
-	} else if secret.IsMsiSecret() {
-		secretValue, err := tokenutil.GetRegistryRefreshToken(secret.ID, secret.AadResourceID, secret.MsiClientID)
-		if err != nil {
-			errorChan <- err
-			return
-		}
-		secret.ResolvedValue = secretValue
-		secret.ResolvedChan <- true
+	}
+	provider, err := GetSecretProvider(name)
+	if err != nil {
+		errorChan <- err
 		return
 	}
 
-	errorChan <- fmt.Errorf("cannot resolve secret with ID: %s", secret.ID)
+	secretValue, err := provider.Resolve(ctx, secret)
+	if err != nil {
+		errorChan <- err
+		return
+	}
+	secret.ResolvedValue = secretValue
+	secret.ResolvedChan <- true
 }
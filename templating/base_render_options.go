@@ -16,7 +16,21 @@ import (
 	"github.com/pkg/errors"
 )
 
-var shellEscapePattern = regexp.MustCompile(`[^\w_^@=+%,:./-]`)
+var (
+	shellEscapePattern = regexp.MustCompile(`[^\w_^@=+%,:./-]`)
+
+	// stepOutputReferencePattern matches references to step outputs, i.e. .Steps.build.Outputs.version.
+	stepOutputReferencePattern = regexp.MustCompile(`\.Steps\.(` + graph.StepIDPattern + `)\.Outputs\.([A-Za-z_][A-Za-z0-9_]*)`)
+
+	// actionPattern matches the actions of a template, i.e. {{ .Run.ID }}.
+	actionPattern = regexp.MustCompile(`(?s)\{\{.*?\}\}`)
+
+	// fieldNamePattern matches the names which a template can reference as fields.
+	fieldNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// matrixReferencePattern matches references to a step's matrix values, i.e. .Matrix.os.
+	matrixReferencePattern = regexp.MustCompile(`\.Matrix\.([A-Za-z_][A-Za-z0-9_]*)`)
+)
 
 // BaseRenderOptions represents additional information for the composition of the final rendering.
 type BaseRenderOptions struct {
@@ -79,28 +93,38 @@ type BaseRenderOptions struct {
 	TaskName string
 }
 
+// RunValues returns the properties of the run which are exposed to templates as .Run
+// and to step conditions as Run.
+func (opts *BaseRenderOptions) RunValues() map[string]string {
+	return map[string]string{
+		"ID":           opts.ID,
+		"Commit":       opts.Commit,
+		"Repository":   opts.Repository,
+		"Branch":       opts.Branch,
+		"GitTag":       opts.GitTag,
+		"TriggeredBy":  opts.TriggeredBy,
+		"Registry":     opts.Registry,
+		"RegistryName": parseRegistryName(opts.Registry),
+		"Date":         opts.Date.Format("20060102-150405z"), // yyyyMMdd-HHmmssz
+		"SharedVolume": opts.SharedVolume,
+		"OS":           opts.OS,
+		"OSVersion":    opts.OSVersion,
+		"Architecture": opts.Architecture,
+		"TaskName":     opts.TaskName,
+	}
+}
+
 // OverrideValuesWithBuildInfo overrides the specified config's values and provides a default set of values.
 func OverrideValuesWithBuildInfo(c1 *Config, c2 *Config, opts *BaseRenderOptions) (Values, error) {
+	run := map[string]interface{}{}
+	for k, v := range opts.RunValues() {
+		run[k] = v
+	}
 	base := map[string]interface{}{
 		"Build": map[string]interface{}{
 			"ID": opts.ID,
 		},
-		"Run": map[string]interface{}{
-			"ID":           opts.ID,
-			"Commit":       opts.Commit,
-			"Repository":   opts.Repository,
-			"Branch":       opts.Branch,
-			"GitTag":       opts.GitTag,
-			"TriggeredBy":  opts.TriggeredBy,
-			"Registry":     opts.Registry,
-			"RegistryName": parseRegistryName(opts.Registry),
-			"Date":         opts.Date.Format("20060102-150405z"), // yyyyMMdd-HHmmssz
-			"SharedVolume": opts.SharedVolume,
-			"OS":           opts.OS,
-			"OSVersion":    opts.OSVersion,
-			"Architecture": opts.Architecture,
-			"TaskName":     opts.TaskName,
-		},
+		"Run": run,
 	}
 
 	vals, err := OverrideValues(c1, c2)
@@ -149,35 +173,41 @@ func LoadAndRenderBuildSteps(_ context.Context, template *Template, opts *BaseRe
 // LoadAndRenderSteps loads a template file for exec and renders it according to an optional values file, --set values,
 // and base render options.
 func LoadAndRenderSteps(ctx context.Context, template *Template, opts *BaseRenderOptions) (string, error) {
+	rendered, _, err := renderSteps(ctx, template, opts)
+	return rendered, err
+}
+
+// renderSteps renders a template like LoadAndRenderSteps and also returns the resolved values of its secrets by ID.
+func renderSteps(ctx context.Context, template *Template, opts *BaseRenderOptions) (string, Values, error) {
 	// load steps and override values
 	mergedVals, err := loadSteps(template, opts)
 	if err != nil {
-		return "", fmt.Errorf("error while loading exec steps: %v", err)
+		return "", nil, fmt.Errorf("error while loading exec steps: %v", err)
 	}
 	// return empty rendered string for an empty template.
 	if mergedVals == nil {
-		return "", nil
+		return "", nil, nil
 	}
 
 	engine := NewEngine()
 	// we will pass nil for the secret resolve override so as to use the default resolve function.
 	secrets, err := renderAndResolveSecrets(ctx, template, engine, nil, opts, mergedVals)
 	if err != nil {
-		return "", fmt.Errorf("failed to resolve secrets in the task with error: %v", err)
+		return "", nil, fmt.Errorf("failed to resolve secrets in the task with error: %v", err)
 	}
 	// update the secrets collection with resolved secrets.
 	mergedVals["Secrets"] = secrets
 
 	rendered, err := engine.Render(template, mergedVals)
 	if err != nil {
-		return "", fmt.Errorf("error while rendering templates: %v", err)
+		return "", nil, fmt.Errorf("error while rendering templates: %v", err)
 	}
 
 	if rendered == "" {
-		return "", errors.New("rendered template was empty")
+		return "", nil, errors.New("rendered template was empty")
 	}
 
-	return rendered, nil
+	return rendered, secrets, nil
 }
 
 // loadSteps loads a template file and overrides values with build info
@@ -215,10 +245,56 @@ func loadSteps(template *Template, opts *BaseRenderOptions) (Values, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to override values: %v", err)
 	}
+	mergedVals["Steps"] = deferStepOutputs(template.GetData())
+	mergedVals["Matrix"] = deferMatrixValues(template.GetData())
+	template.Data = indexStepOutputReferences(template.GetData())
 
 	return mergedVals, nil
 }
 
+// deferStepOutputs returns the values for the step outputs referenced in the template.
+// Step outputs aren't known until the task runs, so each reference renders as itself
+// and is resolved when the referencing step starts.
+func deferStepOutputs(data []byte) Values {
+	steps := Values{}
+	for _, match := range stepOutputReferencePattern.FindAllSubmatch(data, -1) {
+		id, key := string(match[1]), string(match[2])
+		step, ok := steps[id].(map[string]interface{})
+		if !ok {
+			step = map[string]interface{}{"Outputs": map[string]interface{}{}}
+			steps[id] = step
+		}
+		step["Outputs"].(map[string]interface{})[key] = graph.OutputReference(id, key)
+	}
+	return steps
+}
+
+// indexStepOutputReferences rewrites the references to the outputs of steps whose IDs can't be referenced as
+// template fields, e.g. .Steps.build-1.21.Outputs.version, to index the step outputs instead.
+func indexStepOutputReferences(data []byte) []byte {
+	return actionPattern.ReplaceAllFunc(data, func(action []byte) []byte {
+		return stepOutputReferencePattern.ReplaceAllFunc(action, func(ref []byte) []byte {
+			match := stepOutputReferencePattern.FindSubmatch(ref)
+			id, key := string(match[1]), string(match[2])
+			if fieldNamePattern.MatchString(id) {
+				return ref
+			}
+			return []byte(fmt.Sprintf("(index .Steps %q \"Outputs\" %q)", id, key))
+		})
+	})
+}
+
+// deferMatrixValues returns the values for the matrix values referenced in the template.
+// Each reference renders as itself and is resolved when the task's matrix steps are expanded.
+func deferMatrixValues(data []byte) Values {
+	matrix := Values{}
+	for _, match := range matrixReferencePattern.FindAllSubmatch(data, -1) {
+		key := string(match[1])
+		matrix[key] = graph.MatrixReference(key)
+	}
+	return matrix
+}
+
 // renderAndResolveSecrets parses the secrets in the template, resolves them using vault providers and returns the resolved secret values.
 func renderAndResolveSecrets(
 	ctx context.Context,
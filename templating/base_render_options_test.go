@@ -137,6 +137,56 @@ func TestLoadAndRenderSteps(t *testing.T) {
 	}
 }
 
+func TestLoadAndRenderSteps_DefersReferences(t *testing.T) {
+	template := NewTemplate("outputs", []byte(`steps:
+  - id: version
+    cmd: bash -c 'echo tag=1.0 >> $ACB_OUTPUT'
+  - build: -t {{.Run.Registry}}/app:{{ .Steps.version.Outputs.tag }}-{{ .Matrix.os }} .
+    matrix:
+      os: [linux, windows]`))
+	opts := &BaseRenderOptions{Registry: "foo.azurecr.io"}
+
+	expected := `steps:
+  - id: version
+    cmd: bash -c 'echo tag=1.0 >> $ACB_OUTPUT'
+  - build: -t foo.azurecr.io/app:{{.Steps.version.Outputs.tag}}-{{.Matrix.os}} .
+    matrix:
+      os: [linux, windows]`
+	actual, err := LoadAndRenderSteps(context.Background(), template, opts)
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if actual != expected {
+		t.Errorf("Expected \n%s\n but got \n%s\n", expected, actual)
+	}
+}
+
+func TestLoadAndRenderSteps_DefersMatrixStepOutputs(t *testing.T) {
+	template := NewTemplate("outputs", []byte(`steps:
+  - id: build
+    cmd: bash -c 'echo tag=1.0 >> $ACB_OUTPUT'
+    matrix:
+      go: ["1.21"]
+  - cmd: {{.Run.Registry}}/app:{{ .Steps.build-1.21.Outputs.tag }} test
+    when: ["build-1.21"]`))
+	opts := &BaseRenderOptions{Registry: "foo.azurecr.io"}
+
+	expected := `steps:
+  - id: build
+    cmd: bash -c 'echo tag=1.0 >> $ACB_OUTPUT'
+    matrix:
+      go: ["1.21"]
+  - cmd: foo.azurecr.io/app:{{.Steps.build-1.21.Outputs.tag}} test
+    when: ["build-1.21"]`
+	actual, err := LoadAndRenderSteps(context.Background(), template, opts)
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if actual != expected {
+		t.Errorf("Expected \n%s\n but got \n%s\n", expected, actual)
+	}
+}
+
 func TestLoadAndRenderBuildSteps(t *testing.T) {
 	opts := &BaseRenderOptions{
 		ValuesFile: "",
@@ -0,0 +1,69 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package templating
+
+import (
+	"context"
+	"log"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/pkg/errors"
+)
+
+// LoadAndRenderTask renders the template, replacing any aliases it uses, and unmarshals the result into a Task.
+func LoadAndRenderTask(ctx context.Context, template *Template, opts *BaseRenderOptions, taskOpts *graph.TaskOptions, debug bool) (*graph.Task, error) {
+	var alias *graph.Alias
+
+	versionInUse := graph.FindVersion(template.GetData())
+	shouldIncludeAlias := versionInUse >= "v1.1.0"
+	if shouldIncludeAlias {
+		log.Printf("Alias support enabled for version >= 1.1.0, please see https://aka.ms/acr/tasks/task-aliases for more information.")
+		// separate alias and remaining data from the Task
+		aliasData, taskData := graph.SeparateAliasFromRest(template.GetData())
+
+		// render alias data
+		renderedAlias, renderAliasErr := LoadAndRenderSteps(ctx, NewTemplate("aliasData", aliasData), opts)
+		if renderAliasErr != nil {
+			return nil, errors.Wrap(renderAliasErr, "unable to render alias data")
+		}
+		aliasData = []byte(renderedAlias)
+		// Preprocess the task to replace all aliases based on the alias sources.
+		processedTask, _alias, aliasErr := graph.SearchReplaceAlias(template.GetData(), aliasData, taskData)
+		alias = _alias
+		if aliasErr != nil {
+			return nil, errors.Wrap(aliasErr, "unable to search/replace aliases in task")
+		}
+		if debug {
+			log.Printf("Processed task before rendering data:\n%s", processedTask)
+		}
+		// update the template.Data
+		template.Data = processedTask
+	}
+
+	rendered, secrets, err := renderSteps(ctx, template, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to render task")
+	}
+	if debug {
+		log.Printf("Rendered template:\n%s", rendered)
+	}
+
+	task, err := graph.UnmarshalTaskFromString(ctx, rendered, taskOpts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal task before running")
+	}
+
+	// The secrets were resolved while rendering, so the steps which read keys from them, e.g. to sign images,
+	// use the values resolved with the run's identity and options instead of resolving them again.
+	for _, secret := range task.Secrets {
+		if value, ok := secrets[secret.ID].(string); ok {
+			secret.ResolvedValue = value
+		}
+	}
+
+	if shouldIncludeAlias {
+		graph.ExpandCommandAliases(alias, task)
+	}
+	return task, nil
+}
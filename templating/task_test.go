@@ -0,0 +1,33 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package templating
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/secretmgmt"
+)
+
+func TestLoadAndRenderTask_ResolvesSecrets(t *testing.T) {
+	t.Setenv("ACB_TEST_SIGNING_KEY", "signing key")
+	template := NewTemplate("task", []byte(`
+secrets:
+  - id: key
+    provider: env
+    options:
+      name: ACB_TEST_SIGNING_KEY
+steps:
+  - cmd: alpine echo hello
+`))
+	opts := &BaseRenderOptions{SecretResolveTimeout: secretmgmt.DefaultSecretResolveTimeout}
+	task, err := LoadAndRenderTask(context.Background(), template, opts, &graph.TaskOptions{}, false)
+	if err != nil {
+		t.Fatalf("failed to load task: %v", err)
+	}
+	if len(task.Secrets) != 1 || task.Secrets[0].ResolvedValue != "signing key" {
+		t.Errorf("expected the task's secret to be resolved but got %+v", task.Secrets)
+	}
+}
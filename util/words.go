@@ -0,0 +1,79 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package util
+
+import (
+	"fmt"
+	"runtime"
+	"unicode"
+)
+
+// SplitWords splits a command into words the way a shell does, without expanding variables or interpreting
+// operators such as ; or |. Single quotes preserve everything between them, double quotes preserve everything
+// except backslash escapes of ", \, $ and `, and a backslash outside of quotes escapes the next character.
+// A backslash followed by a newline, outside of quotes or in double quotes, continues the line and is removed.
+// On Windows, backslashes are path separators rather than escapes.
+func SplitWords(s string) ([]string, error) {
+	escapes := runtime.GOOS != WindowsOS
+	var words []string
+	var word []rune
+	inWord := false
+	var quote rune
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word = append(word, c)
+			}
+		case quote == '"':
+			switch {
+			case c == '"':
+				quote = 0
+			case c == '\\' && escapes && i+1 < len(runes) && runes[i+1] == '\n':
+				i++
+			case c == '\\' && escapes && i+1 < len(runes) && isDoubleQuoteEscapable(runes[i+1]):
+				i++
+				word = append(word, runes[i])
+			default:
+				word = append(word, c)
+			}
+		case c == '\\' && escapes:
+			if i+1 >= len(runes) {
+				return nil, fmt.Errorf("command %q ends with an escape character", s)
+			}
+			i++
+			// A line continuation is removed rather than escaped.
+			if runes[i] != '\n' {
+				word = append(word, runes[i])
+				inWord = true
+			}
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case unicode.IsSpace(c):
+			if inWord {
+				words = append(words, string(word))
+				word = word[:0]
+				inWord = false
+			}
+		default:
+			word = append(word, c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("command %q has an unterminated %c quote", s, quote)
+	}
+	if inWord {
+		words = append(words, string(word))
+	}
+	return words, nil
+}
+
+func isDoubleQuoteEscapable(c rune) bool {
+	return c == '"' || c == '\\' || c == '$' || c == '`'
+}
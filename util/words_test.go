@@ -0,0 +1,51 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package util
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	if runtime.GOOS == WindowsOS {
+		t.Skip("backslashes aren't escapes on Windows")
+	}
+	tests := []struct {
+		s        string
+		expected []string
+	}{
+		{"", nil},
+		{"  golang   go build  ", []string{"golang", "go", "build"}},
+		{`bash -c 'echo $HOME; ls | wc -l'`, []string{"bash", "-c", "echo $HOME; ls | wc -l"}},
+		{`alpine echo "a \"quoted\" \$value"`, []string{"alpine", "echo", `a "quoted" $value`}},
+		{`alpine echo "C:\path"`, []string{"alpine", "echo", `C:\path`}},
+		{`alpine echo foo\ bar`, []string{"alpine", "echo", "foo bar"}},
+		{`alpine echo ""`, []string{"alpine", "echo", ""}},
+		{`alpine echo {"a":1,"b":[2]}`, []string{"alpine", "echo", `{a:1,b:[2]}`}},
+		{`alpine echo '{"a":1,"b":[2]}'`, []string{"alpine", "echo", `{"a":1,"b":[2]}`}},
+		{"alpine echo a;b", []string{"alpine", "echo", "a;b"}},
+		{"docker build \\\n -t foo .", []string{"docker", "build", "-t", "foo", "."}},
+		{"alpine echo foo\\\nbar", []string{"alpine", "echo", "foobar"}},
+		{"alpine echo \"foo \\\nbar\"", []string{"alpine", "echo", "foo bar"}},
+		{"alpine echo 'foo \\\nbar'", []string{"alpine", "echo", "foo \\\nbar"}},
+	}
+	for _, test := range tests {
+		actual, err := SplitWords(test.s)
+		if err != nil {
+			t.Errorf("failed to split %q: %v", test.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("expected %q but got %q", test.expected, actual)
+		}
+	}
+
+	for _, s := range []string{`alpine echo "foo`, `alpine echo 'foo`, `alpine echo foo\`} {
+		if _, err := SplitWords(s); err == nil {
+			t.Errorf("expected %q to fail to split", s)
+		}
+	}
+}